@@ -0,0 +1,38 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writablePath reports whether path, relative to the repo root, matches one
+// of globs or lies under a directory matching one of them. Globs use
+// filepath.Match syntax on slash separated paths.
+func writablePath(globs []string, path string) bool {
+	path = strings.Trim(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	for p := path; p != "" && p != "."; p = filepath.ToSlash(filepath.Dir(p)) {
+		for _, glob := range globs {
+			if ok, _ := filepath.Match(glob, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWritable validates filename, then returns a permission error if
+// writable paths are configured and filename is not one of them.
+func (g *GitFs) checkWritable(op, filename string) error {
+	if err := g.checkPath(op, filename); err != nil {
+		return err
+	}
+	if g.writable == nil || writablePath(g.writable, filepath.Join(g.root, filename)) {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: filename, Err: os.ErrPermission}
+}
+
+func isWriteFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
@@ -0,0 +1,80 @@
+package gitfs
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+var ErrAlreadyPushed = errors.New("commit has already been pushed")
+
+// CommitAmend folds the changes of the worktree into the last commit,
+// replacing its message by msg unless empty. The last commit must not be
+// pushed yet, as while offline, otherwise it fails with ErrAlreadyPushed.
+// The amended commit is pushed by the next Sync or Flush.
+func (g *GitFs) CommitAmend(msg string) (err error) {
+	defer g.git.trace("gitfs.CommitAmend")(&err)
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	if err := g.checkQuota(); err != nil {
+		return err
+	}
+	if err := g.git.AddAll(); err != nil {
+		return errors.Wrapf(err, "error adding files to git")
+	}
+	if err := g.git.runPreCommit(true); err != nil {
+		return err
+	}
+	return g.git.amend(msg)
+}
+
+// amend replaces the HEAD commit by one of the staged changes on top of
+// its parent, so the usual commit path signs and reports it.
+func (g *Git) amend(msg string) error {
+	head, err := g.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return errors.New("no commit to amend")
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+	c, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD commit")
+	}
+	if c.NumParents() > 1 {
+		return errors.Errorf("%v is a merge commit, which can't be amended", c.Hash)
+	}
+
+	if !g.noRemote {
+		remote, err := g.repo.Storer.Reference(plumbing.NewRemoteReferenceName("origin", g.branchName()))
+		if err == nil && remote.Hash() == c.Hash {
+			return errors.Wrapf(ErrAlreadyPushed, "commit %v", c.Hash)
+		} else if err != nil && err != plumbing.ErrReferenceNotFound {
+			return errors.Wrapf(err, "error reading remote branch")
+		}
+	}
+	if msg == "" {
+		msg = c.Message
+	}
+
+	if c.NumParents() == 0 {
+		err = g.repo.Storer.RemoveReference(head.Name())
+	} else {
+		err = g.repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), c.ParentHashes[0]))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error updating %v", head.Name())
+	}
+
+	if err := g.Commit(msg); err != nil {
+		if rerr := g.repo.Storer.SetReference(head); rerr != nil {
+			return errors.Wrapf(rerr, "error restoring %v after %v", head.Name(), err)
+		}
+		return errors.Wrapf(err, "error committing amended changes")
+	}
+	return nil
+}
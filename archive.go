@@ -0,0 +1,131 @@
+package gitfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// ArchiveFormat is the format of an archive written by Archive.
+type ArchiveFormat int
+
+const (
+	ArchiveTarGz ArchiveFormat = iota
+	ArchiveZip
+)
+
+// Archive writes the tree of revision, HEAD if empty, to w as an archive
+// of the given format, like git archive. Any revision git rev-parse accepts
+// in go-git works, e.g. a branch, tag or commit hash. Each path is prefixed
+// with prefix, e.g. "project/". Files get the commit time as mod time.
+func (g *GitFs) Archive(w io.Writer, format ArchiveFormat, revision, prefix string) (err error) {
+	defer g.git.trace("gitfs.Archive")(&err)
+
+	c, err := g.git.resolveCommit(revision)
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return errors.Wrapf(err, "error reading tree of %v", c.Hash)
+	}
+
+	var add func(f *object.File, data []byte) error
+	var closeArchive func() error
+	switch format {
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		add = func(f *object.File, data []byte) error {
+			hdr := &tar.Header{
+				Name:    path.Join(prefix, f.Name),
+				ModTime: c.Committer.When,
+			}
+			switch f.Mode {
+			case filemode.Symlink:
+				hdr.Typeflag = tar.TypeSymlink
+				hdr.Linkname = string(data)
+				hdr.Mode = 0777
+				data = nil
+			case filemode.Executable:
+				hdr.Typeflag = tar.TypeReg
+				hdr.Mode = 0755
+			default:
+				hdr.Typeflag = tar.TypeReg
+				hdr.Mode = 0644
+			}
+			hdr.Size = int64(len(data))
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := tw.Write(data)
+			return err
+		}
+		closeArchive = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gw.Close()
+		}
+	case ArchiveZip:
+		zw := zip.NewWriter(w)
+		add = func(f *object.File, data []byte) error {
+			hdr := &zip.FileHeader{
+				Name:   path.Join(prefix, f.Name),
+				Method: zip.Deflate,
+			}
+			hdr.SetModTime(c.Committer.When)
+			mode, _ := f.Mode.ToOSFileMode()
+			hdr.SetMode(mode)
+			fw, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = fw.Write(data)
+			return err
+		}
+		closeArchive = zw.Close
+	default:
+		return errors.Errorf("unknown archive format %v", format)
+	}
+
+	if err := tree.Files().ForEach(func(f *object.File) error {
+		r, err := f.Reader()
+		if err != nil {
+			return errors.Wrapf(err, "error reading %v", f.Name)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %v", f.Name)
+		}
+		return errors.Wrapf(add(f, data), "error archiving %v", f.Name)
+	}); err != nil {
+		return err
+	}
+	return errors.Wrapf(closeArchive(), "error writing archive")
+}
+
+// resolveCommit returns the commit of revision, HEAD if empty.
+func (g *Git) resolveCommit(revision string) (*object.Commit, error) {
+	if revision == "" {
+		revision = string(plumbing.HEAD)
+	}
+	h, err := g.repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving revision %v", revision)
+	}
+	c, err := g.repo.CommitObject(*h)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading commit %v", h)
+	}
+	return c, nil
+}
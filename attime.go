@@ -0,0 +1,160 @@
+package gitfs
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var ErrReadOnly = errors.New("repo view is read only")
+
+// AtTime returns a read-only view of the repo as of t, at the last commit
+// of the current branch committed at or before t, following first parents.
+// Changes not synced are not part of it. Writes fail with a permission
+// error, Sync, Apply and Pull with ErrReadOnly.
+func (g *GitFs) AtTime(t time.Time) (*GitFs, error) {
+	c, err := g.git.commitAt(t)
+	if err != nil {
+		return nil, err
+	}
+	return g.view(c.Hash)
+}
+
+// SnapshotReader returns a read-only view of the HEAD commit, like AtTime,
+// pinned to it whatever Pull and Sync do afterwards, so reads served while
+// the repo is being updated never observe a half-updated worktree. Changes
+// not synced are not part of it. The view reads objects under a read lock
+// of the repo, copying them, so reads wait while Pull, Sync or another
+// change of the repo holds the repo lock.
+func (g *GitFs) SnapshotReader() (*GitFs, error) {
+	if g.git.readOnly {
+		return g.view(g.git.headHash())
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	head := g.git.headHash()
+	unlock(&err)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.IsZero() {
+		return nil, errors.New("no commit to read yet")
+	}
+	return g.view(head)
+}
+
+// commitAt returns the first commit committed at or before t, walking the
+// first parents from HEAD.
+func (g *Git) commitAt(t time.Time) (*object.Commit, error) {
+	c, err := g.resolveCommit("")
+	if err != nil {
+		return nil, err
+	}
+	for c.Committer.When.After(t) {
+		if c.NumParents() == 0 {
+			return nil, errors.Errorf("no commit at or before %v", t.Format(time.RFC3339))
+		}
+		if c, err = c.Parent(0); err != nil {
+			return nil, errors.Wrapf(err, "error walking history")
+		}
+	}
+	return c, nil
+}
+
+// view returns a read-only GitFs of the tree of commit hash, read straight
+// from the object store of g like a bare repo.
+func (g *GitFs) view(hash plumbing.Hash) (*GitFs, error) {
+	parent := g.git
+	s := &viewStorer{
+		worktreeStorer: &worktreeStorer{
+			Storer: parent.repo.Storer,
+			head:   plumbing.NewHashReference(plumbing.HEAD, hash),
+		},
+		objects: parent.objects,
+	}
+	repo, err := git.Open(s, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %v", hash)
+	}
+	temp := newTempAreas(parent.temp.name, false)
+	bare, err := newBareFs(repo, temp)
+	if err != nil {
+		return nil, err
+	}
+
+	child := *parent
+	child.fs = bare
+	child.repo = repo
+	child.wt = nil
+	child.bare = bare
+	child.branch = ""
+	child.temp = temp
+	child.statusCache = nil
+	if parent.modTimes != nil {
+		child.modTimes = newModTimeCache()
+	}
+	if parent.eol != nil {
+		child.eol = newEOLPolicy(parent.eol.autocrlf, parent.eol.attributes)
+	}
+	child.readOnly = true
+
+	return &GitFs{
+		git:           &child,
+		fs:            bare,
+		compressAbove: g.compressAbove,
+		writable:      []string{},
+		offline:       true,
+		events:        newEventBus(),
+	}, nil
+}
+
+// viewStorer reads the object store shared with the repo of a view under
+// the read lock of the repo, copying the objects read, so reading them
+// doesn't race with changes of the repo.
+type viewStorer struct {
+	*worktreeStorer
+	objects *sync.RWMutex
+}
+
+func (s *viewStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+
+	obj, err := s.worktreeStorer.EncodedObject(t, h)
+	if err != nil {
+		return nil, err
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	c := &plumbing.MemoryObject{}
+	c.SetType(obj.Type())
+	if _, err := io.Copy(c, r); err != nil {
+		return nil, errors.Wrapf(err, "error reading object %v", h)
+	}
+	return c, nil
+}
+
+func (s *viewStorer) HasEncodedObject(h plumbing.Hash) error {
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+	return s.worktreeStorer.HasEncodedObject(h)
+}
+
+func (s *viewStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+	return s.worktreeStorer.EncodedObjectSize(h)
+}
@@ -0,0 +1,45 @@
+package gitfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotReaderReadsUnderRepoLock(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"a.txt": "v1"})
+	g := r.clone(nil)
+	snap, err := g.SnapshotReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan string, 1)
+	go func() {
+		data, _ := snap.ReadFile("a.txt")
+		read <- string(data)
+	}()
+	select {
+	case data := <-read:
+		t.Fatalf("read %q while the repo is changed", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock(new(error))
+	if data := <-read; data != "v1" {
+		t.Fatalf("got %q", data)
+	}
+
+	r.commit(map[string]string{"a.txt": "v2"})
+	if err := g.Pull(); err != nil {
+		t.Fatal(err)
+	}
+	if data := readTestFile(t, g, "a.txt"); data != "v2" {
+		t.Fatalf("pulled %q", data)
+	}
+	if data := readTestFile(t, snap, "a.txt"); data != "v1" {
+		t.Fatalf("snapshot moved to %q", data)
+	}
+}
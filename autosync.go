@@ -0,0 +1,144 @@
+package gitfs
+
+import (
+	"context"
+	"time"
+)
+
+// CommitPolicy decides when pending changes are worth a commit. A zero
+// field disables its limit, a zero policy commits any change.
+type CommitPolicy struct {
+	// Commit once the oldest pending change is this old
+	MaxInterval time.Duration
+	// Commit once this many files are changed
+	MaxFiles int
+	// Commit once changed files total this many bytes
+	MaxBytes int64
+}
+
+// Pending describes the changes not yet committed.
+type Pending struct {
+	Since time.Time
+	Files int
+	Bytes int64
+}
+
+// ShouldCommit reports whether p calls for a commit at now.
+func (c CommitPolicy) ShouldCommit(p Pending, now time.Time) bool {
+	if p.Files == 0 {
+		return false
+	}
+	if c.MaxInterval <= 0 && c.MaxFiles <= 0 && c.MaxBytes <= 0 {
+		return true
+	}
+	return (c.MaxInterval > 0 && now.Sub(p.Since) >= c.MaxInterval) ||
+		(c.MaxFiles > 0 && p.Files >= c.MaxFiles) ||
+		(c.MaxBytes > 0 && p.Bytes >= c.MaxBytes)
+}
+
+// maxAutoSyncBackoff caps how long AutoSync waits to try again after
+// failing.
+const maxAutoSyncBackoff = 10 * time.Minute
+
+// AutoSync checks the worktree for changes every poll and syncs them as
+// policy decides, until ctx is done. Changes still pending then are synced
+// before returning ctx.Err(), or the error of that sync. Errors meanwhile
+// are reported to Hooks.OnError, or Hooks.OnConflict, and tried again
+// after a backoff doubling from poll up to maxAutoSyncBackoff.
+func (g *GitFs) AutoSync(ctx context.Context, policy CommitPolicy, poll time.Duration) error {
+	return g.autoSync(ctx, policy, poll, true)
+}
+
+// autoSync syncs like AutoSync, syncing the changes still pending once ctx
+// is done only with flush.
+func (g *GitFs) autoSync(ctx context.Context, policy CommitPolicy, poll time.Duration, flush bool) error {
+	ticks, stop := g.git.clock.NewTicker(poll)
+	defer stop()
+
+	var since, retryAt time.Time
+	failures := 0
+	fail := func(err error, now time.Time) {
+		g.git.reportError("gitfs.AutoSync", err)
+		backoff := poll
+		for i := 0; i < failures && backoff < maxAutoSyncBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxAutoSyncBackoff {
+			backoff = maxAutoSyncBackoff
+		}
+		failures++
+		retryAt = now.Add(backoff)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !flush {
+				return ctx.Err()
+			}
+			p, err := g.pending(since)
+			if err == nil && p.Files > 0 {
+				err = g.Sync(false)
+			}
+			if err != nil {
+				return err
+			}
+			return ctx.Err()
+		case now := <-ticks:
+			if now.Before(retryAt) {
+				continue
+			}
+			p, err := g.pending(since)
+			if err != nil {
+				fail(err, now)
+				continue
+			}
+			// a failed sync is tried again whatever policy says, it may
+			// have committed the changes without pushing them
+			retrying := failures > 0
+			if p.Files == 0 && !retrying {
+				since = time.Time{}
+				continue
+			}
+			if since.IsZero() && p.Files > 0 {
+				since = now
+				p.Since = now
+			}
+			if !retrying && !policy.ShouldCommit(p, now) {
+				continue
+			}
+			if _, err := g.syncContext(ctx, SyncOptions{}); err != nil {
+				if ctx.Err() == nil {
+					fail(err, now)
+				}
+				continue
+			}
+			since, retryAt, failures = time.Time{}, time.Time{}, 0
+		}
+	}
+}
+
+func (g *GitFs) pending(since time.Time) (Pending, error) {
+	files, err := g.scopeStatus()
+	if err != nil {
+		return Pending{}, err
+	}
+
+	p := Pending{Since: since, Files: len(files)}
+	for path, code := range files {
+		if code == Deleted {
+			continue
+		}
+		if fi, err := g.git.fs.Lstat(path); err == nil {
+			p.Bytes += fi.Size()
+		}
+	}
+	return p, nil
+}
+
+// syncEvery syncs any change every interval until ctx is done, see
+// AutoSync. Changes still pending then are left to Close, see
+// Config.SyncOnClose.
+func (g *GitFs) syncEvery(ctx context.Context, interval time.Duration) {
+	g.autoSync(ctx, CommitPolicy{}, interval, false)
+}
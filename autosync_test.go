@@ -0,0 +1,77 @@
+package gitfs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// waitFor fails t unless cond holds within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+func TestAutoSyncRetriesWithBackoff(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	var cloned, pushes, reported int32
+	clock := NewManualClock(time.Now())
+	g := r.clone(NewConfig().UseMemFs().SetClock(clock).
+		SetHooks(Hooks{OnError: func(e ErrorEvent) {
+			if e.Op == "gitfs.AutoSync" {
+				atomic.AddInt32(&reported, 1)
+			}
+		}}).
+		SetAuthProvider(AuthProviderFunc(func(ctx context.Context) (transport.AuthMethod, error) {
+			if atomic.LoadInt32(&cloned) == 0 {
+				return nil, nil
+			}
+			if atomic.AddInt32(&pushes, 1) <= 2 {
+				return nil, errors.New("remote down")
+			}
+			return nil, nil
+		})))
+	atomic.StoreInt32(&cloned, 1)
+	writeTestFile(t, g, "a.txt", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.AutoSync(ctx, CommitPolicy{}, time.Minute) }()
+	waitFor(t, "the ticker", func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.tickers) == 1
+	})
+
+	clock.Add(time.Minute)
+	waitFor(t, "the first push", func() bool { return atomic.LoadInt32(&reported) == 1 })
+	clock.Add(time.Minute)
+	waitFor(t, "the second push", func() bool { return atomic.LoadInt32(&reported) == 2 })
+	// backing off for two polls
+	clock.Add(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&pushes); n != 2 {
+		t.Fatalf("pushed %v times while backing off", n)
+	}
+	clock.Add(time.Minute)
+	waitFor(t, "a.txt pushed", func() bool {
+		_, ok := r.file("a.txt")
+		return ok
+	})
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("AutoSync returned %v", err)
+	}
+	if n := atomic.LoadInt32(&reported); n != 2 {
+		t.Fatalf("reported %v errors", n)
+	}
+}
@@ -0,0 +1,131 @@
+package gitfs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// Max bytes read from a throttled stream at once, keeping the rate smooth.
+const throttleChunk = 32 << 10
+
+// bandwidth is a byte rate shared by all streams with a remote. Up to a
+// second of unused rate is saved for bursts.
+type bandwidth struct {
+	rate int64
+
+	mu sync.Mutex
+	// When the bytes transferred so far are paid for
+	next time.Time
+}
+
+// wait blocks until n more bytes are allowed, or ctx is done.
+func (b *bandwidth) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if earliest := now.Add(-time.Second); b.next.Before(earliest) {
+		b.next = earliest
+	}
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.rate))
+	d := b.next.Sub(now)
+	b.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns how many bytes are read at once.
+func (b *bandwidth) chunk() int {
+	if b.rate < throttleChunk {
+		return int(b.rate)
+	}
+	return throttleChunk
+}
+
+// throttledTransport throttles the packfiles transferred with a remote to
+// its bandwidth, the bulk of what clones, fetches and pushes transfer.
+type throttledTransport struct {
+	transport.Transport
+	b *bandwidth
+}
+
+func (t *throttledTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	s, err := t.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledUploadPack{UploadPackSession: s, b: t.b}, nil
+}
+
+func (t *throttledTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	s, err := t.Transport.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReceivePack{ReceivePackSession: s, b: t.b}, nil
+}
+
+type throttledUploadPack struct {
+	transport.UploadPackSession
+	b *bandwidth
+}
+
+func (s *throttledUploadPack) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	throttled := packp.NewUploadPackResponseWithPackfile(req, &throttledReader{ctx: ctx, r: resp, b: s.b})
+	throttled.ShallowUpdate = resp.ShallowUpdate
+	throttled.ServerResponse = resp.ServerResponse
+	return throttled, nil
+}
+
+type throttledReceivePack struct {
+	transport.ReceivePackSession
+	b *bandwidth
+}
+
+func (s *throttledReceivePack) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if req.Packfile != nil {
+		req.Packfile = &throttledReader{ctx: ctx, r: req.Packfile, b: s.b}
+	}
+	return s.ReceivePackSession.ReceivePack(ctx, req)
+}
+
+// throttledReader reads r no faster than b allows, until ctx is done.
+type throttledReader struct {
+	ctx context.Context
+	r   io.ReadCloser
+	b   *bandwidth
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if n := r.b.chunk(); len(p) > n {
+		p = p[:n]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.b.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) Close() error {
+	return r.r.Close()
+}
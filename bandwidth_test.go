@@ -0,0 +1,57 @@
+package gitfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBandwidthWaitCanceled(t *testing.T) {
+	b := &bandwidth{rate: 10}
+	if err := b.wait(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := b.wait(ctx, 100); err != context.Canceled {
+		t.Fatalf("wait got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("canceled wait took %v", d)
+	}
+}
+
+func TestBandwidthPerInstance(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	limited := r.clone(NewConfig().UseMemFs().SetBandwidthLimit(1 << 20))
+	unlimited := r.clone(nil)
+	if limited.git.transport.bandwidth == nil {
+		t.Fatal("limited remote has no bandwidth")
+	}
+	if unlimited.git.transport.bandwidth != nil {
+		t.Fatal("limit of another repo applied")
+	}
+
+	writeTestFile(t, limited, "a.txt", "a")
+	if err := limited.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	b := limited.git.transport.bandwidth
+	b.mu.Lock()
+	throttled := !b.next.IsZero()
+	b.mu.Unlock()
+	if !throttled {
+		t.Fatal("transfers of the limited repo not throttled")
+	}
+	if err := unlimited.Pull(); err != nil {
+		t.Fatal(err)
+	}
+	if data := readTestFile(t, unlimited, "a.txt"); data != "a" {
+		t.Fatalf("got a.txt %q", data)
+	}
+}
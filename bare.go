@@ -0,0 +1,817 @@
+package gitfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var errReadOnlyFile = errors.New("file is opened read only")
+
+type bareEntry struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// bareFs is the worktree of a bare repo. Files are read straight from the
+// tree of HEAD, written files are kept in memory until committed. Paths
+// are slash separated and relative to the root, "" being the root itself.
+type bareFs struct {
+	repo *git.Repository
+	mu   sync.Mutex
+	// Files of the HEAD tree
+	base map[string]bareEntry
+	// Content of files written since the last commit
+	mem     billy.Filesystem
+	written map[string]bool
+	// Files of base removed since the last commit
+	deleted map[string]bool
+	// Changed paths added to the next commit
+	staged map[string]bool
+	// All directories, git itself doesn't track them
+	dirs map[string]bool
+	temp *tempAreas
+}
+
+func newBareFs(repo *git.Repository, temp *tempAreas) (*bareFs, error) {
+	fs := &bareFs{
+		repo:    repo,
+		temp:    temp,
+		mem:     memfs.New(),
+		written: map[string]bool{},
+		deleted: map[string]bool{},
+		staged:  map[string]bool{},
+		dirs:    map[string]bool{"": true},
+	}
+	if err := fs.reload(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func bareClean(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.Replace(filename, "\\", "/", -1)), "/")
+}
+
+func (fs *bareFs) addDirs(p string) {
+	for d := path.Dir(p); d != "." && d != "/"; d = path.Dir(d) {
+		fs.dirs[d] = true
+	}
+}
+
+// reload reads base from the tree of HEAD, keeping changes not yet
+// committed.
+func (fs *bareFs) reload() error {
+	base := map[string]bareEntry{}
+	head, err := fs.repo.Head()
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+	if err == nil {
+		c, err := fs.repo.CommitObject(head.Hash())
+		if err != nil {
+			return errors.Wrapf(err, "error reading HEAD commit")
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return errors.Wrapf(err, "error reading HEAD tree")
+		}
+		if err := tree.Files().ForEach(func(f *object.File) error {
+			base[f.Name] = bareEntry{hash: f.Hash, mode: f.Mode}
+			return nil
+		}); err != nil {
+			return errors.Wrapf(err, "error reading HEAD tree")
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.base = base
+	for p := range base {
+		fs.addDirs(p)
+	}
+	for p := range fs.deleted {
+		if _, ok := base[p]; !ok {
+			delete(fs.deleted, p)
+		}
+	}
+	return nil
+}
+
+// isFile reports whether p is an existing file, assuming fs.mu is held.
+func (fs *bareFs) isFile(p string) bool {
+	if fs.written[p] {
+		return true
+	}
+	_, ok := fs.base[p]
+	return ok && !fs.deleted[p]
+}
+
+// files returns all existing files, assuming fs.mu is held.
+func (fs *bareFs) files() []string {
+	var files []string
+	for p := range fs.base {
+		if !fs.deleted[p] && !fs.written[p] {
+			files = append(files, p)
+		}
+	}
+	for p := range fs.written {
+		files = append(files, p)
+	}
+	return files
+}
+
+func (fs *bareFs) readBlob(h plumbing.Hash) ([]byte, error) {
+	blob, err := fs.repo.BlobObject(h)
+	if err != nil {
+		return nil, err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// copyToMem makes the base file p writable by copying it to mem, assuming
+// fs.mu is held.
+func (fs *bareFs) copyToMem(p string) error {
+	e := fs.base[p]
+	data, err := fs.readBlob(e.hash)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v", p)
+	}
+	mode, _ := e.mode.ToOSFileMode()
+	if e.mode == filemode.Symlink {
+		err = fs.mem.Symlink(string(data), p)
+	} else {
+		err = util.WriteFile(fs.mem, p, data, mode.Perm())
+	}
+	if err != nil {
+		return err
+	}
+	fs.written[p] = true
+	return nil
+}
+
+// resolve returns the path of filename once symlinks are followed.
+func (fs *bareFs) resolve(filename string) (string, error) {
+	p := bareClean(filename)
+	for i := 0; i < 40; i++ {
+		fi, err := fs.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+		target, err := fs.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			p = bareClean(target)
+		} else {
+			p = bareClean(path.Join(path.Dir(p), target))
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: filename, Err: errors.New("too many levels of symbolic links")}
+}
+
+func (fs *bareFs) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *bareFs) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *bareFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p, err := fs.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.dirs[p] {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+	}
+	if fs.written[p] {
+		return fs.mem.OpenFile(p, flag, perm)
+	}
+	if fs.isFile(p) {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+		}
+		if !isWriteFlag(flag) {
+			obj, err := fs.repo.Storer.EncodedObject(plumbing.BlobObject, fs.base[p].hash)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error reading %v", filename)
+			}
+			return &bareFile{blobReader: newBlobReader(obj, filename)}, nil
+		}
+		if err := fs.copyToMem(p); err != nil {
+			return nil, err
+		}
+		return fs.mem.OpenFile(p, flag&^os.O_EXCL, perm)
+	}
+
+	if flag&os.O_CREATE == 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	f, err := fs.mem.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	fs.written[p] = true
+	delete(fs.deleted, p)
+	fs.addDirs(p)
+	return f, nil
+}
+
+func (fs *bareFs) Stat(filename string) (os.FileInfo, error) {
+	p, err := fs.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Lstat(p)
+}
+
+func (fs *bareFs) Lstat(filename string) (os.FileInfo, error) {
+	p := bareClean(filename)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.written[p] {
+		return fs.mem.Lstat(p)
+	}
+	if fs.isFile(p) {
+		e := fs.base[p]
+		obj, err := fs.repo.Storer.EncodedObject(plumbing.BlobObject, e.hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v", filename)
+		}
+		mode, _ := e.mode.ToOSFileMode()
+		return &bareFileInfo{name: path.Base(p), size: obj.Size(), mode: mode}, nil
+	}
+	if fs.dirs[p] {
+		return &bareFileInfo{name: path.Base("/" + p), mode: os.ModeDir | 0755}, nil
+	}
+	return nil, &os.PathError{Op: "lstat", Path: filename, Err: os.ErrNotExist}
+}
+
+func (fs *bareFs) Rename(oldpath, newpath string) error {
+	from, to := bareClean(oldpath), bareClean(newpath)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	moves := map[string]string{}
+	if fs.isFile(from) {
+		moves[from] = to
+	} else if fs.dirs[from] && from != "" {
+		for _, p := range fs.files() {
+			if strings.HasPrefix(p, from+"/") {
+				moves[p] = to + p[len(from):]
+			}
+		}
+		for d := range fs.dirs {
+			if d == from || strings.HasPrefix(d, from+"/") {
+				delete(fs.dirs, d)
+				fs.dirs[to+d[len(from):]] = true
+			}
+		}
+	} else {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+
+	for src, dst := range moves {
+		if !fs.written[src] {
+			if err := fs.copyToMem(src); err != nil {
+				return err
+			}
+		}
+		if err := fs.moveMem(src, dst); err != nil {
+			return errors.Wrapf(err, "error renaming %v", src)
+		}
+		delete(fs.written, src)
+		if _, ok := fs.base[src]; ok {
+			fs.deleted[src] = true
+		}
+		fs.written[dst] = true
+		delete(fs.deleted, dst)
+		fs.addDirs(dst)
+	}
+	return nil
+}
+
+// moveMem moves file src of mem to dst, replacing it.
+func (fs *bareFs) moveMem(src, dst string) error {
+	fi, err := fs.mem.Lstat(src)
+	if err != nil {
+		return err
+	}
+	fs.mem.Remove(dst)
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.mem.Readlink(src)
+		if err != nil {
+			return err
+		}
+		err = fs.mem.Symlink(target, dst)
+	} else {
+		data, err := readFile(fs.mem, src)
+		if err != nil {
+			return err
+		}
+		err = util.WriteFile(fs.mem, dst, data, fi.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	return fs.mem.Remove(src)
+}
+
+func (fs *bareFs) Remove(filename string) error {
+	p := bareClean(filename)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.isFile(p) {
+		if fs.written[p] {
+			fs.mem.Remove(p)
+			delete(fs.written, p)
+		}
+		if _, ok := fs.base[p]; ok {
+			fs.deleted[p] = true
+		}
+		return nil
+	}
+	if !fs.dirs[p] || p == "" {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrNotExist}
+	}
+	for _, f := range fs.files() {
+		if strings.HasPrefix(f, p+"/") {
+			return &os.PathError{Op: "remove", Path: filename, Err: errors.New("directory not empty")}
+		}
+	}
+	for d := range fs.dirs {
+		if strings.HasPrefix(d, p+"/") {
+			return &os.PathError{Op: "remove", Path: filename, Err: errors.New("directory not empty")}
+		}
+	}
+	delete(fs.dirs, p)
+	return nil
+}
+
+func (fs *bareFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (fs *bareFs) TempFile(dir, prefix string) (billy.File, error) {
+	d := bareClean(dir)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := fs.mem.TempFile(d, prefix)
+	if err != nil {
+		return nil, err
+	}
+	p := bareClean(f.Name())
+	fs.written[p] = true
+	delete(fs.deleted, p)
+	fs.addDirs(p)
+	return f, nil
+}
+
+func (fs *bareFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	p, err := fs.resolve(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	if !fs.dirs[p] {
+		fs.mu.Unlock()
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: os.ErrNotExist}
+	}
+	prefix := p + "/"
+	if p == "" {
+		prefix = ""
+	}
+	names := map[string]bool{}
+	for _, f := range fs.files() {
+		if strings.HasPrefix(f, prefix) && !strings.Contains(f[len(prefix):], "/") {
+			names[f] = true
+		}
+	}
+	for d := range fs.dirs {
+		if d != "" && path.Dir("/"+d) == "/"+p {
+			names[d] = true
+		}
+	}
+	fs.mu.Unlock()
+
+	var fis []os.FileInfo
+	for name := range names {
+		fi, err := fs.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+func (fs *bareFs) MkdirAll(filename string, perm os.FileMode) error {
+	p := bareClean(filename)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for d := p; d != "" && d != "."; d = strings.TrimPrefix(path.Dir("/"+d), "/") {
+		if fs.isFile(d) {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: errors.New("not a directory")}
+		}
+	}
+	fs.dirs[p] = true
+	fs.addDirs(p)
+	return nil
+}
+
+func (fs *bareFs) Symlink(target, link string) error {
+	p := bareClean(link)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.isFile(p) || fs.dirs[p] {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+	if err := fs.mem.Symlink(target, p); err != nil {
+		return err
+	}
+	fs.written[p] = true
+	delete(fs.deleted, p)
+	fs.addDirs(p)
+	return nil
+}
+
+func (fs *bareFs) Readlink(link string) (string, error) {
+	p := bareClean(link)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.written[p] {
+		return fs.mem.Readlink(p)
+	}
+	if !fs.isFile(p) {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: os.ErrNotExist}
+	}
+	e := fs.base[p]
+	if e.mode != filemode.Symlink {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: errors.New("not a symlink")}
+	}
+	data, err := fs.readBlob(e.hash)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading %v", link)
+	}
+	return string(data), nil
+}
+
+func (fs *bareFs) Chroot(p string) (billy.Filesystem, error) {
+	return chroot.New(fs, p), nil
+}
+
+func (fs *bareFs) Root() string {
+	return "/"
+}
+
+// status returns the changes since the last commit. Like with a worktree,
+// files are untracked or changed in the worktree until staged.
+func (fs *bareFs) status() (git.Status, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	s := git.Status{}
+	for p := range fs.written {
+		if fs.temp.contains(p) {
+			continue
+		}
+		h, mode, err := fs.memEntry(p)
+		if err != nil {
+			return nil, err
+		}
+		code := git.Untracked
+		if e, ok := fs.base[p]; ok {
+			if e.hash == h && e.mode == mode {
+				continue
+			}
+			code = git.Modified
+		}
+		s[p] = fs.fileStatus(p, code)
+	}
+	for p := range fs.deleted {
+		if !fs.written[p] {
+			s[p] = fs.fileStatus(p, git.Deleted)
+		}
+	}
+	return s, nil
+}
+
+func (fs *bareFs) fileStatus(p string, code git.StatusCode) *git.FileStatus {
+	if fs.staged[p] {
+		if code == git.Untracked {
+			code = git.Added
+		}
+		return &git.FileStatus{Staging: code, Worktree: git.Unmodified}
+	}
+	if code == git.Untracked {
+		return &git.FileStatus{Staging: git.Untracked, Worktree: git.Untracked}
+	}
+	return &git.FileStatus{Staging: git.Unmodified, Worktree: code}
+}
+
+// memEntry returns the blob hash and mode of the written file p.
+func (fs *bareFs) memEntry(p string) (plumbing.Hash, filemode.FileMode, error) {
+	fi, err := fs.mem.Lstat(p)
+	if err != nil {
+		return plumbing.ZeroHash, 0, err
+	}
+	var data []byte
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var target string
+		target, err = fs.mem.Readlink(p)
+		data = []byte(target)
+	} else {
+		data, err = readFile(fs.mem, p)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, 0, errors.Wrapf(err, "error reading %v", p)
+	}
+	mode, err := filemode.NewFromOSFileMode(fi.Mode())
+	if err != nil {
+		return plumbing.ZeroHash, 0, err
+	}
+	if mode == filemode.Deprecated {
+		mode = filemode.Regular
+	}
+	return plumbing.ComputeHash(plumbing.BlobObject, data), mode, nil
+}
+
+// stage adds the changes of paths, and of files under them, to the next
+// commit, all changes if paths is nil.
+func (fs *bareFs) stage(paths []string) error {
+	s, err := fs.status()
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for p := range s {
+		if paths == nil || underAny(p, paths) {
+			fs.staged[p] = true
+		}
+	}
+	return nil
+}
+
+// bareCommit commits the staged changes, only those under paths unless nil,
+// to the branch of HEAD, and with all the changes of tracked files too.
+// Commits merged follow HEAD as parents.
+func (g *Git) bareCommit(msg string, sig *object.Signature, all bool, paths []string, merged []plumbing.Hash) (plumbing.Hash, error) {
+	fs := g.bare
+	s, err := fs.status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	files := map[string]bareEntry{}
+	for p, e := range fs.base {
+		files[p] = e
+	}
+	var committed []string
+	for p, fstatus := range s {
+		code := fstatus.Staging
+		if all && (fstatus.Worktree == git.Modified || fstatus.Worktree == git.Deleted) {
+			code = fstatus.Worktree
+		}
+		if code == git.Unmodified || code == git.Untracked || paths != nil && !underAny(p, paths) {
+			continue
+		}
+
+		committed = append(committed, p)
+		if code == git.Deleted {
+			delete(files, p)
+			continue
+		}
+		e, err := fs.storeMem(p)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		files[p] = e
+	}
+
+	tree, err := g.storeTree(files, "")
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error storing tree")
+	}
+
+	c := &object.Commit{
+		Author:    *sig,
+		Committer: *sig,
+		Message:   msg,
+		TreeHash:  tree,
+	}
+	head, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error reading HEAD")
+	}
+	if parent, err := g.repo.Reference(head.Target(), true); err == nil {
+		c.ParentHashes = []plumbing.Hash{parent.Hash()}
+	} else if err != plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error reading %v", head.Target())
+	}
+	c.ParentHashes = append(c.ParentHashes, merged...)
+
+	if g.pgpKey != nil {
+		payload, err := commitPayload(c)
+		if err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "error encoding commit")
+		}
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, g.pgpKey, bytes.NewReader(payload), nil); err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "error signing commit")
+		}
+		c.PGPSignature = sig.String()
+	}
+
+	hash, err := g.storeCommit(c)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error storing commit")
+	}
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(head.Target(), hash)); err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error updating %v", head.Target())
+	}
+
+	for _, p := range committed {
+		if e, ok := files[p]; ok {
+			fs.base[p] = e
+		} else {
+			delete(fs.base, p)
+		}
+		if fs.written[p] {
+			fs.mem.Remove(p)
+			delete(fs.written, p)
+		}
+		delete(fs.deleted, p)
+		delete(fs.staged, p)
+	}
+	return hash, nil
+}
+
+// storeMem stores the written file p as blob, assuming fs.mu is held.
+func (fs *bareFs) storeMem(p string) (bareEntry, error) {
+	_, mode, err := fs.memEntry(p)
+	if err != nil {
+		return bareEntry{}, err
+	}
+	var data []byte
+	if mode == filemode.Symlink {
+		var target string
+		target, err = fs.mem.Readlink(p)
+		data = []byte(target)
+	} else {
+		data, err = readFile(fs.mem, p)
+	}
+	if err != nil {
+		return bareEntry{}, errors.Wrapf(err, "error reading %v", p)
+	}
+
+	obj := fs.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return bareEntry{}, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return bareEntry{}, err
+	}
+	if err := w.Close(); err != nil {
+		return bareEntry{}, err
+	}
+	h, err := fs.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return bareEntry{}, errors.Wrapf(err, "error storing %v", p)
+	}
+	return bareEntry{hash: h, mode: mode}, nil
+}
+
+// storeTree stores the tree of the files under dir, and its subtrees.
+func (g *Git) storeTree(files map[string]bareEntry, dir string) (plumbing.Hash, error) {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+
+	tree := &object.Tree{}
+	subdirs := map[string]bool{}
+	for p, e := range files {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		name := p[len(prefix):]
+		if i := strings.Index(name, "/"); i >= 0 {
+			subdirs[name[:i]] = true
+			continue
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: e.mode, Hash: e.hash})
+	}
+	for name := range subdirs {
+		h, err := g.storeTree(files, prefix+name)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: h})
+	}
+
+	// git sorts directories as if their names ended with a slash
+	key := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return key(tree.Entries[i]) < key(tree.Entries[j])
+	})
+
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// bareFile is a file of the HEAD tree opened for reading, streamed from
+// the object store.
+type bareFile struct {
+	*blobReader
+}
+
+func (f *bareFile) Name() string {
+	return f.name
+}
+
+func (f *bareFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: errReadOnlyFile}
+}
+
+func (f *bareFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: errReadOnlyFile}
+}
+
+func (f *bareFile) Lock() error {
+	return nil
+}
+
+func (f *bareFile) Unlock() error {
+	return nil
+}
+
+type bareFileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func (fi *bareFileInfo) Name() string       { return fi.name }
+func (fi *bareFileInfo) Size() int64        { return fi.size }
+func (fi *bareFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *bareFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *bareFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *bareFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,111 @@
+package gitfs
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// ErrLargeBinary is returned by Sync refusing to commit new binary files
+// above the size limit, see Config.RefuseLargeBinaries.
+var ErrLargeBinary = errors.New("large binary file")
+
+// IsBinary reports whether the named file is binary rather than text, like
+// git tells: by its text attribute if .gitattributes are used, see
+// Config.UseGitAttributes, and else by a NUL byte in its first 8000 bytes.
+// Files stored compressed by WriteFile are sniffed decompressed.
+func (g *GitFs) IsBinary(filename string) (binary bool, err error) {
+	defer g.git.trace("gitfs.IsBinary")(&err)
+
+	if err := g.checkPath("read", filename); err != nil {
+		return false, err
+	}
+	if g.git != nil {
+		if text, ok, err := g.git.textAttribute(g.repoPath(filename)); err != nil || ok {
+			return !text, err
+		}
+	}
+
+	binary, err = sniffBinary(g.fs, filename, false)
+	if os.IsNotExist(err) {
+		if zbinary, zerr := sniffBinary(g.fs, filename+compressedExt, true); zerr == nil {
+			return zbinary, nil
+		}
+	}
+	return binary, err
+}
+
+// sniffBinary reads the head of filename, decompressing it if compressed,
+// and reports whether it looks binary.
+func sniffBinary(fs billy.Filesystem, filename string, compressed bool) (bool, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if compressed {
+		if r, err = gzip.NewReader(f); err != nil {
+			return false, errors.Wrapf(err, "error decompressing %v", filename)
+		}
+	}
+	head := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, errors.Wrapf(err, "error reading %v", filename)
+	}
+	return looksBinary(head[:n]), nil
+}
+
+// largeBinaries returns the new files about to be committed by Sync which
+// are binary and larger than the binary size limit.
+func (g *GitFs) largeBinaries() ([]string, error) {
+	changes, err := g.syncChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	var large []string
+	for _, c := range changes {
+		if c.Status != Added {
+			continue
+		}
+		fi, err := g.fs.Lstat(c.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v", c.Path)
+		}
+		if !fi.Mode().IsRegular() || fi.Size() <= g.binaryLimit {
+			continue
+		}
+		binary, err := g.IsBinary(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		if binary {
+			large = append(large, c.Path)
+		}
+	}
+	return large, nil
+}
+
+// checkLargeBinaries fails with ErrLargeBinary if refusing large binaries
+// and there are any, else reports them in res.
+func (g *GitFs) checkLargeBinaries(res *SyncResult) error {
+	if g.binaryLimit <= 0 {
+		return nil
+	}
+	large, err := g.largeBinaries()
+	if err != nil {
+		return err
+	}
+	if len(large) > 0 && g.refuseBinary {
+		return errors.Wrapf(ErrLargeBinary, "%v above %v bytes", strings.Join(large, ", "), g.binaryLimit)
+	}
+	res.LargeBinaries = large
+	return nil
+}
@@ -0,0 +1,71 @@
+package gitfs
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// BlobHash returns the git blob hash of filename, e.g. for HTTP ETags or to
+// tell cheaply whether a file changed. It is the hash HashContent returns
+// for its content, and for a symlink the hash of its target, like git.
+// Files stored compressed by WriteFile hash as stored. Files unchanged since
+// they were last staged, by size and mtime, are not read at all, others
+// are hashed as they are in the worktree.
+func (g *GitFs) BlobHash(filename string) (hash string, err error) {
+	defer g.git.trace("gitfs.BlobHash")(&err)
+
+	if err := g.checkPath("hash", filename); err != nil {
+		return "", err
+	}
+	h, err := g.blobHash(filename)
+	if os.IsNotExist(err) {
+		if zh, zerr := g.blobHash(filename + compressedExt); zerr == nil {
+			return zh.String(), nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+func (g *GitFs) blobHash(filename string) (plumbing.Hash, error) {
+	fi, err := g.fs.Lstat(filename)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if h, ok := g.git.stagedHash(g.repoPath(filename), fi); ok {
+		return h, nil
+	}
+	return hashFile(g.fs, filename, fi)
+}
+
+// stagedHash returns the hash of p, a slash separated path relative to the
+// repo root, if the file is known to be unchanged since it was staged.
+func (g *Git) stagedHash(p string, fi os.FileInfo) (plumbing.Hash, bool) {
+	if g == nil {
+		return plumbing.ZeroHash, false
+	}
+	if g.bare != nil {
+		g.bare.mu.Lock()
+		defer g.bare.mu.Unlock()
+		e, ok := g.bare.base[p]
+		return e.hash, ok && !g.bare.written[p] && !g.bare.deleted[p]
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	e, err := idx.Entry(p)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	// like git, an mtime too recent may hide a change within its granularity
+	if int64(e.Size) != fi.Size() || !e.ModifiedAt.Equal(fi.ModTime()) || time.Since(fi.ModTime()) <= racyWindow {
+		return plumbing.ZeroHash, false
+	}
+	return e.Hash, true
+}
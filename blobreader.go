@@ -0,0 +1,161 @@
+package gitfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// blobWindow is how much of a blob a blobReader keeps of what it last
+// read, so seeking back within it doesn't read the blob again.
+const blobWindow = 1 << 20
+
+// blobReader reads a blob straight from the object store in chunks, so
+// memory use doesn't grow with the blob size. Objects can only be read
+// from their start, so the last blobWindow bytes read are kept: seeking
+// back within them is served from memory, seeking back further reopens
+// the blob and reads it again up to the offset. Blobs stored as deltas in
+// packfiles are still resolved in memory by go-git.
+type blobReader struct {
+	obj  plumbing.EncodedObject
+	name string
+
+	mu sync.Mutex
+	r  io.ReadCloser
+	// Position of r, the last bytes read up to it, and offset of the next
+	// Read
+	pos int64
+	win []byte
+	off int64
+}
+
+func newBlobReader(obj plumbing.EncodedObject, name string) *blobReader {
+	return &blobReader{obj: obj, name: name}
+}
+
+// readAt reads len(p) bytes at off, reopening the blob if off is before
+// the window, assuming mu is held.
+func (b *blobReader) readAt(p []byte, off int64) (int, error) {
+	if b.r == nil || off < b.pos-int64(len(b.win)) {
+		if err := b.reopen(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for n < len(p) {
+		cur := off + int64(n)
+		if cur >= b.obj.Size() {
+			return n, io.EOF
+		}
+		if cur < b.pos {
+			n += copy(p[n:], b.win[int64(len(b.win))-(b.pos-cur):])
+			continue
+		}
+		if err := b.fill(cur); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (b *blobReader) reopen() error {
+	if b.r != nil {
+		b.r.Close()
+	}
+	r, err := b.obj.Reader()
+	if err != nil {
+		b.r = nil
+		return errors.Wrapf(err, "error reading blob of %v", b.name)
+	}
+	b.r, b.pos, b.win = r, 0, b.win[:0]
+	return nil
+}
+
+// fill reads the next chunk of the blob into the window, skipping what
+// lies before off and out of the window.
+func (b *blobReader) fill(off int64) error {
+	if skip := off - b.pos - blobWindow; skip > 0 {
+		n, err := io.CopyN(ioutil.Discard, b.r, skip)
+		b.pos += n
+		b.win = b.win[:0]
+		if err != nil {
+			return errors.Wrapf(err, "error reading blob of %v", b.name)
+		}
+	}
+
+	if cap(b.win) == 0 {
+		b.win = make([]byte, 0, 2*blobWindow)
+	} else if len(b.win) == cap(b.win) {
+		b.win = append(b.win[:0], b.win[len(b.win)-blobWindow:]...)
+	}
+	end := len(b.win) + 32<<10
+	if end > cap(b.win) {
+		end = cap(b.win)
+	}
+	n, err := b.r.Read(b.win[len(b.win):end])
+	b.win = b.win[:len(b.win)+n]
+	b.pos += int64(n)
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrapf(err, "error reading blob of %v", b.name)
+	}
+	return nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.readAt(p, b.off)
+	b.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (b *blobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: b.name, Err: errors.New("negative offset")}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readAt(p, off)
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += b.obj.Size()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: b.name, Err: errors.New("negative offset")}
+	}
+	b.off = offset
+	return offset, nil
+}
+
+func (b *blobReader) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.r == nil {
+		return nil
+	}
+	err := b.r.Close()
+	b.r, b.win = nil, nil
+	return err
+}
@@ -0,0 +1,63 @@
+package gitfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// countingObject counts the readers opened of its content.
+type countingObject struct {
+	plumbing.EncodedObject
+	opened int
+}
+
+func (o *countingObject) Reader() (io.ReadCloser, error) {
+	o.opened++
+	return o.EncodedObject.Reader()
+}
+
+func TestBlobReaderSeeksBackWithinWindow(t *testing.T) {
+	data := make([]byte, 3*blobWindow+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	mem := &plumbing.MemoryObject{}
+	mem.SetType(plumbing.BlobObject)
+	mem.Write(data)
+	obj := &countingObject{EncodedObject: mem}
+	b := newBlobReader(obj, "big.bin")
+	defer b.Close()
+
+	read, err := ioutil.ReadAll(io.LimitReader(b, 2*blobWindow))
+	if err != nil || !bytes.Equal(read, data[:2*blobWindow]) {
+		t.Fatalf("read %v bytes, %v", len(read), err)
+	}
+	p := make([]byte, 4096)
+	for _, off := range []int64{2*blobWindow - 4096, blobWindow + 17, 2*blobWindow + 5, 3*blobWindow - 4096} {
+		n, err := b.ReadAt(p, off)
+		if err != nil || !bytes.Equal(p[:n], data[off:off+int64(n)]) {
+			t.Fatalf("ReadAt %v got %v bytes, %v", off, n, err)
+		}
+	}
+	if obj.opened != 1 {
+		t.Fatalf("blob opened %v times seeking back within the window", obj.opened)
+	}
+
+	if _, err := b.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	read, err = ioutil.ReadAll(b)
+	if err != nil || !bytes.Equal(read, data[10:]) {
+		t.Fatalf("read %v bytes, %v", len(read), err)
+	}
+	if obj.opened != 2 {
+		t.Fatalf("blob opened %v times, want 2", obj.opened)
+	}
+	if n, err := b.ReadAt(p, int64(len(data))-10); n != 10 || err != io.EOF {
+		t.Fatalf("ReadAt at the end got %v, %v", n, err)
+	}
+}
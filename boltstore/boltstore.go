@@ -0,0 +1,376 @@
+// Package boltstore implements a go-git storage.Storer keeping all git
+// objects, refs and metadata in a single bolt database file. Use it with
+// gitfs.Config.SetStorer.
+package boltstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+var (
+	objectsBucket = []byte("objects")
+	refsBucket    = []byte("refs")
+	metaBucket    = []byte("meta")
+
+	configKey  = []byte("config")
+	indexKey   = []byte("index")
+	shallowKey = []byte("shallow")
+)
+
+// Storage is a storage.Storer backed by a bolt database.
+type Storage struct {
+	db *bolt.DB
+	// bucket name prefix, used to namespace submodules
+	prefix string
+}
+
+var _ storage.Storer = (*Storage)(nil)
+
+// Open opens, creating it if needed, the bolt database at path.
+func Open(path string) (*Storage, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening bolt db %v", path)
+	}
+	return &Storage{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) bucket(name []byte) []byte {
+	return append([]byte(s.prefix), name...)
+}
+
+func (s *Storage) get(bucket, key []byte) ([]byte, error) {
+	var v []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(s.bucket(bucket)); b != nil {
+			if data := b.Get(key); data != nil {
+				v = append([]byte{}, data...)
+			}
+		}
+		return nil
+	})
+	return v, err
+}
+
+func (s *Storage) put(bucket, key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+// --- objects, stored as type byte followed by content ---
+
+func (s *Storage) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+func (s *Storage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	switch obj.Type() {
+	case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+	default:
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := obj.Hash()
+	return h, s.put(objectsBucket, h[:], append([]byte{byte(obj.Type())}, content...))
+}
+
+func decodeObject(data []byte) (plumbing.EncodedObject, error) {
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.ObjectType(data[0]))
+	if _, err := obj.Write(data[1:]); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *Storage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	data, err := s.get(objectsBucket, h[:])
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || (t != plumbing.AnyObject && plumbing.ObjectType(data[0]) != t) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	return decodeObject(data)
+}
+
+func (s *Storage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	var hashes []plumbing.Hash
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket(objectsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if t == plumbing.AnyObject || plumbing.ObjectType(v[0]) == t {
+				var h plumbing.Hash
+				copy(h[:], k)
+				hashes = append(hashes, h)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return &objectIter{s: s, t: t, hashes: hashes}, nil
+}
+
+func (s *Storage) HasEncodedObject(h plumbing.Hash) error {
+	data, err := s.get(objectsBucket, h[:])
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return plumbing.ErrObjectNotFound
+	}
+	return nil
+}
+
+func (s *Storage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	data, err := s.get(objectsBucket, h[:])
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, plumbing.ErrObjectNotFound
+	}
+	return int64(len(data) - 1), nil
+}
+
+// objectIter loads objects lazily, so iterating a large store doesn't hold
+// all of them in memory.
+type objectIter struct {
+	s      *Storage
+	t      plumbing.ObjectType
+	hashes []plumbing.Hash
+}
+
+func (it *objectIter) Next() (plumbing.EncodedObject, error) {
+	if len(it.hashes) == 0 {
+		return nil, storer.ErrStop
+	}
+	h := it.hashes[0]
+	it.hashes = it.hashes[1:]
+	return it.s.EncodedObject(it.t, h)
+}
+
+func (it *objectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	for {
+		obj, err := it.Next()
+		if err == storer.ErrStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := cb(obj); err == storer.ErrStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (it *objectIter) Close() {
+	it.hashes = nil
+}
+
+// --- references, stored as name to target ---
+
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+	parts := ref.Strings()
+	return s.put(refsBucket, []byte(parts[0]), []byte(parts[1]))
+}
+
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket(refsBucket))
+		if err != nil {
+			return err
+		}
+
+		parts := ref.Strings()
+		if old != nil {
+			if cur := b.Get([]byte(parts[0])); cur != nil {
+				if plumbing.NewReferenceFromStrings(parts[0], string(cur)).Hash() != old.Hash() {
+					return storage.ErrReferenceHasChanged
+				}
+			}
+		}
+		return b.Put([]byte(parts[0]), []byte(parts[1]))
+	})
+}
+
+func (s *Storage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	data, err := s.get(refsBucket, []byte(n))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+	return plumbing.NewReferenceFromStrings(string(n), string(data)), nil
+}
+
+func (s *Storage) IterReferences() (storer.ReferenceIter, error) {
+	var refs []*plumbing.Reference
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket(refsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			refs = append(refs, plumbing.NewReferenceFromStrings(string(k), string(v)))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+func (s *Storage) RemoveReference(n plumbing.ReferenceName) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket(refsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(n))
+	})
+}
+
+func (s *Storage) CountLooseRefs() (int, error) {
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(s.bucket(refsBucket)); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *Storage) PackRefs() error {
+	return nil
+}
+
+// --- shallow commits, index and config ---
+
+func (s *Storage) SetShallow(commits []plumbing.Hash) error {
+	var buf bytes.Buffer
+	for _, h := range commits {
+		buf.WriteString(h.String())
+		buf.WriteByte('\n')
+	}
+	return s.put(metaBucket, shallowKey, buf.Bytes())
+}
+
+func (s *Storage) Shallow() ([]plumbing.Hash, error) {
+	data, err := s.get(metaBucket, shallowKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []plumbing.Hash
+	for _, line := range strings.Fields(string(data)) {
+		commits = append(commits, plumbing.NewHash(line))
+	}
+	return commits, nil
+}
+
+func (s *Storage) SetIndex(idx *index.Index) error {
+	var buf bytes.Buffer
+	if err := index.NewEncoder(&buf).Encode(idx); err != nil {
+		return errors.Wrapf(err, "error encoding index")
+	}
+	return s.put(metaBucket, indexKey, buf.Bytes())
+}
+
+func (s *Storage) Index() (*index.Index, error) {
+	data, err := s.get(metaBucket, indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index.Index{Version: 2}
+	if data == nil {
+		return idx, nil
+	}
+	if err := index.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return nil, errors.Wrapf(err, "error decoding index")
+	}
+	return idx, nil
+}
+
+func (s *Storage) SetConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	data, err := cfg.Marshal()
+	if err != nil {
+		return errors.Wrapf(err, "error encoding config")
+	}
+	return s.put(metaBucket, configKey, data)
+}
+
+func (s *Storage) Config() (*config.Config, error) {
+	data, err := s.get(metaBucket, configKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.NewConfig()
+	if data == nil {
+		return cfg, nil
+	}
+	if err := cfg.Unmarshal(data); err != nil {
+		return nil, errors.Wrapf(err, "error decoding config")
+	}
+	return cfg, nil
+}
+
+// Module returns the storage of the named submodule, sharing the database.
+func (s *Storage) Module(name string) (storage.Storer, error) {
+	return &Storage{
+		db:     s.db,
+		prefix: s.prefix + "module/" + name + "/",
+	}, nil
+}
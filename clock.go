@@ -0,0 +1,111 @@
+package gitfs
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells gitfs the time commits are made at and AutoSync polls at, see
+// Config.SetClock.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a channel receiving the time every d, like
+	// time.NewTicker, and the func stopping it.
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock is the Clock of the system time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// epochClock stamps commits at the Unix epoch, for Config.Deterministic,
+// ticking on the system time.
+type epochClock struct {
+	systemClock
+}
+
+func (epochClock) Now() time.Time {
+	return time.Unix(0, 0).UTC()
+}
+
+// clockOrDefault returns the clock set by c, by default the system time,
+// or the epoch if deterministic.
+func (c *Config) clockOrDefault() Clock {
+	switch {
+	case c.clock != nil:
+		return c.clock
+	case c.deterministic:
+		return epochClock{}
+	}
+	return systemClock{}
+}
+
+// ManualClock is a Clock standing still until moved by Add, for tests.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+type manualTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewManualClock returns a ManualClock at now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time of c.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker firing as Add moves c past its periods.
+func (c *ManualClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t.c, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, o := range c.tickers {
+			if o == t {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Add moves c forward by d, firing the tickers whose periods pass. Like
+// those of time.Ticker, ticks not yet received are dropped.
+func (c *ManualClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
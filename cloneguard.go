@@ -0,0 +1,136 @@
+package gitfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// cloneMarker is the file in the git dir marking a clone in progress.
+const cloneMarker = "gitfs-cloning"
+
+// cloneGuard removes what a clone into a dir on disk left behind when
+// canceled or killed midway, so the next New clones afresh rather than
+// opening a broken repo. A marker holds the owner of the clone, as lock
+// files do, and the entries the dir had before it.
+type cloneGuard struct {
+	fs     billy.Filesystem
+	marker string
+}
+
+// newCloneGuard returns the guard of the osFs base dir or worktree fs of
+// c, nil if the repo is kept in memory or by a custom storer.
+func newCloneGuard(c *Config) *cloneGuard {
+	switch {
+	case c.storer != nil || c.useMemFs:
+		return nil
+	case c.bare:
+		return &cloneGuard{fs: osfs.New(c.osFsBaseDir), marker: cloneMarker}
+	case c.worktreeFs != nil:
+		return &cloneGuard{fs: c.worktreeFs, marker: path.Join(git.GitDirName, cloneMarker)}
+	}
+	return &cloneGuard{fs: osfs.New(c.osFsBaseDir), marker: path.Join(git.GitDirName, cloneMarker)}
+}
+
+// mark records a clone starting.
+func (c *cloneGuard) mark() error {
+	infos, err := c.fs.ReadDir("/")
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error reading clone dir")
+	}
+
+	var b bytes.Buffer
+	host, _ := os.Hostname()
+	fmt.Fprintf(&b, "%v %v %v\n", os.Getpid(), host, time.Now().UnixNano())
+	for _, fi := range infos {
+		fmt.Fprintln(&b, fi.Name())
+	}
+	return errors.Wrapf(util.WriteFile(c.fs, c.marker, b.Bytes(), 0644), "error writing %v", c.marker)
+}
+
+// done records the clone finished.
+func (c *cloneGuard) done() error {
+	if err := c.fs.Remove(c.marker); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error removing %v", c.marker)
+	}
+	return nil
+}
+
+// removeInterrupted removes what a clone no longer running left behind, if
+// any, failing if it still runs.
+func (c *cloneGuard) removeInterrupted() error {
+	if _, err := c.fs.Stat(c.marker); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error checking %v", c.marker)
+	}
+	owner, ok := readLockOwner(c.fs, c.marker)
+	if ok && !owner.gone() && owner.pid != os.Getpid() {
+		return errors.Errorf("repo is being cloned by process %v on %v, if it no longer runs remove %v",
+			owner.pid, owner.host, c.marker)
+	}
+	return c.remove()
+}
+
+// remove removes what the clone added to the dir, the marker last, so
+// removals cut short are taken up again.
+func (c *cloneGuard) remove() error {
+	data, err := readFile(c.fs, c.marker)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading %v", c.marker)
+	}
+	kept := map[string]bool{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		kept[s.Text()] = true
+	}
+
+	gitDir := path.Dir(c.marker)
+	removeAll := func(dir string, keep func(name string) bool) error {
+		infos, err := c.fs.ReadDir(dir)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %v", dir)
+		}
+		for _, fi := range infos {
+			p := strings.TrimPrefix(path.Join(dir, fi.Name()), "/")
+			if keep(p) || p == c.marker {
+				continue
+			}
+			if err := util.RemoveAll(c.fs, p); err != nil {
+				return errors.Wrapf(err, "error removing %v of partial clone", p)
+			}
+		}
+		return nil
+	}
+	if err := removeAll("/", func(p string) bool {
+		return kept[p] || p == gitDir
+	}); err != nil {
+		return err
+	}
+	if gitDir != "." {
+		if err := removeAll(gitDir, func(string) bool { return false }); err != nil {
+			return err
+		}
+	}
+	if err := c.done(); err != nil {
+		return err
+	}
+	if gitDir != "." {
+		if err := c.fs.Remove(gitDir); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error removing %v of partial clone", gitDir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package gitfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// undeletableFs fails to remove anything.
+type undeletableFs struct {
+	billy.Filesystem
+}
+
+func (fs undeletableFs) Remove(filename string) error {
+	return errors.New("remove refused")
+}
+
+func TestFailedCloneReportsCleanupError(t *testing.T) {
+	newTestRemote(t, nil)
+	_, err := New(context.Background(), NewConfig().SetUrl(testScheme+"://missing/repo.git").
+		SetWorktreeFS(undeletableFs{memfs.New()}, false))
+	if err == nil {
+		t.Fatal("cloned a missing repo")
+	}
+	if !strings.Contains(err.Error(), "error removing failed clone: ") {
+		t.Fatalf("got %v, missing the error removing the partial clone", err)
+	}
+}
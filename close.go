@@ -0,0 +1,112 @@
+package gitfs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+var ErrClosed = errors.New("repo is closed")
+
+// lifecycle runs the background goroutines of a GitFs until Close, which
+// it configures.
+type lifecycle struct {
+	// Of the background goroutines, canceled first by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// Of the operations of the repo, canceled once Close synced
+	ops       context.Context
+	cancelOps context.CancelFunc
+	closed    int32
+	once      sync.Once
+	err       error
+
+	syncOnClose bool
+	snapshot    io.Writer
+}
+
+func newLifecycle(ctx context.Context, c *Config) *lifecycle {
+	l := &lifecycle{syncOnClose: c.syncOnClose, snapshot: c.closeSnapshot}
+	l.ops, l.cancelOps = context.WithCancel(ctx)
+	l.ctx, l.cancel = context.WithCancel(l.ops)
+	return l
+}
+
+func (l *lifecycle) isClosed() bool {
+	return l != nil && atomic.LoadInt32(&l.closed) != 0
+}
+
+// background runs fn in a goroutine Close cancels and waits for, or
+// simply in a goroutine for views and worktrees.
+func (g *GitFs) background(fn func(ctx context.Context)) {
+	l := g.life
+	if l == nil {
+		go fn(context.Background())
+		return
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn(l.ctx)
+	}()
+}
+
+// Close tears the repo down: it stops the background syncs and prefetches,
+// canceling their transfers, syncs pending changes and writes a snapshot if
+// configured, see Config.SyncOnClose and Config.SnapshotOnClose, then
+// unsubscribes all event subscribers, cancels the transfers of operations
+// still running and waits for them to release the repo lock, releases the
+// locks still held and removes the spill dir of hybridFs. Changes fail with ErrClosed afterwards, including those
+// of views, worktrees and the GitFs returned by Chroot, which share the
+// repo. Closing views and worktrees themselves does nothing. Close returns
+// the first error met, carrying on with the teardown, and is safe to call
+// more than once.
+func (g *GitFs) Close() (err error) {
+	defer g.git.trace("gitfs.Close")(&err)
+
+	l := g.life
+	if l == nil {
+		return nil
+	}
+	l.once.Do(func() {
+		fail := func(err error) {
+			if l.err == nil {
+				l.err = err
+			}
+		}
+
+		l.cancel()
+		l.wg.Wait()
+		if l.syncOnClose {
+			fail(errors.Wrapf(g.Sync(false), "error syncing on close"))
+		}
+		if l.snapshot != nil {
+			fail(errors.Wrapf(g.Snapshot(l.snapshot), "error writing snapshot on close"))
+		}
+
+		atomic.StoreInt32(&l.closed, 1)
+		g.events.mu.Lock()
+		g.events.subs = map[int]func(Event){}
+		g.events.mu.Unlock()
+
+		l.cancelOps()
+		keep := ""
+		if _, err := g.git.acquire(repoLockName, g.git.lockTimeout); err != nil {
+			// still held by an operation
+			fail(errors.Wrapf(err, "error waiting for operations to finish"))
+			keep = repoLockName + ".lock"
+		}
+		fail(g.git.locks.releaseAll(keep))
+		if s, ok := g.git.fs.(*spillFs); ok {
+			fail(errors.Wrapf(s.removeDisk(), "error removing spill dir"))
+		}
+		if c, ok := g.git.repo.Storer.(io.Closer); ok && !g.git.custom {
+			fail(errors.Wrapf(c.Close(), "error closing repo storage"))
+		}
+	})
+	return l.err
+}
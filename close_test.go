@@ -0,0 +1,93 @@
+package gitfs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// blockingPusher blocks pushes until released.
+type blockingPusher struct {
+	entered, release chan struct{}
+}
+
+func (p *blockingPusher) Push() error {
+	p.entered <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func TestCloseWaitsForRepoLock(t *testing.T) {
+	p := &blockingPusher{entered: make(chan struct{}), release: make(chan struct{})}
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetPusher(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", "a")
+	synced := make(chan error, 1)
+	go func() { synced <- g.Sync(false) }()
+	<-p.entered
+
+	closed := make(chan error, 1)
+	go func() { closed <- g.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned during a sync: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if held := g.git.locks.held[repoLockName+".lock"]; !held {
+		t.Fatal("repo lock of the sync released")
+	}
+
+	close(p.release)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if len(g.git.locks.held) != 0 {
+		t.Fatalf("locks still held: %v", g.git.locks.held)
+	}
+}
+
+func TestCloseCancelsBackgroundSync(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	entered := make(chan struct{}, 1)
+	var cloned int32
+	c := NewConfig().UseMemFs().
+		SetAuthProvider(AuthProviderFunc(func(ctx context.Context) (transport.AuthMethod, error) {
+			if atomic.LoadInt32(&cloned) == 0 {
+				return nil, nil
+			}
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+	c.syncInterval = 10 * time.Millisecond
+	g := r.clone(c)
+	atomic.StoreInt32(&cloned, 1)
+	writeTestFile(t, g, "a.txt", "a")
+
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background sync didn't push")
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- g.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs on the background sync")
+	}
+	if err := g.Sync(false); err != ErrClosed {
+		t.Fatalf("sync after Close got %v", err)
+	}
+}
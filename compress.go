@@ -0,0 +1,140 @@
+package gitfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// compressedExt marks files stored compressed by WriteFile.
+const compressedExt = ".gitfs.gz"
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+// decompressedFile is a file stored compressed by WriteFile, opened for
+// reading decompressed.
+type decompressedFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *decompressedFile) Name() string {
+	return f.name
+}
+
+func (f *decompressedFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: errReadOnlyFile}
+}
+
+func (f *decompressedFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: errReadOnlyFile}
+}
+
+func (f *decompressedFile) Close() error {
+	return nil
+}
+
+func (f *decompressedFile) Lock() error {
+	return nil
+}
+
+func (f *decompressedFile) Unlock() error {
+	return nil
+}
+
+// decompressedFileInfo describes a file stored compressed by WriteFile by
+// its name and size decompressed.
+type decompressedFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (fi *decompressedFileInfo) Name() string { return fi.name }
+func (fi *decompressedFileInfo) Size() int64  { return fi.size }
+
+// openCompressed opens filename, stored compressed, for reading.
+func (g *GitFs) openCompressed(filename string) (File, error) {
+	zdata, err := readFile(g.fs, filename+compressedExt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decompress(zdata)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decompressing %v", filename)
+	}
+	return &decompressedFile{Reader: bytes.NewReader(data), name: filename}, nil
+}
+
+// decompressedInfo returns fi, of the compressed file zname, describing it
+// decompressed. The size is the one recorded at the end of the gzip stream.
+func (g *GitFs) decompressedInfo(zname string, fi os.FileInfo) (os.FileInfo, error) {
+	f, err := g.fs.Open(zname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var size [4]byte
+	if _, err := f.Seek(-int64(len(size)), io.SeekEnd); err != nil {
+		return nil, errors.Wrapf(err, "error seeking %v", zname)
+	}
+	if _, err := io.ReadFull(f, size[:]); err != nil {
+		return nil, errors.Wrapf(err, "error reading %v", zname)
+	}
+	return &decompressedFileInfo{
+		FileInfo: fi,
+		name:     strings.TrimSuffix(fi.Name(), compressedExt),
+		size:     int64(binary.LittleEndian.Uint32(size[:])),
+	}, nil
+}
+
+// statCompressed stats filename, stored compressed, by stat.
+// storedName returns the name filename is stored under in g.fs, its
+// compressed name if only that exists.
+func (g *GitFs) storedName(filename string) string {
+	if _, err := g.fs.Lstat(filename); os.IsNotExist(err) {
+		if _, zerr := g.fs.Lstat(filename + compressedExt); zerr == nil {
+			return filename + compressedExt
+		}
+	}
+	return filename
+}
+
+func (g *GitFs) statCompressed(filename string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	zname := filename + compressedExt
+	fi, err := stat(zname)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err = g.withModTime(zname, fi); err != nil {
+		return nil, err
+	}
+	return g.decompressedInfo(zname, fi)
+}
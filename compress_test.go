@@ -0,0 +1,117 @@
+package gitfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressedFilesReadDecompressed(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetCompression(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("compressed ", 10)
+	writeTestFile(t, g, "dir/big.txt", data)
+	writeTestFile(t, g, "dir/small.txt", "small")
+	if _, err := g.fs.Stat("dir/big.txt" + compressedExt); err != nil {
+		t.Fatalf("big.txt not stored compressed: %v", err)
+	}
+
+	f, err := g.Open("dir/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != data {
+		t.Fatalf("Open read %q, %v", got, err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Fatal("wrote a decompressed file")
+	}
+
+	for _, stat := range []func(string) (os.FileInfo, error){g.Stat, g.Lstat} {
+		if fi, err := stat("dir/big.txt"); err != nil || fi.Name() != "big.txt" || fi.Size() != int64(len(data)) {
+			t.Fatalf("got stat %v, %v", fi, err)
+		}
+	}
+	if ok, err := g.Exist("dir/big.txt"); err != nil || !ok {
+		t.Fatalf("Exist got %v, %v", ok, err)
+	}
+
+	infos, err := g.ReadDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Name() != "big.txt" || infos[0].Size() != int64(len(data)) || infos[1].Name() != "small.txt" {
+		t.Fatalf("got dir entries %v", infos)
+	}
+
+	tx, err := g.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if f, err = tx.Open("dir/big.txt"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != data {
+		t.Fatalf("Tx.Open read %q, %v", got, err)
+	}
+}
+
+func TestCompressedFilesRemovedAndRenamedByName(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetCompression(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("compressed ", 10)
+	for _, name := range []string{"d/a.log", "d/b.log", "d/c.log"} {
+		writeTestFile(t, g, name, data)
+	}
+	writeTestFile(t, g, "d/plain.log", "plain")
+
+	var walked []string
+	if err := g.Walk("d", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			walked = append(walked, path+" "+fi.Name())
+			if fi.Size() != int64(len(data)) && fi.Name() != "plain.log" {
+				t.Fatalf("walked %v of %v bytes", path, fi.Size())
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(walked, ","); got != "d/a.log a.log,d/b.log b.log,d/c.log c.log,d/plain.log plain.log" {
+		t.Fatalf("walked %v", got)
+	}
+
+	if err := g.Remove("d/a.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.RemoveAll("d/b.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Rename("d/c.log", "d/plain.log"); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := g.ReadDir("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "plain.log" {
+		t.Fatalf("got dir %v", infos)
+	}
+	if got := readTestFile(t, g, "d/plain.log"); got != data {
+		t.Fatalf("renamed file reads %q", got)
+	}
+}
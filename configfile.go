@@ -0,0 +1,221 @@
+package gitfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the layout of config files, e.g.
+//
+//	url: git@github.com:org/repo.git
+//	branch: main
+//	backend: os
+//	base_dir: /var/lib/repo
+//	open_existing: true
+//	auth_method: ssh
+//	sync_interval: 5m
+//
+// Environment variables are named after the keys, see ConfigFromEnv.
+type fileConfig struct {
+	Url    string `json:"url" yaml:"url"`
+	Branch string `json:"branch" yaml:"branch"`
+	Bare   bool   `json:"bare" yaml:"bare"`
+	// If branch is created without history when the remote lacks it
+	Orphan bool `json:"orphan" yaml:"orphan"`
+	// memory, hybrid or os, os if base_dir is set and memory otherwise
+	Backend      string `json:"backend" yaml:"backend"`
+	BaseDir      string `json:"base_dir" yaml:"base_dir"`
+	OpenExisting bool   `json:"open_existing" yaml:"open_existing"`
+	MemBudget    int64  `json:"mem_budget" yaml:"mem_budget"`
+	NoRemote     bool   `json:"no_remote" yaml:"no_remote"`
+	Offline      bool   `json:"offline" yaml:"offline"`
+	// basic, credential_helper or ssh, derived from the url if empty
+	AuthMethod   string `json:"auth_method" yaml:"auth_method"`
+	AuthUser     string `json:"auth_user" yaml:"auth_user"`
+	AuthPassword string `json:"auth_password" yaml:"auth_password"`
+	Proxy        string `json:"proxy" yaml:"proxy"`
+	// Durations like 30s or 5m
+	SyncInterval string `json:"sync_interval" yaml:"sync_interval"`
+	LockTimeout  string `json:"lock_timeout" yaml:"lock_timeout"`
+	AllowEmpty   bool   `json:"allow_empty" yaml:"allow_empty"`
+	Concurrency  int    `json:"concurrency" yaml:"concurrency"`
+	StatusCache  bool   `json:"status_cache" yaml:"status_cache"`
+	TempDir      string `json:"temp_dir" yaml:"temp_dir"`
+	MaxFileSize  int64  `json:"max_file_size" yaml:"max_file_size"`
+	RepoQuota    int64  `json:"repo_quota" yaml:"repo_quota"`
+
+	// Bytes per second of packfiles transferred, 0 for no limit
+	BandwidthLimit int64 `json:"bandwidth_limit" yaml:"bandwidth_limit"`
+}
+
+// ConfigFromFile reads a Config from a .json, .yaml or .yml file. Unknown
+// keys are rejected. Keys are those of ConfigFromEnv, lower cased, e.g.
+// url, branch, backend, base_dir, auth_method and sync_interval.
+func ConfigFromFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading config %v", path)
+	}
+
+	var fc fileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&fc)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &fc)
+	default:
+		return nil, errors.Errorf("unsupported config format %v, use .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing config %v", path)
+	}
+	return fc.config(nil)
+}
+
+// ConfigFromEnv reads a Config from the environment variables named after
+// the config file keys, upper cased and prefixed by prefix and "_", e.g.
+// GITFS_URL, GITFS_BASE_DIR and GITFS_AUTH_PASSWORD for prefix "GITFS".
+// Unset variables are left at their defaults.
+func ConfigFromEnv(prefix string) (*Config, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	var fc fileConfig
+	var errs []error
+	v := reflect.ValueOf(&fc).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := prefix + strings.ToUpper(v.Type().Field(i).Tag.Get("yaml"))
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				errs = append(errs, errors.Errorf("%v is not a bool: %q", name, s))
+			}
+			f.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				errs = append(errs, errors.Errorf("%v is not an integer: %q", name, s))
+			}
+			f.SetInt(n)
+		}
+	}
+	return fc.config(errs)
+}
+
+// config builds the Config fc describes, failing with errs and any other
+// problem found.
+func (fc *fileConfig) config(errs []error) (*Config, error) {
+	duration := func(key, s string) time.Duration {
+		if s == "" {
+			return 0
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			errs = append(errs, errors.Errorf("%v is not a duration like 30s or 5m: %q", key, s))
+		}
+		return d
+	}
+
+	c := NewConfig()
+	if fc.NoRemote {
+		c.NoRemote()
+	} else {
+		c.SetUrl(fc.Url)
+	}
+
+	switch strings.ToLower(fc.Backend) {
+	case "":
+		if fc.BaseDir != "" {
+			c.UseOsFs(fc.BaseDir, fc.OpenExisting)
+		} else {
+			c.UseMemFs()
+		}
+	case "memory", "mem":
+		c.UseMemFs()
+	case "hybrid":
+		if fc.MemBudget <= 0 {
+			errs = append(errs, errors.New("hybrid backend requires a mem_budget"))
+		}
+		c.UseHybridFs(fc.MemBudget)
+	case "os":
+		c.UseOsFs(fc.BaseDir, fc.OpenExisting)
+	default:
+		errs = append(errs, errors.Errorf("unknown backend %v, use memory, hybrid or os", fc.Backend))
+	}
+
+	if fc.Orphan {
+		c.SetOrphanBranch(fc.Branch)
+	} else {
+		c.branch = fc.Branch
+	}
+	if fc.Bare {
+		c.bare = true
+	}
+	if fc.Offline {
+		c.Offline()
+	}
+
+	switch strings.ToLower(fc.AuthMethod) {
+	case "":
+		if fc.AuthUser != "" || fc.AuthPassword != "" {
+			if strings.HasPrefix(fc.Url, "http") {
+				c.SetBasicAuth(fc.AuthUser, fc.AuthPassword)
+			} else {
+				c.SetSSHUser(fc.AuthUser)
+			}
+		}
+	case "basic":
+		c.SetBasicAuth(fc.AuthUser, fc.AuthPassword)
+	case "credential_helper":
+		c.UseCredentialHelper()
+	case "ssh":
+		// keys come from the ssh agent and ~/.ssh
+		c.SetSSHUser(fc.AuthUser)
+	default:
+		errs = append(errs, errors.Errorf("unknown auth method %v, use basic, credential_helper or ssh", fc.AuthMethod))
+	}
+
+	if fc.Proxy != "" {
+		c.SetProxy(fc.Proxy)
+	}
+	if fc.StatusCache {
+		c.EnableStatusCache()
+	}
+	if fc.AllowEmpty {
+		c.AllowEmpty()
+	}
+	c.syncInterval = duration("sync_interval", fc.SyncInterval)
+	c.SetLockTimeout(duration("lock_timeout", fc.LockTimeout))
+	c.SetConcurrency(fc.Concurrency)
+	c.SetTempDir(fc.TempDir)
+	c.SetMaxFileSize(fc.MaxFileSize)
+	c.SetRepoQuota(fc.RepoQuota)
+	c.SetBandwidthLimit(fc.BandwidthLimit)
+
+	if len(errs) > 0 {
+		return nil, &ConfigError{Problems: errs}
+	}
+	return c, nil
+}
@@ -0,0 +1,58 @@
+package gitfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// credentialFill asks the credential helpers the user configured for git,
+// e.g. osxkeychain, manager-core or libsecret, for the credentials of ep
+// through `git credential fill`. Prompting is disabled, so it fails rather
+// than blocking if no helper knows them.
+func credentialFill(ep *transport.Endpoint) (user, password string, err error) {
+	host := ep.Host
+	if ep.Port != 0 {
+		host = fmt.Sprintf("%v:%v", ep.Host, ep.Port)
+	}
+	var in bytes.Buffer
+	fmt.Fprintf(&in, "protocol=%v\nhost=%v\npath=%v\n", ep.Protocol, host, strings.TrimPrefix(ep.Path, "/"))
+	if ep.User != "" {
+		fmt.Fprintf(&in, "username=%v\n", ep.User)
+	}
+	in.WriteString("\n")
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = &in
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error running git credential fill: %s", bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			user = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+	if password == "" {
+		return "", "", errors.Errorf("no credentials of %v found by git credential helpers", host)
+	}
+	return user, password, nil
+}
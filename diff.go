@@ -0,0 +1,89 @@
+package gitfs
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// ChangedPaths returns the files changed between the commits of revisions
+// from and to, e.g. hashes or refs, HEAD if empty, sorted by path. A file
+// removed and added elsewhere with similar content is reported once as
+// Renamed, see Config.SetRenameThreshold. Data is not loaded.
+func (g *GitFs) ChangedPaths(from, to string) (changes []Change, err error) {
+	defer g.git.trace("gitfs.ChangedPaths")(&err)
+
+	fc, err := g.git.resolveCommit(from)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := g.git.resolveCommit(to)
+	if err != nil {
+		return nil, err
+	}
+	return g.git.treeChanges(fc, tc)
+}
+
+// hashChanges returns the changes between the commits from and to, a zero
+// hash having an empty tree.
+func (g *Git) hashChanges(from, to plumbing.Hash) ([]Change, error) {
+	commit := func(h plumbing.Hash) (*object.Commit, error) {
+		if h.IsZero() {
+			return nil, nil
+		}
+		c, err := g.repo.CommitObject(h)
+		return c, errors.Wrapf(err, "error reading commit %v", h)
+	}
+	fc, err := commit(from)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := commit(to)
+	if err != nil {
+		return nil, err
+	}
+	return g.treeChanges(fc, tc)
+}
+
+// treeChanges returns the changes between the trees of from and to, a nil
+// commit having an empty tree.
+func (g *Git) treeChanges(from, to *object.Commit) ([]Change, error) {
+	files := func(c *object.Commit) (map[string]bareEntry, error) {
+		if c == nil {
+			return map[string]bareEntry{}, nil
+		}
+		return commitFiles(c)
+	}
+	ff, err := files(from)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := files(to)
+	if err != nil {
+		return nil, err
+	}
+	return g.renames.apply(diffFiles(ff, tf), g.blobRefs(ff), g.blobRefs(tf))
+}
+
+// diffFiles classifies the paths whose files differ between from and to.
+func diffFiles(from, to map[string]bareEntry) []Change {
+	var changes []Change
+	for p := range changedFiles(from, to) {
+		_, inFrom := from[p]
+		_, inTo := to[p]
+		code := Modified
+		if !inFrom {
+			code = Added
+		} else if !inTo {
+			code = Deleted
+		}
+		changes = append(changes, Change{Path: p, Status: code})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
@@ -0,0 +1,140 @@
+// Package docstore stores JSON and YAML documents in a GitFs, validating
+// every document before it is committed.
+package docstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Validator checks a document before it is committed. doc is the document
+// decoded into generic maps, slices and scalars.
+type Validator func(name string, doc interface{}) error
+
+// Store keeps documents under a directory of a GitFs. The format of a
+// document is derived from its name: .json, .yaml or .yml.
+type Store struct {
+	fs       *gitfs.GitFs
+	dir      string
+	validate Validator
+}
+
+// New creates a store keeping its documents under dir of fs.
+func New(fs *gitfs.GitFs, dir string) *Store {
+	return &Store{
+		fs:  fs,
+		dir: dir,
+	}
+}
+
+// SetValidator sets the hook run on every document before it is committed.
+// A document failing validation is never written.
+func (s *Store) SetValidator(v Validator) *Store {
+	s.validate = v
+	return s
+}
+
+type codec struct {
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+}
+
+var codecs = map[string]codec{
+	".json": {marshal: func(v interface{}) ([]byte, error) { return json.MarshalIndent(v, "", "  ") }, unmarshal: json.Unmarshal},
+	".yaml": {marshal: yaml.Marshal, unmarshal: yaml.Unmarshal},
+	".yml":  {marshal: yaml.Marshal, unmarshal: yaml.Unmarshal},
+}
+
+func codecOf(name string) (codec, error) {
+	c, ok := codecs[strings.ToLower(path.Ext(name))]
+	if !ok {
+		return codec{}, errors.Errorf("unsupported document format %v", name)
+	}
+	return c, nil
+}
+
+// Get decodes the named document into v.
+func (s *Store) Get(name string, v interface{}) error {
+	c, err := codecOf(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.fs.ReadFile(path.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	return c.unmarshal(data, v)
+}
+
+// Put encodes v as the named document, validates it, then commits and
+// pushes it.
+func (s *Store) Put(name string, v interface{}) error {
+	c, err := codecOf(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding %v", name)
+	}
+	return s.PutRaw(name, data)
+}
+
+// PutRaw validates the encoded document data, then commits and pushes it.
+// Data which can't be decoded is rejected.
+func (s *Store) PutRaw(name string, data []byte) error {
+	return s.PutAll(map[string][]byte{name: data})
+}
+
+// PutAll validates all given encoded documents and commits them together.
+// Nothing is written if any of them is rejected.
+func (s *Store) PutAll(docs map[string][]byte) error {
+	for name, data := range docs {
+		if err := s.check(name, data); err != nil {
+			return err
+		}
+	}
+
+	return s.fs.Apply(func(w gitfs.Writer) error {
+		for name, data := range docs {
+			if err := w.WriteFile(path.Join(s.dir, name), data, 0644); err != nil {
+				return errors.Wrapf(err, "error writing %v", name)
+			}
+		}
+		return nil
+	}, fmt.Sprintf("docstore: update %v documents", len(docs)))
+}
+
+// Delete removes the named document, then commits and pushes the removal.
+func (s *Store) Delete(name string) error {
+	return s.fs.Apply(func(w gitfs.Writer) error {
+		return w.Remove(path.Join(s.dir, name))
+	}, fmt.Sprintf("docstore: delete %v", name))
+}
+
+func (s *Store) check(name string, data []byte) error {
+	c, err := codecOf(name)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err := c.unmarshal(data, &doc); err != nil {
+		return errors.Wrapf(err, "malformed document %v", name)
+	}
+
+	if s.validate != nil {
+		if err := s.validate(name, doc); err != nil {
+			return errors.Wrapf(err, "invalid document %v", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package gitfs
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitattributes"
+)
+
+// AutoCRLF is the line ending conversion of text files without text or eol
+// attributes, like core.autocrlf of git.
+type AutoCRLF int
+
+const (
+	// AutoCRLFFalse converts nothing
+	AutoCRLFFalse AutoCRLF = iota
+	// AutoCRLFInput commits text files with LF line endings
+	AutoCRLFInput
+	// AutoCRLFTrue commits text files with LF line endings, and reads
+	// them with CRLF ones
+	AutoCRLFTrue
+)
+
+const gitattributesFile = ".gitattributes"
+
+// eolPolicy decides the line endings of files from .gitattributes, if
+// enabled, and the AutoCRLF mode.
+type eolPolicy struct {
+	autocrlf   AutoCRLF
+	attributes bool
+
+	mu sync.Mutex
+	// Loaded from the .gitattributes files of the worktree, nil until
+	// loaded or once stale
+	matcher gitattributes.Matcher
+	// HEAD the matcher was loaded at, reloaded once HEAD moves
+	head plumbing.Hash
+}
+
+// eolRule is how a file is converted.
+type eolRule struct {
+	// If CRLF is committed as LF, only if the file is text if auto
+	normalize bool
+	auto      bool
+	// If LF is read as CRLF
+	crlf bool
+}
+
+func newEOLPolicy(autocrlf AutoCRLF, attributes bool) *eolPolicy {
+	return &eolPolicy{autocrlf: autocrlf, attributes: attributes}
+}
+
+// enabled reports whether any conversion may apply.
+func (p *eolPolicy) enabled() bool {
+	return p != nil && (p.attributes || p.autocrlf != AutoCRLFFalse)
+}
+
+// invalidate drops the matcher if filename is a .gitattributes file.
+func (p *eolPolicy) invalidate(filename string) {
+	if !p.enabled() || path.Base(filepath.ToSlash(filename)) != gitattributesFile {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.matcher = nil
+}
+
+// eolRule returns the conversion of filename, relative to the repo root.
+func (g *Git) eolRule(filename string) (eolRule, error) {
+	p := g.eol
+	if !p.enabled() {
+		return eolRule{}, nil
+	}
+
+	var text, eol gitattributes.Attribute
+	if p.attributes {
+		attrs, err := g.attributes(filename)
+		if err != nil {
+			return eolRule{}, err
+		}
+		text, eol = attrs["text"], attrs["eol"]
+	}
+
+	r := eolRule{}
+	switch {
+	case text != nil && text.IsUnset():
+		return r, nil
+	case text != nil && text.IsValueSet() && text.Value() == "auto":
+		r.normalize, r.auto = true, true
+	case text != nil && text.IsSet():
+		r.normalize = true
+	case eol != nil && eol.IsValueSet():
+		// eol implies text
+		r.normalize = true
+	case p.autocrlf != AutoCRLFFalse:
+		r.normalize, r.auto = true, true
+	default:
+		return r, nil
+	}
+
+	if eol != nil && eol.IsValueSet() {
+		r.crlf = eol.Value() == "crlf"
+	} else {
+		r.crlf = p.autocrlf == AutoCRLFTrue
+	}
+	return r, nil
+}
+
+// attributes returns the attributes .gitattributes files give filename.
+func (g *Git) attributes(filename string) (map[string]gitattributes.Attribute, error) {
+	m, err := g.eolMatcher()
+	if err != nil {
+		return nil, err
+	}
+	attrs, _ := m.Match(strings.Split(strings.Trim(filepath.ToSlash(filename), "/"), "/"), nil)
+	return attrs, nil
+}
+
+// textAttribute returns whether filename is text by its text attribute,
+// ok only if .gitattributes are used and set or unset it.
+func (g *Git) textAttribute(filename string) (text, ok bool, err error) {
+	if !g.eol.enabled() || !g.eol.attributes {
+		return false, false, nil
+	}
+	attrs, err := g.attributes(filename)
+	if err != nil {
+		return false, false, err
+	}
+	switch a := attrs["text"]; {
+	case a == nil:
+		return false, false, nil
+	case a.IsUnset():
+		return false, true, nil
+	case a.IsSet():
+		return true, true, nil
+	}
+	return false, false, nil
+}
+
+// eolMatcher returns the matcher of the .gitattributes files, loading it
+// if stale.
+func (g *Git) eolMatcher() (gitattributes.Matcher, error) {
+	p := g.eol
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	head := g.headHash()
+	if p.matcher != nil && p.head == head {
+		return p.matcher, nil
+	}
+	attrs, err := gitattributes.ReadPatterns(g.fs, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %v files", gitattributesFile)
+	}
+	p.matcher, p.head = gitattributes.NewMatcher(attrs), head
+	return p.matcher, nil
+}
+
+// toRepoEOL converts data of filename to the line endings committed.
+func (g *Git) toRepoEOL(filename string, data []byte) ([]byte, error) {
+	r, err := g.eolRule(filename)
+	if err != nil || !r.normalize || (r.auto && looksBinary(data)) {
+		return data, err
+	}
+	return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), nil
+}
+
+// toWorktreeEOL converts data of filename, as committed, to the line
+// endings it is read with.
+func (g *Git) toWorktreeEOL(filename string, data []byte) ([]byte, error) {
+	r, err := g.eolRule(filename)
+	if err != nil || !r.crlf || looksBinary(data) {
+		return data, err
+	}
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1), nil
+}
+
+// normalizeEOL rewrites the new and modified files of the worktree with
+// the line endings committed, so files written with CRLF, e.g. by streaming
+// writes or other processes, don't change every line.
+func (g *Git) normalizeEOL() error {
+	if !g.eol.enabled() {
+		return nil
+	}
+	s, err := g.status()
+	if err != nil {
+		return errors.Wrapf(err, "error getting status")
+	}
+	for p, fstatus := range s {
+		if fstatus.Worktree != git.Untracked && fstatus.Worktree != git.Modified {
+			continue
+		}
+		fi, err := g.fs.Lstat(p)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		data, err := readFile(g.fs, p)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %v", p)
+		}
+		clean, err := g.toRepoEOL(p, data)
+		if err != nil {
+			return err
+		}
+		if len(clean) == len(data) {
+			continue
+		}
+		if err := util.WriteFile(g.fs, p, clean, fi.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "error normalizing line endings of %v", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// EventOp is the kind of a filesystem event.
+type EventOp int
+
+const (
+	// A file or symlink was created
+	EventCreate EventOp = iota
+	// A file was written, reported once the written file is closed
+	EventWrite
+	// A file or directory was removed
+	EventRemove
+	// A file or directory was renamed
+	EventRename
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventCreate:
+		return "create"
+	case EventWrite:
+		return "write"
+	case EventRemove:
+		return "remove"
+	case EventRename:
+		return "rename"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a filesystem change made through GitFs. Changes made by git,
+// e.g. by Pull, are not reported.
+type Event struct {
+	Op EventOp
+	// Slash separated path relative to the repo root
+	Path string
+	// Former path for EventRename
+	OldPath string
+}
+
+type eventBus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]func(Event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[int]func(Event){}}
+}
+
+// Subscribe calls fn for every subsequent filesystem event, including those
+// of GitFs returned by Chroot, until the returned func is called. fn is
+// called synchronously by the goroutine making the change.
+func (g *GitFs) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b := g.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func (g *GitFs) hasSubscribers() bool {
+	if g.events == nil {
+		return false
+	}
+	g.events.mu.RLock()
+	defer g.events.mu.RUnlock()
+	return len(g.events.subs) > 0
+}
+
+func (g *GitFs) repoPath(filename string) string {
+	return filepath.ToSlash(filepath.Join(string(filepath.Separator), g.root, filename))[1:]
+}
+
+func (g *GitFs) emit(op EventOp, filename, oldname string) {
+	if g.git != nil {
+		// line ending rules change along with .gitattributes files
+		g.git.eol.invalidate(filename)
+		g.git.eol.invalidate(oldname)
+	}
+	g.prefetched.invalidate(g.repoPath(filename))
+	if oldname != "" {
+		g.prefetched.invalidate(g.repoPath(oldname))
+	}
+	if !g.hasSubscribers() {
+		return
+	}
+
+	e := Event{Op: op, Path: g.repoPath(filename)}
+	if op == EventRename {
+		e.OldPath = g.repoPath(oldname)
+	}
+
+	g.events.mu.RLock()
+	defer g.events.mu.RUnlock()
+	for _, fn := range g.events.subs {
+		fn(e)
+	}
+}
+
+// exists reports whether filename exists, if anyone cares.
+func (g *GitFs) exists(filename string) bool {
+	if !g.hasSubscribers() {
+		return true
+	}
+	_, err := g.fs.Lstat(filename)
+	return !os.IsNotExist(err)
+}
+
+// writeOp returns the op of writing filename as a whole, EventCreate
+// unless it exists, compressed or not.
+func (g *GitFs) writeOp(filename string) EventOp {
+	if g.exists(filename) || g.exists(filename+compressedExt) {
+		return EventWrite
+	}
+	return EventCreate
+}
+
+// watchFile reports the creation of f, opened as filename, if created, and
+// its write once it is closed after being written.
+func (g *GitFs) watchFile(filename string, f File, err error, created bool) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		g.emit(EventCreate, filename, "")
+	}
+	return &watchedFile{File: f, g: g, name: filename}, nil
+}
+
+type watchedFile struct {
+	File
+	g       *GitFs
+	name    string
+	written bool
+}
+
+func (f *watchedFile) Write(p []byte) (int, error) {
+	f.written = true
+	return f.File.Write(p)
+}
+
+func (f *watchedFile) Truncate(size int64) error {
+	f.written = true
+	return f.File.Truncate(size)
+}
+
+func (f *watchedFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if f.written {
+		f.g.emit(EventWrite, f.name, "")
+	}
+	return nil
+}
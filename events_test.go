@@ -0,0 +1,42 @@
+package gitfs
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWriteEmitsCreateForNewFiles(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetCompression(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	defer g.Subscribe(func(e Event) { events = append(events, e) })()
+
+	writeTestFile(t, g, "a.txt", "a")
+	writeTestFile(t, g, "a.txt", "b")
+	writeTestFile(t, g, "big.txt", "compressed data")
+	writeTestFile(t, g, "big.txt", "compressed again")
+	for _, name := range []string{"b.txt", "b.txt"} {
+		if err := g.AtomicWrite(name, func(w io.Writer) error {
+			_, err := io.WriteString(w, "b")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []Event{
+		{Op: EventCreate, Path: "a.txt"},
+		{Op: EventWrite, Path: "a.txt"},
+		{Op: EventCreate, Path: "big.txt"},
+		{Op: EventWrite, Path: "big.txt"},
+		{Op: EventCreate, Path: "b.txt"},
+		{Op: EventWrite, Path: "b.txt"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %+v, want %+v", events, want)
+	}
+}
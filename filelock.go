@@ -0,0 +1,262 @@
+package gitfs
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+const (
+	lockDir            = "gitfs-locks"
+	repoLockName       = "repo"
+	defaultLockTimeout = 30 * time.Second
+)
+
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// FileLock is a lock held through a lock file in the repo dir, excluding
+// other processes sharing the dir as well as other goroutines.
+type FileLock struct {
+	locks *lockFiles
+	path  string
+	owner lockOwner
+}
+
+// lockFiles holds the lock files of a repo.
+type lockFiles struct {
+	fs billy.Filesystem
+	// The git dir, for the lock files of git itself, nil if process local
+	dir billy.Filesystem
+	// Guards creating and removing lock files, as memfs is not safe for
+	// concurrent use
+	mu sync.Mutex
+	// Lock files created and not yet removed, released by Close
+	held map[string]bool
+}
+
+// newLockFiles keeps lock files within the .git dir of fs, or the base
+// dir of bare repos, so processes sharing the dir see the same locks.
+// Repos with nothing on disk get process local locks.
+func newLockFiles(c *Config, fs billy.Filesystem) (*lockFiles, error) {
+	if c.storer != nil || c.useMemFs {
+		return &lockFiles{fs: memfs.New(), held: map[string]bool{}}, nil
+	}
+
+	var dir billy.Filesystem
+	var err error
+	if c.bare {
+		dir = osfs.New(c.osFsBaseDir)
+	} else if dir, err = fs.Chroot(git.GitDirName); err != nil {
+		return nil, errors.Wrapf(err, "error chrooting %v", git.GitDirName)
+	}
+	lfs, err := dir.Chroot(lockDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error chrooting %v", lockDir)
+	}
+	return &lockFiles{fs: lfs, dir: dir, held: map[string]bool{}}, nil
+}
+
+// LockRepo acquires the lock Sync, Apply and Pull hold while changing the
+// index and refs, waiting at most timeout for it, so other changes can be
+// serialized with them, also across processes sharing an osFs base dir.
+func (g *GitFs) LockRepo(timeout time.Duration) (*FileLock, error) {
+	return g.git.lock(repoLockName, timeout)
+}
+
+// LockFile acquires a lock of the named file, waiting at most timeout for
+// it. Locks are advisory, they only exclude other LockFile calls.
+func (g *GitFs) LockFile(filename string, timeout time.Duration) (*FileLock, error) {
+	if err := g.checkPath("lock", filename); err != nil {
+		return nil, err
+	}
+	return g.git.lock(fmt.Sprintf("files/%x", sha1.Sum([]byte(g.repoPath(filename)))), timeout)
+}
+
+// Unlock releases the lock. It fails, leaving the lock file, if another
+// process took the lock over, having taken this one for stale.
+func (l *FileLock) Unlock() error {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+	if owner, ok := readLockOwner(l.locks.fs, l.path); ok && !owner.same(l.owner) {
+		delete(l.locks.held, l.path)
+		return errors.Errorf("lock file %v was taken over by process %v on %v", l.path, owner.pid, owner.host)
+	}
+	if err := l.locks.fs.Remove(l.path); err != nil {
+		return errors.Wrapf(err, "error removing lock file %v", l.path)
+	}
+	delete(l.locks.held, l.path)
+	return nil
+}
+
+// lock creates the lock file of name, retrying until timeout passes.
+func (g *Git) lock(name string, timeout time.Duration) (*FileLock, error) {
+	if g.life.isClosed() {
+		return nil, ErrClosed
+	}
+	return g.acquire(name, timeout)
+}
+
+// acquire locks like lock, even once closed.
+func (g *Git) acquire(name string, timeout time.Duration) (*FileLock, error) {
+	p := name + ".lock"
+	deadline := time.Now().Add(timeout)
+	wait := 5 * time.Millisecond
+	for {
+		owner, ok, err := g.locks.tryLock(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating lock file %v", p)
+		} else if ok {
+			return &FileLock{locks: g.locks, path: p, owner: owner}, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, errors.Wrapf(ErrLockTimeout, "lock %v", name)
+		}
+		time.Sleep(wait)
+		if wait < 200*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+// tryLock creates the lock file p unless it exists, recording the owner
+// of the lock in it.
+func (l *lockFiles) tryLock(p string) (lockOwner, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// memfs ignores O_EXCL
+	if _, err := l.fs.Stat(p); err == nil {
+		// the lock of a crashed process is taken over
+		if ok, err := l.takeOver(p); !ok || err != nil {
+			return lockOwner{}, false, err
+		}
+	} else if !os.IsNotExist(err) {
+		return lockOwner{}, false, err
+	}
+	return l.create(p)
+}
+
+// takeOver removes the lock file p if its owner is gone, reporting whether
+// it did. Processes taking over p hold the takeover lock of p while doing
+// so and recheck the owner, so none removes the lock another one created
+// in the meantime, having found p stale before.
+func (l *lockFiles) takeOver(p string) (_ bool, err error) {
+	owner, ok := readLockOwner(l.fs, p)
+	if !ok || !owner.gone() {
+		return false, nil
+	}
+
+	guard := strings.TrimSuffix(p, ".lock") + ".takeover.lock"
+	if _, err := l.fs.Stat(guard); err == nil {
+		// the takeover lock of a process crashed while taking over p
+		if owner, ok := readLockOwner(l.fs, guard); ok && owner.gone() {
+			if err := l.fs.Remove(guard); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	if _, ok, err := l.create(guard); !ok || err != nil {
+		return false, err
+	}
+	defer func() {
+		rerr := l.fs.Remove(guard)
+		delete(l.held, guard)
+		if err == nil && rerr != nil {
+			err = rerr
+		}
+	}()
+
+	if now, ok := readLockOwner(l.fs, p); ok && !now.same(owner) {
+		return false, nil
+	}
+	if err := l.fs.Remove(p); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// create creates the lock file p unless it exists, returning the owner
+// recorded in it.
+func (l *lockFiles) create(p string) (lockOwner, bool, error) {
+	f, err := l.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return lockOwner{}, false, nil
+	} else if err != nil {
+		return lockOwner{}, false, err
+	}
+
+	host, _ := os.Hostname()
+	owner := lockOwner{pid: os.Getpid(), host: host, at: time.Now()}
+	_, err = fmt.Fprintf(f, "%v %v %v\n", owner.pid, owner.host, owner.at.UnixNano())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if rerr := l.fs.Remove(p); rerr != nil {
+			err = errors.Wrapf(err, "error removing lock file %v: %v", p, rerr)
+		}
+		return lockOwner{}, false, err
+	}
+	l.held[p] = true
+	return owner, true, nil
+}
+
+// releaseAll removes the lock files still held but keep, so other
+// processes need not wait for them to be taken over.
+func (l *lockFiles) releaseAll(keep string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for p := range l.held {
+		if p == keep {
+			continue
+		}
+		if err := l.fs.Remove(p); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = errors.Wrapf(err, "error removing lock file %v", p)
+		}
+		delete(l.held, p)
+	}
+	return firstErr
+}
+
+// lockRepo acquires the repo lock with the configured timeout, returning
+// the func releasing it, which sets the error err points to, unless set,
+// to that of releasing the lock. It fails with ErrLocked while git locks
+// the repo, with ErrReadOnly for views and with ErrClosed once closed, as
+// every change takes the lock.
+func (g *Git) lockRepo() (func(err *error), error) {
+	if g.readOnly {
+		return nil, ErrReadOnly
+	}
+	l, err := g.lock(repoLockName, g.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.locks.checkGitLocks(); err != nil {
+		if uerr := l.Unlock(); uerr != nil {
+			g.reportError("gitfs.Unlock", uerr)
+		}
+		return nil, err
+	}
+	g.objects.Lock()
+	return func(err *error) {
+		g.objects.Unlock()
+		if uerr := l.Unlock(); uerr != nil && *err == nil {
+			*err = uerr
+		}
+	}, nil
+}
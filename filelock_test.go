@@ -0,0 +1,106 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// goneOwner returns the owner line of a process no longer running.
+func goneOwner(t *testing.T) string {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%v %v %v\n", cmd.Process.Pid, host, time.Now().UnixNano())
+}
+
+func TestTryLockTakesOverStaleLock(t *testing.T) {
+	l := &lockFiles{fs: memfs.New(), held: map[string]bool{}}
+	if err := util.WriteFile(l.fs, "repo.lock", []byte(goneOwner(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := l.tryLock("repo.lock"); err != nil || !ok {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+	if _, err := l.fs.Stat("repo.takeover.lock"); !os.IsNotExist(err) {
+		t.Fatalf("takeover lock left: %v", err)
+	}
+	if len(l.held) != 1 || !l.held["repo.lock"] {
+		t.Fatalf("got held locks %v", l.held)
+	}
+}
+
+func TestTryLockWaitsForTakeover(t *testing.T) {
+	l := &lockFiles{fs: memfs.New(), held: map[string]bool{}}
+	if err := util.WriteFile(l.fs, "repo.lock", []byte(goneOwner(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// another process taking the lock over
+	host, _ := os.Hostname()
+	live := fmt.Sprintf("%v %v %v\n", os.Getppid(), host, time.Now().UnixNano())
+	if err := util.WriteFile(l.fs, "repo.takeover.lock", []byte(live), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := l.tryLock("repo.lock"); err != nil || ok {
+		t.Fatalf("lock taken over during another takeover: %v", err)
+	}
+
+	// the takeover lock of a crashed process is broken
+	if err := util.WriteFile(l.fs, "repo.takeover.lock", []byte(goneOwner(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := l.tryLock("repo.lock"); err != nil || ok {
+		t.Fatalf("lock taken over with a takeover lock: %v", err)
+	}
+	if _, ok, err := l.tryLock("repo.lock"); err != nil || !ok {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+}
+
+func TestUnlockFailsOnceTakenOver(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another process took the lock for stale
+	if err := util.WriteFile(g.git.locks.fs, repoLockName+".lock", []byte(goneOwner(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if unlock(&err); err == nil {
+		t.Fatal("lock taken over unlocked")
+	}
+	if owner, ok := readLockOwner(g.git.locks.fs, repoLockName+".lock"); !ok || owner.pid == os.Getpid() {
+		t.Fatalf("lock of the other process removed: %+v", owner)
+	}
+}
+
+func TestLockOwnerGone(t *testing.T) {
+	host, _ := os.Hostname()
+	if (lockOwner{pid: os.Getpid(), host: host}).gone() {
+		t.Fatal("running process gone")
+	}
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "x.lock", []byte(goneOwner(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	owner, ok := readLockOwner(fs, "x.lock")
+	if !ok || !owner.gone() {
+		t.Fatalf("exited process %+v not gone", owner)
+	}
+	if (lockOwner{pid: owner.pid, host: host + "-other"}).gone() {
+		t.Fatal("process of another host gone")
+	}
+}
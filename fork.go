@@ -0,0 +1,121 @@
+package gitfs
+
+import (
+	"os"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Fork is a copy-on-write view of a GitFs, e.g. for speculative edits like
+// building a preview which may be discarded. Writes go to an in-memory
+// overlay like those of a Tx, reads see them on top of the GitFs, which is
+// left untouched until Merge.
+type Fork struct {
+	*Tx
+}
+
+// Fork returns a new fork of g.
+func (g *GitFs) Fork() (*Fork, error) {
+	tx, err := g.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Fork{Tx: tx}, nil
+}
+
+// Stat returns a FileInfo describing the named file, preferring the
+// version written to the fork.
+func (f *Fork) Stat(filename string) (os.FileInfo, error) {
+	if f.done {
+		return nil, ErrTxDone
+	}
+	if fi, err := f.overlay.Stat(filename); err == nil {
+		return fi, nil
+	}
+	if f.isRemoved(filename) {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: os.ErrNotExist}
+	}
+	return f.g.Stat(filename)
+}
+
+// Exist reports whether path exists in the fork.
+func (f *Fork) Exist(path string) (bool, error) {
+	_, err := f.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "error stating path")
+	}
+	return true, nil
+}
+
+// ReadDir reads the named directory of the fork, returning its entries
+// sorted by filename.
+func (f *Fork) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if f.done {
+		return nil, ErrTxDone
+	}
+
+	entries := map[string]os.FileInfo{}
+	found := false
+	if !f.isRemoved(dirname) {
+		fis, err := f.g.ReadDir(dirname)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		found = err == nil
+		for _, fi := range fis {
+			if !f.isRemoved(path.Join(dirname, fi.Name())) {
+				entries[fi.Name()] = fi
+			}
+		}
+	}
+	fis, err := f.overlay.ReadDir(dirname)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	found = found || err == nil
+	for _, fi := range fis {
+		entries[fi.Name()] = fi
+	}
+	if !found {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: os.ErrNotExist}
+	}
+
+	var result []os.FileInfo
+	for _, fi := range entries {
+		result = append(result, fi)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+// Changes returns the slash separated, root relative paths written or
+// removed in the fork, sorted.
+func (f *Fork) Changes() ([]string, error) {
+	if f.done {
+		return nil, ErrTxDone
+	}
+	paths, err := f.paths()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing forked files")
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Merge applies the changes of the fork to the GitFs it was forked from,
+// overwriting changes made there since, and ends the fork. They are
+// committed by the next Sync.
+func (f *Fork) Merge() error {
+	return f.Commit()
+}
+
+// Discard drops the changes of the fork and ends it.
+func (f *Fork) Discard() error {
+	return f.Rollback()
+}
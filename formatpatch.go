@@ -0,0 +1,329 @@
+package gitfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+)
+
+// patchContext is the number of unchanged lines around the changes of a
+// hunk.
+const patchContext = 3
+
+// FormatPatch writes the commits after from up to to, revisions like hashes
+// or refs, to w as patches in mailbox format like git format-patch, oldest
+// first, e.g. to ship changes to an air-gapped replica as patch files,
+// applied there by git am or ApplyPatch. An empty from formats the whole
+// history, an empty to is HEAD. Merge commits are skipped, and binary files
+// can't be formatted.
+func (g *GitFs) FormatPatch(from, to string, w io.Writer) (err error) {
+	defer g.git.trace("gitfs.FormatPatch")(&err)
+
+	tc, err := g.git.resolveCommit(to)
+	if err != nil {
+		return err
+	}
+	known := map[plumbing.Hash]bool{}
+	if from != "" {
+		fc, err := g.git.resolveCommit(from)
+		if err != nil {
+			return err
+		}
+		if err := object.NewCommitPreorderIter(fc, nil, nil).ForEach(func(c *object.Commit) error {
+			known[c.Hash] = true
+			return nil
+		}); err != nil {
+			return errors.Wrapf(err, "error walking history of %v", from)
+		}
+	}
+	var commits []*object.Commit
+	if err := object.NewCommitPreorderIter(tc, known, nil).ForEach(func(c *object.Commit) error {
+		if c.NumParents() <= 1 {
+			commits = append([]*object.Commit{c}, commits...)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "error walking history of %v", to)
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, c := range commits {
+		if err := g.git.formatCommit(bw, c, i+1, len(commits)); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(bw.Flush(), "error writing patches")
+}
+
+// formatCommit writes commit c, the nth of total, as a mailbox message.
+func (g *Git) formatCommit(w *bufio.Writer, c *object.Commit, n, total int) error {
+	var parent *object.Commit
+	if c.NumParents() > 0 {
+		var err error
+		if parent, err = c.Parent(0); err != nil {
+			return errors.Wrapf(err, "error reading parent of %v", c.Hash)
+		}
+	}
+	changes, err := g.treeChanges(parent, c)
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(c)
+	if err != nil {
+		return err
+	}
+	parentFiles := map[string]bareEntry{}
+	if parent != nil {
+		if parentFiles, err = commitFiles(parent); err != nil {
+			return err
+		}
+	}
+
+	msg := strings.TrimSpace(c.Message)
+	subject, body := msg, ""
+	if i := strings.Index(msg, "\n\n"); i >= 0 {
+		subject, body = msg[:i], strings.TrimSpace(msg[i:])
+	}
+	subject = strings.Join(strings.Fields(subject), " ")
+	prefix := "[PATCH]"
+	if total > 1 {
+		prefix = fmt.Sprintf("[PATCH %d/%d]", n, total)
+	}
+
+	fmt.Fprintf(w, "From %v Mon Sep 17 00:00:00 2001\n", c.Hash)
+	fmt.Fprintf(w, "From: %v <%v>\n", mime.QEncoding.Encode("utf-8", c.Author.Name), c.Author.Email)
+	fmt.Fprintf(w, "Date: %v\n", c.Author.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(w, "Subject: %v %v\n", prefix, mime.QEncoding.Encode("utf-8", subject))
+	if !isASCII(msg) {
+		w.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	w.WriteString("\n")
+	if body != "" {
+		w.WriteString(body + "\n")
+	}
+	w.WriteString("---\n\n")
+
+	for _, ch := range changes {
+		old := ch.Path
+		if ch.Status == Renamed {
+			old = ch.OldPath
+		}
+		if err := g.formatFile(w, old, ch.Path, parentFiles[old], files[ch.Path]); err != nil {
+			return err
+		}
+	}
+	w.WriteString("-- \ngitfs\n\n")
+	return nil
+}
+
+// formatFile writes the git diff of file from, a, to file to, b, zero
+// entries standing for missing files.
+func (g *Git) formatFile(w *bufio.Writer, from, to string, a, b bareEntry) error {
+	var versions [2]string
+	for i, e := range []bareEntry{a, b} {
+		if e.hash.IsZero() {
+			continue
+		}
+		data, err := g.readBlob(e.hash)
+		if err != nil {
+			return err
+		}
+		if looksBinary(data) {
+			return errors.Errorf("binary file %v can't be formatted as a patch", to)
+		}
+		versions[i] = string(data)
+	}
+
+	fmt.Fprintf(w, "diff --git %v %v\n", quotePath("a/"+from), quotePath("b/"+to))
+	switch {
+	case a.hash.IsZero():
+		fmt.Fprintf(w, "new file mode %o\nindex %v..%v\n", b.mode, shortHash(a.hash), shortHash(b.hash))
+	case b.hash.IsZero():
+		fmt.Fprintf(w, "deleted file mode %o\nindex %v..%v\n", a.mode, shortHash(a.hash), shortHash(b.hash))
+	default:
+		if a.mode != b.mode {
+			fmt.Fprintf(w, "old mode %o\nnew mode %o\n", a.mode, b.mode)
+		}
+		if from != to {
+			fmt.Fprintf(w, "rename from %v\nrename to %v\n", quotePath(from), quotePath(to))
+		}
+		if a.hash == b.hash {
+			return nil
+		} else if a.mode != b.mode {
+			fmt.Fprintf(w, "index %v..%v\n", shortHash(a.hash), shortHash(b.hash))
+		} else {
+			fmt.Fprintf(w, "index %v..%v %o\n", shortHash(a.hash), shortHash(b.hash), b.mode)
+		}
+	}
+	if versions[0] == versions[1] {
+		// empty file created or deleted
+		return nil
+	}
+
+	fromPath, toPath := "/dev/null", "/dev/null"
+	if !a.hash.IsZero() {
+		fromPath = quotePath("a/" + from)
+	}
+	if !b.hash.IsZero() {
+		toPath = quotePath("b/" + to)
+	}
+	fmt.Fprintf(w, "--- %v%v\n+++ %v%v\n", fromPath, pathTab(fromPath), toPath, pathTab(toPath))
+	writeHunks(w, lineOps(versions[0], versions[1]))
+	return nil
+}
+
+// lineOp is a line of a diff, kept, deleted or added as its kind is ' ', '-'
+// or '+'.
+type lineOp struct {
+	kind byte
+	text string
+}
+
+func lineOps(a, b string) []lineOp {
+	var ops []lineOp
+	for _, d := range diff.Do(a, b) {
+		kind := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			kind = '-'
+		case diffmatchpatch.DiffInsert:
+			kind = '+'
+		}
+		for _, l := range splitLines(d.Text) {
+			ops = append(ops, lineOp{kind, l})
+		}
+	}
+	return ops
+}
+
+// writeHunks writes ops as hunks, with patchContext unchanged lines around
+// their changes.
+func writeHunks(w *bufio.Writer, ops []lineOp) {
+	oldLine, newLine := 0, 0
+	count := func(ops []lineOp) (olds, news int) {
+		for _, op := range ops {
+			if op.kind != '+' {
+				olds++
+			}
+			if op.kind != '-' {
+				news++
+			}
+		}
+		return olds, news
+	}
+
+	done := 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := i - patchContext
+		if start < done {
+			start = done
+		}
+		// changes less than two contexts apart share a hunk
+		end := i
+		for j := i; j < len(ops); {
+			if ops[j].kind != ' ' {
+				j++
+				end = j
+				continue
+			}
+			k := j
+			for k < len(ops) && ops[k].kind == ' ' {
+				k++
+			}
+			if k == len(ops) || k-j > 2*patchContext {
+				break
+			}
+			j = k
+		}
+		stop := end + patchContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		skippedOld, skippedNew := count(ops[done:start])
+		oldLine, newLine = oldLine+skippedOld, newLine+skippedNew
+		olds, news := count(ops[start:stop])
+		fmt.Fprintf(w, "@@ -%v +%v @@\n", hunkRange(oldLine, olds), hunkRange(newLine, news))
+		for _, op := range ops[start:stop] {
+			w.WriteByte(op.kind)
+			w.WriteString(op.text)
+			if !strings.HasSuffix(op.text, "\n") {
+				w.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		oldLine, newLine = oldLine+olds, newLine+news
+		done, i = stop, stop
+	}
+}
+
+// hunkRange formats the range of count lines after line of a hunk header.
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line)
+	case 1:
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+// quotePath quotes p like git if it has control characters, quotes or
+// backslashes.
+func quotePath(p string) string {
+	if !strings.ContainsAny(p, "\"\\") && strings.IndexFunc(p, func(r rune) bool { return r < ' ' || r == 0x7f }) < 0 {
+		return p
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// pathTab returns the tab git ends ---, +++ lines with for paths with
+// spaces, telling them from timestamps.
+func pathTab(p string) string {
+	if strings.Contains(p, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+func shortHash(h plumbing.Hash) string {
+	return h.String()[:7]
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -4,24 +4,149 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage"
 )
 
 type Config struct {
-	// Remote repo url. Only support ssh protocol
+	// Remote repo url, ssh, https, git or a local path / file:// url
 	repoUrl string
+	// Basic auth credentials for https urls
+	httpUser     string
+	httpPassword string
+	// Consulted for auth before every transport operation, overriding the
+	// other auth settings
+	authProvider AuthProvider
+	// If https credentials are asked to git credential helpers
+	credentialHelper bool
+	// Proxy for http(s) remotes, empty for the environment ones
+	proxyUrl string
+	// User for ssh remotes, overriding the url and ssh config
+	sshUser string
+	// Private key and certificate files of ssh remotes, overriding the
+	// ssh config
+	sshKeyFile  string
+	sshCertFile string
+	// Cache of ssh auth shared with other repos, set by Manager
+	sshAuths *sshAuthCache
+	// Admits remote operations, possibly shared with other repos
+	rateLimiter *RateLimiter
+	// Bytes per second transferred with the remote host, 0 for no limit
+	bandwidthLimit int64
+	// Provider of the tracer spans are started with, nil to disable
+	tracerProvider trace.TracerProvider
+	hooks          Hooks
+	preCommit      PreCommitHook
+	// If symlinks can't be created nor committed
+	noSymlinks bool
+	// If .git is accessible through GitFs, for debugging
+	exposeGitDir bool
 	// If use local memory to back filesystem
 	useMemFs bool
+	// If > 0, memory budget in bytes beyond which memfs files spill to disk
+	spillBudget int64
 	// If use OS file system (not memory fs), then provide dir path
 	osFsBaseDir string
 	// If open existing repo
 	openExisting bool
+	// If an existing repo is verified, and recloned if corrupt
+	verifyOnOpen bool
+	// If corrupt repos are cloned again keeping their worktree
+	autoRecover bool
+	// Number of workers for checkout and status, <= 1 to disable
+	concurrency int
+	// If status caches file hashes by size and mtime
+	statusCache bool
+	// Custom backends, overriding memFs/osFs when set
+	storer     storage.Storer
+	worktreeFs billy.Filesystem
+	// Keys to sign commits with, at most one is set
+	pgpKey    *openpgp.Entity
+	sshSigner ssh.Signer
+	// Keys pulled commits must be signed with
+	trustKeys     []PublicKey
+	requireSigned bool
+	// Size above which WriteFile stores files compressed, 0 to disable
+	compressAbove int64
+	// Size limits in bytes, 0 to disable
+	maxFileSize int64
+	repoQuota   int64
+	// Globs of paths GitFs may write, nil for all
+	writablePaths []string
+	// If commits are kept local until Flush
+	offline bool
+	// If the repo has no remote at all
+	noRemote bool
+	// Branch cloned and synced, empty for the default one. If bare, it
+	// is read and written through the tree of branch
+	bare   bool
+	branch string
+	// If branch is created without history when the remote lacks it
+	orphan bool
+	// If > 0, changes are synced this often in the background until Close,
+	// as set by the sync_interval of ConfigFromFile
+	syncInterval time.Duration
+	// If Close syncs changes, and where it writes a snapshot, nil for none
+	syncOnClose   bool
+	closeSnapshot io.Writer
+	// If Sync commits even without changes
+	allowEmpty bool
+	// Name of the temp dirs excluded from staging, and if Sync removes them
+	tempDir   string
+	cleanTemp bool
+	// How long Sync, Apply and Pull wait for the repo lock
+	lockTimeout time.Duration
+	// Bundle the repo is restored from instead of cloned, set by Restore
+	bundle io.Reader
+	// Minimum similarity in percent of renamed files, 0 for the default
+	renameScore  int
+	noRenames    bool
+	detectCopies bool
+	// If FileInfo mod times are those of the last commit of each path
+	commitModTime bool
+	// If set, Sync opens a review of its changes instead of pushing
+	review     ReviewProvider
+	reviewOpts ReviewOptions
+	// Template of sync commit messages, empty for the default, and the
+	// conventional commit types of paths
+	commitTemplate string
+	commitTypes    []commitType
+	// If sync commit bodies list the changes with line stats
+	commitSummary bool
+	// External commands run before and after syncs, nil for none
+	preSync  *SyncCommand
+	postSync *SyncCommand
+	// Line ending conversion of text files
+	gitAttributes bool
+	autocrlf      AutoCRLF
+	// Size above which new binary files are reported by Sync, or refused
+	binaryLimit    int64
+	refuseBinaries bool
+	// Globs of hot paths read ahead after clones and pulls
+	prefetch []string
+	// Merge drivers by file extension, see SetMergeDriver
+	mergeDrivers map[string]MergeDriver
+	// Time of commits and AutoSync, nil for the system time
+	clock Clock
+	// If commits are reproducible, see Deterministic
+	deterministic bool
+	// Replacing the pulls and pushes of git, nil for none
+	puller Puller
+	pusher Pusher
 }
 
 func NewConfig() *Config {
@@ -33,33 +158,656 @@ func (c *Config) SetUrl(url string) *Config {
 	return c
 }
 
+// SetBasicAuth sets the credentials used for https repo urls. For token
+// based hosts, pass the token as password. Without it, credentials embedded
+// in the url are used.
+func (c *Config) SetBasicAuth(user, password string) *Config {
+	c.httpUser = user
+	c.httpPassword = password
+	return c
+}
+
+// UseCredentialHelper takes the credentials of https urls, unless set by
+// SetBasicAuth or in the url, from the credential helpers configured for
+// git, e.g. osxkeychain, manager-core or libsecret, so gitfs reuses what
+// the user already set up. It runs `git credential fill` once when the
+// repo is opened, which requires the git binary.
+func (c *Config) UseCredentialHelper() *Config {
+	c.credentialHelper = true
+	return c
+}
+
+// SetTokenSource authenticates to https remotes with tokens of ts, taken
+// before every clone, pull, fetch and push, so tokens are refreshed across
+// long-running syncs. It is a shorthand for SetAuthProvider(TokenAuth(ts)).
+func (c *Config) SetTokenSource(ts TokenSource) *Config {
+	return c.SetAuthProvider(TokenAuth(ts))
+}
+
+// SetAuthProvider takes the auth method of the remote repo from p before
+// every clone, pull, fetch and push, overriding the other auth settings.
+func (c *Config) SetAuthProvider(p AuthProvider) *Config {
+	c.authProvider = p
+	return c
+}
+
+// SetSSHUser sets the user ssh remotes are logged in as, overriding the
+// user of the url, e.g. "ssh://git@host:2222/org/repo.git", and of
+// ~/.ssh/config. Without any of them, "git" is used.
+func (c *Config) SetSSHUser(user string) *Config {
+	c.sshUser = user
+	return c
+}
+
+// SetSSHCertificate logs in to ssh remotes with the private key in keyFile,
+// presenting the OpenSSH certificate in certFile signed by the ssh CA of
+// the git host. Empty files default to the IdentityFile and
+// CertificateFile of ~/.ssh/config, and like OpenSSH, a certificate next to
+// the key named after it plus "-cert.pub" is presented if there is one.
+// For certificates rotating while the repo is open, see SetAuthProvider
+// and SSHCertificateAuth.
+func (c *Config) SetSSHCertificate(keyFile, certFile string) *Config {
+	c.sshKeyFile = keyFile
+	c.sshCertFile = certFile
+	return c
+}
+
+// SetProxy routes https remotes through proxyUrl, either an http CONNECT or
+// a socks5 proxy, e.g. "http://proxy:3128" or "socks5://proxy:1080".
+// Without it, HTTPS_PROXY, HTTP_PROXY and ALL_PROXY are honored. The proxy
+// applies to the remote of this GitFs only. ssh remotes only honor a
+// socks5 ALL_PROXY, as go-git v4 allows no custom ssh dialer, which also
+// rules out ProxyJump.
+func (c *Config) SetProxy(proxyUrl string) *Config {
+	c.proxyUrl = proxyUrl
+	return c
+}
+
+// SetRateLimiter makes clones, pulls, fetches and pushes wait for l to
+// admit them, failing with ErrRateLimited if it doesn't in time. Share l
+// between repos to bound their operations as a whole, see
+// Manager.SetRateLimiter.
+func (c *Config) SetRateLimiter(l *RateLimiter) *Config {
+	c.rateLimiter = l
+	return c
+}
+
+// SetBandwidthLimit throttles the packfiles clones, fetches and pushes
+// transfer to bytesPerSec, so the initial clone of a large repo doesn't
+// saturate the uplink of an edge device. Like SetProxy, the limit applies
+// to the remote of this GitFs only, shared by all its transfers. A
+// throttled transfer stops waiting once its operation is canceled. 0
+// disables it.
+func (c *Config) SetBandwidthLimit(bytesPerSec int64) *Config {
+	c.bandwidthLimit = bytesPerSec
+	return c
+}
+
 func (c *Config) UseMemFs() *Config {
+	c.worktreeFs = nil
 	c.useMemFs = true
+	c.spillBudget = 0
 	c.openExisting = false
 	c.osFsBaseDir = ""
 	return c
 }
 
+// UseHybridFs backs the filesystem by memory like UseMemFs, but files
+// written after memBudget bytes are in use are moved to a temp dir on disk.
+func (c *Config) UseHybridFs(memBudget int64) *Config {
+	c.UseMemFs()
+	c.spillBudget = memBudget
+	return c
+}
+
 func (c *Config) UseOsFs(baseDir string, openExisting bool) *Config {
+	c.worktreeFs = nil
 	c.useMemFs = false
+	c.spillBudget = 0
 	c.openExisting = openExisting
 	c.osFsBaseDir = baseDir
 	return c
 }
 
+// VerifyOnOpen verifies an existing repo when opened, reading every object
+// reachable from its refs like git fsck, and those the index refers to. The
+// worktree is not checked, changes not yet synced are no corruption. A
+// corrupt osFs repo is cloned again keeping its worktree, like AutoRecover
+// does. Without a remote, or offline, New fails with ErrCorruptRepo
+// instead. Verifying reads the whole history, so it takes a while on large
+// repos.
+func (c *Config) VerifyOnOpen() *Config {
+	c.verifyOnOpen = true
+	return c
+}
+
+// AutoRecover recovers an osFs repo whose git dir is found corrupt when
+// opened, e.g. with objects or refs truncated by a power loss, rather than
+// failing: the worktree is backed up next to the base dir, the repo cloned
+// again and the worktree restored over the clone, so changes not yet synced
+// survive as such. Recoveries are reported to Hooks.OnError. Opening checks
+// the index and HEAD only, VerifyOnOpen checks all objects. Other errors,
+// like those of I/O, fail New as usual. Without a remote, or offline, New
+// fails with ErrCorruptRepo instead.
+func (c *Config) AutoRecover() *Config {
+	c.autoRecover = true
+	return c
+}
+
+// SetConcurrency sets the number of workers hashing files for status,
+// writing files for the initial checkout on osFs and reading files for
+// ReadFiles. Values <= 1 disable parallelism, though ReadFiles reads with
+// 8 workers unless set.
+func (c *Config) SetConcurrency(n int) *Config {
+	c.concurrency = n
+	return c
+}
+
+// EnableStatusCache makes status skip rehashing files whose size and mtime
+// are unchanged since the last status. memFs reports no real mtimes, so it
+// doesn't benefit.
+func (c *Config) EnableStatusCache() *Config {
+	c.statusCache = true
+	return c
+}
+
+// Offline opens an existing clone without contacting the remote repo, and
+// makes Sync and Apply only commit locally. Queued commits are pushed by
+// GitFs.Flush. It requires opening an existing repo.
+func (c *Config) Offline() *Config {
+	c.offline = true
+	return c
+}
+
+// NoRemote inits, or opens, a purely local repo. Sync and Apply commit
+// without pushing, Pull and Flush fail with ErrNoRemote.
+func (c *Config) NoRemote() *Config {
+	c.repoUrl = ""
+	c.noRemote = true
+	return c
+}
+
+// SetTracerProvider traces clone, pull, commit, push and large fs
+// operations with spans of a tracer from tp, an OpenTelemetry
+// TracerProvider. Spans are children of the span of the context given to
+// New, those of the commit and push of a sync children of its span.
+func (c *Config) SetTracerProvider(tp trace.TracerProvider) *Config {
+	c.tracerProvider = tp
+	return c
+}
+
+// SetHooks sets the callbacks notified of pulls, commits, pushes, conflicts
+// and errors.
+func (c *Config) SetHooks(h Hooks) *Config {
+	c.hooks = h
+	return c
+}
+
+// SetPreCommitHook sets the hook validating changes before Sync and Apply
+// commit them. If it fails, nothing is committed.
+func (c *Config) SetPreCommitHook(h PreCommitHook) *Config {
+	c.preCommit = h
+	return c
+}
+
+// ForbidSymlinks makes Symlink fail with ErrSymlinkForbidden, and Sync and
+// Apply refuse to commit symlinks found in the worktree otherwise. Symlinks
+// are allowed by default and committed with mode 120000 on all backends.
+func (c *Config) ForbidSymlinks() *Config {
+	c.noSymlinks = true
+	return c
+}
+
+// ExposeGitDir makes .git visible and writable through GitFs, which is
+// blocked by default as writes to it can corrupt the repo. Only meant for
+// debugging.
+func (c *Config) ExposeGitDir() *Config {
+	c.exposeGitDir = true
+	return c
+}
+
+// AllowEmpty makes Sync commit and push even when nothing changed, e.g.
+// for heartbeat commits. By default such syncs are skipped.
+func (c *Config) AllowEmpty() *Config {
+	c.allowEmpty = true
+	return c
+}
+
+// SetOrphanBranch clones, pulls and pushes branch instead of the default
+// branch of the remote, e.g. "gitfs-data" to keep data alongside a project
+// without touching its code branches. If the remote has no such branch
+// yet, the worktree starts empty and the first Sync creates it with no
+// history, sharing no commit with other branches.
+func (c *Config) SetOrphanBranch(branch string) *Config {
+	c.branch = branch
+	c.orphan = true
+	return c
+}
+
+// SyncOnClose makes Close sync changes still pending, pushing them unless
+// offline, like Sync.
+func (c *Config) SyncOnClose() *Config {
+	c.syncOnClose = true
+	return c
+}
+
+// SnapshotOnClose makes Close write a Snapshot of the repo to w, e.g. so a
+// memFs repo outlives the process, recreated by Restore.
+func (c *Config) SnapshotOnClose(w io.Writer) *Config {
+	c.closeSnapshot = w
+	return c
+}
+
+// Bare opens, clones or inits a bare repo, with no checkout at all. Files
+// are streamed straight from the tree of branch, the default one if empty,
+// so large files are never loaded whole, and written files are kept in
+// memory until committed by Sync. With osFs, the base dir is the bare repo
+// itself. Repos with a worktree read files from it instead, osFs streaming
+// them from disk and memFs holding them whole, only their views, like
+// SnapshotReader, stream from the object store.
+func (c *Config) Bare(branch string) *Config {
+	c.bare = true
+	c.branch = branch
+	return c
+}
+
+// SetTempDir sets the name of the dir TempFile creates files in when
+// given no dir, ".gitfs-tmp" by default. Dirs of this name are never
+// staged, wherever they are, so temp files don't end up in commits.
+func (c *Config) SetTempDir(name string) *Config {
+	c.tempDir = name
+	return c
+}
+
+// CleanTempOnSync makes Sync remove the temp dirs, see SetTempDir, and the
+// files TempFile created in other dirs, once changes are committed. Temp
+// files still in use are removed too.
+func (c *Config) CleanTempOnSync() *Config {
+	c.cleanTemp = true
+	return c
+}
+
+// SetLockTimeout sets how long Sync, Apply and Pull wait for the repo
+// lock held by other processes or goroutines, 30s by default.
+func (c *Config) SetLockTimeout(d time.Duration) *Config {
+	c.lockTimeout = d
+	return c
+}
+
+// SetRenameThreshold sets how similar in percent, by lines of content, a
+// removed and an added file must be for Status, ChangedPaths and
+// PullWithResult to report them as a single rename, 50 by default like
+// git. 100 only pairs files of equal content.
+func (c *Config) SetRenameThreshold(score int) *Config {
+	c.renameScore = score
+	c.noRenames = false
+	return c
+}
+
+// DisableRenameDetection reports renamed files as removed and added.
+func (c *Config) DisableRenameDetection() *Config {
+	c.noRenames = true
+	return c
+}
+
+// DetectCopies reports added files as Copied from an existing file they
+// are equal to, or from a file modified by the same change they are
+// similar to, like git's -C.
+func (c *Config) DetectCopies() *Config {
+	c.detectCopies = true
+	return c
+}
+
+// UseCommitModTime makes Stat, Lstat and ReadDir report the time of the
+// last commit changing a path, as of HEAD, as its mod time, e.g. for HTTP
+// caching. Times are computed per directory and cached until HEAD moves.
+// Files not committed yet keep the mod time of the backing filesystem.
+func (c *Config) UseCommitModTime() *Config {
+	c.commitModTime = true
+	return c
+}
+
+// SetReviewProvider makes Sync push its commits to a branch of their own,
+// named by opts, and open a pull or merge request of it into the synced
+// branch through p, for remotes where direct pushes are forbidden. Commits
+// stay ahead of the remote branch until the request is merged and pulled.
+func (c *Config) SetReviewProvider(p ReviewProvider, opts ReviewOptions) *Config {
+	c.review = p
+	c.reviewOpts = opts
+	return c
+}
+
+// SetCommitMessageTemplate renders the messages of Sync commits with the
+// text/template tmpl, given a CommitMessage, e.g.
+//
+//	{{.Type}}: {{.Summary}} on {{.Host}}
+//
+//	{{join .ChangedFiles "\n"}}
+//
+// The default is "gitfs sync - " and the time, prefixed by the type.
+func (c *Config) SetCommitMessageTemplate(tmpl string) *Config {
+	c.commitTemplate = tmpl
+	return c
+}
+
+// AddCommitType sets the conventional commit type, e.g. "docs" or
+// "feat(config)", of Sync commits whose changes are all under glob, in
+// writable path syntax, see SetWritablePaths. The first type added whose
+// glob matches all changes applies.
+func (c *Config) AddCommitType(glob, typ string) *Config {
+	c.commitTypes = append(c.commitTypes, commitType{glob: glob, typ: typ})
+	return c
+}
+
+// EnableCommitSummary appends a body to the messages of Sync commits
+// listing the added, modified and deleted files with the lines added and
+// removed, like git diff --stat, so repo browsers show what each sync
+// changed. Binary files and those above 1 MiB get no line stats.
+func (c *Config) EnableCommitSummary() *Config {
+	c.commitSummary = true
+	return c
+}
+
+// SetPreSyncCommand runs cmd before Sync stages changes, e.g. to format
+// or validate them, see SyncCommand. Files it changes are committed along.
+// If it fails, nothing is committed and Sync fails with its output. Syncs
+// without changes skip it.
+func (c *Config) SetPreSyncCommand(cmd SyncCommand) *Config {
+	c.preSync = &cmd
+	return c
+}
+
+// SetPostSyncCommand runs cmd after a Sync created a commit, e.g. to notify
+// other systems, see SyncCommand. Its failure is reported to
+// Hooks.OnError, the sync has succeeded by then.
+func (c *Config) SetPostSyncCommand(cmd SyncCommand) *Config {
+	c.postSync = &cmd
+	return c
+}
+
+// UseGitAttributes converts the line endings of files as the text and eol
+// attributes of .gitattributes say, like git does: WriteFile and Sync
+// commit text files with LF line endings, and ReadFile reads those with
+// eol=crlf with CRLF ones. Files opened as streams are read as stored,
+// with LF line endings, but Sync normalizes what they wrote. Files
+// without these attributes are converted as SetAutoCRLF says.
+func (c *Config) UseGitAttributes() *Config {
+	c.gitAttributes = true
+	return c
+}
+
+// SetAutoCRLF converts the line endings of text files like core.autocrlf
+// of git, e.g. so files edited on Windows don't change every line on each
+// sync, see UseGitAttributes. Files are text unless they have a NUL byte
+// in their first 8000 bytes.
+func (c *Config) SetAutoCRLF(mode AutoCRLF) *Config {
+	c.autocrlf = mode
+	return c
+}
+
+// WarnLargeBinaries makes Sync report new binary files larger than size
+// bytes in SyncResult.LargeBinaries, see GitFs.IsBinary. Binaries bloat
+// the history for good, even once removed.
+func (c *Config) WarnLargeBinaries(size int64) *Config {
+	c.binaryLimit = size
+	c.refuseBinaries = false
+	return c
+}
+
+// RefuseLargeBinaries makes Sync fail with ErrLargeBinary, committing
+// nothing, if there are new binary files larger than size bytes.
+func (c *Config) RefuseLargeBinaries(size int64) *Config {
+	c.binaryLimit = size
+	c.refuseBinaries = true
+	return c
+}
+
+// Prefetch reads the files under paths matching one of globs into memory
+// in the background once the repo is cloned or opened and after every pull
+// moving HEAD, so their first reads don't wait for the disk or for
+// decompressing files stored compressed. Globs match like
+// SetWritablePaths, e.g. "configs" or "configs/*.json". Prefetched files
+// are dropped once read or written, and at most 64MiB are kept.
+func (c *Config) Prefetch(globs ...string) *Config {
+	c.prefetch = append([]string{}, globs...)
+	return c
+}
+
+// SetMergeDriver makes Merge with MergeContent merge files with extension
+// ext, e.g. ".json", by driver. JSON and YAML files are merged by MergeJSON
+// and MergeYAML unless set, other files by Merge3.
+func (c *Config) SetMergeDriver(ext string, driver MergeDriver) *Config {
+	if c.mergeDrivers == nil {
+		c.mergeDrivers = map[string]MergeDriver{}
+	}
+	c.mergeDrivers[strings.ToLower(ext)] = driver
+	return c
+}
+
+// SetClock makes commits timestamped, and AutoSync poll, by clock instead
+// of the system time, e.g. a ManualClock in tests.
+func (c *Config) SetClock(clock Clock) *Config {
+	c.clock = clock
+	return c
+}
+
+// Deterministic makes commits reproducible, byte-identical for the same
+// changes, e.g. for tests and reproducible builds: unless a clock is set,
+// they are timestamped at the Unix epoch, and commit message templates see
+// the host "gitfs". Signed commits still differ by their signatures.
+func (c *Config) Deterministic() *Config {
+	c.deterministic = true
+	return c
+}
+
+// SetPuller makes Pull pull by p instead of git, e.g. a fake in unit tests
+// of the sync logic of applications.
+func (c *Config) SetPuller(p Puller) *Config {
+	c.puller = p
+	return c
+}
+
+// SetPusher makes Sync and the like push by p instead of git, even in repos
+// without remote, e.g. a fake in unit tests of the sync logic of
+// applications. Syncs then push only the commits they make, those queued by
+// failed pushes are left to Flush.
+func (c *Config) SetPusher(p Pusher) *Config {
+	c.pusher = p
+	return c
+}
+
+// SetStorer stores the git objects and refs in s instead of the .git dir
+// of the worktree filesystem. Reset, and thus Sync with purge, is not
+// supported with a custom storer.
+func (c *Config) SetStorer(s storage.Storer) *Config {
+	c.storer = s
+	return c
+}
+
+// SetWorktreeFS backs the worktree by fs instead of memFs or osFs.
+// openExisting tells whether an existing repo in fs is used.
+func (c *Config) SetWorktreeFS(fs billy.Filesystem, openExisting bool) *Config {
+	c.worktreeFs = fs
+	c.useMemFs = false
+	c.spillBudget = 0
+	c.osFsBaseDir = ""
+	c.openExisting = openExisting
+	return c
+}
+
+// SetSigningKey signs all commits with the given decrypted openpgp key.
+func (c *Config) SetSigningKey(key *openpgp.Entity) *Config {
+	c.pgpKey = key
+	c.sshSigner = nil
+	return c
+}
+
+// SetSSHSigningKey signs all commits with the given ssh key, in the format
+// of git's gpg.format=ssh.
+func (c *Config) SetSSHSigningKey(signer ssh.Signer) *Config {
+	c.sshSigner = signer
+	c.pgpKey = nil
+	return c
+}
+
+// VerifySignatures makes Pull reject remote commits which are not signed by
+// one of keys. It is a shorthand for SetTrustPolicy(keys, true).
+func (c *Config) VerifySignatures(keys ...PublicKey) *Config {
+	return c.SetTrustPolicy(keys, true)
+}
+
+// SetTrustPolicy makes Pull refuse to fast-forward onto remote commits
+// whose signature is not made by one of keys. Unsigned commits are accepted
+// unless requireSigned is set. An empty keys disables verification, and is
+// refused by Valid with requireSigned, which no commit could meet.
+func (c *Config) SetTrustPolicy(keys []PublicKey, requireSigned bool) *Config {
+	c.trustKeys = keys
+	c.requireSigned = requireSigned
+	return c
+}
+
+// SetCompression makes WriteFile gzip files larger than threshold bytes,
+// storing them under their name plus ".gitfs.gz". ReadFile, Open, Stat,
+// Lstat and ReadDir present such files decompressed under their name,
+// opened read only.
+func (c *Config) SetCompression(threshold int64) *Config {
+	c.compressAbove = threshold
+	return c
+}
+
+// SetMaxFileSize makes writes growing a file beyond n bytes fail with a
+// QuotaError.
+func (c *Config) SetMaxFileSize(n int64) *Config {
+	c.maxFileSize = n
+	return c
+}
+
+// SetRepoQuota makes Sync fail with a QuotaError instead of committing and
+// pushing, if the worktree and the objects of the repo take up more than n
+// bytes. Apply fails the same before writing any file if its changes would
+// grow the repo beyond n bytes.
+func (c *Config) SetRepoQuota(n int64) *Config {
+	c.repoQuota = n
+	return c
+}
+
+// SetWritablePaths restricts writes through GitFs to paths matching one of
+// globs, or under a directory matching one. Globs are relative to the repo
+// root and use filepath.Match syntax, e.g. "services/billing" or
+// "config/*.json". Other writes fail with a permission error. An empty list
+// makes the GitFs read-only.
+func (c *Config) SetWritablePaths(globs []string) *Config {
+	c.writablePaths = append([]string{}, globs...)
+	return c
+}
+
+// Valid checks and normalizes c, see normalizeRepoUrl, returning a
+// ConfigError listing every problem found.
 func (c *Config) Valid() error {
+	var errs []error
+	fail := func(err error) {
+		errs = append(errs, err)
+	}
+
 	c.repoUrl = strings.TrimSpace(c.repoUrl)
-	if c.repoUrl == "" {
-		return errors.New("empty repo url")
+	if c.noRemote {
+		if c.repoUrl != "" {
+			fail(errors.New("repo url and no remote are mutually exclusive"))
+		}
+	} else if c.repoUrl == "" {
+		fail(errors.New("empty repo url"))
+	} else if u, err := normalizeRepoUrl(c.repoUrl); err != nil {
+		fail(err)
+	} else {
+		c.repoUrl = u
+	}
+
+	if c.proxyUrl != "" {
+		if _, err := parseProxyUrl(c.proxyUrl); err != nil {
+			fail(err)
+		}
 	}
 
 	c.osFsBaseDir = strings.TrimSpace(c.osFsBaseDir)
-	if c.useMemFs && c.osFsBaseDir != "" {
-		return errors.New("memFs and osFs base dir are mutually exclusive")
+	if c.worktreeFs != nil {
+		if c.useMemFs || c.osFsBaseDir != "" {
+			fail(errors.New("custom worktree fs is mutually exclusive with memFs and osFs"))
+		}
+	} else if c.useMemFs && c.osFsBaseDir != "" {
+		fail(errors.New("memFs and osFs base dir are mutually exclusive"))
 	} else if !c.useMemFs && c.osFsBaseDir == "" {
-		return errors.New("osFs base dir is not provided")
+		fail(errors.New("osFs base dir is not provided"))
+	} else if !c.useMemFs {
+		if err := checkWritableDir(c.osFsBaseDir); err != nil {
+			fail(err)
+		}
+	}
+
+	if c.orphan && c.branch == "" {
+		fail(errors.New("orphan branch name is empty"))
+	}
+
+	if c.bare && c.worktreeFs != nil {
+		fail(errors.New("bare repo and custom worktree fs are mutually exclusive"))
+	}
+
+	if c.review != nil && c.noRemote {
+		fail(errors.New("review provider requires a remote"))
 	}
 
+	if c.offline && c.noRemote {
+		fail(errors.New("offline mode and no remote are mutually exclusive"))
+	} else if c.offline && !c.openExisting {
+		fail(errors.New("offline mode requires opening an existing repo"))
+	}
+
+	if c.requireSigned && len(c.trustKeys) == 0 {
+		fail(errors.New("signed commits are required but no key is trusted"))
+	}
+
+	if c.bandwidthLimit < 0 {
+		fail(errors.Errorf("bandwidth limit %v is negative", c.bandwidthLimit))
+	}
+
+	if c.renameScore < 0 || c.renameScore > 100 {
+		fail(errors.Errorf("rename threshold %v is not a percentage", c.renameScore))
+	}
+
+	if err := validTempDir(c.tempDir); err != nil {
+		fail(err)
+	}
+
+	for _, cmd := range []*SyncCommand{c.preSync, c.postSync} {
+		if cmd != nil && len(cmd.Args) == 0 {
+			fail(errors.New("sync command has no program"))
+		}
+	}
+
+	if c.commitTemplate != "" {
+		if _, err := parseCommitTemplate(c.commitTemplate); err != nil {
+			fail(err)
+		}
+	}
+	for _, t := range c.commitTypes {
+		if _, err := filepath.Match(t.glob, ""); err != nil {
+			fail(errors.Wrapf(err, "invalid commit type path %v", t.glob))
+		}
+	}
+
+	for _, glob := range c.writablePaths {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			fail(errors.Wrapf(err, "invalid writable path %v", glob))
+		}
+	}
+	for _, glob := range c.prefetch {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			fail(errors.Wrapf(err, "invalid prefetch path %v", glob))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Problems: errs}
+	}
 	return nil
 }
 
@@ -68,39 +816,363 @@ func New(ctx context.Context, config *Config) (*GitFs, error) {
 		return nil, err
 	}
 
-	git, err := NewGit(ctx, config.repoUrl, config.useMemFs, config.osFsBaseDir, !config.openExisting)
+	message, err := newCommitMessage(config)
+	if err != nil {
+		return nil, err
+	}
+
+	git, err := NewGit(ctx, config)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating git client")
 	}
 
-	return &GitFs{
-		git: git,
-		fs:  git.FileSystem(),
-	}, nil
+	g := &GitFs{
+		git:           git,
+		fs:            git.FileSystem(),
+		compressAbove: config.compressAbove,
+		maxFileSize:   config.maxFileSize,
+		repoQuota:     config.repoQuota,
+		writable:      config.writablePaths,
+		offline:       config.offline,
+		events:        newEventBus(),
+		exposeGitDir:  config.exposeGitDir,
+		allowEmpty:    config.allowEmpty,
+		review:        config.review,
+		reviewOpts:    config.reviewOpts,
+		message:       message,
+		preSync:       config.preSync,
+		postSync:      config.postSync,
+		binaryLimit:   config.binaryLimit,
+		refuseBinary:  config.refuseBinaries,
+		prefetched:    newPrefetcher(config.prefetch),
+		mergeDrivers:  config.mergeDrivers,
+		puller:        config.puller,
+		pusher:        config.pusher,
+	}
+	g.life = newLifecycle(ctx, config)
+	git.life, git.ctx = g.life, g.life.ops
+	if g.prefetched != nil {
+		g.background(func(context.Context) { g.prefetch() })
+	}
+	if config.syncInterval > 0 {
+		g.background(func(ctx context.Context) { g.syncEvery(ctx, config.syncInterval) })
+	}
+	return g, nil
 }
 
 type GitFs struct {
-	git *Git
-	fs  billy.Filesystem
+	git           *Git
+	fs            billy.Filesystem
+	compressAbove int64
+	maxFileSize   int64
+	repoQuota     int64
+	writable      []string
+	offline       bool
+	events        *eventBus
+	exposeGitDir  bool
+	// Path of fs within the repo, set by Chroot
+	root string
+	// If Sync commits even without changes
+	allowEmpty bool
+	// If set, Sync opens reviews, the last one of commit reviewed
+	review     ReviewProvider
+	reviewOpts ReviewOptions
+	reviewed   plumbing.Hash
+	// Renders sync commit messages, nil for the default one
+	message *commitMessage
+	// Commands run around syncs
+	preSync  *SyncCommand
+	postSync *SyncCommand
+	// Size above which Sync reports new binaries, or refuses them
+	binaryLimit  int64
+	refuseBinary bool
+	// Hot files read ahead, nil unless configured
+	prefetched *prefetcher
+	// Path within the repo syncs are limited to, set by Scope
+	scope string
+	// Merge drivers by file extension, nil for the default ones
+	mergeDrivers map[string]MergeDriver
+	// Replacing the pulls and pushes of git, nil for none
+	puller Puller
+	pusher Pusher
+	// Background goroutines and teardown, nil for views and worktrees
+	life *lifecycle
+}
+
+// SetOffline switches offline mode, see Config.Offline. Going online does
+// not push queued commits by itself, call Flush.
+func (g *GitFs) SetOffline(offline bool) {
+	g.offline = offline
+}
+
+// Flush pushes all commits queued while offline to the remote repo.
+func (g *GitFs) Flush() error {
+	if g.git.noRemote && g.pusher == nil {
+		return ErrNoRemote
+	}
+	if _, err := g.push(g.git.ctx); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "error pushing queued changes to remote repo")
+	}
+	return nil
+}
+
+// PushRefs pushes the given refspecs to the remote repo, e.g.
+// "refs/heads/dev:refs/heads/dev" or "refs/tags/v1:refs/tags/v1", forcing
+// the updates if force is set. Like Flush, it pushes even when offline.
+func (g *GitFs) PushRefs(refspecs []string, force bool) error {
+	specs := make([]string, len(refspecs))
+	for i, s := range refspecs {
+		if force && !strings.HasPrefix(s, "+") {
+			s = "+" + s
+		}
+		specs[i] = s
+	}
+	if err := g.git.PushRefs(specs); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "error pushing refs to remote repo")
+	}
+	return nil
+}
+
+// PushTags pushes all tags to the remote repo, without moving tags the
+// remote already has.
+func (g *GitFs) PushTags() error {
+	return g.PushRefs([]string{"refs/tags/*:refs/tags/*"}, false)
+}
+
+// Ping verifies the remote repo is reachable and auth works, e.g. for
+// readiness probes.
+func (g *GitFs) Ping(ctx context.Context) error {
+	return g.git.Ping(ctx)
+}
+
+// PullResult describes what a pull did.
+type PullResult struct {
+	// HEAD before and after the pull, equal if already up to date
+	Before plumbing.Hash
+	After  plumbing.Hash
+	// Files changed by the pull, sorted by path, see ChangedPaths
+	Changes []Change
 }
 
 func (g *GitFs) Pull() error {
-	return g.git.Pull()
+	_, err := g.PullWithResult()
+	return err
+}
+
+// PullWithResult pulls like Pull, reporting the HEAD before and after and
+// the files changed in between, e.g. to invalidate caches of those only.
+func (g *GitFs) PullWithResult() (res PullResult, err error) {
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return res, err
+	}
+	defer unlock(&err)
+
+	res.Before = g.git.headHash()
+	if err := g.pull(g.git.ctx); err != nil {
+		return res, err
+	}
+	res.After = g.git.headHash()
+	if res.After == res.Before {
+		return res, nil
+	}
+	if g.prefetched != nil {
+		g.background(func(context.Context) { g.prefetch() })
+	}
+
+	res.Changes, err = g.git.hashChanges(res.Before, res.After)
+	return res, err
+}
+
+// Status returns the status code of every changed file of the worktree.
+// Renamed and copied files are reported at their new path only, see
+// StatusChanges.
+func (g *GitFs) Status() (map[string]StatusCode, error) {
+	changes, err := g.StatusChanges()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]StatusCode{}
+	for _, c := range changes {
+		files[c.Path] = c.Status
+	}
+	return files, nil
+}
+
+// StatusChanges returns the changes of the worktree sorted by path, with
+// the former path of renamed and copied files, see
+// Config.SetRenameThreshold. Data is not loaded. Scopes report their own
+// changes only.
+func (g *GitFs) StatusChanges() (changes []Change, err error) {
+	defer g.git.trace("gitfs.Status")(&err)
+	if changes, err = g.git.worktreeChanges(); err != nil || g.scope == "" {
+		return changes, err
+	}
+	return scopeChanges(changes, g.inScope), nil
+}
+
+// IsDirty reports whether the worktree has changes not yet synced.
+func (g *GitFs) IsDirty() (bool, error) {
+	files, err := g.scopeStatus()
+	if err != nil {
+		return false, err
+	}
+	return len(files) > 0, nil
+}
+
+// SyncResult describes what a sync did.
+type SyncResult struct {
+	// If nothing changed, so no commit was created
+	NoChanges bool
+	// Commit created, zero if none
+	Commit plumbing.Hash
+	// If the branch was pushed
+	Pushed bool
+	// Objects pushed, of them sent as deltas against objects the remote
+	// has, and bytes of the pack sent
+	PushedObjects int
+	PushedDeltas  int
+	PushedBytes   int64
+	// Branch pushed and url of the review opened, see
+	// Config.SetReviewProvider
+	ReviewBranch string
+	ReviewURL    string
+	// New binary files committed above the size limit, see
+	// Config.WarnLargeBinaries
+	LargeBinaries []string
 }
 
 func (g *GitFs) Sync(purge bool) error {
+	_, err := g.SyncWithResult(purge)
+	return err
+}
+
+// SyncWithResult syncs like Sync, reporting what it did. Without changes,
+// unless Config.AllowEmpty is set, nothing is committed, and the branch is
+// pushed only if commits are still queued, e.g. by a failed push.
+func (g *GitFs) SyncWithResult(purge bool) (res SyncResult, err error) {
+	return g.SyncWithOptions(SyncOptions{Purge: purge})
+}
+
+// SyncOptions tunes a single sync, see SyncWithOptions.
+type SyncOptions struct {
+	// If changes are discarded rather than committed, like Sync(true)
+	Purge bool
+	// Trailers appended to the commit message, e.g. to trace the commit
+	// back to the request causing it
+	Trailers []Trailer
+}
+
+// SyncWithOptions syncs like SyncWithResult, as tuned by opts.
+func (g *GitFs) SyncWithOptions(opts SyncOptions) (SyncResult, error) {
+	return g.syncContext(g.git.ctx, opts)
+}
+
+// syncContext syncs like SyncWithOptions, with the commands, pushes and
+// reviews of the sync canceled once ctx is done.
+func (g *GitFs) syncContext(ctx context.Context, opts SyncOptions) (res SyncResult, err error) {
+	if g.root != "" {
+		return g.top().syncContext(ctx, opts)
+	}
+	ctx, end := g.git.traceContext(ctx, "gitfs.Sync")
+	defer end(&err)
+
+	if err := validTrailers(opts.Trailers); err != nil {
+		return res, err
+	}
+	purge := opts.Purge
+	if purge && g.scope != "" {
+		return res, errors.Errorf("purge is not supported by scope %v", g.scope)
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return res, err
+	}
+	defer unlock(&err)
+
 	if purge {
 		if err := g.git.Reset(); err != nil {
-			return errors.Wrapf(err, "error resetting git")
+			return res, errors.Wrapf(err, "error resetting git")
 		}
 	}
 
-	if err := g.git.AddAll(); err != nil {
-		return errors.Wrapf(err, "error adding files to git")
+	if err := g.checkQuota(); err != nil {
+		return res, err
+	}
+
+	if err := g.runPreSync(ctx); err != nil {
+		return res, err
+	}
+	if err := g.git.normalizeEOL(); err != nil {
+		return res, err
 	}
+	before := g.git.headHash()
+	defer func() {
+		if err == nil {
+			g.runPostSync(ctx, before, res)
+		}
+	}()
 
-	if err := g.git.Commit(fmt.Sprintf("gitfs sync - %v", time.Now().Format("2006-01-02T15:04:05Z07:00"))); err != nil {
-		return errors.Wrapf(err, "error committing sync changes")
+	// A refused sync unstages its changes, lest another scope commits them
+	restore, err := g.git.saveStaged()
+	if err != nil {
+		return res, err
+	}
+	committed := false
+	defer func() {
+		if err != nil && !committed {
+			g.git.reportError("gitfs.Sync", restore())
+		}
+	}()
+
+	if err := g.stageSync(); err != nil {
+		return res, errors.Wrapf(err, "error adding files to git")
+	}
+
+	changed, err := g.hasSyncChanges()
+	if err != nil {
+		return res, err
+	}
+	res.NoChanges = !changed
+
+	if changed || g.allowEmpty {
+		if err := g.checkLargeBinaries(&res); err != nil {
+			return res, err
+		}
+		if err := g.git.runPreCommit(g.scope == ""); err != nil {
+			return res, err
+		}
+
+		msg, err := g.syncMessage()
+		if err != nil {
+			return res, err
+		}
+		if err := g.commitSync(ctx, withTrailers(msg, opts.Trailers)); err != nil {
+			return res, errors.Wrapf(err, "error committing sync changes")
+		}
+		committed = true
+		res.Commit = g.git.headHash()
+	}
+
+	if err := g.git.cleanTemp(); err != nil {
+		return res, err
+	}
+
+	if !g.pushes() {
+		return res, nil
+	}
+	if res.Commit.IsZero() {
+		if g.pusher != nil {
+			// commits queued are known to git only
+			return res, nil
+		}
+		if ahead, err := g.git.unpushed(); err != nil || !ahead {
+			return res, err
+		}
+	}
+	if g.review != nil {
+		return res, g.pushReview(ctx, &res)
 	}
 
 	/* TODO: currently merge is not supported by go-git
@@ -109,10 +1181,34 @@ func (g *GitFs) Sync(purge bool) error {
 	}
 	*/
 
-	if err := g.git.Push(); err != nil {
-		return errors.Wrapf(err, "error pushing change to remote repo")
+	stats, err := g.push(ctx)
+	if err != nil {
+		return res, errors.Wrapf(err, "error pushing change to remote repo")
 	}
-	return nil
+	res.Pushed = true
+	res.PushedObjects, res.PushedDeltas, res.PushedBytes = stats.objects, stats.deltas, stats.bytes
+	return res, nil
+}
+
+// syncMessage returns the message of the sync commit of all changes.
+func (g *GitFs) syncMessage() (string, error) {
+	var changes []Change
+	if g.message != nil {
+		var err error
+		if changes, err = g.syncChanges(); err != nil {
+			return "", err
+		}
+	}
+	msg, err := g.message.render(g.git.clock.Now(), g.git.branchName(), changes)
+	if err != nil || g.message == nil || !g.message.summary || len(changes) == 0 {
+		return msg, err
+	}
+
+	stats, err := g.git.changeStats(changes)
+	if err != nil {
+		return "", errors.Wrapf(err, "error summarizing changes")
+	}
+	return strings.TrimRight(msg, "\n") + "\n\n" + summaryBody(stats), nil
 }
 
 // --- Below are standard fs operations ---
@@ -125,7 +1221,7 @@ type File interface {
 	io.Seeker
 	io.Closer
 	// Lock locks the file like e.g. flock. It protects against access from
-	// other processes.
+	// other processes. It is a no-op on memFs, see GitFs.LockFile.
 	Lock() error
 	// Unlock unlocks the file.
 	Unlock() error
@@ -137,14 +1233,28 @@ type File interface {
 // it if it already exists. If successful, methods on the returned File can
 // be used for I/O; the associated file descriptor has mode O_RDWR.
 func (g *GitFs) Create(filename string) (File, error) {
-	return g.fs.Create(filename)
+	if err := g.checkWritable("create", filename); err != nil {
+		return nil, err
+	}
+	created := !g.exists(filename)
+	f, err := g.limitFile(g.fs.Create(filename))
+	return g.watchFile(filename, f, err, created)
 }
 
 // Open opens the named file for reading. If successful, methods on the
 // returned file can be used for reading; the associated file descriptor has
 // mode O_RDONLY.
 func (g *GitFs) Open(filename string) (File, error) {
-	return g.fs.Open(filename)
+	if err := g.checkPath("open", filename); err != nil {
+		return nil, err
+	}
+	f, err := g.fs.Open(filename)
+	if os.IsNotExist(err) {
+		if zf, zerr := g.openCompressed(filename); zerr == nil {
+			return zf, nil
+		}
+	}
+	return f, err
 }
 
 // OpenFile is the generalized open call; most users will use Open or Create
@@ -152,29 +1262,132 @@ func (g *GitFs) Open(filename string) (File, error) {
 // perm, (0666 etc.) if applicable. If successful, methods on the returned
 // File can be used for I/O.
 func (g *GitFs) OpenFile(filename string, flag int, perm os.FileMode) (File, error) {
-	return g.fs.OpenFile(filename, flag, perm)
+	if isWriteFlag(flag) {
+		if err := g.checkWritable("open", filename); err != nil {
+			return nil, err
+		}
+	}
+	if !isWriteFlag(flag) {
+		if err := g.checkPath("open", filename); err != nil {
+			return nil, err
+		}
+		f, err := g.fs.OpenFile(filename, flag, perm)
+		if os.IsNotExist(err) {
+			if zf, zerr := g.openCompressed(filename); zerr == nil {
+				return zf, nil
+			}
+		}
+		return f, err
+	}
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		// memfs ignores O_EXCL
+		if _, err := g.fs.Lstat(filename); err == nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+		}
+	}
+	created := flag&os.O_CREATE != 0 && !g.exists(filename)
+	f, err := g.limitFile(g.fs.OpenFile(filename, flag, perm))
+	return g.watchFile(filename, f, err, created)
 }
 
 // Stat returns a FileInfo describing the named file.
 func (g *GitFs) Stat(filename string) (os.FileInfo, error) {
-	return g.fs.Stat(filename)
+	if err := g.checkPath("stat", filename); err != nil {
+		return nil, err
+	}
+	fi, err := g.fs.Stat(filename)
+	if os.IsNotExist(err) {
+		if zfi, zerr := g.statCompressed(filename, g.fs.Stat); zerr == nil {
+			return zfi, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.withModTime(filename, fi)
 }
 
 // Rename renames (moves) oldpath to newpath. If newpath already exists and
 // is not a directory, Rename replaces it. OS-specific restrictions may
 // apply when oldpath and newpath are in different directories.
 func (g *GitFs) Rename(oldpath, newpath string) error {
-	return g.fs.Rename(oldpath, newpath)
+	if err := g.checkWritable("rename", oldpath); err != nil {
+		return err
+	}
+	if err := g.checkWritable("rename", newpath); err != nil {
+		return err
+	}
+	for _, p := range []string{oldpath, newpath} {
+		if isRoot(p) {
+			return &InvalidPathError{Op: "rename", Path: p, Reason: "is the root"}
+		}
+	}
+	// files stored compressed stay so, replacing newpath either way
+	src, dst, stale := g.storedName(oldpath), newpath, newpath+compressedExt
+	if src != oldpath {
+		dst, stale = stale, dst
+	}
+	if err := g.fs.Rename(src, dst); err != nil {
+		return err
+	}
+	if fi, err := g.fs.Lstat(stale); err == nil && !fi.IsDir() {
+		if err := g.fs.Remove(stale); err != nil {
+			return errors.Wrapf(err, "error removing %v", stale)
+		}
+	}
+	g.emit(EventRename, newpath, oldpath)
+	return nil
 }
 
 // Remove removes the named file or directory.
 func (g *GitFs) Remove(filename string) error {
-	return g.fs.Remove(filename)
+	if err := g.checkWritable("remove", filename); err != nil {
+		return err
+	}
+	if isRoot(filename) {
+		return &InvalidPathError{Op: "remove", Path: filename, Reason: "is the root"}
+	}
+	if err := g.fs.Remove(g.storedName(filename)); err != nil {
+		return err
+	}
+	g.emit(EventRemove, filename, "")
+	return nil
 }
 
 // RemoveAll removes the named file or directory including sub-directories.
-func (g *GitFs) RemoveAll(path string) error {
-	return util.RemoveAll(g.fs, path)
+// Removing the root removes everything but the git dir.
+func (g *GitFs) RemoveAll(path string) (err error) {
+	defer g.git.trace("gitfs.RemoveAll")(&err)
+
+	if err := g.checkWritable("remove", path); err != nil {
+		return err
+	}
+	if isRoot(path) {
+		return g.removeRootEntries()
+	}
+	if err := util.RemoveAll(g.fs, g.storedName(path)); err != nil {
+		return err
+	}
+	g.emit(EventRemove, path, "")
+	return nil
+}
+
+// removeRootEntries removes everything in the root but the git dir.
+func (g *GitFs) removeRootEntries() error {
+	infos, err := g.fs.ReadDir("/")
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if strings.EqualFold(fi.Name(), git.GitDirName) {
+			continue
+		}
+		if err := util.RemoveAll(g.fs, fi.Name()); err != nil {
+			return err
+		}
+		g.emit(EventRemove, fi.Name(), "")
+	}
+	return nil
 }
 
 // Join joins any number of path elements into a single path, adding a
@@ -192,15 +1405,65 @@ func (g *GitFs) Join(elem ...string) string {
 // Multiple programs calling TempFile simultaneously will not choose the
 // same file. The caller can use f.Name() to find the pathname of the file.
 // It is the caller's responsibility to remove the file when no longer
-// needed.
+// needed. Temp files are never staged, and CleanTempOnSync removes the
+// temp dir, or the very files created in other dirs, leaving the rest of
+// those dirs alone.
 func (g *GitFs) TempFile(dir, prefix string) (File, error) {
-	return g.fs.TempFile(dir, prefix)
+	dedicated := dir == ""
+	if dedicated {
+		dir = g.git.temp.name
+	}
+	if err := g.checkWritable("tempfile", dir); err != nil {
+		return nil, err
+	}
+	if dedicated {
+		g.git.temp.addDir(g.repoPath(dir))
+	}
+	f, err := g.limitFile(g.fs.TempFile(dir, prefix))
+	if err != nil {
+		return nil, err
+	}
+	if !dedicated {
+		g.git.temp.addFile(g.repoPath(f.Name()))
+	}
+	return g.watchFile(f.Name(), f, nil, true)
 }
 
 // ReadDir reads the directory named by dirname and returns a list of
 // directory entries sorted by filename.
 func (g *GitFs) ReadDir(path string) ([]os.FileInfo, error) {
-	return g.fs.ReadDir(path)
+	if err := g.checkPath("readdir", path); err != nil {
+		return nil, err
+	}
+
+	files, err := g.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if !g.exposeGitDir {
+		visible := files[:0]
+		for _, fi := range files {
+			if !strings.EqualFold(fi.Name(), git.GitDirName) {
+				visible = append(visible, fi)
+			}
+		}
+		files = visible
+	}
+	if files, err = g.withModTimes(path, files); err != nil {
+		return nil, err
+	}
+	for i, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), compressedExt) {
+			if files[i], err = g.decompressedInfo(g.fs.Join(path, fi.Name()), fi); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// memfs lists in no particular order
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
 }
 
 // MkdirAll creates a directory named path, along with any necessary
@@ -208,6 +1471,9 @@ func (g *GitFs) ReadDir(path string) ([]os.FileInfo, error) {
 // perm are used for all directories that MkdirAll creates. If path is/
 // already a directory, MkdirAll does nothing and returns nil.
 func (g *GitFs) MkdirAll(filename string, perm os.FileMode) error {
+	if err := g.checkWritable("mkdir", filename); err != nil {
+		return err
+	}
 	return g.fs.MkdirAll(filename, perm)
 }
 
@@ -215,30 +1481,68 @@ func (g *GitFs) MkdirAll(filename string, perm os.FileMode) error {
 // symbolic link, the returned FileInfo describes the symbolic link. Lstat
 // makes no attempt to follow the link.
 func (g *GitFs) Lstat(filename string) (os.FileInfo, error) {
-	return g.fs.Lstat(filename)
+	if err := g.checkPath("lstat", filename); err != nil {
+		return nil, err
+	}
+	fi, err := g.fs.Lstat(filename)
+	if os.IsNotExist(err) {
+		if zfi, zerr := g.statCompressed(filename, g.fs.Lstat); zerr == nil {
+			return zfi, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.withModTime(filename, fi)
 }
 
 // Symlink creates a symbolic-link from link to target. target may be an
 // absolute or relative path, and need not refer to an existing node.
 // Parent directories of link are created as necessary.
 func (g *GitFs) Symlink(target, link string) error {
-	return g.fs.Symlink(target, link)
+	if g.git != nil && g.git.noSymlinks {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: ErrSymlinkForbidden}
+	}
+	if err := g.checkWritable("symlink", link); err != nil {
+		return err
+	}
+	if err := validateLink(target, link); err != nil {
+		return err
+	}
+	// memfs replaces links to missing files
+	if _, err := g.fs.Lstat(link); err == nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+	if err := g.fs.Symlink(target, link); err != nil {
+		return err
+	}
+	g.emit(EventCreate, link, "")
+	return nil
 }
 
 // Readlink returns the target path of link.
 func (g *GitFs) Readlink(link string) (string, error) {
+	if err := g.checkPath("readlink", link); err != nil {
+		return "", err
+	}
 	return g.fs.Readlink(link)
 }
 
 // Chroot returns a new filesystem from the same type where the new root is
 // the given path. Files outside of the designated directory tree cannot be
-// accessed.
+// accessed. The view shares the repo of g, its syncs commit the changes of
+// the whole repo, see Scope for views syncing their own changes only.
 func (g *GitFs) Chroot(path string) (*GitFs, error) {
+	if err := g.checkPath("chroot", path); err != nil {
+		return nil, err
+	}
 	fs, err := g.fs.Chroot(path)
 	if err != nil {
 		return nil, err
 	}
-	return &GitFs{fs: fs}, nil
+	c := *g
+	c.fs, c.root = fs, filepath.Join(g.root, path)
+	return &c, nil
 }
 
 // Root returns the root path of the filesystem.
@@ -246,8 +1550,202 @@ func (g *GitFs) Root() string {
 	return g.fs.Root()
 }
 
+// ReadFile reads the named file and returns its contents. Files stored
+// compressed by WriteFile are decompressed.
+func (g *GitFs) ReadFile(filename string) (data []byte, err error) {
+	defer g.git.trace("gitfs.ReadFile")(&err)
+	return g.readFile(filename)
+}
+
+// readFile reads filename like ReadFile, without tracing.
+func (g *GitFs) readFile(filename string) (data []byte, err error) {
+	if err := g.checkPath("read", filename); err != nil {
+		return nil, err
+	}
+	data, ok := g.readPrefetched(filename)
+	if !ok {
+		data, err = readFile(g.fs, filename)
+	}
+	if os.IsNotExist(err) {
+		zdata, zerr := readFile(g.fs, filename+compressedExt)
+		if zerr != nil {
+			return nil, err
+		}
+		data, err = decompress(zdata)
+	}
+	if err != nil || g.git == nil {
+		return data, err
+	}
+	return g.git.toWorktreeEOL(filepath.Join(g.root, filename), data)
+}
+
+func readFile(fs billy.Filesystem, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// WriteFile writes data to the named file, creating it and any missing
+// parent directories if necessary. Data is written to a temporary file
+// which then replaces filename, so readers never observe partial content.
+// If compression is configured and data exceeds the threshold, it is
+// stored compressed instead.
+func (g *GitFs) WriteFile(filename string, data []byte, perm os.FileMode) (err error) {
+	defer g.git.trace("gitfs.WriteFile")(&err)
+
+	if err := g.checkWritable("write", filename); err != nil {
+		return err
+	}
+
+	if g.git != nil {
+		if data, err = g.git.toRepoEOL(filepath.Join(g.root, filename), data); err != nil {
+			return err
+		}
+	}
+
+	target, stale := filename, filename+compressedExt
+	if g.compressAbove > 0 && int64(len(data)) > g.compressAbove {
+		zdata, err := compress(data)
+		if err != nil {
+			return errors.Wrapf(err, "error compressing %v", filename)
+		}
+		data, target, stale = zdata, stale, target
+	}
+
+	if err := g.checkFileSize(target, int64(len(data))); err != nil {
+		return err
+	}
+
+	op := g.writeOp(filename)
+	if err := g.writeFileAtomic(target, data, perm); err != nil {
+		return err
+	}
+
+	if err := g.fs.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error removing %v", stale)
+	}
+	g.emit(op, filename, "")
+	return nil
+}
+
+// AtomicWrite replaces the named file, creating it and any missing parent
+// directories if necessary, with the content fn writes. The content goes to
+// a hidden temporary file in the same directory which then replaces
+// filename, so readers never observe partial content, on memFs too. If fn
+// fails, filename is left untouched. The file keeps its mode, new files get
+// mode 0644. Unlike WriteFile, content is never compressed.
+func (g *GitFs) AtomicWrite(filename string, fn func(w io.Writer) error) (err error) {
+	defer g.git.trace("gitfs.AtomicWrite")(&err)
+
+	if err := g.checkWritable("write", filename); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	op := EventCreate
+	if fi, err := g.fs.Stat(filename); err == nil {
+		perm, op = fi.Mode().Perm(), EventWrite
+	}
+	if err := g.replaceFile(filename, perm, fn); err != nil {
+		return err
+	}
+	g.emit(op, filename, "")
+	return nil
+}
+
+func (g *GitFs) writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return g.replaceFile(filename, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceFile replaces filename with a temp file fn writes to.
+func (g *GitFs) replaceFile(filename string, perm os.FileMode, fn func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	if err := g.fs.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "error creating dir %v", dir)
+	}
+
+	tmp := g.fs.Join(dir, fmt.Sprintf(".%v.%v.tmp", filepath.Base(filename), time.Now().UnixNano()))
+	f, err := g.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.Wrapf(err, "error creating temp file %v", tmp)
+	}
+
+	err = fn(&sizeLimiter{w: f, g: g, name: filename})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		g.fs.Remove(tmp)
+		return errors.Wrapf(err, "error writing temp file %v", tmp)
+	}
+
+	if err := g.fs.Rename(tmp, filename); err != nil {
+		g.fs.Remove(tmp)
+		return errors.Wrapf(err, "error replacing %v", filename)
+	}
+
+	return nil
+}
+
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// HashContent returns the git blob hash of data, as used by WriteFileIf.
+func HashContent(data []byte) string {
+	return plumbing.ComputeHash(plumbing.BlobObject, data).String()
+}
+
+// WriteFileIf is like WriteFile, but only writes if the current content
+// of filename hashes to expectedHash (see HashContent). An empty
+// expectedHash requires that filename does not exist yet.
+// ErrPreconditionFailed is returned otherwise. The lock of filename, see
+// LockFile, is held from the read to the write, so concurrent WriteFileIf
+// calls can't both succeed. The file keeps its mode, new files get mode
+// 0644.
+func (g *GitFs) WriteFileIf(filename string, data []byte, expectedHash string) (err error) {
+	l, err := g.LockFile(filename, g.git.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := l.Unlock(); err == nil {
+			err = uerr
+		}
+	}()
+
+	perm := os.FileMode(0644)
+	cur, err := g.ReadFile(filename)
+	if os.IsNotExist(err) {
+		if expectedHash != "" {
+			return ErrPreconditionFailed
+		}
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading %v", filename)
+	} else if HashContent(cur) != expectedHash {
+		return ErrPreconditionFailed
+	} else if fi, err := g.Stat(filename); err != nil {
+		return errors.Wrapf(err, "error stating %v", filename)
+	} else {
+		perm = fi.Mode().Perm()
+	}
+
+	return g.WriteFile(filename, data, perm)
+}
+
 func (g *GitFs) Exist(path string) (bool, error) {
+	if err := g.checkPath("stat", path); err != nil {
+		return false, err
+	}
 	_, err := g.fs.Stat(path)
+	if os.IsNotExist(err) {
+		_, err = g.fs.Stat(path + compressedExt)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
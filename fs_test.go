@@ -0,0 +1,71 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileIfConcurrent(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", "a")
+	hash := HashContent([]byte("a"))
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- g.WriteFileIf("a.txt", []byte(fmt.Sprint(i)), hash)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	written := 0
+	for err := range errs {
+		if err == nil {
+			written++
+		} else if err != ErrPreconditionFailed {
+			t.Fatal(err)
+		}
+	}
+	if written != 1 {
+		t.Fatalf("%v writes of the same content succeeded", written)
+	}
+}
+
+func TestWriteFileIfKeepsMode(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	g, err := New(context.Background(), NewConfig().NoRemote().UseOsFs(dir, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.WriteFile("run.sh", []byte("echo a\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.WriteFileIf("run.sh", []byte("echo b\n"), HashContent([]byte("echo a\n"))); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := g.Stat("run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("got mode %v", fi.Mode())
+	}
+
+	if err := g.WriteFileIf("new.txt", []byte("n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := g.Stat("new.txt"); err != nil || fi.Mode().Perm() != 0644 {
+		t.Fatalf("got new file %v, %v", fi, err)
+	}
+}
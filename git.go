@@ -2,13 +2,17 @@ package gitfs
 
 import (
 	"context"
-	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/memfs"
@@ -16,79 +20,369 @@ import (
 	"gopkg.in/src-d/go-billy.v4/util"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
-	gogitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
 // Not thread safe
 type Git struct {
-	ctx     context.Context
-	repoUrl string
-	auth    *gogitssh.PublicKeys
-	fs      billy.Filesystem
-	repo    *git.Repository
-	wt      *git.Worktree
-	pulled  bool
+	ctx       context.Context
+	repoUrl   string
+	auth      transport.AuthMethod
+	fs        billy.Filesystem
+	repo      *git.Repository
+	wt        *git.Worktree
+	pulled    bool
+	pgpKey    *openpgp.Entity
+	sshSigner ssh.Signer
+	trust     trustPolicy
+	// If the storer is provided by the user rather than built on fs
+	custom      bool
+	concurrency int
+	statusCache *statusCache
+	noRemote    bool
+	tracer      trace.Tracer
+	hooks       Hooks
+	preCommit   PreCommitHook
+	noSymlinks  bool
+	clock       Clock
+	// Branch checked out, pulled and pushed, empty for master
+	branch string
+	// Worktree of a bare repo, nil otherwise
+	bare *bareFs
+	temp *tempAreas
+	// Lock files, and how long lockRepo waits
+	locks       *lockFiles
+	lockTimeout time.Duration
+	// If a view of a past commit, see AtTime
+	readOnly bool
+	renames  renameDetector
+	// Commit mod times by dir, nil unless enabled
+	modTimes *modTimeCache
+	// Transport of the remote, nil without one
+	transport *remoteTransport
+	// Consulted for auth before every transport operation if set
+	authSource AuthProvider
+	// Admits remote operations, shared with other repos, nil for no limit
+	limiter *RateLimiter
+	// Line ending conversion of text files, nil if disabled
+	eol *eolPolicy
+	// Of the GitFs owning the repo, shared with its views and worktrees
+	life *lifecycle
+	// Held by changes of the repo, see lockRepo, and by reads of snapshot
+	// views for reading, shared with them
+	objects *sync.RWMutex
 }
 
-func NewGit(ctx context.Context, repoUrl string, useMemfs bool, baseDir string, errorIfExists bool) (*Git, error) {
-	sshKey, err := ioutil.ReadFile(fmt.Sprintf("%s/.ssh/id_rsa", os.Getenv("HOME")))
+var ErrNoRemote = errors.New("repo has no remote")
+
+// NewGit opens, clones or inits the repo of c. Clones into a dir on disk
+// failing midway, e.g. as ctx is canceled, leave nothing behind, and those
+// of processes killed midway are removed by the next NewGit.
+func NewGit(ctx context.Context, c *Config) (*Git, error) {
+	guard := newCloneGuard(c)
+	if guard == nil {
+		return newGit(ctx, c, nil)
+	}
+	if err := guard.removeInterrupted(); err != nil {
+		return nil, err
+	}
+	g, err := newGit(ctx, c, guard)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error reading private key")
+		if rerr := guard.remove(); rerr != nil {
+			return nil, errors.Wrapf(err, "error removing failed clone: %v", rerr)
+		}
+		return nil, err
+	}
+	if err := guard.done(); err != nil {
+		return nil, err
 	}
+	return g, nil
+}
 
-	signer, err := ssh.ParsePrivateKey([]byte(sshKey))
-	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing private key")
+// newGit creates the Git of c, marking clones by guard unless nil.
+func newGit(ctx context.Context, c *Config, guard *cloneGuard) (*Git, error) {
+	repoUrl, useMemfs, baseDir, errorIfExists := c.repoUrl, c.useMemFs, c.osFsBaseDir, !c.openExisting || c.bundle != nil
+
+	var auth transport.AuthMethod
+	var remote *remoteTransport
+	if !c.noRemote {
+		var err error
+		if auth, err = remoteAuth(c); err != nil {
+			return nil, err
+		}
+		if remote, err = newRemoteTransport(c); err != nil {
+			return nil, err
+		}
 	}
-	auth := &gogitssh.PublicKeys{User: "git", Signer: signer}
+	cloneAuth := auth
+	if c.authProvider != nil && !c.noRemote {
+		var err error
+		if cloneAuth, err = providerAuth(ctx, c.authProvider); err != nil {
+			return nil, err
+		}
+	}
+	cloneAuth = remote.with(cloneAuth)
 
 	var fs billy.Filesystem
-	if useMemfs {
+	if c.bare {
+		// bare repos get their worktree once opened
+	} else if c.worktreeFs != nil {
+		fs = c.worktreeFs
+	} else if useMemfs && c.spillBudget > 0 {
+		spillDir, err := ioutil.TempDir("", "gitfs-spill")
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating spill dir")
+		}
+		fs = newSpillFs(osfs.New(spillDir), c.spillBudget)
+	} else if useMemfs {
 		fs = memfs.New()
 	} else {
 		fs = osfs.New(baseDir)
 	}
 
-	dotStore, exists, err := buildDotStore(fs, errorIfExists)
+	var dotStore storage.Storer
+	var exists bool
+	var err error
+	if c.storer != nil {
+		dotStore = c.storer
+		exists, err = storerHasRepo(dotStore)
+		if err == nil && exists && errorIfExists {
+			err = errors.New("repo already exists")
+		}
+	} else if c.bare {
+		dotStore, exists, err = buildBareStore(c, errorIfExists)
+	} else {
+		dotStore, exists, err = buildDotStore(fs, errorIfExists)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "error chrooting .git")
+		return nil, errors.Wrapf(err, "error building git storage")
+	}
+
+	// parallel checkout needs a filesystem safe for concurrent writes
+	parallelCheckout := c.concurrency > 1 && !useMemfs && c.worktreeFs == nil
+
+	var tracer trace.Tracer
+	if c.tracerProvider != nil {
+		tracer = c.tracerProvider.Tracer(tracerName)
 	}
 
 	var repo *git.Repository
+	if !exists && c.offline {
+		return nil, errors.New("offline mode requires an existing repo")
+	}
 	if exists {
 		repo, err = git.Open(dotStore, fs)
+	} else if c.bundle != nil {
+		repo, err = initFromBundle(dotStore, fs, c.bundle, repoUrl)
+	} else if c.noRemote {
+		repo, err = initRepo(dotStore, fs, c.branch)
 	} else {
-		repo, err = git.CloneContext(
-			ctx,
-			dotStore,
-			fs,
-			&git.CloneOptions{
-				URL:      repoUrl,
-				Auth:     auth,
-				Progress: os.Stdout,
-			})
+		if guard != nil {
+			if err := guard.mark(); err != nil {
+				return nil, err
+			}
+		}
+		opts := &git.CloneOptions{
+			URL:        repoUrl,
+			Auth:       cloneAuth,
+			NoCheckout: parallelCheckout,
+			Progress:   os.Stdout,
+		}
+		if c.branch != "" {
+			opts.ReferenceName = plumbing.NewBranchReferenceName(c.branch)
+		}
+		cloneCtx, end := startSpan(ctx, tracer, "gitfs.Clone")
+		var done func(*error)
+		if done, err = c.rateLimiter.begin(cloneCtx); err == nil {
+			if c.orphan {
+				repo, err = cloneOrphan(cloneCtx, dotStore, fs, opts, c.branch)
+			} else {
+				repo, err = git.CloneContext(cloneCtx, dotStore, fs, opts)
+			}
+			done(&err)
+		}
+		end(&err)
 	}
 
-	if err != nil {
-		return nil, errors.Wrapf(err, "error cloning repo %v", repoUrl)
+	if err != nil && exists && (c.verifyOnOpen || c.autoRecover && corrupted(err)) {
+		return recloneCorrupt(ctx, c, err)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error opening repo %v", repoUrl)
 	}
 
-	wt, err := repo.Worktree()
+	locks, err := newLockFiles(c, fs)
 	if err != nil {
+		return nil, err
+	}
+	lockTimeout := c.lockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = defaultLockTimeout
+	}
+
+	// the branch HEAD points to is the default branch of the remote when
+	// cloned
+	branch := c.branch
+	if branch == "" {
+		branch = headBranch(repo.Storer)
+	}
+
+	temp := newTempAreas(c.tempDir, c.cleanTemp)
+	var wt *git.Worktree
+	var bare *bareFs
+	if c.bare {
+		if repo, err = checkoutBare(repo, branch); err != nil {
+			return nil, err
+		}
+		if bare, err = newBareFs(repo, temp); err != nil {
+			return nil, err
+		}
+		fs = bare
+	} else if wt, err = repo.Worktree(); err != nil {
 		return nil, errors.Wrapf(err, "error reading worktree")
+	} else {
+		wt.Excludes = append(wt.Excludes, temp.pattern())
 	}
 
-	return &Git{
-		repoUrl: repoUrl,
-		auth:    auth,
-		repo:    repo,
-		wt:      wt,
-		fs:      fs,
-		pulled:  false,
-	}, nil
+	g := &Git{
+		ctx:         ctx,
+		repoUrl:     repoUrl,
+		auth:        auth,
+		authSource:  c.authProvider,
+		transport:   remote,
+		limiter:     c.rateLimiter,
+		repo:        repo,
+		wt:          wt,
+		fs:          fs,
+		pulled:      false,
+		pgpKey:      c.pgpKey,
+		sshSigner:   c.sshSigner,
+		trust:       trustPolicy{keys: c.trustKeys, requireSigned: c.requireSigned},
+		custom:      c.storer != nil,
+		concurrency: c.concurrency,
+		noRemote:    c.noRemote,
+		tracer:      tracer,
+		hooks:       c.hooks,
+		preCommit:   c.preCommit,
+		noSymlinks:  c.noSymlinks,
+		clock:       c.clockOrDefault(),
+		branch:      branch,
+		bare:        bare,
+		temp:        temp,
+		locks:       locks,
+		lockTimeout: lockTimeout,
+		renames:     renameDetector{score: c.renameScore, copies: c.detectCopies},
+		objects:     &sync.RWMutex{},
+	}
+	if c.noRenames {
+		g.renames.score = -1
+	}
+	if c.statusCache {
+		g.statusCache = newStatusCache()
+	}
+	if c.commitModTime {
+		g.modTimes = newModTimeCache()
+	}
+	if c.gitAttributes || c.autocrlf != AutoCRLFFalse {
+		g.eol = newEOLPolicy(c.autocrlf, c.gitAttributes)
+	}
+
+	if exists && c.verifyOnOpen {
+		if err := g.verify(); err != nil {
+			return recloneCorrupt(ctx, c, err)
+		}
+	} else if exists && c.autoRecover {
+		if err := g.checkOpen(); err != nil && corrupted(err) {
+			return recloneCorrupt(ctx, c, err)
+		}
+	}
+
+	if !exists && parallelCheckout {
+		end := g.trace("gitfs.Checkout")
+		err := g.parallelCheckout(c.concurrency)
+		if end(&err); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// storerHasRepo reports whether s already holds a repository.
+func storerHasRepo(s storage.Storer) (bool, error) {
+	_, err := s.Reference(plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// buildBareStore builds the storage of a bare repo, in memory or in the
+// osFs base dir.
+func buildBareStore(c *Config, errorIfExists bool) (storage.Storer, bool, error) {
+	var s storage.Storer
+	if c.useMemFs {
+		s = memory.NewStorage()
+	} else {
+		s = filesystem.NewStorage(osfs.New(c.osFsBaseDir), cache.NewObjectLRUDefault())
+	}
+
+	exists, err := storerHasRepo(s)
+	if err == nil && exists && errorIfExists {
+		err = errors.New("repo already exists")
+	}
+	return s, exists, err
+}
+
+// headBranch returns the branch HEAD of s points to, empty if detached.
+func headBranch(s storage.Storer) string {
+	head, err := s.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return ""
+	}
+	return head.Target().Short()
+}
+
+// checkoutBare returns repo with HEAD pointing to branch, master if empty,
+// without changing the HEAD of the repo itself. A branch only known to
+// origin is created locally first.
+func checkoutBare(repo *git.Repository, branch string) (*git.Repository, error) {
+	if branch == "" {
+		branch = "master"
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+
+	_, err := repo.Storer.Reference(name)
+	if err == plumbing.ErrReferenceNotFound {
+		remote, rerr := repo.Storer.Reference(plumbing.NewRemoteReferenceName("origin", branch))
+		if rerr == nil {
+			err = repo.Storer.SetReference(plumbing.NewHashReference(name, remote.Hash()))
+		} else if rerr == plumbing.ErrReferenceNotFound {
+			// unborn branch, created by the first commit
+			err = nil
+		} else {
+			err = rerr
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving branch %v", branch)
+	}
+
+	s := &worktreeStorer{
+		Storer: repo.Storer,
+		head:   plumbing.NewSymbolicReference(plumbing.HEAD, name),
+	}
+	repo, err = git.Open(s, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening branch %v", branch)
+	}
+	return repo, nil
 }
 
 func buildDotStore(fs billy.Filesystem, errorIfExists bool) (*filesystem.Storage, bool, error) {
@@ -115,26 +409,43 @@ func buildDotStore(fs billy.Filesystem, errorIfExists bool) (*filesystem.Storage
 }
 
 func (g *Git) Reset() error {
-	if err := util.RemoveAll(g.fs, git.GitDirName); err != nil {
-		return errors.Wrapf(err, "error removing .git")
+	if g.custom {
+		return errors.New("reset is not supported with a custom storer")
+	} else if g.bare != nil {
+		return errors.New("reset is not supported with a bare repo")
 	}
 
-	dotStore, _, err := buildDotStore(g.fs, true)
+	// the lock files are kept, the repo lock is held while resetting
+	infos, err := g.fs.ReadDir(git.GitDirName)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "error reading .git")
+	}
+	for _, fi := range infos {
+		if fi.Name() == lockDir {
+			continue
+		}
+		if err := util.RemoveAll(g.fs, path.Join(git.GitDirName, fi.Name())); err != nil {
+			return errors.Wrapf(err, "error removing .git")
+		}
 	}
 
-	repo, err := git.Init(dotStore, g.fs)
+	dot, err := g.fs.Chroot(git.GitDirName)
 	if err != nil {
-		return errors.Wrapf(err, "error initing repo")
+		return errors.Wrapf(err, "error chrooting %v", git.GitDirName)
 	}
-
-	_, err = repo.CreateRemote(&config.RemoteConfig{
-		Name: "origin",
-		URLs: []string{g.repoUrl},
-	})
+	repo, err := initRepo(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), g.fs, g.branch)
 	if err != nil {
-		return errors.Wrapf(err, "error pushing change")
+		return err
+	}
+
+	if !g.noRemote {
+		_, err = repo.CreateRemote(&config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{g.repoUrl},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error pushing change")
+		}
 	}
 
 	wt, err := repo.Worktree()
@@ -148,80 +459,467 @@ func (g *Git) Reset() error {
 	return nil
 }
 
+// initRepo inits a repo in s and fs with HEAD pointing to branch, master
+// if empty.
+func initRepo(s storage.Storer, fs billy.Filesystem, branch string) (*git.Repository, error) {
+	repo, err := git.Init(s, fs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initing repo")
+	}
+	if branch != "" {
+		head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+		if err := s.SetReference(head); err != nil {
+			return nil, errors.Wrapf(err, "error setting HEAD to %v", branch)
+		}
+	}
+	return repo, nil
+}
+
 func (g *Git) FileSystem() billy.Filesystem {
 	return g.fs
 }
 
 func (g *Git) Pull() error {
-	if err := g.wt.Pull(&git.PullOptions{
+	return g.pull(g.ctx)
+}
+
+// pull pulls like Pull, canceled once ctx is done.
+func (g *Git) pull(ctx context.Context) (err error) {
+	ctx, end := g.traceContext(ctx, "gitfs.Pull")
+	defer end(&err)
+	defer g.pullHook(time.Now(), g.headHash())(&err)
+
+	if g.noRemote {
+		return ErrNoRemote
+	}
+	done, err := g.limiter.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+	if len(g.trust.keys) > 0 || g.bare != nil {
+		return g.pullVerified(ctx)
+	}
+
+	auth, err := g.authMethod(ctx)
+	if err != nil {
+		return err
+	}
+	opts := &git.PullOptions{
 		RemoteName: "origin",
-		Auth:       g.auth,
+		Auth:       auth,
 		Progress:   os.Stdout,
-	}); err != nil && err != git.NoErrAlreadyUpToDate {
+	}
+	if g.branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(g.branch)
+	}
+	if err := g.wt.PullContext(ctx, opts); err != nil && err != git.NoErrAlreadyUpToDate {
 		return errors.Wrapf(err, "error pulling changes from origin")
 	}
 
 	return nil
 }
 
+func (g *Git) branchName() string {
+	if g.branch == "" {
+		return "master"
+	}
+	return g.branch
+}
+
 func traverseDir(fs billy.Filesystem, dir string, cb func(string) error) error {
-	fmt.Printf("traverse dir %v\n", dir)
+	return walk(fs, dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return cb(slashPath(path))
+	})
+}
 
-	files, err := fs.ReadDir(dir)
-	if err != nil {
-		fmt.Printf("read dir err: %v\n", err)
+func (g *Git) AddAll() error {
+	if g.bare != nil {
+		return g.bare.stage(nil)
+	}
+	if _, err := g.wt.Add(""); err != nil {
 		return err
 	}
+	return g.unstageTemp()
+}
+
+// Stage adds changes of the given paths, and of any files under them if
+// they are directories, to the index. Deleted files are removed from it.
+func (g *Git) Stage(paths []string) error {
+	if g.bare != nil {
+		return g.bare.stage(paths)
+	}
 
-	for _, fi := range files {
-		if fi.Name() == git.GitDirName {
+	s, err := g.wt.Status()
+	if err != nil {
+		return errors.Wrapf(err, "error getting status")
+	}
+
+	for file, fstatus := range s {
+		if fstatus.Worktree == git.Unmodified || !underAny(file, paths) {
 			continue
 		}
 
-		path := filepath.Join(dir, fi.Name())
-		fmt.Printf("dir %v, name %v, path %v\n", dir, fi.Name(), path)
-		if fi.IsDir() {
-			if err := traverseDir(fs, path, cb); err != nil {
-				return err
-			}
+		if fstatus.Worktree == git.Deleted {
+			_, err = g.wt.Remove(file)
 		} else {
-			if err := cb(path[1:]); err != nil {
-				return err
-			}
+			_, err = g.wt.Add(file)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error staging %v", file)
 		}
 	}
 
 	return nil
 }
 
-func (g *Git) AddAll() error {
-	_, err := g.wt.Add("")
-	return err
+func underAny(file string, paths []string) bool {
+	for _, p := range paths {
+		if file == p || strings.HasPrefix(file, p+"/") {
+			return true
+		}
+	}
+	return false
 }
 
 func (g *Git) Commit(msg string) error {
-	_, err := g.wt.Commit(msg, &git.CommitOptions{
-		All: true,
-		Author: &object.Signature{
-			Name:  "gitfs",
-			Email: "gitfs@github.com",
-			When:  time.Now(),
-		},
+	return g.commit(msg, true)
+}
+
+// CommitStaged commits only changes already added to the index.
+func (g *Git) CommitStaged(msg string) error {
+	return g.commit(msg, false)
+}
+
+// CommitMerge commits the changes added to the index as the merge of
+// commit merged into the current branch.
+func (g *Git) CommitMerge(msg string, merged plumbing.Hash) error {
+	return g.commit(msg, false, merged)
+}
+
+// hasChanges reports whether a commit of all changes would change HEAD.
+func (g *Git) hasChanges() (bool, error) {
+	s, err := g.status()
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting status")
+	}
+	for _, fstatus := range s {
+		code := fstatus.Staging
+		if fstatus.Worktree == git.Deleted || fstatus.Worktree == git.Modified {
+			code = fstatus.Worktree
+		}
+		if code != git.Unmodified && code != git.Untracked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// unpushed reports whether the branch has commits origin lacks, as far as
+// the last pull or push tells.
+func (g *Git) unpushed() (bool, error) {
+	head := g.headHash()
+	if head.IsZero() {
+		return false, nil
+	}
+	ref, err := g.repo.Storer.Reference(plumbing.NewRemoteReferenceName("origin", g.branchName()))
+	if err == plumbing.ErrReferenceNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "error reading remote branch")
+	}
+	return ref.Hash() != head, nil
+}
+
+// commit commits the staged changes, or all of them, with the commits
+// merged, if any, as parents after HEAD.
+func (g *Git) commit(msg string, all bool, merged ...plumbing.Hash) error {
+	return g.commitPaths(g.ctx, msg, all, nil, merged)
+}
+
+// commitPaths commits the changes added to the index, only those under
+// paths unless nil, and with all the changes of tracked files too, traced
+// in ctx. Commits merged follow HEAD as parents. Changes staged under
+// other paths stay staged.
+func (g *Git) commitPaths(ctx context.Context, msg string, all bool, paths []string, merged []plumbing.Hash) (err error) {
+	_, end := g.traceContext(ctx, "gitfs.Commit")
+	defer end(&err)
+	defer g.commitHook(msg)(&err)
+
+	sig := &object.Signature{
+		Name:  "gitfs",
+		Email: "gitfs@github.com",
+		When:  g.clock.Now(),
+	}
+	var hash plumbing.Hash
+	if g.bare != nil {
+		hash, err = g.bareCommit(msg, sig, all, paths, merged)
+	} else {
+		if paths != nil {
+			restore, nerr := g.narrowIndex(paths)
+			if nerr != nil {
+				return nerr
+			}
+			defer func() {
+				if rerr := restore(); err == nil {
+					err = rerr
+				}
+			}()
+		}
+		opts := &git.CommitOptions{
+			All:     all,
+			Author:  sig,
+			SignKey: g.pgpKey,
+		}
+		if len(merged) > 0 {
+			opts.Parents = append([]plumbing.Hash{g.headHash()}, merged...)
+		}
+		hash, err = g.wt.Commit(msg, opts)
+	}
+	if err != nil || g.sshSigner == nil {
+		return err
+	}
+
+	return g.sshSignCommit(hash)
+}
+
+// narrowIndex sets the index to the files of HEAD but for the entries
+// under paths, so a commit includes only the changes staged under paths.
+// restore sets the former index back.
+func (g *Git) narrowIndex(paths []string) (restore func() error, err error) {
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading index")
+	}
+	files, err := g.headFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	narrowed := &index.Index{Version: idx.Version}
+	for _, e := range idx.Entries {
+		if underAny(e.Name, paths) {
+			narrowed.Entries = append(narrowed.Entries, e)
+		}
+	}
+	for p, f := range files {
+		if !underAny(p, paths) {
+			narrowed.Entries = append(narrowed.Entries, &index.Entry{Name: p, Hash: f.hash, Mode: f.mode})
+		}
+	}
+	sort.Slice(narrowed.Entries, func(i, j int) bool {
+		return narrowed.Entries[i].Name < narrowed.Entries[j].Name
 	})
-	return err
+	if err := g.repo.Storer.SetIndex(narrowed); err != nil {
+		return nil, errors.Wrapf(err, "error writing index")
+	}
+	return func() error {
+		return g.repo.Storer.SetIndex(idx)
+	}, nil
+}
+
+// saveStaged saves what is staged, for restore to unstage the changes
+// staged since, like those of a refused sync.
+func (g *Git) saveStaged() (restore func() error, err error) {
+	if fs := g.bare; fs != nil {
+		fs.mu.Lock()
+		staged := make(map[string]bool, len(fs.staged))
+		for p := range fs.staged {
+			staged[p] = true
+		}
+		fs.mu.Unlock()
+		return func() error {
+			fs.mu.Lock()
+			defer fs.mu.Unlock()
+			fs.staged = staged
+			return nil
+		}, nil
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading index")
+	}
+	// Staging may change the entries of idx in place
+	saved := *idx
+	saved.Entries = make([]*index.Entry, len(idx.Entries))
+	for i, e := range idx.Entries {
+		c := *e
+		saved.Entries[i] = &c
+	}
+	return func() error {
+		return g.repo.Storer.SetIndex(&saved)
+	}, nil
 }
 
 func (g *Git) Push() error {
-	return g.repo.Push(&git.PushOptions{
+	_, err := g.pushBranch(g.ctx)
+	return err
+}
+
+// PushRefs pushes the given refspecs to origin, e.g.
+// "refs/heads/dev:refs/heads/dev". Refspecs starting with "+" are forced.
+func (g *Git) PushRefs(refspecs []string) error {
+	return g.pushRefs(g.ctx, refspecs)
+}
+
+// pushRefs pushes like PushRefs, canceled once ctx is done.
+func (g *Git) pushRefs(ctx context.Context, refspecs []string) (err error) {
+	ctx, end := g.traceContext(ctx, "gitfs.Push")
+	defer end(&err)
+	defer g.pushHook(time.Now(), refspecs)(&err)
+
+	if g.noRemote {
+		return ErrNoRemote
+	}
+	specs := make([]config.RefSpec, len(refspecs))
+	for i, s := range refspecs {
+		specs[i] = config.RefSpec(s)
+		if err := specs[i].Validate(); err != nil {
+			return errors.Wrapf(err, "invalid refspec %v", s)
+		}
+	}
+	return g.pushRefSpecs(ctx, specs)
+}
+
+// RemoteRef returns the named reference as advertised by the remote repo,
+// or nil if the remote has no such reference.
+func (g *Git) RemoteRef(name plumbing.ReferenceName) (ref *plumbing.Reference, err error) {
+	remote, err := g.repo.Remote("origin")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting remote origin")
+	}
+
+	done, err := g.limiter.begin(g.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done(&err)
+
+	auth, err := g.authMethod(g.ctx)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing remote refs")
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref, nil
+		}
+	}
+	return nil, nil
+}
+
+// Ping lists the refs of origin, verifying it is reachable and auth works
+// without transferring objects. go-git can't cancel listing, so on ctx done
+// Ping returns early while listing finishes in the background.
+func (g *Git) Ping(ctx context.Context) error {
+	if g.noRemote {
+		return ErrNoRemote
+	}
+
+	remote, err := g.repo.Remote("origin")
+	if err != nil {
+		return errors.Wrapf(err, "error getting remote origin")
+	}
+
+	opDone, err := g.limiter.begin(ctx)
+	if err != nil {
+		return err
+	}
+	auth, err := g.authMethod(ctx)
+	if err != nil {
+		opDone(&err)
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := remote.List(&git.ListOptions{Auth: auth})
+		if err == transport.ErrEmptyRemoteRepository {
+			err = nil
+		}
+		opDone(&err)
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return errors.Wrapf(err, "error listing remote refs")
+		}
+		return nil
+	}
+}
+
+// FetchRefSpecs fetches the given refspecs from origin.
+func (g *Git) FetchRefSpecs(specs []config.RefSpec) (err error) {
+	defer g.trace("gitfs.Fetch")(&err)
+
+	done, err := g.limiter.begin(g.ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+	auth, err := g.authMethod(g.ctx)
+	if err != nil {
+		return err
+	}
+	if err := g.repo.FetchContext(g.ctx, &git.FetchOptions{
 		RemoteName: "origin",
-		RefSpecs: []config.RefSpec{
-			"+refs/heads/master:refs/heads/master",
-		},
-		Auth:     g.auth,
-		Progress: os.Stdout,
+		RefSpecs:   specs,
+		Auth:       auth,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+// PushRefSpecs pushes the given refspecs to origin.
+func (g *Git) PushRefSpecs(specs []config.RefSpec) (err error) {
+	defer g.trace("gitfs.Push")(&err)
+	defer g.pushHook(time.Now(), refSpecStrings(specs))(&err)
+
+	return g.pushRefSpecs(g.ctx, specs)
+}
+
+// pushRefSpecs pushes specs to origin, canceled once ctx is done.
+func (g *Git) pushRefSpecs(ctx context.Context, specs []config.RefSpec) (err error) {
+	done, err := g.limiter.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+	auth, err := g.authMethod(ctx)
+	if err != nil {
+		return err
+	}
+	return g.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   specs,
+		Auth:       auth,
+		Progress:   os.Stdout,
 	})
 }
 
+func refSpecStrings(specs []config.RefSpec) []string {
+	var s []string
+	for _, spec := range specs {
+		s = append(s, spec.String())
+	}
+	return s
+}
+
 const (
 	branchNamePrefix = "refs/heads/"
 )
@@ -241,24 +939,16 @@ const (
 )
 
 func (g *Git) GetStatus() (map[string]StatusCode, error) {
-	s, err := g.wt.Status()
+	s, err := g.status()
 	if err != nil {
 		return nil, errors.Wrapf(err, "error getting status")
 	}
-	fmt.Printf("status: len %v, %v\n", len(s), s.String())
 	files := map[string]StatusCode{}
 	if err := traverseDir(g.fs, "/", func(path string) error {
-		fstatus := s[path]
-		if fstatus == nil {
-		} else if fstatus.Staging == git.Unmodified && fstatus.Worktree == git.Unmodified {
-		} else if fstatus.Staging == git.Unmodified {
-			files[path] = StatusCode(byte(fstatus.Worktree))
-		} else if fstatus.Worktree == git.Unmodified {
-			files[path] = StatusCode(byte(fstatus.Staging))
-		} else if fstatus.Staging != fstatus.Worktree {
-			files[path] = Inconsistent
-		} else {
-			files[path] = StatusCode(byte(fstatus.Worktree))
+		if fstatus := s[path]; fstatus != nil {
+			if code := fileStatusCode(fstatus); code != Unmodified {
+				files[path] = code
+			}
 		}
 		return nil
 	}); err != nil {
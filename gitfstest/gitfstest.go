@@ -0,0 +1,379 @@
+// Package gitfstest checks that a GitFs behaves like a file system, the
+// same on every backend, so upgrades of backends or upstream packages can't
+// change its semantics unnoticed:
+//
+//	func TestMemFs(t *testing.T) {
+//		g, err := gitfs.NewWithOptions(ctx, "", gitfs.WithMemFS())
+//		...
+//		gitfstest.TestFS(t, g)
+//	}
+package gitfstest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+)
+
+// Dir is the dir of g the checks of TestFS write in, removed once done.
+const Dir = "gitfstest"
+
+// TestFS checks the file system semantics of g: reads and writes, O_EXCL,
+// renames over existing files, ReadDir ordering, symlinks, unless g forbids
+// them, and the like. The checks write under Dir only, which g must allow,
+// and leave the rest of the worktree untouched.
+func TestFS(t *testing.T, g *gitfs.GitFs) {
+	if _, err := g.Lstat(Dir); err == nil {
+		t.Fatalf("%v exists in %v", Dir, g.Root())
+	}
+	defer func() {
+		if err := g.RemoveAll(Dir); err != nil {
+			t.Errorf("error removing %v: %v", Dir, err)
+		}
+	}()
+
+	for _, c := range []struct {
+		name string
+		test func(t *testing.T, g *gitfs.GitFs, dir string)
+	}{
+		{"WriteRead", testWriteRead},
+		{"Missing", testMissing},
+		{"CreateTruncates", testCreateTruncates},
+		{"OpenExcl", testOpenExcl},
+		{"Append", testAppend},
+		{"SeekReadAt", testSeekReadAt},
+		{"RenameOverExisting", testRenameOverExisting},
+		{"RenameDir", testRenameDir},
+		{"ReadDirOrder", testReadDirOrder},
+		{"MkdirAllRemove", testMkdirAllRemove},
+		{"TempFile", testTempFile},
+		{"Chmod", testChmod},
+		{"Symlinks", testSymlinks},
+		{"Chroot", testChroot},
+	} {
+		dir := path.Join(Dir, c.name)
+		t.Run(c.name, func(t *testing.T) {
+			if err := g.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("error creating %v: %v", dir, err)
+			}
+			c.test(t, g, dir)
+		})
+	}
+}
+
+func testWriteRead(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "sub", "file")
+	writeFile(t, g, p, "hello\n")
+	checkFile(t, g, p, "hello\n")
+
+	fi, err := g.Stat(p)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Name() != "file" || fi.Size() != 6 || !fi.Mode().IsRegular() {
+		t.Errorf("Stat of %v: name %v, size %v, mode %v, want file, 6 and a regular file", p, fi.Name(), fi.Size(), fi.Mode())
+	}
+	if fi, err := g.Stat(path.Join(dir, "sub")); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of the parent of %v: %v, %v, want a dir", p, fi, err)
+	}
+
+	writeFile(t, g, p, "bye\n")
+	checkFile(t, g, p, "bye\n")
+}
+
+func testMissing(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "missing")
+	if _, err := g.Open(p); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Open of a missing file: %v, want not exist", err)
+	}
+	if _, err := g.Stat(p); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Stat of a missing file: %v, want not exist", err)
+	}
+	if _, err := g.ReadFile(p); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("ReadFile of a missing file: %v, want not exist", err)
+	}
+	if err := g.Remove(p); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Remove of a missing file: %v, want not exist", err)
+	}
+	if ok, err := g.Exist(p); ok || err != nil {
+		t.Errorf("Exist of a missing file: %v, %v, want false", ok, err)
+	}
+}
+
+func testCreateTruncates(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "file")
+	writeFile(t, g, p, "long content\n")
+	f, err := g.Create(p)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.Write([]byte("short\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	checkFile(t, g, p, "short\n")
+}
+
+func testOpenExcl(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "file")
+	f, err := g.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile with O_EXCL of a new file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := g.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(errors.Cause(err)) {
+		t.Errorf("OpenFile with O_EXCL of an existing file: %v, want exist", err)
+	}
+}
+
+func testAppend(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "file")
+	writeFile(t, g, p, "a\n")
+	f, err := g.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile with O_APPEND: %v", err)
+	}
+	if _, err := f.Write([]byte("b\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	checkFile(t, g, p, "a\nb\n")
+}
+
+func testSeekReadAt(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "file")
+	writeFile(t, g, p, "0123456789")
+	f, err := g.Open(p)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	if f.Name() != p {
+		t.Errorf("Name: %v, want %v", f.Name(), p)
+	}
+	if off, err := f.Seek(4, io.SeekStart); off != 4 || err != nil {
+		t.Errorf("Seek: %v, %v, want 4", off, err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "456" {
+		t.Errorf("Read after Seek: %q, %v, want 456", buf, err)
+	}
+	if _, err := f.ReadAt(buf, 1); err != nil || string(buf) != "123" {
+		t.Errorf("ReadAt: %q, %v, want 123", buf, err)
+	}
+	if off, err := f.Seek(-2, io.SeekEnd); off != 8 || err != nil {
+		t.Errorf("Seek from the end: %v, %v, want 8", off, err)
+	}
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "89" {
+		t.Errorf("Read to the end: %q, %v, want 89", data, err)
+	}
+}
+
+func testRenameOverExisting(t *testing.T, g *gitfs.GitFs, dir string) {
+	from, to := path.Join(dir, "from"), path.Join(dir, "to")
+	writeFile(t, g, from, "new\n")
+	writeFile(t, g, to, "old\n")
+	if err := g.Rename(from, to); err != nil {
+		t.Fatalf("Rename over an existing file: %v", err)
+	}
+	checkFile(t, g, to, "new\n")
+	if _, err := g.Stat(from); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Stat of a renamed file: %v, want not exist", err)
+	}
+}
+
+func testRenameDir(t *testing.T, g *gitfs.GitFs, dir string) {
+	from, to := path.Join(dir, "from"), path.Join(dir, "to")
+	writeFile(t, g, path.Join(from, "a", "file"), "a\n")
+	if err := g.Rename(from, to); err != nil {
+		t.Fatalf("Rename of a dir: %v", err)
+	}
+	checkFile(t, g, path.Join(to, "a", "file"), "a\n")
+	if _, err := g.Stat(from); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Stat of a renamed dir: %v, want not exist", err)
+	}
+}
+
+func testReadDirOrder(t *testing.T, g *gitfs.GitFs, dir string) {
+	for _, name := range []string{"c", "a", "B", "b.txt"} {
+		writeFile(t, g, path.Join(dir, name), name)
+	}
+	if err := g.MkdirAll(path.Join(dir, "b"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	infos, err := g.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+		if fi.IsDir() != (fi.Name() == "b") {
+			t.Errorf("ReadDir: %v is a dir: %v", fi.Name(), fi.IsDir())
+		}
+	}
+	if got, want := strings.Join(names, " "), "B a b b.txt c"; got != want {
+		t.Errorf("ReadDir: %v, want %v, sorted by name", got, want)
+	}
+}
+
+func testMkdirAllRemove(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "a", "b", "c")
+	if err := g.MkdirAll(p, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := g.MkdirAll(p, 0755); err != nil {
+		t.Errorf("MkdirAll of an existing dir: %v", err)
+	}
+	writeFile(t, g, path.Join(p, "file"), "x")
+	if err := g.MkdirAll(path.Join(p, "file"), 0755); err == nil {
+		t.Errorf("MkdirAll over a file succeeded")
+	}
+
+	if err := g.Remove(path.Join(dir, "a")); err == nil {
+		t.Errorf("Remove of a dir that isn't empty succeeded")
+	}
+	if err := g.RemoveAll(path.Join(dir, "a")); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if _, err := g.Stat(path.Join(dir, "a")); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Stat of a removed dir: %v, want not exist", err)
+	}
+	if err := g.RemoveAll(path.Join(dir, "a")); err != nil {
+		t.Errorf("RemoveAll of a missing dir: %v", err)
+	}
+}
+
+func testTempFile(t *testing.T, g *gitfs.GitFs, dir string) {
+	names := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		f, err := g.TempFile(dir, "tmp")
+		if err != nil {
+			t.Fatalf("TempFile: %v", err)
+		}
+		name := f.Name()
+		if _, err := f.Write([]byte("x")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if path.Dir(name) != dir || !strings.HasPrefix(path.Base(name), "tmp") {
+			t.Errorf("TempFile: %v, want a file of %v starting with tmp", name, dir)
+		}
+		if names[name] {
+			t.Errorf("TempFile: %v returned twice", name)
+		}
+		names[name] = true
+		checkFile(t, g, name, "x")
+	}
+}
+
+func testChmod(t *testing.T, g *gitfs.GitFs, dir string) {
+	p := path.Join(dir, "file")
+	writeFile(t, g, p, "#!/bin/sh\n")
+	if err := g.Chmod(p, 0755); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if fi, err := g.Stat(p); err != nil || fi.Mode()&0100 == 0 {
+		t.Errorf("Stat after Chmod: %v, %v, want the executable bit", fi.Mode(), err)
+	}
+	checkFile(t, g, p, "#!/bin/sh\n")
+	if err := g.Chmod(p, 0644); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if fi, err := g.Stat(p); err != nil || fi.Mode()&0111 != 0 {
+		t.Errorf("Stat after Chmod: %v, %v, want no executable bit", fi.Mode(), err)
+	}
+}
+
+func testSymlinks(t *testing.T, g *gitfs.GitFs, dir string) {
+	target, link := path.Join(dir, "target"), path.Join(dir, "link")
+	writeFile(t, g, target, "target\n")
+	if err := g.Symlink("target", link); err != nil {
+		if le, ok := err.(*os.LinkError); ok && le.Err == gitfs.ErrSymlinkForbidden {
+			t.Skip("symlinks are forbidden")
+		}
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	if fi, err := g.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of a symlink: %v, want a symlink", err)
+	}
+	if fi, err := g.Stat(link); err != nil || !fi.Mode().IsRegular() || fi.Size() != 7 {
+		t.Errorf("Stat of a symlink: %v, want its regular target of size 7", err)
+	}
+	if s, err := g.Readlink(link); err != nil || s != "target" {
+		t.Errorf("Readlink: %v, %v, want target", s, err)
+	}
+	checkFile(t, g, link, "target\n")
+
+	if err := g.Symlink("target", link); !os.IsExist(errors.Cause(err)) {
+		t.Errorf("Symlink over an existing link: %v, want exist", err)
+	}
+	if err := g.Remove(link); err != nil {
+		t.Fatalf("Remove of a symlink: %v", err)
+	}
+	checkFile(t, g, target, "target\n")
+
+	dangling := path.Join(dir, "dangling")
+	if err := g.Symlink("missing", dangling); err != nil {
+		t.Fatalf("Symlink to a missing target: %v", err)
+	}
+	if _, err := g.Lstat(dangling); err != nil {
+		t.Errorf("Lstat of a dangling symlink: %v", err)
+	}
+	if _, err := g.Stat(dangling); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Stat of a dangling symlink: %v, want not exist", err)
+	}
+	if err := g.Symlink("target", dangling); !os.IsExist(errors.Cause(err)) {
+		t.Errorf("Symlink over a dangling link: %v, want exist", err)
+	}
+}
+
+func testChroot(t *testing.T, g *gitfs.GitFs, dir string) {
+	writeFile(t, g, path.Join(dir, "sub", "file"), "chroot\n")
+	c, err := g.Chroot(path.Join(dir, "sub"))
+	if err != nil {
+		t.Fatalf("Chroot: %v", err)
+	}
+	checkFile(t, c, "file", "chroot\n")
+	writeFile(t, c, "new", "new\n")
+	checkFile(t, g, path.Join(dir, "sub", "new"), "new\n")
+	if _, err := c.ReadFile("../sub/file"); err == nil {
+		t.Errorf("ReadFile outside of a chroot succeeded")
+	}
+}
+
+func writeFile(t *testing.T, g *gitfs.GitFs, p, data string) {
+	t.Helper()
+	if err := g.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile %v: %v", p, err)
+	}
+}
+
+func checkFile(t *testing.T, g *gitfs.GitFs, p, want string) {
+	t.Helper()
+	data, err := g.ReadFile(p)
+	if err != nil {
+		t.Fatalf("ReadFile %v: %v", p, err)
+	}
+	if !bytes.Equal(data, []byte(want)) {
+		t.Errorf("ReadFile %v: %q, want %q", p, data, want)
+	}
+}
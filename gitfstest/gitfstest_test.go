@@ -0,0 +1,48 @@
+package gitfstest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/iamjinlei/gitfs/gitfstest"
+)
+
+func newFS(t *testing.T, opts ...gitfs.Option) *gitfs.GitFs {
+	g, err := gitfs.NewWithOptions(context.Background(), "", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestMemFs(t *testing.T) {
+	g := newFS(t, gitfs.WithMemFS())
+	defer g.Close()
+	gitfstest.TestFS(t, g)
+}
+
+func TestHybridFs(t *testing.T) {
+	g := newFS(t, gitfs.WithHybridFS(16))
+	defer g.Close()
+	gitfstest.TestFS(t, g)
+}
+
+func TestOsFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitfstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	g := newFS(t, gitfs.WithOsFS(dir, false))
+	defer g.Close()
+	gitfstest.TestFS(t, g)
+}
+
+func TestBare(t *testing.T) {
+	g := newFS(t, gitfs.WithMemFS(), gitfs.WithBare())
+	defer g.Close()
+	gitfstest.TestFS(t, g)
+}
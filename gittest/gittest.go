@@ -0,0 +1,317 @@
+// Package gittest serves git repos kept in memory in process, so tests of
+// applications using gitfs need neither network access nor credentials.
+//
+//	remote, err := gittest.NewRemote(map[string]string{"config.yaml": "a: 1\n"})
+//	...
+//	defer remote.Close()
+//	fs, err := remote.Clone(ctx)
+//
+// Remotes are served by the go-git server transport at gittest:// urls.
+package gittest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// Scheme is the url scheme remotes are served at.
+const Scheme = "gittest"
+
+var (
+	install sync.Once
+	served  = &loader{repos: map[string]storer.Storer{}}
+	lastID  int64
+)
+
+// loader loads the served repos by the host and path of their urls.
+type loader struct {
+	mu    sync.Mutex
+	repos map[string]storer.Storer
+}
+
+func (l *loader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.repos[ep.Host+ep.Path]
+	if !ok {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return s, nil
+}
+
+// Remote is a bare repo kept in memory and served at URL until closed.
+// Its branches are changed in place by Commit and Remove, and by the pushes
+// of clients. It is not meant for concurrent pushes.
+type Remote struct {
+	key  string
+	repo *git.Repository
+}
+
+// NewRemote returns a remote whose master branch holds files, by slash
+// separated path, in a single commit, or which is empty if files is.
+func NewRemote(files map[string]string) (*Remote, error) {
+	install.Do(func() {
+		client.InstallProtocol(Scheme, server.NewClient(served))
+	})
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating repo")
+	}
+	r := &Remote{
+		key:  fmt.Sprintf("remote-%d/repo.git", atomic.AddInt64(&lastID, 1)),
+		repo: repo,
+	}
+	if len(files) > 0 {
+		if _, err := r.Commit("master", "Initial commit", files); err != nil {
+			return nil, err
+		}
+	}
+
+	served.mu.Lock()
+	served.repos[r.key] = repo.Storer
+	served.mu.Unlock()
+	return r, nil
+}
+
+// URL returns the url r is served at.
+func (r *Remote) URL() string {
+	return Scheme + "://" + r.key
+}
+
+// Repository returns the repo of r, e.g. to check what was pushed.
+func (r *Remote) Repository() *git.Repository {
+	return r.repo
+}
+
+// Clone returns a GitFs of r, backed by memory unless set otherwise by
+// opts.
+func (r *Remote) Clone(ctx context.Context, opts ...gitfs.Option) (*gitfs.GitFs, error) {
+	return gitfs.NewWithOptions(ctx, r.URL(), opts...)
+}
+
+// Close stops serving r.
+func (r *Remote) Close() {
+	served.mu.Lock()
+	defer served.mu.Unlock()
+	delete(served.repos, r.key)
+}
+
+// Commit adds or replaces files, by slash separated path, on branch with a
+// commit of msg, returning its hash. A branch missing so far starts at
+// master.
+func (r *Remote) Commit(branch, msg string, files map[string]string) (string, error) {
+	return r.commit(branch, msg, func(entries map[string]object.TreeEntry) error {
+		for p, data := range files {
+			obj := r.repo.Storer.NewEncodedObject()
+			obj.SetType(plumbing.BlobObject)
+			w, err := obj.Writer()
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(data)); err != nil {
+				return err
+			}
+			if err := w.Close(); err != nil {
+				return err
+			}
+			h, err := r.repo.Storer.SetEncodedObject(obj)
+			if err != nil {
+				return errors.Wrapf(err, "error storing %v", p)
+			}
+			entries[cleanPath(p)] = object.TreeEntry{Mode: filemode.Regular, Hash: h}
+		}
+		return nil
+	})
+}
+
+// Remove removes paths from branch with a commit of msg, returning its
+// hash.
+func (r *Remote) Remove(branch, msg string, paths ...string) (string, error) {
+	return r.commit(branch, msg, func(entries map[string]object.TreeEntry) error {
+		for _, p := range paths {
+			if _, ok := entries[cleanPath(p)]; !ok {
+				return errors.Errorf("%v not found on %v", p, branch)
+			}
+			delete(entries, cleanPath(p))
+		}
+		return nil
+	})
+}
+
+// Head returns the hash of the last commit of branch, empty if it doesn't
+// exist.
+func (r *Remote) Head(branch string) (string, error) {
+	ref, err := r.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "error reading branch %v", branch)
+	}
+	return ref.Hash().String(), nil
+}
+
+// ReadFile returns the content of the file at path on branch.
+func (r *Remote) ReadFile(branch, path string) ([]byte, error) {
+	files, err := r.Files(branch)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := files[cleanPath(path)]
+	if !ok {
+		return nil, errors.Errorf("%v not found on %v", path, branch)
+	}
+	return []byte(data), nil
+}
+
+// Files returns the files of branch by slash separated path.
+func (r *Remote) Files(branch string) (map[string]string, error) {
+	c, err := r.branchCommit(branch)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	if c == nil {
+		return files, nil
+	}
+	iter, err := c.Files()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading files of %v", branch)
+	}
+	err = iter.ForEach(func(f *object.File) error {
+		data, err := f.Contents()
+		files[f.Name] = data
+		return err
+	})
+	return files, errors.Wrapf(err, "error reading files of %v", branch)
+}
+
+// branchCommit returns the last commit of branch, nil if it doesn't exist.
+func (r *Remote) branchCommit(branch string) (*object.Commit, error) {
+	h, err := r.Head(branch)
+	if err != nil || h == "" {
+		return nil, err
+	}
+	c, err := r.repo.CommitObject(plumbing.NewHash(h))
+	return c, errors.Wrapf(err, "error reading commit %v", h)
+}
+
+// commit commits the files of branch as changed by change, keyed by path.
+func (r *Remote) commit(branch, msg string, change func(map[string]object.TreeEntry) error) (string, error) {
+	parent, err := r.branchCommit(branch)
+	if err != nil {
+		return "", err
+	}
+	if parent == nil && branch != "master" {
+		if parent, err = r.branchCommit("master"); err != nil {
+			return "", err
+		}
+	}
+
+	entries := map[string]object.TreeEntry{}
+	var parents []plumbing.Hash
+	if parent != nil {
+		parents = append(parents, parent.Hash)
+		iter, err := parent.Files()
+		if err != nil {
+			return "", errors.Wrapf(err, "error reading files of %v", branch)
+		}
+		if err := iter.ForEach(func(f *object.File) error {
+			entries[f.Name] = object.TreeEntry{Mode: f.Mode, Hash: f.Hash}
+			return nil
+		}); err != nil {
+			return "", errors.Wrapf(err, "error reading files of %v", branch)
+		}
+	}
+	if err := change(entries); err != nil {
+		return "", err
+	}
+
+	tree, err := r.storeTree(entries)
+	if err != nil {
+		return "", errors.Wrapf(err, "error storing tree")
+	}
+	sig := object.Signature{Name: "gittest", Email: "gittest@example.com", When: time.Now()}
+	obj := r.repo.Storer.NewEncodedObject()
+	if err := (&object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      msg,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}).Encode(obj); err != nil {
+		return "", errors.Wrapf(err, "error encoding commit")
+	}
+	h, err := r.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return "", errors.Wrapf(err, "error storing commit")
+	}
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), h)
+	if err := r.repo.Storer.SetReference(ref); err != nil {
+		return "", errors.Wrapf(err, "error updating branch %v", branch)
+	}
+	return h.String(), nil
+}
+
+// storeTree stores the tree of entries, keyed by slash separated path.
+func (r *Remote) storeTree(entries map[string]object.TreeEntry) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	dirs := map[string]map[string]object.TreeEntry{}
+	for p, e := range entries {
+		if i := strings.IndexByte(p, '/'); i >= 0 {
+			if dirs[p[:i]] == nil {
+				dirs[p[:i]] = map[string]object.TreeEntry{}
+			}
+			dirs[p[:i]][p[i+1:]] = e
+			continue
+		}
+		e.Name = p
+		tree.Entries = append(tree.Entries, e)
+	}
+	for d, sub := range dirs {
+		h, err := r.storeTree(sub)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: d, Mode: filemode.Dir, Hash: h})
+	}
+	// git sorts dirs as if their names ended with a slash
+	key := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return key(tree.Entries[i]) < key(tree.Entries[j])
+	})
+
+	obj := r.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.repo.Storer.SetEncodedObject(obj)
+}
+
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,93 @@
+package gittest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iamjinlei/gitfs/gittest"
+)
+
+func TestCloneSyncPull(t *testing.T) {
+	r, err := gittest.NewRemote(map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g, err := r.Clone(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if data, err := g.ReadFile("dir/b.txt"); err != nil || string(data) != "b" {
+		t.Fatalf("got dir/b.txt %q, %v", data, err)
+	}
+
+	if err := g.WriteFile("c.txt", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := r.ReadFile("master", "c.txt"); err != nil || string(data) != "c" {
+		t.Fatalf("got pushed c.txt %q, %v", data, err)
+	}
+
+	if _, err := r.Commit("master", "change a", map[string]string{"a.txt": "a2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Remove("master", "remove b", "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Pull(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := g.ReadFile("a.txt"); err != nil || string(data) != "a2" {
+		t.Fatalf("got pulled a.txt %q, %v", data, err)
+	}
+	if _, err := g.Stat("dir/b.txt"); err == nil {
+		t.Fatal("removed dir/b.txt still exists")
+	}
+}
+
+func TestBranches(t *testing.T) {
+	r, err := gittest.NewRemote(map[string]string{"a.txt": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if h, err := r.Head("feature"); err != nil || h != "" {
+		t.Fatalf("got head %q, %v of a missing branch", h, err)
+	}
+	h, err := r.Commit("feature", "add b", map[string]string{"b.txt": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := r.Head("feature"); err != nil || head != h {
+		t.Fatalf("got head %q, %v, want %v", head, err, h)
+	}
+	files, err := r.Files("feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["a.txt"] != "a" || files["b.txt"] != "b" {
+		t.Fatalf("got files %v of a branch started at master", files)
+	}
+	if _, err := r.Remove("master", "remove", "missing.txt"); err == nil {
+		t.Fatal("removed a missing file")
+	}
+}
+
+func TestEmptyAndClosed(t *testing.T) {
+	r, err := gittest.NewRemote(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err := r.Files("master"); err != nil || len(files) != 0 {
+		t.Fatalf("got files %v, %v of an empty remote", files, err)
+	}
+	r.Close()
+	if g, err := r.Clone(context.Background()); err == nil {
+		g.Close()
+		t.Fatal("cloned a closed remote")
+	}
+}
@@ -0,0 +1,135 @@
+package gitfs
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Hooks are callbacks notified of lifecycle events, e.g. for audit logging
+// or alerting. Nil callbacks are skipped. They are called synchronously, so
+// they should return quickly.
+type Hooks struct {
+	OnPull     func(PullEvent)
+	OnCommit   func(CommitEvent)
+	OnPush     func(PushEvent)
+	OnConflict func(ConflictEvent)
+	OnError    func(ErrorEvent)
+}
+
+// PullEvent describes a successful pull.
+type PullEvent struct {
+	// HEAD before and after the pull, equal if already up to date
+	Before   plumbing.Hash
+	After    plumbing.Hash
+	Duration time.Duration
+}
+
+// CommitEvent describes a created commit.
+type CommitEvent struct {
+	Hash    plumbing.Hash
+	Message string
+	When    time.Time
+}
+
+// PushEvent describes a successful push.
+type PushEvent struct {
+	RefSpecs []string
+	// If the remote already had all pushed refs
+	UpToDate bool
+	Duration time.Duration
+}
+
+// ConflictEvent describes an operation that failed as the remote repo has
+// diverged.
+type ConflictEvent struct {
+	Op  string
+	Err error
+}
+
+// ErrorEvent describes any other failed operation.
+type ErrorEvent struct {
+	Op  string
+	Err error
+}
+
+func isConflict(err error) bool {
+	switch errors.Cause(err) {
+	case git.ErrNonFastForwardUpdate, git.ErrForceNeeded:
+		return true
+	}
+	return false
+}
+
+// reportError notifies hooks if err is not nil, reporting whether it was.
+func (g *Git) reportError(op string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if isConflict(err) {
+		if g.hooks.OnConflict != nil {
+			g.hooks.OnConflict(ConflictEvent{Op: op, Err: err})
+		}
+	} else if g.hooks.OnError != nil {
+		g.hooks.OnError(ErrorEvent{Op: op, Err: err})
+	}
+	return true
+}
+
+func (g *Git) headHash() plumbing.Hash {
+	head, err := g.repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	return head.Hash()
+}
+
+// pullHook returns the func notifying hooks of the outcome of a pull
+// started at start, with HEAD at before.
+func (g *Git) pullHook(start time.Time, before plumbing.Hash) func(err *error) {
+	return func(err *error) {
+		if g.reportError("pull", *err) || g.hooks.OnPull == nil {
+			return
+		}
+		g.hooks.OnPull(PullEvent{
+			Before:   before,
+			After:    g.headHash(),
+			Duration: time.Since(start),
+		})
+	}
+}
+
+// commitHook returns the func notifying hooks of the outcome of a commit
+// of msg, which becomes HEAD on success.
+func (g *Git) commitHook(msg string) func(err *error) {
+	return func(err *error) {
+		if g.reportError("commit", *err) || g.hooks.OnCommit == nil {
+			return
+		}
+		g.hooks.OnCommit(CommitEvent{
+			Hash:    g.headHash(),
+			Message: msg,
+			When:    g.clock.Now(),
+		})
+	}
+}
+
+// pushHook returns the func notifying hooks of the outcome of a push of
+// specs started at start.
+func (g *Git) pushHook(start time.Time, specs []string) func(err *error) {
+	return func(err *error) {
+		upToDate := *err == git.NoErrAlreadyUpToDate
+		if upToDate {
+			if g.hooks.OnPush != nil {
+				g.hooks.OnPush(PushEvent{RefSpecs: specs, UpToDate: true, Duration: time.Since(start)})
+			}
+			return
+		}
+		if g.reportError("push", *err) || g.hooks.OnPush == nil {
+			return
+		}
+		g.hooks.OnPush(PushEvent{RefSpecs: specs, Duration: time.Since(start)})
+	}
+}
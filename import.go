@@ -0,0 +1,206 @@
+package gitfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImportOptions configures Import and ImportDir.
+type ImportOptions struct {
+	// Format of the archive read by Import
+	Format ArchiveFormat
+	// Number of leading path components stripped from archive paths, e.g. 1
+	// for archives made by Archive with a prefix
+	StripComponents int
+	// If existing files are replaced, otherwise importing fails on them
+	Overwrite bool
+}
+
+// Import unpacks the archive read from r into dest, preserving the
+// executable bit and symlinks, ready for a single Sync. Entries are written
+// like by WriteFile, so the writable paths and size limits apply.
+func (g *GitFs) Import(r io.Reader, dest string, opts ImportOptions) (err error) {
+	defer g.git.trace("gitfs.Import")(&err)
+
+	switch opts.Format {
+	case ArchiveTarGz:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrapf(err, "error reading archive")
+		}
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return errors.Wrapf(err, "error reading archive")
+			}
+
+			var ierr error
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				ierr = g.importDir(dest, hdr.Name, opts)
+			case tar.TypeSymlink:
+				ierr = g.importSymlink(dest, hdr.Name, hdr.Linkname, opts)
+			case tar.TypeReg, tar.TypeRegA:
+				ierr = g.importFile(dest, hdr.Name, tr, hdr.FileInfo().Mode(), opts)
+			}
+			if ierr != nil {
+				return ierr
+			}
+		}
+	case ArchiveZip:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrapf(err, "error reading archive")
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return errors.Wrapf(err, "error reading archive")
+		}
+		for _, f := range zr.File {
+			if err := g.importZipEntry(dest, f, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown archive format %v", opts.Format)
+	}
+}
+
+func (g *GitFs) importZipEntry(dest string, f *zip.File, opts ImportOptions) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return g.importDir(dest, f.Name, opts)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v", f.Name)
+	}
+	defer rc.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %v", f.Name)
+		}
+		return g.importSymlink(dest, f.Name, string(target), opts)
+	}
+	return g.importFile(dest, f.Name, rc, mode, opts)
+}
+
+// ImportDir copies the host directory dir into dest, preserving the
+// executable bit and symlinks, ready for a single Sync. Files are written
+// like by WriteFile, so the writable paths and size limits apply. A .git
+// directory of dir is skipped.
+func (g *GitFs) ImportDir(dir, dest string, opts ImportOptions) (err error) {
+	defer g.git.trace("gitfs.ImportDir")(&err)
+
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case fi.IsDir():
+			return g.importDir(dest, rel, opts)
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return errors.Wrapf(err, "error reading %v", p)
+			}
+			return g.importSymlink(dest, rel, filepath.ToSlash(target), opts)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return errors.Wrapf(err, "error reading %v", p)
+			}
+			defer f.Close()
+			return g.importFile(dest, rel, f, fi.Mode(), opts)
+		}
+		// sockets, devices and the like git can't store
+		return nil
+	})
+}
+
+// importPath returns the path name is imported to, or "" to skip it.
+func importPath(dest, name string, strip int) string {
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	if len(parts) <= strip || parts[0] == "" {
+		return ""
+	}
+	return path.Join(dest, strings.Join(parts[strip:], "/"))
+}
+
+func (g *GitFs) importDir(dest, name string, opts ImportOptions) error {
+	p := importPath(dest, name, opts.StripComponents)
+	if p == "" {
+		return nil
+	}
+	return errors.Wrapf(g.MkdirAll(p, 0755), "error importing %v", name)
+}
+
+// importable returns an error if p exists and may not be replaced.
+func (g *GitFs) importable(p string, opts ImportOptions) error {
+	if _, err := g.Lstat(p); err == nil {
+		if !opts.Overwrite {
+			return &os.PathError{Op: "import", Path: p, Err: os.ErrExist}
+		}
+		return g.Remove(p)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (g *GitFs) importFile(dest, name string, r io.Reader, mode os.FileMode, opts ImportOptions) error {
+	p := importPath(dest, name, opts.StripComponents)
+	if p == "" {
+		return nil
+	}
+	if err := g.importable(p, opts); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v", name)
+	}
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	return errors.Wrapf(g.WriteFile(p, data, perm), "error importing %v", name)
+}
+
+func (g *GitFs) importSymlink(dest, name, target string, opts ImportOptions) error {
+	p := importPath(dest, name, opts.StripComponents)
+	if p == "" {
+		return nil
+	}
+	if err := g.importable(p, opts); err != nil {
+		return err
+	}
+	return errors.Wrapf(g.Symlink(target, p), "error importing %v", name)
+}
@@ -0,0 +1,353 @@
+package gitfs
+
+import (
+	"compress/flate"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/objfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+)
+
+var ErrCorruptRepo = errors.New("repo is corrupt")
+
+// verify checks that every object reachable from the refs is intact, like
+// git fsck, and that the index refers to intact objects. The worktree is
+// not checked, its changes are merely not synced yet.
+func (g *Git) verify() (err error) {
+	defer g.trace("gitfs.Verify")(&err)
+
+	shallow, err := g.repo.Storer.Shallow()
+	if err != nil {
+		return errors.Wrapf(err, "error reading shallow commits")
+	}
+	seen := map[plumbing.Hash]bool{}
+	for _, h := range shallow {
+		if err := g.verifyCommit(h, seen, false); err != nil {
+			return err
+		}
+	}
+
+	refs, err := g.repo.Storer.IterReferences()
+	if err != nil {
+		return errors.Wrapf(err, "error reading refs")
+	}
+	if err := refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		return g.verifyTarget(ref.Hash(), seen)
+	}); err != nil {
+		return err
+	}
+
+	if g.bare != nil {
+		return nil
+	}
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return errors.Wrapf(err, "error reading index")
+	}
+	for _, e := range idx.Entries {
+		if e.Mode == filemode.Submodule || seen[e.Hash] {
+			continue
+		}
+		if err := g.verifyObject(e.Hash, seen); err != nil {
+			return errors.Wrapf(err, "error verifying index entry %v", e.Name)
+		}
+	}
+	return nil
+}
+
+// verifyTarget verifies the object h a ref points to, following tags.
+func (g *Git) verifyTarget(h plumbing.Hash, seen map[plumbing.Hash]bool) error {
+	for !seen[h] {
+		obj, err := g.repo.Storer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return errors.Wrapf(err, "error reading object %v", h)
+		}
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			return g.verifyCommit(h, seen, true)
+		case plumbing.TreeObject:
+			return g.verifyTree(h, seen)
+		case plumbing.TagObject:
+			if err := g.verifyObject(h, seen); err != nil {
+				return err
+			}
+			tag, err := object.DecodeTag(g.repo.Storer, obj)
+			if err != nil {
+				return errors.Wrapf(err, "error reading tag %v", h)
+			}
+			h = tag.Target
+		default:
+			return g.verifyObject(h, seen)
+		}
+	}
+	return nil
+}
+
+// verifyCommit verifies the commit h, its tree and, with parents, its
+// ancestors.
+func (g *Git) verifyCommit(h plumbing.Hash, seen map[plumbing.Hash]bool, parents bool) error {
+	stack := []plumbing.Hash{h}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[h] {
+			continue
+		}
+		if err := g.verifyObject(h, seen); err != nil {
+			return err
+		}
+
+		c, err := g.repo.CommitObject(h)
+		if err != nil {
+			return errors.Wrapf(err, "error reading commit %v", h)
+		}
+		if err := g.verifyTree(c.TreeHash, seen); err != nil {
+			return err
+		}
+		if parents {
+			stack = append(stack, c.ParentHashes...)
+		}
+	}
+	return nil
+}
+
+func (g *Git) verifyTree(h plumbing.Hash, seen map[plumbing.Hash]bool) error {
+	if seen[h] {
+		return nil
+	}
+	if err := g.verifyObject(h, seen); err != nil {
+		return err
+	}
+
+	tree, err := g.repo.TreeObject(h)
+	if err != nil {
+		return errors.Wrapf(err, "error reading tree %v", h)
+	}
+	for _, e := range tree.Entries {
+		switch e.Mode {
+		case filemode.Dir:
+			err = g.verifyTree(e.Hash, seen)
+		case filemode.Submodule:
+			// commit of another repo
+		default:
+			if !seen[e.Hash] {
+				err = g.verifyObject(e.Hash, seen)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyObject reads object h, checking its content hashes to h.
+func (g *Git) verifyObject(h plumbing.Hash, seen map[plumbing.Hash]bool) error {
+	obj, err := g.repo.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return errors.Wrapf(err, "error reading object %v", h)
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return errors.Wrapf(err, "error reading object %v", h)
+	}
+	defer r.Close()
+
+	hasher := plumbing.NewHasher(obj.Type(), obj.Size())
+	if _, err := io.Copy(hasher, r); err != nil {
+		return errors.Wrapf(err, "error reading object %v", h)
+	}
+	if sum := hasher.Sum(); sum != h {
+		return errors.Errorf("object %v hashes to %v", h, sum)
+	}
+	seen[h] = true
+	return nil
+}
+
+// checkOpen reads what opening the repo relies on, the index and the
+// commit and tree of HEAD, unless its branch is unborn.
+func (g *Git) checkOpen() error {
+	if g.wt != nil {
+		if _, err := g.repo.Storer.Index(); err != nil {
+			return errors.Wrapf(err, "error reading index")
+		}
+	}
+	head, err := g.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+	c, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return errors.Wrapf(err, "error reading commit %v", head.Hash())
+	}
+	if _, err := c.Tree(); err != nil {
+		return errors.Wrapf(err, "error reading tree %v", c.TreeHash)
+	}
+	return nil
+}
+
+// corruptErrors are the errors of reading a corrupt git dir: missing
+// objects and refs, bad packs, loose objects, index and packed-refs.
+var corruptErrors = []error{
+	git.ErrRepositoryNotExists,
+	plumbing.ErrObjectNotFound,
+	plumbing.ErrReferenceNotFound,
+	plumbing.ErrInvalidType,
+	dotgit.ErrPackfileNotFound,
+	dotgit.ErrIdxNotFound,
+	dotgit.ErrPackedRefsBadFormat,
+	dotgit.ErrPackedRefsDuplicatedRef,
+	dotgit.ErrSymRefTargetNotFound,
+	idxfile.ErrMalformedIdxFile,
+	idxfile.ErrUnsupportedVersion,
+	packfile.ErrReferenceDeltaNotFound,
+	packfile.ErrInvalidDelta,
+	packfile.ErrDeltaCmd,
+	objfile.ErrHeader,
+	objfile.ErrNegativeSize,
+	index.ErrMalformedSignature,
+	index.ErrInvalidChecksum,
+	index.ErrUnsupportedVersion,
+	zlib.ErrHeader,
+	zlib.ErrChecksum,
+	io.ErrUnexpectedEOF,
+}
+
+// corrupted tells errors of a corrupt git dir, worth cloning again for,
+// from others, like those of I/O or running out of file descriptors,
+// which cloning again wouldn't help with.
+func corrupted(err error) bool {
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case *packfile.Error, flate.CorruptInputError:
+		return true
+	}
+	for _, e := range corruptErrors {
+		if cause == e {
+			return true
+		}
+	}
+	return false
+}
+
+// recloneCorrupt clones the corrupt osFs repo of c again, keeping the
+// worktree, or fails with ErrCorruptRepo if it can't. Bare repos, with no
+// worktree, are removed first.
+func recloneCorrupt(ctx context.Context, c *Config, cause error) (*Git, error) {
+	if c.noRemote || c.offline || c.useMemFs || c.storer != nil || c.worktreeFs != nil {
+		return nil, errors.Wrapf(ErrCorruptRepo, "%v", cause)
+	}
+	if !c.bare {
+		return recoverCorrupt(ctx, c, cause)
+	}
+	if err := os.RemoveAll(c.osFsBaseDir); err != nil {
+		return nil, errors.Wrapf(err, "error removing corrupt repo %v", c.osFsBaseDir)
+	}
+
+	fresh := *c
+	fresh.openExisting = false
+	fresh.verifyOnOpen = false
+	fresh.autoRecover = false
+	g, err := NewGit(ctx, &fresh)
+	if err == nil {
+		g.reportError("gitfs.Recover", errors.Wrapf(ErrCorruptRepo, "%v, cloned again", cause))
+	}
+	return g, err
+}
+
+// recoverCorrupt clones the corrupt osFs repo of c again, keeping its
+// worktree: it is moved to a backup dir next to the base dir meanwhile,
+// then over the checkout of the clone, so changes not yet synced show as
+// such. If cloning fails, the worktree is moved back as it was.
+func recoverCorrupt(ctx context.Context, c *Config, cause error) (*Git, error) {
+	base := filepath.Clean(c.osFsBaseDir)
+	backup, err := ioutil.TempDir(filepath.Dir(base), filepath.Base(base)+".backup-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating backup dir of %v", base)
+	}
+	if err := moveEntries(base, backup, ""); err != nil {
+		moveEntries(backup, base, "")
+		os.Remove(backup)
+		return nil, errors.Wrapf(err, "error backing up worktree of %v", base)
+	}
+
+	fresh := *c
+	fresh.openExisting = false
+	fresh.verifyOnOpen = false
+	fresh.autoRecover = false
+	g, err := NewGit(ctx, &fresh)
+	if err != nil {
+		if rerr := removeEntries(base, ""); rerr != nil {
+			return nil, errors.Wrapf(rerr, "error restoring worktree of %v, it is backed up in %v", base, backup)
+		}
+		if rerr := moveEntries(backup, base, ""); rerr != nil {
+			return nil, errors.Wrapf(rerr, "error restoring worktree of %v, it is backed up in %v", base, backup)
+		}
+		os.Remove(backup)
+		return nil, errors.Wrapf(ErrCorruptRepo, "%v, cloning again failed: %v", cause, err)
+	}
+
+	if err := removeEntries(base, git.GitDirName); err != nil {
+		return nil, errors.Wrapf(err, "error restoring worktree of %v, it is backed up in %v", base, backup)
+	}
+	if err := moveEntries(backup, base, git.GitDirName); err != nil {
+		return nil, errors.Wrapf(err, "error restoring worktree of %v, it is backed up in %v", base, backup)
+	}
+	if err := os.RemoveAll(backup); err != nil {
+		return nil, errors.Wrapf(err, "error removing backup dir %v", backup)
+	}
+	g.reportError("gitfs.Recover", errors.Wrapf(ErrCorruptRepo, "%v, cloned again keeping the worktree", cause))
+	return g, nil
+}
+
+// moveEntries moves the entries of dir from to dir to, but for skip.
+func moveEntries(from, to, skip string) error {
+	infos, err := ioutil.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if fi.Name() == skip {
+			continue
+		}
+		if err := os.Rename(filepath.Join(from, fi.Name()), filepath.Join(to, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEntries removes the entries of dir, but for skip.
+func removeEntries(dir, skip string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if fi.Name() == skip {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package gitfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+)
+
+func TestVerifyOnOpenKeepsLocalChanges(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	r := newTestRemote(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	g := r.clone(NewConfig().UseOsFs(dir, false))
+	writeTestFile(t, g, "a.txt", "edited")
+	writeTestFile(t, g, "new.txt", "new")
+
+	var events []ErrorEvent
+	g, err := New(context.Background(), NewConfig().SetUrl(r.url).UseOsFs(dir, true).VerifyOnOpen().
+		SetHooks(Hooks{OnError: func(e ErrorEvent) { events = append(events, e) }}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("intact repo recovered: %v", events)
+	}
+	if got := readTestFile(t, g, "a.txt"); got != "edited" {
+		t.Fatalf("a.txt is %q", got)
+	}
+	if got := readTestFile(t, g, "new.txt"); got != "new" {
+		t.Fatalf("new.txt is %q", got)
+	}
+}
+
+func TestVerifyOnOpenRecoversKeepingWorktree(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	r := newTestRemote(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	g := r.clone(NewConfig().UseOsFs(dir, false))
+	writeTestFile(t, g, "a.txt", "edited")
+	writeTestFile(t, g, "new.txt", "new")
+	if err := g.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	removePacks(t, dir)
+
+	var events []ErrorEvent
+	g, err := New(context.Background(), NewConfig().SetUrl(r.url).UseOsFs(dir, true).VerifyOnOpen().
+		SetHooks(Hooks{OnError: func(e ErrorEvent) { events = append(events, e) }}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || errors.Cause(events[0].Err) != ErrCorruptRepo {
+		t.Fatalf("recovery not reported: %v", events)
+	}
+	if got := readTestFile(t, g, "a.txt"); got != "edited" {
+		t.Fatalf("a.txt is %q", got)
+	}
+	if got := readTestFile(t, g, "new.txt"); got != "new" {
+		t.Fatalf("new.txt is %q", got)
+	}
+	if _, err := g.Stat("b.txt"); !os.IsNotExist(err) {
+		t.Fatalf("removed b.txt restored: %v", err)
+	}
+	status, err := g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("status after recovery is %v", status)
+	}
+}
+
+func TestAutoRecoverKeepsWorktree(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	r := newTestRemote(t, map[string]string{"a.txt": "a"})
+	g := r.clone(NewConfig().UseOsFs(dir, false))
+	writeTestFile(t, g, "a.txt", "edited")
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "index"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := New(context.Background(), NewConfig().SetUrl(r.url).UseOsFs(dir, true).AutoRecover())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, g, "a.txt"); got != "edited" {
+		t.Fatalf("a.txt is %q", got)
+	}
+	infos, err := ioutil.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range infos {
+		if filepath.Ext(fi.Name()) != "" && fi.Name() != filepath.Base(dir) &&
+			len(fi.Name()) > len(filepath.Base(dir)) && fi.Name()[:len(filepath.Base(dir))+1] == filepath.Base(dir)+"." {
+			t.Fatalf("backup dir %v left", fi.Name())
+		}
+	}
+}
+
+func TestAutoRecoverRestoresWorktreeIfCloneFails(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	r := newTestRemote(t, map[string]string{"a.txt": "a"})
+	g := r.clone(NewConfig().UseOsFs(dir, false))
+	writeTestFile(t, g, "a.txt", "edited")
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "index"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := New(context.Background(), NewConfig().SetUrl(testScheme+"://missing/repo.git").UseOsFs(dir, true).AutoRecover())
+	if errors.Cause(err) != ErrCorruptRepo {
+		t.Fatalf("got %v, want ErrCorruptRepo", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "edited" {
+		t.Fatalf("a.txt is %q, %v", data, err)
+	}
+}
+
+func TestCorruptedOnlyForCorruptGitDir(t *testing.T) {
+	for _, err := range []error{
+		plumbing.ErrObjectNotFound,
+		errors.Wrapf(index.ErrMalformedSignature, "error reading index"),
+		packfile.ErrZLib.AddDetails("bad object"),
+	} {
+		if !corrupted(err) {
+			t.Errorf("%v not corrupted", err)
+		}
+	}
+	for _, err := range []error{
+		&os.PathError{Op: "read", Path: "index", Err: syscall.EIO},
+		&os.PathError{Op: "open", Path: "pack", Err: syscall.EMFILE},
+		os.ErrPermission,
+		context.Canceled,
+	} {
+		if corrupted(errors.Wrapf(err, "error reading index")) {
+			t.Errorf("%v corrupted", err)
+		}
+	}
+}
+
+// removePacks corrupts the osFs repo in dir by removing its objects.
+func removePacks(t *testing.T, dir string) {
+	t.Helper()
+	packs := filepath.Join(dir, ".git", "objects", "pack")
+	infos, err := ioutil.ReadDir(packs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range infos {
+		if err := os.Remove(filepath.Join(packs, fi.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package journal implements an append-only record log on top of a GitFs.
+// Records are stored in dated, size-rotated files, so the git history of
+// the repo makes any tampering with past records evident.
+package journal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxFileSize = 1 << 20
+	defaultBatchSize   = 100
+	headerSize         = 4
+	dateLayout         = "2006-01-02"
+	fileExt            = ".log"
+)
+
+var ErrCorrupted = errors.New("corrupted journal file")
+
+// Journal appends records to files under dir/<yyyy-mm-dd>/<seq>.log. Each
+// record is stored as a 4 byte big endian length followed by its content.
+type Journal struct {
+	mu          sync.Mutex
+	fs          *gitfs.GitFs
+	dir         string
+	maxFileSize int64
+	batchSize   int
+	pending     [][]byte
+}
+
+// New creates a journal keeping its files under dir of fs.
+func New(fs *gitfs.GitFs, dir string) *Journal {
+	return &Journal{
+		fs:          fs,
+		dir:         dir,
+		maxFileSize: defaultMaxFileSize,
+		batchSize:   defaultBatchSize,
+	}
+}
+
+// SetMaxFileSize sets the size after which a new journal file is started.
+func (j *Journal) SetMaxFileSize(n int64) *Journal {
+	j.maxFileSize = n
+	return j
+}
+
+// SetBatchSize sets the number of buffered records which triggers a
+// commit. A size of 1 commits every record immediately.
+func (j *Journal) SetBatchSize(n int) *Journal {
+	if n < 1 {
+		n = 1
+	}
+	j.batchSize = n
+	return j
+}
+
+// Append adds a record to the journal.
+func (j *Journal) Append(record []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.pending = append(j.pending, append([]byte(nil), record...))
+	if len(j.pending) < j.batchSize {
+		return nil
+	}
+	return j.flush()
+}
+
+// Flush commits and pushes all buffered records.
+func (j *Journal) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.flush()
+}
+
+func (j *Journal) flush() error {
+	if len(j.pending) == 0 {
+		return nil
+	}
+
+	dayDir := path.Join(j.dir, time.Now().UTC().Format(dateLayout))
+	seq, size, err := j.lastFile(dayDir)
+	if err != nil {
+		return err
+	}
+
+	var order []string
+	appends := map[string][]byte{}
+	for _, r := range j.pending {
+		n := int64(headerSize + len(r))
+		if size > 0 && size+n > j.maxFileSize {
+			seq++
+			size = 0
+		}
+		size += n
+
+		name := path.Join(dayDir, fmt.Sprintf("%06d%v", seq, fileExt))
+		if _, ok := appends[name]; !ok {
+			order = append(order, name)
+		}
+		var hdr [headerSize]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(r)))
+		appends[name] = append(append(appends[name], hdr[:]...), r...)
+	}
+
+	if err := j.fs.Apply(func(w gitfs.Writer) error {
+		for _, name := range order {
+			data, err := w.ReadFile(name)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "error reading %v", name)
+			}
+			if err := w.WriteFile(name, append(data, appends[name]...), 0644); err != nil {
+				return errors.Wrapf(err, "error writing %v", name)
+			}
+		}
+		return nil
+	}, fmt.Sprintf("journal: append %v records", len(j.pending))); err != nil {
+		return err
+	}
+
+	j.pending = nil
+	return nil
+}
+
+// lastFile returns the sequence number and size of the newest file in dir.
+func (j *Journal) lastFile(dir string) (int, int64, error) {
+	files, err := j.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, errors.Wrapf(err, "error listing %v", dir)
+	}
+
+	seq, size := 0, int64(0)
+	for _, fi := range files {
+		var n int
+		if _, err := fmt.Sscanf(fi.Name(), "%06d.log", &n); err != nil {
+			continue
+		}
+		if n >= seq {
+			seq, size = n, fi.Size()
+		}
+	}
+	return seq, size, nil
+}
+
+// Iterator returns an iterator over all flushed records, oldest first.
+// Records still buffered are not visited.
+func (j *Journal) Iterator() (*Iterator, error) {
+	var files []string
+	if err := j.fs.Walk(j.dir, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fi.IsDir() && path.Ext(p) == fileExt {
+			files = append(files, p)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error listing journal files")
+	}
+	sort.Strings(files)
+
+	return &Iterator{fs: j.fs, files: files}, nil
+}
+
+// Iterator walks journal records in order.
+type Iterator struct {
+	fs     *gitfs.GitFs
+	files  []string
+	data   []byte
+	record []byte
+	err    error
+}
+
+// Next advances to the next record. It returns false at the end of the
+// journal or on error.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for len(it.data) == 0 {
+		if len(it.files) == 0 {
+			return false
+		}
+		it.data, it.err = it.fs.ReadFile(it.files[0])
+		if it.err != nil {
+			it.err = errors.Wrapf(it.err, "error reading %v", it.files[0])
+			return false
+		}
+		it.files = it.files[1:]
+	}
+
+	if len(it.data) < headerSize {
+		it.err = ErrCorrupted
+		return false
+	}
+	n := int(binary.BigEndian.Uint32(it.data))
+	if len(it.data) < headerSize+n {
+		it.err = ErrCorrupted
+		return false
+	}
+	it.record = it.data[headerSize : headerSize+n]
+	it.data = it.data[headerSize+n:]
+	return true
+}
+
+// Record returns the current record.
+func (it *Iterator) Record() []byte {
+	return it.record
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,41 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+)
+
+func TestIteratorReadsCompressedFiles(t *testing.T) {
+	fs, err := gitfs.New(context.Background(), gitfs.NewConfig().NoRemote().UseMemFs().SetCompression(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := New(fs, "journal").SetBatchSize(1)
+	var want []string
+	for i := 0; i < 10; i++ {
+		r := fmt.Sprintf("record %v %v", i, strings.Repeat("x", 32))
+		if err := j.Append([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, r)
+	}
+
+	it, err := j.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for it.Next() {
+		got = append(got, string(it.Record()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got records %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+// Package kv implements a versioned key-value store on top of a GitFs,
+// storing one file per key, named by the base32 encoding of the key so no
+// key can name a dir like "." or "..".
+package kv
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBatchSize = 100
+	// Longest file name most filesystems accept
+	maxNameLen = 255
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// keyEncoding encodes keys as file names, lower case for case insensitive
+// filesystems.
+var keyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Store is a key-value store backed by a directory of a GitFs. Puts and
+// Deletes are buffered and written as a single commit once the batch size
+// is reached, or when Flush is called.
+type Store struct {
+	mu        sync.Mutex
+	fs        *gitfs.GitFs
+	dir       string
+	batchSize int
+	// pending changes, a nil value marks a deletion
+	pending map[string][]byte
+}
+
+// New creates a store keeping its keys under dir of fs.
+func New(fs *gitfs.GitFs, dir string) *Store {
+	return &Store{
+		fs:        fs,
+		dir:       dir,
+		batchSize: defaultBatchSize,
+		pending:   map[string][]byte{},
+	}
+}
+
+// SetBatchSize sets the number of buffered changes which triggers a commit.
+// A size of 1 commits every change immediately.
+func (s *Store) SetBatchSize(n int) *Store {
+	if n < 1 {
+		n = 1
+	}
+	s.batchSize = n
+	return s
+}
+
+func (s *Store) keyPath(key string) string {
+	return path.Join(s.dir, keyEncoding.EncodeToString([]byte(key)))
+}
+
+// checkKey fails for keys which can't be stored.
+func checkKey(key string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	if keyEncoding.EncodedLen(len(key)) > maxNameLen {
+		return errors.Errorf("key of %v bytes is too long", len(key))
+	}
+	return nil
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (s *Store) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.pending[key]; ok {
+		if v == nil {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+
+	v, err := s.fs.ReadFile(s.keyPath(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error reading key %v", key)
+	}
+	return v, nil
+}
+
+// Put sets the value of key.
+func (s *Store) Put(key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[key] = value
+	return s.maybeFlush()
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (s *Store) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[key] = nil
+	return s.maybeFlush()
+}
+
+// GetJSON decodes the JSON value of key into v.
+func (s *Store) GetJSON(key string, v interface{}) error {
+	data, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// PutJSON sets the value of key to the JSON encoding of v.
+func (s *Store) PutJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding value of key %v", key)
+	}
+	return s.Put(key, data)
+}
+
+// List returns all keys starting with prefix, sorted.
+func (s *Store) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := map[string]bool{}
+	files, err := s.fs.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "error listing keys")
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		key, err := keyEncoding.DecodeString(fi.Name())
+		if err != nil || len(key) == 0 {
+			continue
+		}
+		keys[string(key)] = true
+	}
+	for key, v := range s.pending {
+		keys[key] = v != nil
+	}
+
+	var list []string
+	for key, ok := range keys {
+		if ok && strings.HasPrefix(key, prefix) {
+			list = append(list, key)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Flush commits and pushes all buffered changes.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+func (s *Store) maybeFlush() error {
+	if len(s.pending) < s.batchSize {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *Store) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	if err := s.fs.Apply(func(w gitfs.Writer) error {
+		for key, v := range s.pending {
+			var err error
+			if v == nil {
+				err = w.Remove(s.keyPath(key))
+			} else {
+				err = w.WriteFile(s.keyPath(key), v, 0644)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "error writing key %v", key)
+			}
+		}
+		return nil
+	}, fmt.Sprintf("kv: update %v keys", len(s.pending))); err != nil {
+		return err
+	}
+
+	s.pending = map[string][]byte{}
+	return nil
+}
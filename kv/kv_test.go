@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+)
+
+func newTestStore(t *testing.T) (*Store, *gitfs.GitFs) {
+	t.Helper()
+	fs, err := gitfs.New(context.Background(), gitfs.NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("outside.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return New(fs, "data").SetBatchSize(1), fs
+}
+
+func TestPathLikeKeysStayInDir(t *testing.T) {
+	s, fs := newTestStore(t)
+	for _, key := range []string{".", "..", "../outside.txt", "a/b", "data"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+		if v, err := s.Get(key); err != nil || string(v) != key {
+			t.Fatalf("Get(%q) = %q, %v", key, v, err)
+		}
+	}
+	for _, key := range []string{".", ".."} {
+		if err := s.Delete(key); err != nil {
+			t.Fatalf("Delete(%q): %v", key, err)
+		}
+		if _, err := s.Get(key); err != ErrNotFound {
+			t.Fatalf("Get(%q) after Delete: %v", key, err)
+		}
+	}
+	if data, err := fs.ReadFile("outside.txt"); err != nil || string(data) != "x" {
+		t.Fatalf("outside.txt is %q, %v", data, err)
+	}
+
+	keys, err := s.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"../outside.txt", "a/b", "data"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("List = %v, want %v", keys, want)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	s, _ := newTestStore(t)
+	if err := s.Put("", []byte("x")); err == nil {
+		t.Fatal("empty key put")
+	}
+	if err := s.Delete(""); err == nil {
+		t.Fatal("empty key deleted")
+	}
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 'k'
+	}
+	if err := s.Put(string(long), []byte("x")); err == nil {
+		t.Fatal("too long key put")
+	}
+}
+
+func TestBatchedChangesCommitOnFlush(t *testing.T) {
+	s, fs := newTestStore(t)
+	s.SetBatchSize(10)
+	if err := s.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("pending Get = %q, %v", v, err)
+	}
+	if _, err := fs.ReadFile(s.keyPath("a")); err == nil {
+		t.Fatal("batched put written before Flush")
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(s.keyPath("a")); err != nil || string(data) != "1" {
+		t.Fatalf("flushed put is %q, %v", data, err)
+	}
+}
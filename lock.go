@@ -0,0 +1,187 @@
+package gitfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	lockRefPrefix = "refs/gitfs/locks/"
+	lockExpiresAt = "Expires: "
+)
+
+var ErrLockHeld = errors.New("lock is held by another owner")
+
+// Lock is a lock shared through the remote repo. It is represented by a
+// ref under refs/gitfs/locks/ pointing to a commit that records its owner
+// and expiry. Ref updates are pushed without force, so the remote accepts
+// exactly one of several concurrent acquirers.
+type Lock struct {
+	g       *GitFs
+	name    string
+	ref     plumbing.ReferenceName
+	hash    plumbing.Hash
+	expires time.Time
+}
+
+// AcquireLock acquires the named lock on the remote repo for ttl. It fails
+// with ErrLockHeld if the lock is currently held by someone else and not yet
+// expired.
+func (g *GitFs) AcquireLock(name string, ttl time.Duration) (*Lock, error) {
+	l := &Lock{
+		g:    g,
+		name: name,
+		ref:  plumbing.ReferenceName(lockRefPrefix + name),
+	}
+
+	remote, err := g.git.RemoteRef(l.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []plumbing.Hash
+	if remote != nil {
+		if err := g.git.FetchRefSpecs([]config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%v:%v", l.ref, l.ref)),
+		}); err != nil {
+			return nil, errors.Wrapf(err, "error fetching lock %v", name)
+		}
+
+		c, err := g.git.repo.CommitObject(remote.Hash())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading lock %v", name)
+		}
+		if expires, err := lockExpiry(c.Message); err == nil && g.git.clock.Now().Before(expires) {
+			return nil, ErrLockHeld
+		}
+		parents = []plumbing.Hash{remote.Hash()}
+	}
+
+	if err := l.update(ttl, parents); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Name returns the name of the lock.
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Expires returns the time the lock expires unless refreshed.
+func (l *Lock) Expires() time.Time {
+	return l.expires
+}
+
+// Refresh extends the lock to expire ttl from now. It fails with
+// ErrLockHeld if the lock was taken over by someone else meanwhile.
+func (l *Lock) Refresh(ttl time.Duration) error {
+	return l.update(ttl, []plumbing.Hash{l.hash})
+}
+
+// Release releases the lock if it is still held by the caller, failing
+// with ErrLockHeld otherwise. Ref deletes can't be pushed conditionally, so
+// the lock is released by pushing it expired instead, which the remote
+// only accepts on top of the commit of the caller.
+func (l *Lock) Release() error {
+	remote, err := l.g.git.RemoteRef(l.ref)
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		return nil
+	}
+	if remote.Hash() != l.hash {
+		return ErrLockHeld
+	}
+
+	if err := l.update(0, []plumbing.Hash{l.hash}); err != nil {
+		return err
+	}
+	return l.g.git.repo.Storer.RemoveReference(l.ref)
+}
+
+func (l *Lock) update(ttl time.Duration, parents []plumbing.Hash) error {
+	now := l.g.git.clock.Now()
+	expires := now.Add(ttl)
+
+	host, _ := os.Hostname()
+	msg := fmt.Sprintf("gitfs lock %v\n\nOwner: %v/%v\n%v%v\n",
+		l.name, host, os.Getpid(), lockExpiresAt, expires.Format(time.RFC3339Nano))
+
+	hash, err := l.g.git.metaCommit(msg, parents, now)
+	if err != nil {
+		return errors.Wrapf(err, "error creating lock commit")
+	}
+
+	prev, _ := l.g.git.repo.Storer.Reference(l.ref)
+	if err := l.g.git.repo.Storer.SetReference(plumbing.NewHashReference(l.ref, hash)); err != nil {
+		return errors.Wrapf(err, "error updating lock ref")
+	}
+
+	if err := l.g.git.PushRefSpecs([]config.RefSpec{
+		config.RefSpec(fmt.Sprintf("%v:%v", l.ref, l.ref)),
+	}); err != nil {
+		// the lock ref is restored, not to point to a commit the remote
+		// rejected, which fetching the lock again fails on
+		if prev != nil {
+			l.g.git.repo.Storer.SetReference(prev)
+		} else {
+			l.g.git.repo.Storer.RemoveReference(l.ref)
+		}
+		if remote, rerr := l.g.git.RemoteRef(l.ref); rerr == nil && remote != nil && remote.Hash() != hash {
+			return ErrLockHeld
+		}
+		return errors.Wrapf(err, "error pushing lock %v", l.name)
+	}
+
+	l.hash = hash
+	l.expires = expires
+	return nil
+}
+
+func lockExpiry(msg string) (time.Time, error) {
+	s := bufio.NewScanner(strings.NewReader(msg))
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, lockExpiresAt) {
+			return time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, lockExpiresAt))
+		}
+	}
+	return time.Time{}, errors.New("lock expiry not found")
+}
+
+// metaCommit stores a commit of an empty tree, without touching the
+// worktree or HEAD.
+func (g *Git) metaCommit(msg string, parents []plumbing.Hash, when time.Time) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	treeHash, err := g.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	sig := object.Signature{
+		Name:  "gitfs",
+		Email: "gitfs@github.com",
+		When:  when,
+	}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      msg,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	return g.storeCommit(commit)
+}
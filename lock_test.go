@@ -0,0 +1,46 @@
+package gitfs
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestReleaseFailsOnceTakenOver(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	clock := NewManualClock(time.Now())
+	a := r.clone(NewConfig().UseMemFs().SetClock(clock))
+	b := r.clone(NewConfig().UseMemFs().SetClock(clock))
+
+	la, err := a.AcquireLock("deploy", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AcquireLock("deploy", time.Minute); err != ErrLockHeld {
+		t.Fatalf("got %v acquiring a held lock", err)
+	}
+	clock.Add(2 * time.Minute)
+	lb, err := b.AcquireLock("deploy", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := la.Release(); err != ErrLockHeld {
+		t.Fatalf("got %v releasing a lock taken over", err)
+	}
+	// the lock taken over between checking and releasing it
+	if err := la.update(0, []plumbing.Hash{la.hash}); err != ErrLockHeld {
+		t.Fatalf("got %v releasing a lock taken over", err)
+	}
+	if _, err := a.AcquireLock("deploy", time.Minute); err != ErrLockHeld {
+		t.Fatalf("got %v acquiring a held lock", err)
+	}
+
+	if err := lb.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AcquireLock("deploy", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
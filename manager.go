@@ -0,0 +1,246 @@
+package gitfs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Health is the sync health of a repo owned by a Manager.
+type Health struct {
+	// Time of the last sync attempt and of the last successful one
+	LastSync    time.Time
+	LastSuccess time.Time
+	// Error of the last sync attempt, nil if it succeeded
+	LastErr error
+	// Number of sync attempts failed in a row
+	Failures int
+	// If the last attempt was rate limited, SyncAll skips the repo until
+	// then, backing off exponentially
+	RetryAt time.Time
+}
+
+// Healthy reports whether the last sync attempt, if any, succeeded.
+func (h Health) Healthy() bool {
+	return h.LastErr == nil
+}
+
+type managedRepo struct {
+	fs *GitFs
+	// Serializes syncs of the repo
+	mu       sync.Mutex
+	healthMu sync.Mutex
+	health   Health
+}
+
+// Rate limited syncs are retried after this, doubled per failure in a row
+// up to maxRateLimitBackoff.
+const (
+	rateLimitBackoff    = 10 * time.Second
+	maxRateLimitBackoff = 10 * time.Minute
+)
+
+// Manager owns many GitFs keyed by name, e.g. one per tenant. Repos share
+// ssh auth, so keys are read once, and are synced by a bounded pool of
+// workers.
+type Manager struct {
+	mu       sync.RWMutex
+	repos    map[string]*managedRepo
+	workers  int
+	sshAuths *sshAuthCache
+	limiter  *RateLimiter
+}
+
+// NewManager returns a Manager syncing at most workers repos at once.
+func NewManager(workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Manager{
+		repos:    map[string]*managedRepo{},
+		workers:  workers,
+		sshAuths: newSSHAuthCache(),
+	}
+}
+
+// SetRateLimiter makes the repos added from now on share l, unless their
+// config sets its own, bounding the remote operations of all of them.
+func (m *Manager) SetRateLimiter(l *RateLimiter) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiter = l
+	return m
+}
+
+// Add creates a GitFs from c and registers it as name.
+func (m *Manager) Add(ctx context.Context, name string, c *Config) (*GitFs, error) {
+	m.mu.RLock()
+	_, ok := m.repos[name]
+	m.mu.RUnlock()
+	if ok {
+		return nil, errors.Errorf("repo %v already exists", name)
+	}
+
+	c.sshAuths = m.sshAuths
+	if c.rateLimiter == nil {
+		m.mu.RLock()
+		c.rateLimiter = m.limiter
+		m.mu.RUnlock()
+	}
+	fs, err := New(ctx, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating repo %v", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.repos[name]; ok {
+		return nil, errors.Errorf("repo %v already exists", name)
+	}
+	m.repos[name] = &managedRepo{fs: fs}
+	return fs, nil
+}
+
+// Get returns the repo registered as name.
+func (m *Manager) Get(name string) (*GitFs, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.repos[name]
+	if !ok {
+		return nil, false
+	}
+	return r.fs, true
+}
+
+// Remove unregisters the repo registered as name.
+func (m *Manager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.repos, name)
+}
+
+// Names returns the names of all repos, sorted.
+func (m *Manager) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.repos))
+	for name := range m.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sync syncs the repo registered as name, see GitFs.Sync.
+func (m *Manager) Sync(name string) error {
+	m.mu.RLock()
+	r, ok := m.repos[name]
+	m.mu.RUnlock()
+	if !ok {
+		return errors.Errorf("repo %v does not exist", name)
+	}
+	return r.sync()
+}
+
+// SyncAll syncs all repos, at most workers at once, and returns the errors
+// of the failed ones by name. Repos not yet synced when ctx is done are
+// skipped and fail with the ctx error, those rate limited until their
+// Health.RetryAt with ErrRateLimited.
+func (m *Manager) SyncAll(ctx context.Context) map[string]error {
+	m.mu.RLock()
+	repos := make(map[string]*managedRepo, len(m.repos))
+	for name, r := range m.repos {
+		repos[name] = r
+	}
+	m.mu.RUnlock()
+
+	var mu sync.Mutex
+	errs := map[string]error{}
+	fail := func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[name] = err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, m.workers)
+	for name, r := range repos {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(name, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, r *managedRepo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				fail(name, err)
+			} else if err := r.backingOff(); err != nil {
+				fail(name, err)
+			} else if err := r.sync(); err != nil {
+				fail(name, err)
+			}
+		}(name, r)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Health returns the sync health of the repo registered as name.
+func (m *Manager) Health(name string) (Health, bool) {
+	m.mu.RLock()
+	r, ok := m.repos[name]
+	m.mu.RUnlock()
+	if !ok {
+		return Health{}, false
+	}
+
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	return r.health, true
+}
+
+func (r *managedRepo) sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.fs.Sync(false)
+
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	now := time.Now()
+	r.health.LastSync = now
+	r.health.LastErr = err
+	r.health.RetryAt = time.Time{}
+	if err != nil {
+		r.health.Failures++
+		if errors.Cause(err) == ErrRateLimited {
+			backoff := maxRateLimitBackoff
+			if r.health.Failures <= 6 {
+				backoff = rateLimitBackoff << uint(r.health.Failures-1)
+			}
+			r.health.RetryAt = now.Add(backoff)
+		}
+	} else {
+		r.health.LastSuccess = now
+		r.health.Failures = 0
+	}
+	return err
+}
+
+// backingOff returns ErrRateLimited if the repo is to be retried later.
+func (r *managedRepo) backingOff() error {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	if wait := time.Until(r.health.RetryAt); wait > 0 {
+		return errors.Wrapf(ErrRateLimited, "retrying in %v", wait.Round(time.Second))
+	}
+	return nil
+}
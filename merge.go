@@ -0,0 +1,227 @@
+package gitfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+)
+
+// MergeStrategy decides how Merge resolves files changed on both branches.
+type MergeStrategy int
+
+const (
+	// MergeFail fails with ErrConflict, merging nothing
+	MergeFail MergeStrategy = iota
+	// MergeOurs keeps the files of the current branch
+	MergeOurs
+	// MergeTheirs takes the files of the merged branch
+	MergeTheirs
+	// MergeContent merges the content of files, by the driver for their
+	// extension, see Config.SetMergeDriver, failing with ErrConflict if
+	// any conflict is left
+	MergeContent
+)
+
+// Merge merges branch, a revision like "dev", "origin/dev" or a hash, into
+// the current branch, then pushes it like Apply, e.g. to promote changes
+// staged on a branch once approved. If the current branch is behind, it is
+// fast forwarded, otherwise a merge commit is created. Files are compared
+// whole, files changed on both branches are resolved by strategy. Files
+// with changes not yet synced can't be merged, Merge fails with
+// ErrConflict if it would touch them. Commits merged in are verified
+// against the trust policy like those of Pull.
+func (g *GitFs) Merge(branch string, strategy MergeStrategy) (err error) {
+	defer g.git.trace("gitfs.Merge")(&err)
+
+	if strategy < MergeFail || strategy > MergeContent {
+		return errors.Errorf("unknown merge strategy %v", strategy)
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	theirs, err := g.git.resolveCommit(branch)
+	if err != nil {
+		return err
+	}
+	if err := g.git.verifyIncoming(theirs); err != nil {
+		return err
+	}
+	theirFiles, err := commitFiles(theirs)
+	if err != nil {
+		return err
+	}
+	ourFiles, err := g.git.headFiles()
+	if err != nil {
+		return err
+	}
+	s, err := g.git.status()
+	if err != nil {
+		return errors.Wrapf(err, "error getting status")
+	}
+	name := plumbing.NewBranchReferenceName(g.git.branchName())
+
+	head := g.git.headHash()
+	ff := head.IsZero()
+	if !ff {
+		ours, err := g.git.repo.CommitObject(head)
+		if err != nil {
+			return errors.Wrapf(err, "error reading HEAD commit")
+		}
+		if merged, err := theirs.IsAncestor(ours); err != nil {
+			return errors.Wrapf(err, "error walking history")
+		} else if merged {
+			return nil
+		}
+		if ff, err = ours.IsAncestor(theirs); err != nil {
+			return errors.Wrapf(err, "error walking history")
+		}
+	}
+	if ff {
+		if err := unsynced("merge", branch, s, changedFiles(ourFiles, theirFiles)); err != nil {
+			return err
+		}
+		if err := g.git.fastForward(name, theirs.Hash); err != nil {
+			return err
+		}
+		return g.pushMerge()
+	}
+
+	baseFiles, err := g.git.mergeBaseFiles(head, theirs.Hash)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	// non nil, so only the merged paths are committed, even if none
+	paths := []string{}
+	changed := map[string]bool{}
+	merged := map[string][]byte{}
+	for p := range changedFiles(baseFiles, theirFiles) {
+		switch {
+		case ourFiles[p] == theirFiles[p]:
+			// changed alike on both
+		case ourFiles[p] == baseFiles[p] || strategy == MergeTheirs:
+			paths = append(paths, p)
+			changed[p] = true
+		case strategy == MergeContent:
+			data, ok, err := g.git.mergeContent(g.mergeDrivers, p, baseFiles[p], ourFiles[p], theirFiles[p])
+			if err != nil {
+				return err
+			} else if !ok {
+				conflicts = append(conflicts, p)
+				continue
+			}
+			merged[p] = data
+			paths = append(paths, p)
+			changed[p] = true
+		case strategy == MergeFail:
+			conflicts = append(conflicts, p)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return errors.Wrapf(ErrConflict, "merge of %v at %v", branch, strings.Join(conflicts, ", "))
+	}
+	if err := unsynced("merge", branch, s, changed); err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if data, ok := merged[p]; ok {
+			err = g.git.writeEntry(p, data, ourFiles[p].mode)
+		} else {
+			err = g.git.checkoutEntry(p, theirFiles[p])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(paths) > 0 {
+		if err := g.git.Stage(paths); err != nil {
+			return errors.Wrapf(err, "error adding files to git")
+		}
+	}
+	msg := fmt.Sprintf("Merge %v into %v", branch, g.git.branchName())
+	if err := g.git.commitPaths(g.git.ctx, msg, false, paths, []plumbing.Hash{theirs.Hash}); err != nil {
+		return errors.Wrapf(err, "error committing merge")
+	}
+	return g.pushMerge()
+}
+
+func (g *GitFs) pushMerge() error {
+	if !g.pushes() {
+		return nil
+	}
+	if _, err := g.push(g.git.ctx); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "error pushing change to remote repo")
+	}
+	return nil
+}
+
+// mergeBaseFiles returns the files of the best common ancestor of commits
+// a and b, none if they have no common history.
+func (g *Git) mergeBaseFiles(a, b plumbing.Hash) (map[string]bareEntry, error) {
+	ca, err := g.repo.CommitObject(a)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading commit %v", a)
+	}
+	cb, err := g.repo.CommitObject(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading commit %v", b)
+	}
+	bases, err := ca.MergeBase(cb)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding merge base of %v and %v", a, b)
+	}
+	if len(bases) == 0 {
+		return map[string]bareEntry{}, nil
+	}
+	return commitFiles(bases[0])
+}
+
+// unsynced fails with ErrConflict if any of paths has changes not yet
+// synced, which op of revision would overwrite.
+func unsynced(op, revision string, s git.Status, paths map[string]bool) error {
+	var dirty []string
+	for p := range paths {
+		if fstatus, ok := s[p]; ok && (fstatus.Worktree != git.Unmodified || fstatus.Staging != git.Unmodified) {
+			dirty = append(dirty, p)
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	sort.Strings(dirty)
+	return errors.Wrapf(ErrConflict, "%v of %v over changes not synced at %v", op, revision, strings.Join(dirty, ", "))
+}
+
+// mergeContent merges the content of file p changed from base to ours and
+// theirs, ok only if no conflict is left. Deleted, binary and symlinked
+// files, or files with different modes, can't be merged.
+func (g *Git) mergeContent(drivers map[string]MergeDriver, p string, base, ours, theirs bareEntry) (data []byte, ok bool, err error) {
+	if ours.hash.IsZero() || theirs.hash.IsZero() || ours.mode != theirs.mode || ours.mode == filemode.Symlink {
+		return nil, false, nil
+	}
+	var versions [3][]byte
+	for i, e := range []bareEntry{base, ours, theirs} {
+		if e.hash.IsZero() {
+			continue
+		}
+		if versions[i], err = g.readBlob(e.hash); err != nil {
+			return nil, false, err
+		}
+		if looksBinary(versions[i]) {
+			return nil, false, nil
+		}
+	}
+	data, conflicts := mergeFile(drivers, p, versions[0], versions[1], versions[2])
+	return data, len(conflicts) == 0, nil
+}
@@ -0,0 +1,354 @@
+package gitfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+	"gopkg.in/yaml.v2"
+)
+
+// Conflict is a part of a file changed differently on both sides of a
+// three-way merge.
+type Conflict struct {
+	// Line of the merged file its conflict markers start at, 1 based, for
+	// line merges
+	Line int
+	// Path of the conflicting value, e.g. "spec.replicas", for JSON and
+	// YAML merges
+	Key string
+	// The part in each version, empty if missing
+	Base, Ours, Theirs string
+}
+
+// MergeDriver merges ours and theirs, two versions of a file changed from
+// base, returning the merged file and the conflicts left in it. An error
+// means the driver can't merge the versions at all, e.g. as they don't
+// parse.
+type MergeDriver func(base, ours, theirs []byte) ([]byte, []Conflict, error)
+
+// defaultMergeDrivers merge files by extension unless set by
+// Config.SetMergeDriver, Merge3 merges others.
+var defaultMergeDrivers = map[string]MergeDriver{
+	".json": MergeJSON,
+	".yaml": MergeYAML,
+	".yml":  MergeYAML,
+}
+
+// mergeDriver returns the driver of filename, drivers overriding the
+// default ones.
+func mergeDriver(drivers map[string]MergeDriver, filename string) MergeDriver {
+	ext := strings.ToLower(path.Ext(filename))
+	if d, ok := drivers[ext]; ok {
+		return d
+	}
+	if d, ok := defaultMergeDrivers[ext]; ok {
+		return d
+	}
+	return Merge3
+}
+
+// mergeFile merges the versions of filename with its driver, falling back
+// to a line merge if the driver can't merge them.
+func mergeFile(drivers map[string]MergeDriver, filename string, base, ours, theirs []byte) ([]byte, []Conflict) {
+	if merged, conflicts, err := mergeDriver(drivers, filename)(base, ours, theirs); err == nil {
+		return merged, conflicts
+	}
+	merged, conflicts, _ := Merge3(base, ours, theirs)
+	return merged, conflicts
+}
+
+// Merge3 merges ours and theirs line by line like git, taking the lines
+// changed on one side only. Lines changed differently on both sides are
+// kept between conflict markers, ours first:
+//
+//	<<<<<<< ours
+//	our lines
+//	=======
+//	their lines
+//	>>>>>>> theirs
+func Merge3(base, ours, theirs []byte) ([]byte, []Conflict, error) {
+	b, o, t := splitLines(string(base)), splitLines(string(ours)), splitLines(string(theirs))
+	mo, mt := matchLines(b, o), matchLines(b, t)
+
+	var out bytes.Buffer
+	var conflicts []Conflict
+	line := 1
+	emit := func(lines []string) {
+		for _, l := range lines {
+			out.WriteString(l)
+			line++
+		}
+	}
+	i, a, c := 0, 0, 0
+	for i < len(b) || a < len(o) || c < len(t) {
+		// next base line kept on both sides
+		k := i
+		for k < len(b) && (mo[k] < 0 || mt[k] < 0) {
+			k++
+		}
+		if k < len(b) && k == i && mo[k] == a && mt[k] == c {
+			emit(b[i : i+1])
+			i, a, c = i+1, a+1, c+1
+			continue
+		}
+		ea, ec := len(o), len(t)
+		if k < len(b) {
+			ea, ec = mo[k], mt[k]
+		}
+		bc, oc, tc := b[i:k], o[a:ea], t[c:ec]
+		switch {
+		case equalLines(oc, bc):
+			emit(tc)
+		case equalLines(tc, bc), equalLines(oc, tc):
+			emit(oc)
+		default:
+			conflicts = append(conflicts, Conflict{
+				Line:   line,
+				Base:   strings.Join(bc, ""),
+				Ours:   strings.Join(oc, ""),
+				Theirs: strings.Join(tc, ""),
+			})
+			emit([]string{"<<<<<<< ours\n"})
+			emit(terminated(oc))
+			emit([]string{"=======\n"})
+			emit(terminated(tc))
+			emit([]string{">>>>>>> theirs\n"})
+		}
+		i, a, c = k, ea, ec
+	}
+	return out.Bytes(), conflicts, nil
+}
+
+// splitLines splits s after each newline, the last line may lack one.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		n := strings.IndexByte(s, '\n') + 1
+		if n == 0 {
+			n = len(s)
+		}
+		lines = append(lines, s[:n])
+		s = s[n:]
+	}
+	return lines
+}
+
+// matchLines returns for each line of base its index in other, -1 if not
+// kept there, by their longest common subsequence.
+func matchLines(base, other []string) []int {
+	m := make([]int, len(base))
+	i, j := 0, 0
+	for _, d := range diff.Do(strings.Join(base, ""), strings.Join(other, "")) {
+		n := len(splitLines(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for k := 0; k < n; k++ {
+				m[i+k] = j + k
+			}
+			i, j = i+n, j+n
+		case diffmatchpatch.DiffDelete:
+			for k := 0; k < n; k++ {
+				m[i+k] = -1
+			}
+			i += n
+		case diffmatchpatch.DiffInsert:
+			j += n
+		}
+	}
+	return m
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// terminated returns lines with a newline after the last one.
+func terminated(lines []string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines = append(append([]string{}, lines[:n-1]...), lines[n-1]+"\n")
+	}
+	return lines
+}
+
+// MergeJSON deep merges JSON documents: objects are merged key by key,
+// other values changed on both sides conflict, keeping ours. The merged
+// document is indented by two spaces, with keys sorted.
+func MergeJSON(base, ours, theirs []byte) ([]byte, []Conflict, error) {
+	var docs [3]interface{}
+	for i, data := range [][]byte{base, ours, theirs} {
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&docs[i]); err != nil {
+			return nil, nil, errors.Wrapf(err, "error parsing JSON")
+		}
+	}
+
+	var conflicts []Conflict
+	merged := mergeValues("", docs[0], docs[1], docs[2], &conflicts, func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	})
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error encoding JSON")
+	}
+	return append(data, '\n'), conflicts, nil
+}
+
+// MergeYAML merges YAML files like MergeJSON merges JSON ones, maps key by
+// key. The documents of multi-document files are merged pairwise, the keys
+// of their conflicts prefixed by the document index, e.g. "1.spec.replicas",
+// and files with different numbers of documents can't be merged. Comments
+// are dropped and keys sorted.
+func MergeYAML(base, ours, theirs []byte) ([]byte, []Conflict, error) {
+	var docs [3][]interface{}
+	for i, data := range [][]byte{base, ours, theirs} {
+		var err error
+		if docs[i], err = decodeYAML(data); err != nil {
+			return nil, nil, errors.Wrapf(err, "error parsing YAML")
+		}
+	}
+	n := len(docs[1])
+	if len(docs[2]) != n {
+		return nil, nil, errors.Errorf("ours has %v YAML documents, theirs %v", n, len(docs[2]))
+	}
+	if len(docs[0]) != n {
+		if len(docs[0]) > 1 || docs[0][0] != nil {
+			return nil, nil, errors.Errorf("base has %v YAML documents, ours and theirs %v", len(docs[0]), n)
+		}
+		// Base is empty, as if missing
+		docs[0] = make([]interface{}, n)
+	}
+
+	var conflicts []Conflict
+	var merged bytes.Buffer
+	for i := 0; i < n; i++ {
+		key := ""
+		if n > 1 {
+			key = fmt.Sprint(i)
+		}
+		doc := mergeValues(key, docs[0][i], docs[1][i], docs[2][i], &conflicts, func(v interface{}) string {
+			data, _ := yaml.Marshal(v)
+			return string(data)
+		})
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error encoding YAML")
+		}
+		if i > 0 {
+			merged.WriteString("---\n")
+		}
+		merged.Write(data)
+	}
+	return merged.Bytes(), conflicts, nil
+}
+
+// decodeYAML returns the documents of data, a single nil one if there are
+// none.
+func decodeYAML(data []byte) ([]interface{}, error) {
+	var docs []interface{}
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := d.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		docs = []interface{}{nil}
+	}
+	return docs, nil
+}
+
+// missingValue stands for a key missing from a map.
+type missingValue struct{}
+
+// mergeValues merges values ours and theirs changed from base at key, maps
+// key by key, adding conflicts, formatted by format, to conflicts.
+func mergeValues(key string, base, ours, theirs interface{}, conflicts *[]Conflict, format func(interface{}) string) interface{} {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	}
+
+	o, t := reflect.ValueOf(ours), reflect.ValueOf(theirs)
+	if o.Kind() == reflect.Map && t.Kind() == reflect.Map && o.Type() == t.Type() {
+		b := reflect.ValueOf(base)
+		if b.Kind() != reflect.Map || b.Type() != o.Type() {
+			b = reflect.MakeMap(o.Type())
+		}
+		merged := reflect.MakeMap(o.Type())
+		seen := map[interface{}]bool{}
+		var keys []reflect.Value
+		for _, m := range []reflect.Value{b, o, t} {
+			for _, k := range m.MapKeys() {
+				if !seen[k.Interface()] {
+					seen[k.Interface()] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			v := mergeValues(joinKey(key, k.Interface()), mapValue(b, k), mapValue(o, k), mapValue(t, k), conflicts, format)
+			if _, ok := v.(missingValue); !ok {
+				merged.SetMapIndex(k, reflect.ValueOf(&v).Elem())
+			}
+		}
+		return merged.Interface()
+	}
+
+	c := Conflict{Key: key}
+	for _, s := range []struct {
+		v interface{}
+		f *string
+	}{{base, &c.Base}, {ours, &c.Ours}, {theirs, &c.Theirs}} {
+		if _, ok := s.v.(missingValue); !ok && s.v != nil {
+			*s.f = format(s.v)
+		}
+	}
+	*conflicts = append(*conflicts, c)
+	return ours
+}
+
+func mapValue(m, k reflect.Value) interface{} {
+	v := m.MapIndex(k)
+	if !v.IsValid() {
+		return missingValue{}
+	}
+	return v.Interface()
+}
+
+func joinKey(prefix string, k interface{}) string {
+	if prefix == "" {
+		return fmt.Sprint(k)
+	}
+	return fmt.Sprintf("%v.%v", prefix, k)
+}
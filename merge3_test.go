@@ -0,0 +1,55 @@
+package gitfs
+
+import (
+	"testing"
+)
+
+func TestMergeYAMLMultiDocument(t *testing.T) {
+	base := "a: 1\n---\nb: 1\n"
+	ours := "a: 2\n---\nb: 1\n"
+	theirs := "a: 1\n---\nb: 2\n"
+	merged, conflicts, err := MergeYAML([]byte(base), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("got conflicts %+v", conflicts)
+	}
+	if want := "a: 2\n---\nb: 2\n"; string(merged) != want {
+		t.Fatalf("got %q, want %q", merged, want)
+	}
+
+	_, conflicts, err = MergeYAML([]byte(base), []byte("a: 1\n---\nb: 3\n"), []byte(theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Key != "1.b" {
+		t.Fatalf("got conflicts %+v", conflicts)
+	}
+}
+
+func TestMergeYAMLDocumentCountMismatch(t *testing.T) {
+	if _, _, err := MergeYAML([]byte("a: 1\n"), []byte("a: 1\n---\nb: 1\n"), []byte("a: 2\n")); err == nil {
+		t.Fatal("merged files with different numbers of documents")
+	}
+
+	// The line merge takes over
+	base := "a: 1\nc: 1\n"
+	merged, conflicts := mergeFile(nil, "x.yaml", []byte(base), []byte(base+"---\nb: 1\n"), []byte("a: 2\nc: 1\n"))
+	if len(conflicts) != 0 {
+		t.Fatalf("got conflicts %+v", conflicts)
+	}
+	if want := "a: 2\nc: 1\n---\nb: 1\n"; string(merged) != want {
+		t.Fatalf("got %q, want %q", merged, want)
+	}
+}
+
+func TestMergeYAMLEmptyBase(t *testing.T) {
+	merged, conflicts, err := MergeYAML(nil, []byte("a: 1\n"), []byte("b: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 || string(merged) != "a: 1\nb: 1\n" {
+		t.Fatalf("got %q, %+v", merged, conflicts)
+	}
+}
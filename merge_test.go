@@ -0,0 +1,67 @@
+package gitfs
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestMergeCommitsOnlyMergedFiles(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "base.txt", "base")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	base := g.git.headHash()
+	writeTestFile(t, g, "dev.txt", "dev")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	dev := plumbing.NewHashReference(plumbing.NewBranchReferenceName("dev"), g.git.headHash())
+	if err := g.git.repo.Storer.SetReference(dev); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.git.wt.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "master.txt", "master")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, g, "other.txt", "other")
+	if err := g.git.Stage([]string{"other.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Merge("dev", MergeFail); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := g.git.repo.CommitObject(g.git.headHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.NumParents() != 2 {
+		t.Fatalf("got %v parents", c.NumParents())
+	}
+	files, err := g.git.headFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"base.txt", "dev.txt", "master.txt"} {
+		if _, ok := files[p]; !ok {
+			t.Fatalf("%v not in the merge commit", p)
+		}
+	}
+	if _, ok := files["other.txt"]; ok {
+		t.Fatal("other.txt committed by Merge")
+	}
+	if data := readTestFile(t, g, "other.txt"); data != "other" {
+		t.Fatalf("other.txt changed to %q", data)
+	}
+}
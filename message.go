@@ -0,0 +1,135 @@
+package gitfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultCommitTemplate renders the message of syncs unless set by
+// Config.SetCommitMessageTemplate.
+const defaultCommitTemplate = `{{if .Type}}{{.Type}}: {{end}}gitfs sync - {{.Time.Format "2006-01-02T15:04:05Z07:00"}}`
+
+// CommitMessage holds the variables of commit message templates, see
+// Config.SetCommitMessageTemplate.
+type CommitMessage struct {
+	Time   time.Time
+	Host   string
+	Branch string
+	// Paths of the changed files, sorted
+	ChangedFiles []string
+	// Counts of changes, e.g. "2 added, 1 modified", empty if none
+	Summary string
+	// Conventional commit type of the changes, e.g. "docs", empty if no
+	// type of Config.AddCommitType applies
+	Type string
+}
+
+// commitType is the conventional commit type of changes under glob.
+type commitType struct {
+	glob string
+	typ  string
+}
+
+// commitMessage renders the messages of syncs.
+type commitMessage struct {
+	tmpl  *template.Template
+	types []commitType
+	// If bodies list the changes, see summaryBody
+	summary bool
+	// If the host is hidden, see Config.Deterministic
+	deterministic bool
+}
+
+func parseCommitTemplate(text string) (*template.Template, error) {
+	t, err := template.New("commit").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid commit message template")
+	}
+	return t, nil
+}
+
+// newCommitMessage returns the renderer of the template, types and summary
+// of c, nil if c sets none, so syncs skip collecting changes.
+func newCommitMessage(c *Config) (*commitMessage, error) {
+	if c.commitTemplate == "" && len(c.commitTypes) == 0 && !c.commitSummary {
+		return nil, nil
+	}
+	text := c.commitTemplate
+	if text == "" {
+		text = defaultCommitTemplate
+	}
+	tmpl, err := parseCommitTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	return &commitMessage{tmpl: tmpl, types: c.commitTypes, summary: c.commitSummary, deterministic: c.deterministic}, nil
+}
+
+// render returns the message of a commit of changes to branch.
+func (m *commitMessage) render(now time.Time, branch string, changes []Change) (string, error) {
+	if m == nil {
+		return fmt.Sprintf("gitfs sync - %v", now.Format("2006-01-02T15:04:05Z07:00")), nil
+	}
+
+	host := "gitfs"
+	if !m.deterministic {
+		host, _ = os.Hostname()
+	}
+	msg := CommitMessage{
+		Time:    now,
+		Host:    host,
+		Branch:  branch,
+		Summary: changeSummary(changes),
+		Type:    m.changeType(changes),
+	}
+	for _, c := range changes {
+		msg.ChangedFiles = append(msg.ChangedFiles, c.Path)
+	}
+
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, msg); err != nil {
+		return "", errors.Wrapf(err, "error rendering commit message")
+	}
+	return buf.String(), nil
+}
+
+// changeType returns the type of the first rule all changes are under.
+func (m *commitMessage) changeType(changes []Change) string {
+	if len(changes) == 0 {
+		return ""
+	}
+next:
+	for _, t := range m.types {
+		for _, c := range changes {
+			if !writablePath([]string{t.glob}, c.Path) {
+				continue next
+			}
+		}
+		return t.typ
+	}
+	return ""
+}
+
+// changeSummary counts changes by status, e.g. "2 added, 1 modified".
+func changeSummary(changes []Change) string {
+	counts := map[StatusCode]int{}
+	for _, c := range changes {
+		counts[c.Status]++
+	}
+	var parts []string
+	for _, s := range []struct {
+		code StatusCode
+		verb string
+	}{{Added, "added"}, {Modified, "modified"}, {Renamed, "renamed"}, {Copied, "copied"}, {Deleted, "deleted"}} {
+		if n := counts[s.code]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%v %v", n, s.verb))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,90 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+var ErrNotSupported = errors.New("operation not supported by the backing filesystem")
+
+// osPath returns the OS path of filename if fs is backed by the OS file
+// system.
+func osPath(fs billy.Filesystem, filename string) (string, bool) {
+	ch, ok := fs.(*chroot.ChrootHelper)
+	if !ok {
+		return "", false
+	}
+
+	type underlying interface {
+		Underlying() billy.Basic
+	}
+	var u billy.Basic = ch
+	for {
+		w, ok := u.(underlying)
+		if !ok {
+			break
+		}
+		u = w.Underlying()
+	}
+	if _, ok := u.(*osfs.OS); !ok {
+		return "", false
+	}
+	return filepath.Join(fs.Root(), filepath.Clean(string(filepath.Separator)+filename)), true
+}
+
+// Chmod changes the mode of the named file to mode. Git only records the
+// executable bit, a file with any executable bit set is committed with
+// mode 100755.
+func (g *GitFs) Chmod(filename string, mode os.FileMode) error {
+	if err := g.checkWritable("chmod", filename); err != nil {
+		return err
+	}
+
+	if ch, ok := g.fs.(billy.Change); ok {
+		return ch.Chmod(filename, mode)
+	}
+	if path, ok := osPath(g.fs, filename); ok {
+		return os.Chmod(path, mode)
+	}
+
+	// memfs fixes the mode on creation, so recreate the file
+	fi, err := g.fs.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+		// git doesn't record either mode
+		return nil
+	}
+	if fi.Mode().Perm() == mode.Perm() {
+		return nil
+	}
+
+	data, err := readFile(g.fs, filename)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v", filename)
+	}
+	return g.writeFileAtomic(filename, data, mode.Perm())
+}
+
+// Chtimes changes the access and modification times of the named file. It
+// fails with ErrNotSupported on memFs, which keeps no times.
+func (g *GitFs) Chtimes(filename string, atime time.Time, mtime time.Time) error {
+	if err := g.checkWritable("chtimes", filename); err != nil {
+		return err
+	}
+
+	if ch, ok := g.fs.(billy.Change); ok {
+		return ch.Chtimes(filename, atime, mtime)
+	}
+	if path, ok := osPath(g.fs, filename); ok {
+		return os.Chtimes(path, atime, mtime)
+	}
+	return ErrNotSupported
+}
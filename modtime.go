@@ -0,0 +1,154 @@
+package gitfs
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// modTimeCache caches the time of the last commit touching each entry of a
+// directory, per directory of the HEAD it was computed for.
+type modTimeCache struct {
+	mu   sync.Mutex
+	head plumbing.Hash
+	dirs map[string]map[string]time.Time
+}
+
+func newModTimeCache() *modTimeCache {
+	return &modTimeCache{dirs: map[string]map[string]time.Time{}}
+}
+
+// commitFileInfo is a FileInfo with the mod time of a commit.
+type commitFileInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (fi *commitFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+// dirModTimes returns the commit times of the entries of dir, a slash
+// separated path relative to the repo root, as of HEAD.
+func (g *Git) dirModTimes(dir string) (map[string]time.Time, error) {
+	c := g.modTimes
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	head := g.headHash()
+	if head != c.head {
+		c.head = head
+		c.dirs = map[string]map[string]time.Time{}
+	}
+	if times, ok := c.dirs[dir]; ok {
+		return times, nil
+	}
+
+	times := map[string]time.Time{}
+	if !head.IsZero() {
+		commit, err := g.repo.CommitObject(head)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading HEAD commit")
+		}
+		if err := lastChanges(commit, dir, times); err != nil {
+			return nil, err
+		}
+	}
+	c.dirs[dir] = times
+	return times, nil
+}
+
+// lastChanges sets times to the committer time of the last commit changing
+// each entry of dir, following first parents from c.
+func lastChanges(c *object.Commit, dir string, times map[string]time.Time) error {
+	pending, err := dirEntries(c, dir)
+	if err != nil {
+		return err
+	}
+	for len(pending) > 0 {
+		var parent *object.Commit
+		parentEntries := map[string]plumbing.Hash{}
+		if c.NumParents() > 0 {
+			if parent, err = c.Parent(0); err != nil {
+				return errors.Wrapf(err, "error walking history")
+			}
+			if parentEntries, err = dirEntries(parent, dir); err != nil {
+				return err
+			}
+		}
+		for name, h := range pending {
+			if parentEntries[name] != h {
+				times[name] = c.Committer.When
+				delete(pending, name)
+			}
+		}
+		if parent == nil {
+			break
+		}
+		c = parent
+	}
+	return nil
+}
+
+// dirEntries returns the hashes of the entries of dir in the tree of c,
+// none if c has no such dir.
+func dirEntries(c *object.Commit, dir string) (map[string]plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading tree of %v", c.Hash)
+	}
+	if dir != "" {
+		if tree, err = tree.Tree(dir); err == object.ErrDirectoryNotFound {
+			return map[string]plumbing.Hash{}, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v of %v", dir, c.Hash)
+		}
+	}
+	entries := map[string]plumbing.Hash{}
+	for _, e := range tree.Entries {
+		entries[e.Name] = e.Hash
+	}
+	return entries, nil
+}
+
+// withModTime returns fi of filename with the commit mod time if enabled
+// and known. Files not committed yet keep their mod time.
+func (g *GitFs) withModTime(filename string, fi os.FileInfo) (os.FileInfo, error) {
+	if g.git == nil || g.git.modTimes == nil {
+		return fi, nil
+	}
+	p := g.repoPath(filename)
+	if p == "" {
+		return fi, nil
+	}
+	times, err := g.git.dirModTimes(path.Dir("/" + p)[1:])
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := times[path.Base(p)]; ok {
+		return &commitFileInfo{FileInfo: fi, modTime: t}, nil
+	}
+	return fi, nil
+}
+
+// withModTimes is like withModTime for the entries fis of dir.
+func (g *GitFs) withModTimes(dir string, fis []os.FileInfo) ([]os.FileInfo, error) {
+	if g.git == nil || g.git.modTimes == nil {
+		return fis, nil
+	}
+	times, err := g.git.dirModTimes(g.repoPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range fis {
+		if t, ok := times[fi.Name()]; ok {
+			fis[i] = &commitFileInfo{FileInfo: fi, modTime: t}
+		}
+	}
+	return fis, nil
+}
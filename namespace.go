@@ -0,0 +1,35 @@
+package gitfs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Namespace returns the logical store name kept in the dir name of the repo
+// of g, e.g. "tenants/acme" for multi-tenant services storing each tenant
+// in a dir of one repo. The store is a GitFs scoped to the dir, see
+// GitFs.Scope: it sees only its own files, and its syncs, status and
+// AutoSync cover only its own changes, so each namespace is synced on its
+// own schedule, e.g. by an AutoSync per namespace.
+func Namespace(g *GitFs, name string) (*GitFs, error) {
+	if err := validNamespace(name); err != nil {
+		return nil, err
+	}
+	return g.top().Scope(name)
+}
+
+// validNamespace checks that name is a dir within the repo, in the clean
+// slash separated form namespaces are known by.
+func validNamespace(name string) error {
+	switch {
+	case name == "" || name == ".":
+		return errors.New("empty namespace")
+	case path.Clean(name) != name || strings.HasPrefix(name, "/") || strings.Contains(name, `\`):
+		return errors.Errorf("namespace %v is not a clean relative path", name)
+	case name == ".." || strings.HasPrefix(name, "../"):
+		return errors.Errorf("namespace %v is outside the repo", name)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package gitfs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// Option configures a GitFs created by NewWithOptions.
+type Option func(c *Config)
+
+// NewWithOptions creates a GitFs of the repo at url like New, configured by
+// opts rather than a Config. An empty url means the repo has no remote.
+// The backend defaults to memFs, backend options replace one another, so
+// the last one given wins.
+func NewWithOptions(ctx context.Context, url string, opts ...Option) (*GitFs, error) {
+	c := NewConfig().UseMemFs()
+	if url == "" {
+		c.NoRemote()
+	} else {
+		c.SetUrl(url)
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return New(ctx, c)
+}
+
+// WithMemFS backs the filesystem by memory, see Config.UseMemFs.
+func WithMemFS() Option {
+	return func(c *Config) { c.UseMemFs() }
+}
+
+// WithHybridFS backs the filesystem by memory up to memBudget bytes, see
+// Config.UseHybridFs.
+func WithHybridFS(memBudget int64) Option {
+	return func(c *Config) { c.UseHybridFs(memBudget) }
+}
+
+// WithOsFS backs the filesystem by baseDir, see Config.UseOsFs.
+func WithOsFS(baseDir string, openExisting bool) Option {
+	return func(c *Config) { c.UseOsFs(baseDir, openExisting) }
+}
+
+// WithWorktreeFS backs the worktree by fs, see Config.SetWorktreeFS.
+func WithWorktreeFS(fs billy.Filesystem, openExisting bool) Option {
+	return func(c *Config) { c.SetWorktreeFS(fs, openExisting) }
+}
+
+// WithStorer stores git objects and refs in s, see Config.SetStorer.
+func WithStorer(s storage.Storer) Option {
+	return func(c *Config) { c.SetStorer(s) }
+}
+
+// WithBranch clones and syncs branch instead of master.
+func WithBranch(branch string) Option {
+	return func(c *Config) { c.branch = branch }
+}
+
+// WithOrphanBranch syncs branch, created without history if the remote
+// lacks it, see Config.SetOrphanBranch.
+func WithOrphanBranch(branch string) Option {
+	return func(c *Config) { c.SetOrphanBranch(branch) }
+}
+
+// WithBare opens a bare repo, read and written through the tree of the
+// branch set by WithBranch, see Config.Bare.
+func WithBare() Option {
+	return func(c *Config) { c.bare = true }
+}
+
+// WithBasicAuth sets the credentials of https remotes.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Config) { c.SetBasicAuth(user, password) }
+}
+
+// WithCredentialHelper takes https credentials from the credential
+// helpers of git, see Config.UseCredentialHelper.
+func WithCredentialHelper() Option {
+	return func(c *Config) { c.UseCredentialHelper() }
+}
+
+// WithTokenSource authenticates to https remotes with tokens of ts, see
+// Config.SetTokenSource.
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Config) { c.SetTokenSource(ts) }
+}
+
+// WithAuthProvider takes the auth method from p before every transport
+// operation, see Config.SetAuthProvider.
+func WithAuthProvider(p AuthProvider) Option {
+	return func(c *Config) { c.SetAuthProvider(p) }
+}
+
+// WithRateLimiter makes remote operations wait for l to admit them, see
+// Config.SetRateLimiter.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(c *Config) { c.SetRateLimiter(l) }
+}
+
+// WithBandwidthLimit throttles transfers with the remote host to
+// bytesPerSec, see Config.SetBandwidthLimit.
+func WithBandwidthLimit(bytesPerSec int64) Option {
+	return func(c *Config) { c.SetBandwidthLimit(bytesPerSec) }
+}
+
+// WithCommitMessageTemplate renders sync commit messages with tmpl, see
+// Config.SetCommitMessageTemplate.
+func WithCommitMessageTemplate(tmpl string) Option {
+	return func(c *Config) { c.SetCommitMessageTemplate(tmpl) }
+}
+
+// WithCommitType sets the conventional commit type of syncs changing only
+// paths under glob, see Config.AddCommitType.
+func WithCommitType(glob, typ string) Option {
+	return func(c *Config) { c.AddCommitType(glob, typ) }
+}
+
+// WithCommitSummary lists the changes in the bodies of sync commits, see
+// Config.EnableCommitSummary.
+func WithCommitSummary() Option {
+	return func(c *Config) { c.EnableCommitSummary() }
+}
+
+// WithPreSyncCommand runs cmd before syncs stage changes, see
+// Config.SetPreSyncCommand.
+func WithPreSyncCommand(cmd SyncCommand) Option {
+	return func(c *Config) { c.SetPreSyncCommand(cmd) }
+}
+
+// WithPostSyncCommand runs cmd after syncs created a commit, see
+// Config.SetPostSyncCommand.
+func WithPostSyncCommand(cmd SyncCommand) Option {
+	return func(c *Config) { c.SetPostSyncCommand(cmd) }
+}
+
+// WithGitAttributes converts line endings as .gitattributes says, see
+// Config.UseGitAttributes.
+func WithGitAttributes() Option {
+	return func(c *Config) { c.UseGitAttributes() }
+}
+
+// WithAutoCRLF converts the line endings of text files like core.autocrlf,
+// see Config.SetAutoCRLF.
+func WithAutoCRLF(mode AutoCRLF) Option {
+	return func(c *Config) { c.SetAutoCRLF(mode) }
+}
+
+// WithLargeBinaryWarning reports new binary files above size bytes, see
+// Config.WarnLargeBinaries.
+func WithLargeBinaryWarning(size int64) Option {
+	return func(c *Config) { c.WarnLargeBinaries(size) }
+}
+
+// WithLargeBinaryRefusal refuses to sync new binary files above size
+// bytes, see Config.RefuseLargeBinaries.
+func WithLargeBinaryRefusal(size int64) Option {
+	return func(c *Config) { c.RefuseLargeBinaries(size) }
+}
+
+// WithPrefetch reads hot files ahead, see Config.Prefetch.
+func WithPrefetch(globs ...string) Option {
+	return func(c *Config) { c.Prefetch(globs...) }
+}
+
+// WithMergeDriver merges files with extension ext by driver, see
+// Config.SetMergeDriver.
+func WithMergeDriver(ext string, driver MergeDriver) Option {
+	return func(c *Config) { c.SetMergeDriver(ext, driver) }
+}
+
+// WithClock timestamps commits, and polls AutoSync, by c, see
+// Config.SetClock.
+func WithClock(c Clock) Option {
+	return func(config *Config) { config.SetClock(c) }
+}
+
+// WithDeterministic makes commits reproducible, see Config.Deterministic.
+func WithDeterministic() Option {
+	return func(c *Config) { c.Deterministic() }
+}
+
+// WithPuller pulls by p instead of git, see Config.SetPuller.
+func WithPuller(p Puller) Option {
+	return func(c *Config) { c.SetPuller(p) }
+}
+
+// WithPusher pushes by p instead of git, see Config.SetPusher.
+func WithPusher(p Pusher) Option {
+	return func(c *Config) { c.SetPusher(p) }
+}
+
+// WithSyncOnClose makes Close sync pending changes, see
+// Config.SyncOnClose.
+func WithSyncOnClose() Option {
+	return func(c *Config) { c.SyncOnClose() }
+}
+
+// WithSnapshotOnClose makes Close write a snapshot to w, see
+// Config.SnapshotOnClose.
+func WithSnapshotOnClose(w io.Writer) Option {
+	return func(c *Config) { c.SnapshotOnClose(w) }
+}
+
+// WithAutoRecover recovers corrupt repos when opened, see
+// Config.AutoRecover.
+func WithAutoRecover() Option {
+	return func(c *Config) { c.AutoRecover() }
+}
+
+// WithSSHUser sets the user ssh remotes are logged in as.
+func WithSSHUser(user string) Option {
+	return func(c *Config) { c.SetSSHUser(user) }
+}
+
+// WithSSHCertificate logs in to ssh remotes with a key and its certificate,
+// see Config.SetSSHCertificate.
+func WithSSHCertificate(keyFile, certFile string) Option {
+	return func(c *Config) { c.SetSSHCertificate(keyFile, certFile) }
+}
+
+// WithProxy routes https remotes through proxyUrl, see Config.SetProxy.
+func WithProxy(proxyUrl string) Option {
+	return func(c *Config) { c.SetProxy(proxyUrl) }
+}
+
+// WithConcurrency sets the number of checkout and status workers.
+func WithConcurrency(n int) Option {
+	return func(c *Config) { c.SetConcurrency(n) }
+}
+
+// WithStatusCache caches file hashes by size and mtime.
+func WithStatusCache() Option {
+	return func(c *Config) { c.EnableStatusCache() }
+}
+
+// WithVerifyOnOpen verifies an existing repo when opened, see
+// Config.VerifyOnOpen.
+func WithVerifyOnOpen() Option {
+	return func(c *Config) { c.VerifyOnOpen() }
+}
+
+// WithOffline keeps commits local until Flush, see Config.Offline.
+func WithOffline() Option {
+	return func(c *Config) { c.Offline() }
+}
+
+// WithTracerProvider traces git operations, see Config.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Config) { c.SetTracerProvider(tp) }
+}
+
+// WithHooks sets the callbacks notified of git operations.
+func WithHooks(h Hooks) Option {
+	return func(c *Config) { c.SetHooks(h) }
+}
+
+// WithPreCommitHook sets the hook validating changes before they are
+// committed.
+func WithPreCommitHook(h PreCommitHook) Option {
+	return func(c *Config) { c.SetPreCommitHook(h) }
+}
+
+// WithoutSymlinks forbids creating and committing symlinks.
+func WithoutSymlinks() Option {
+	return func(c *Config) { c.ForbidSymlinks() }
+}
+
+// WithSigningKey signs commits with the pgp key.
+func WithSigningKey(key *openpgp.Entity) Option {
+	return func(c *Config) { c.SetSigningKey(key) }
+}
+
+// WithSSHSigningKey signs commits with the ssh key.
+func WithSSHSigningKey(signer ssh.Signer) Option {
+	return func(c *Config) { c.SetSSHSigningKey(signer) }
+}
+
+// WithTrustPolicy sets the keys pulled commits must be signed with, see
+// Config.SetTrustPolicy.
+func WithTrustPolicy(keys []PublicKey, requireSigned bool) Option {
+	return func(c *Config) { c.SetTrustPolicy(keys, requireSigned) }
+}
+
+// WithCompression stores files above threshold bytes compressed.
+func WithCompression(threshold int64) Option {
+	return func(c *Config) { c.SetCompression(threshold) }
+}
+
+// WithMaxFileSize limits the size of written files.
+func WithMaxFileSize(n int64) Option {
+	return func(c *Config) { c.SetMaxFileSize(n) }
+}
+
+// WithRepoQuota limits the total size of the worktree.
+func WithRepoQuota(n int64) Option {
+	return func(c *Config) { c.SetRepoQuota(n) }
+}
+
+// WithWritablePaths restricts writes to paths matching the globs.
+func WithWritablePaths(globs ...string) Option {
+	return func(c *Config) { c.SetWritablePaths(globs) }
+}
+
+// WithTempDir sets the name of the dir TempFile creates files in.
+func WithTempDir(name string) Option {
+	return func(c *Config) { c.SetTempDir(name) }
+}
+
+// WithCleanTempOnSync removes the temp dirs on Sync.
+func WithCleanTempOnSync() Option {
+	return func(c *Config) { c.CleanTempOnSync() }
+}
+
+// WithLockTimeout sets how long Sync, Apply and Pull wait for the repo
+// lock.
+func WithLockTimeout(d time.Duration) Option {
+	return func(c *Config) { c.SetLockTimeout(d) }
+}
+
+// WithAllowEmpty makes Sync commit even when nothing changed.
+func WithAllowEmpty() Option {
+	return func(c *Config) { c.AllowEmpty() }
+}
+
+// WithRenameThreshold sets the similarity of renamed files, see
+// Config.SetRenameThreshold.
+func WithRenameThreshold(score int) Option {
+	return func(c *Config) { c.SetRenameThreshold(score) }
+}
+
+// WithoutRenameDetection reports renamed files as removed and added.
+func WithoutRenameDetection() Option {
+	return func(c *Config) { c.DisableRenameDetection() }
+}
+
+// WithCopyDetection reports added files copied from existing ones.
+func WithCopyDetection() Option {
+	return func(c *Config) { c.DetectCopies() }
+}
+
+// WithCommitModTime reports the time of the last commit of a path as its
+// mod time, see Config.UseCommitModTime.
+func WithCommitModTime() Option {
+	return func(c *Config) { c.UseCommitModTime() }
+}
+
+// WithReviewProvider makes Sync open pull or merge requests instead of
+// pushing, see Config.SetReviewProvider.
+func WithReviewProvider(p ReviewProvider, opts ReviewOptions) Option {
+	return func(c *Config) { c.SetReviewProvider(p, opts) }
+}
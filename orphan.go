@@ -0,0 +1,76 @@
+package gitfs
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// cloneOrphan clones branch of the remote repo if it has it. Otherwise it
+// clones another branch without checking it out, or inits the repo if the
+// remote has none, with HEAD pointing to the unborn branch, so the first
+// commit starts a history of its own.
+func cloneOrphan(ctx context.Context, s storage.Storer, fs billy.Filesystem, opts *git.CloneOptions, branch string) (*git.Repository, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{opts.URL},
+	})
+	// the in process file transport fails on empty repos for lack of HEAD
+	refs, err := remote.List(&git.ListOptions{Auth: opts.Auth})
+	if err == transport.ErrEmptyRemoteRepository || err == plumbing.ErrReferenceNotFound {
+		refs = nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error listing remote refs")
+	}
+
+	// the branch cloned instead is the one HEAD points to, unless unborn
+	name := plumbing.NewBranchReferenceName(branch)
+	var head, other plumbing.ReferenceName
+	hashes := map[plumbing.ReferenceName]bool{}
+	for _, ref := range refs {
+		if ref.Name() == name {
+			opts.ReferenceName = name
+			return git.CloneContext(ctx, s, fs, opts)
+		}
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			head = ref.Target()
+		} else if ref.Name().IsBranch() && ref.Type() == plumbing.HashReference {
+			hashes[ref.Name()] = true
+			other = ref.Name()
+		}
+	}
+	if hashes[head] {
+		other = head
+	}
+
+	var repo *git.Repository
+	if other == "" {
+		if repo, err = git.Init(s, fs); err != nil {
+			return nil, errors.Wrapf(err, "error initing repo")
+		}
+		if _, err := repo.CreateRemote(&config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{opts.URL},
+		}); err != nil {
+			return nil, errors.Wrapf(err, "error adding remote origin")
+		}
+	} else {
+		opts.ReferenceName = other
+		opts.NoCheckout = true
+		if repo, err = git.CloneContext(ctx, s, fs, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name)); err != nil {
+		return nil, errors.Wrapf(err, "error setting HEAD to %v", branch)
+	}
+	return repo, nil
+}
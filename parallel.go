@@ -0,0 +1,428 @@
+package gitfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// checkoutFile is a decoded blob waiting to be written to the worktree.
+type checkoutFile struct {
+	name string
+	mode filemode.FileMode
+	data []byte
+}
+
+// parallelCheckout materializes the HEAD tree into the worktree with n
+// writers, then builds the index from HEAD. Blobs are decoded by a single
+// goroutine, since go-git object storage is not safe for concurrent use.
+func (g *Git) parallelCheckout(n int) error {
+	head, err := g.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		// empty repo
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+
+	commit, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD commit")
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD tree")
+	}
+
+	files := make(chan checkoutFile, n)
+	errs := make(chan error, n+1)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				if err := writeCheckoutFile(g.fs, f); err != nil {
+					errs <- err
+					// drain so the decoder doesn't block
+					for range files {
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Submodule {
+			return nil
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case err := <-errs:
+			return err
+		case files <- checkoutFile{name: f.Name, mode: f.Mode, data: data}:
+			return nil
+		}
+	})
+	close(files)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		return errors.Wrapf(err, "error checking out files")
+	}
+	if err := <-errs; err != nil {
+		return errors.Wrapf(err, "error checking out files")
+	}
+
+	return g.wt.Reset(&git.ResetOptions{
+		Mode:   git.MixedReset,
+		Commit: head.Hash(),
+	})
+}
+
+func writeCheckoutFile(fs billy.Filesystem, f checkoutFile) error {
+	if f.mode == filemode.Symlink {
+		return fs.Symlink(string(f.data), f.name)
+	}
+
+	perm := os.FileMode(0644)
+	if f.mode == filemode.Executable {
+		perm = 0755
+	}
+	if err := fs.MkdirAll(filepath.Dir(f.name), 0755); err != nil {
+		return err
+	}
+	w, err := fs.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(f.data)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// status returns the worktree status, computed in parallel if concurrency
+// is configured.
+func (g *Git) status() (s git.Status, err error) {
+	defer g.trace("gitfs.Status")(&err)
+
+	if g.bare != nil {
+		return g.bare.status()
+	} else if g.concurrency > 1 {
+		return g.parallelStatus(g.concurrency)
+	} else if g.statusCache != nil {
+		return g.parallelStatus(1)
+	}
+	return g.wt.Status()
+}
+
+// parallelStatus computes the same result as Worktree.Status, hashing
+// worktree files with n workers.
+func (g *Git) parallelStatus(n int) (git.Status, error) {
+	s := git.Status{}
+	file := func(path string) *git.FileStatus {
+		fs, ok := s[path]
+		if !ok {
+			fs = &git.FileStatus{Staging: git.Unmodified, Worktree: git.Unmodified}
+			s[path] = fs
+		}
+		return fs
+	}
+
+	headFiles := map[string]plumbing.Hash{}
+	head, err := g.repo.Head()
+	if err == nil {
+		commit, err := g.repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading HEAD commit")
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading HEAD tree")
+		}
+		if err := tree.Files().ForEach(func(f *object.File) error {
+			headFiles[f.Name] = f.Hash
+			return nil
+		}); err != nil {
+			return nil, errors.Wrapf(err, "error reading HEAD tree")
+		}
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, errors.Wrapf(err, "error reading HEAD")
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading index")
+	}
+	indexed := map[string]plumbing.Hash{}
+	indexedModes := map[string]filemode.FileMode{}
+	for _, e := range idx.Entries {
+		indexed[e.Name] = e.Hash
+		indexedModes[e.Name] = e.Mode
+		if h, ok := headFiles[e.Name]; !ok {
+			file(e.Name).Staging = git.Added
+		} else if h != e.Hash {
+			file(e.Name).Staging = git.Modified
+		}
+	}
+	for name := range headFiles {
+		if _, ok := indexed[name]; !ok {
+			file(name).Staging = git.Deleted
+		}
+	}
+
+	patterns, _ := gitignore.ReadPatterns(g.fs, nil)
+	matcher := gitignore.NewMatcher(append(patterns, g.wt.Excludes...))
+
+	var paths []string
+	if err := walk(g.fs, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, slashPath(path))
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error listing worktree")
+	}
+
+	hashes, modes, err := hashFiles(g.fs, paths, n, g.statusCache)
+	if err != nil {
+		return nil, err
+	}
+
+	inWorktree := map[string]bool{}
+	for i, path := range paths {
+		inWorktree[path] = true
+		h, ok := indexed[path]
+		if !ok {
+			if !matcher.Match(strings.Split(path, "/"), false) {
+				fs := file(path)
+				fs.Staging, fs.Worktree = git.Untracked, git.Untracked
+			}
+		} else if h != hashes[i] || indexedModes[path] != modes[i] {
+			file(path).Worktree = git.Modified
+		}
+	}
+	for name := range indexed {
+		if !inWorktree[name] {
+			file(name).Worktree = git.Deleted
+		}
+	}
+
+	return s, nil
+}
+
+// hashFiles computes the git blob hashes and modes of paths with n workers.
+// Files unchanged according to cache, which may be nil, are not rehashed.
+func hashFiles(fs billy.Filesystem, paths []string, n int, cache *statusCache) ([]plumbing.Hash, []filemode.FileMode, error) {
+	hashes := make([]plumbing.Hash, len(paths))
+	modes := make([]filemode.FileMode, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				h, fi, err := cache.hashFile(fs, paths[j])
+				if err == nil {
+					modes[j], err = filemode.NewFromOSFileMode(fi.Mode())
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = errors.Wrapf(err, "error hashing %v", paths[j])
+					}
+					mu.Unlock()
+					continue
+				}
+				hashes[j] = h
+			}
+		}()
+	}
+	for j := range paths {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	return hashes, modes, firstErr
+}
+
+func hashFile(fs billy.Filesystem, path string, fi os.FileInfo) (plumbing.Hash, error) {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.Readlink(path)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return plumbing.ComputeHash(plumbing.BlobObject, []byte(target)), nil
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer f.Close()
+
+	h := plumbing.NewHasher(plumbing.BlobObject, fi.Size())
+	if _, err := io.Copy(h, f); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return h.Sum(), nil
+}
+
+// statusCache remembers file hashes keyed by size and mtime, like the stat
+// data of git's index.
+type statusCache struct {
+	mu      sync.Mutex
+	entries map[string]statusCacheEntry
+}
+
+type statusCacheEntry struct {
+	size  int64
+	mtime time.Time
+	hash  plumbing.Hash
+}
+
+// racyWindow is how recent an mtime must be for the file to be rehashed
+// anyway, as it may still change within the mtime granularity.
+const racyWindow = 2 * time.Second
+
+func newStatusCache() *statusCache {
+	return &statusCache{entries: map[string]statusCacheEntry{}}
+}
+
+// hashFile returns the blob hash and info of path, the hash from cache if
+// its size and mtime are unchanged. A nil cache always hashes.
+func (c *statusCache) hashFile(fs billy.Filesystem, path string) (plumbing.Hash, os.FileInfo, error) {
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+	if c == nil {
+		h, err := hashFile(fs, path, fi)
+		return h, fi, err
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.mtime.Equal(fi.ModTime()) {
+		return e.hash, fi, nil
+	}
+
+	h, err := hashFile(fs, path, fi)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	c.mu.Lock()
+	if time.Since(fi.ModTime()) > racyWindow {
+		c.entries[path] = statusCacheEntry{size: fi.Size(), mtime: fi.ModTime(), hash: h}
+	} else {
+		delete(c.entries, path)
+	}
+	c.mu.Unlock()
+	return h, fi, nil
+}
+
+// defaultReadWorkers is the number of workers of ReadFiles unless set by
+// Config.SetConcurrency.
+const defaultReadWorkers = 8
+
+// ReadFiles reads the named files like ReadFile with a bounded pool of
+// workers, see Config.SetConcurrency, e.g. to load many small config files
+// at startup. It returns the contents by name, or the first error, wrapped
+// with the name of its file. Bare repos and views read from the object
+// store, which is not safe for concurrent use, so one file at a time.
+func (g *GitFs) ReadFiles(filenames []string) (files map[string][]byte, err error) {
+	defer g.git.trace("gitfs.ReadFiles")(&err)
+
+	n := g.git.concurrency
+	if n == 0 {
+		n = defaultReadWorkers
+	}
+	if n < 1 || g.git.bare != nil {
+		n = 1
+	}
+	if n > len(filenames) {
+		n = len(filenames)
+	}
+
+	data := make([][]byte, len(filenames))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if failed() {
+					continue
+				}
+				d, err := g.readFile(filenames[j])
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = errors.Wrapf(err, "error reading %v", filenames[j])
+					}
+					mu.Unlock()
+					continue
+				}
+				data[j] = d
+			}
+		}()
+	}
+	for j := range filenames {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	files = make(map[string][]byte, len(filenames))
+	for j, name := range filenames {
+		files[name] = data[j]
+	}
+	return files, nil
+}
@@ -0,0 +1,580 @@
+package gitfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PatchOptions tunes a single patch, see ApplyPatchWithOptions.
+type PatchOptions struct {
+	// If the patch is only checked, reporting what it would change
+	DryRun bool
+	// If hunks that don't apply are skipped and reported, applying the
+	// rest, otherwise the patch fails with ErrConflict, changing nothing
+	Reject bool
+	// Number of leading path components stripped from the paths of the
+	// patch, like patch -p, if zero only the a/ and b/ prefixes of git
+	// diffs
+	StripComponents int
+}
+
+// PatchResult reports what ApplyPatchWithOptions did.
+type PatchResult struct {
+	// Files changed, or to be changed by dry runs, in patch order
+	Changes []Change
+	// Hunks not applied, with PatchOptions.Reject
+	Rejects []PatchReject
+}
+
+// PatchReject is a hunk of a patch that doesn't apply.
+type PatchReject struct {
+	// Slash separated path relative to the repo root
+	Path string
+	// Hunk as in the patch, from its @@ line
+	Hunk string
+}
+
+// ApplyPatch applies the unified diff read from r to the worktree, e.g. a
+// patch approved by external review tooling, ready for a Sync. Git diffs
+// may create, delete, rename and chmod files. If any hunk doesn't apply,
+// ApplyPatch fails with ErrConflict, changing nothing.
+func (g *GitFs) ApplyPatch(r io.Reader) error {
+	_, err := g.ApplyPatchWithOptions(r, PatchOptions{})
+	return err
+}
+
+// ApplyPatchWithOptions applies a patch like ApplyPatch, as tuned by opts,
+// reporting what it did. Hunks are matched exactly, at their line or the
+// nearest one they match at. Files are written like by WriteFile, so the
+// writable paths and size limits apply, checked for every file before any
+// is written. If writing a file fails nonetheless, the files already
+// patched are restored. Binary patches and copies are not supported.
+func (g *GitFs) ApplyPatchWithOptions(r io.Reader, opts PatchOptions) (res PatchResult, err error) {
+	defer g.git.trace("gitfs.ApplyPatch")(&err)
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return res, errors.Wrapf(err, "error reading patch")
+	}
+	patches, err := parsePatch(string(data), opts.StripComponents)
+	if err != nil {
+		return res, err
+	}
+
+	files := map[string]*patchedFile{}
+	var order []string
+	load := func(p string) (*patchedFile, error) {
+		if f, ok := files[p]; ok {
+			return f, nil
+		}
+		f, err := g.loadPatched(p)
+		if err != nil {
+			return nil, err
+		}
+		files[p] = f
+		order = append(order, p)
+		return f, nil
+	}
+
+	var conflicts []string
+	for _, fp := range patches {
+		src, dst := fp.oldPath, fp.newPath
+		if src == "" {
+			src = dst
+		}
+		from, err := load(src)
+		if err != nil {
+			return res, err
+		}
+		to := from
+		if dst != "" && dst != src {
+			if to, err = load(dst); err != nil {
+				return res, err
+			}
+		}
+		switch {
+		case fp.oldPath == "" && from.exists:
+			return res, errors.Wrapf(ErrConflict, "patch creates %v, which exists", dst)
+		case fp.oldPath != "" && !from.exists:
+			return res, errors.Wrapf(ErrConflict, "patch changes %v, which doesn't exist", src)
+		case to != from && to.exists:
+			return res, errors.Wrapf(ErrConflict, "patch renames %v to %v, which exists", src, dst)
+		}
+
+		lines, rejected := applyHunks(splitLines(string(from.data)), fp.hunks)
+		for _, h := range rejected {
+			conflicts = append(conflicts, src)
+			res.Rejects = append(res.Rejects, PatchReject{Path: g.repoPath(src), Hunk: h.text})
+		}
+		if len(rejected) > 0 && (fp.newPath == "" || len(rejected) == len(fp.hunks) && to == from && fp.mode == 0) {
+			// nothing left to change
+			continue
+		}
+		content := []byte(strings.Join(lines, ""))
+		from.changed, to.changed = true, true
+
+		c := Change{Path: g.repoPath(src), Status: Modified}
+		switch {
+		case fp.newPath == "":
+			if len(content) > 0 {
+				return res, errors.Wrapf(ErrConflict, "patch deletes %v, which has other content", src)
+			}
+			from.exists, from.data = false, nil
+			c.Status = Deleted
+		default:
+			if fp.oldPath == "" {
+				c.Status = Added
+			} else if to != from {
+				c.Path, c.OldPath, c.Status = g.repoPath(dst), g.repoPath(src), Renamed
+				from.exists, from.data = false, nil
+			}
+			to.exists, to.data, to.mode = true, content, from.mode
+			if fp.mode != 0 {
+				to.mode = fp.mode
+			}
+			c.Data = content
+		}
+		res.Changes = append(res.Changes, c)
+	}
+
+	if len(res.Rejects) > 0 && !opts.Reject {
+		res.Rejects = nil
+		return res, errors.Wrapf(ErrConflict, "patch doesn't apply at %v", strings.Join(uniqueSorted(conflicts), ", "))
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+	if err := g.checkPatched(order, files); err != nil {
+		return res, err
+	}
+	for i, p := range order {
+		if err := g.storePatched(p, files[p]); err != nil {
+			// restore the files patched so far, p maybe half way
+			for j := i; j >= 0; j-- {
+				if rerr := g.restorePatched(order[j], files[order[j]]); rerr != nil {
+					g.git.reportError("gitfs.ApplyPatch", errors.Wrapf(rerr, "error restoring %v", order[j]))
+				}
+			}
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// checkPatched checks that the files about to be patched are writable and
+// within the size limit, before any is written.
+func (g *GitFs) checkPatched(order []string, files map[string]*patchedFile) error {
+	for _, p := range order {
+		f := files[p]
+		if !f.changed {
+			continue
+		}
+		if err := g.checkWritable("write", p); err != nil {
+			return err
+		}
+		// compressed files are checked once compressed, by WriteFile
+		size := int64(len(f.data))
+		if f.exists && f.mode != os.ModeSymlink && (g.compressAbove <= 0 || size <= g.compressAbove) {
+			if err := g.checkFileSize(p, size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// patchedFile is a file of the worktree as a patch changes it.
+type patchedFile struct {
+	exists  bool
+	data    []byte
+	mode    os.FileMode
+	existed bool
+	changed bool
+	// Content and mode before the patch, if existed
+	orig     []byte
+	origMode os.FileMode
+}
+
+func (g *GitFs) loadPatched(p string) (*patchedFile, error) {
+	f := &patchedFile{mode: 0644}
+	fi, err := g.Lstat(p)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil, errors.Errorf("patch changes %v, which is a dir", p)
+	case err == nil && fi.Mode()&os.ModeSymlink != 0:
+		target, err := g.Readlink(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v", p)
+		}
+		f.data, f.mode = []byte(target), os.ModeSymlink
+	case err == nil || os.IsNotExist(err):
+		if err == nil {
+			f.mode = fi.Mode().Perm()
+		}
+		// compressed files exist only under their compressed name
+		f.data, err = g.ReadFile(p)
+		if os.IsNotExist(err) {
+			return f, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v", p)
+		}
+	default:
+		return nil, errors.Wrapf(err, "error reading %v", p)
+	}
+	f.exists, f.existed = true, true
+	f.orig, f.origMode = f.data, f.mode
+	return f, nil
+}
+
+func (g *GitFs) storePatched(p string, f *patchedFile) error {
+	if !f.changed {
+		return nil
+	}
+	if f.existed && (!f.exists || f.mode == os.ModeSymlink) {
+		err := g.Remove(p)
+		if os.IsNotExist(err) {
+			err = g.Remove(p + compressedExt)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error removing %v", p)
+		}
+	}
+	switch {
+	case !f.exists:
+		return nil
+	case f.mode == os.ModeSymlink:
+		return errors.Wrapf(g.Symlink(string(f.data), p), "error patching %v", p)
+	default:
+		return errors.Wrapf(g.WriteFile(p, f.data, f.mode), "error patching %v", p)
+	}
+}
+
+// restorePatched restores p as it was before storePatched.
+func (g *GitFs) restorePatched(p string, f *patchedFile) error {
+	if !f.changed {
+		return nil
+	}
+	if err := g.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch {
+	case !f.existed:
+		return nil
+	case f.origMode == os.ModeSymlink:
+		return g.Symlink(string(f.orig), p)
+	default:
+		return g.WriteFile(p, f.orig, f.origMode)
+	}
+}
+
+// applyHunks applies hunks to lines, returning the patched lines and the
+// hunks that don't apply.
+func applyHunks(lines []string, hunks []patchHunk) ([]string, []patchHunk) {
+	var out []string
+	var rejected []patchHunk
+	last, offset := 0, 0
+	for _, h := range hunks {
+		at := h.oldStart
+		if len(h.oldLines) > 0 {
+			at--
+		}
+		p := matchHunk(lines, h.oldLines, at+offset, last)
+		if p < 0 {
+			rejected = append(rejected, h)
+			continue
+		}
+		out = append(append(out, lines[last:p]...), h.newLines...)
+		last, offset = p+len(h.oldLines), p-at
+	}
+	return append(out, lines[last:]...), rejected
+}
+
+// matchHunk returns the line of lines nearest to at, from min, that old
+// matches at, -1 if none.
+func matchHunk(lines, old []string, at, min int) int {
+	max := len(lines) - len(old)
+	for d := 0; at-d >= min || at+d <= max; d++ {
+		for _, p := range []int{at - d, at + d} {
+			if p >= min && p <= max && equalLines(lines[p:p+len(old)], old) {
+				return p
+			}
+		}
+	}
+	return -1
+}
+
+// filePatch is the patch of one file, an empty path standing for
+// /dev/null.
+type filePatch struct {
+	oldPath, newPath string
+	// Mode of the patched file, 0 to keep it
+	mode  os.FileMode
+	hunks []patchHunk
+}
+
+type patchHunk struct {
+	oldStart           int
+	oldLines, newLines []string
+	text               string
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses the file patches of a unified diff, optionally with git
+// headers, skipping any text around them like commit messages.
+func parsePatch(patch string, strip int) ([]*filePatch, error) {
+	var patches []*filePatch
+	var cur *filePatch
+	git, headers := false, false
+	lines := splitLines(patch)
+	for i := 0; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r\n")
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			old, cnew, err := gitDiffPaths(l[len("diff --git "):], strip)
+			if err != nil {
+				return nil, err
+			}
+			cur = &filePatch{oldPath: old, newPath: cnew}
+			patches = append(patches, cur)
+			git, headers = true, false
+		case git && cur != nil && len(cur.hunks) == 0 && isGitHeader(l):
+			if err := cur.gitHeader(l); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || headers || len(cur.hunks) > 0 {
+				cur = &filePatch{}
+				patches = append(patches, cur)
+				git = false
+			}
+			old, err := patchPath(l[4:], strip, "a/")
+			if err != nil {
+				return nil, err
+			}
+			i++
+			cnew, err := patchPath(strings.TrimRight(lines[i], "\r\n")[4:], strip, "b/")
+			if err != nil {
+				return nil, err
+			}
+			cur.oldPath, cur.newPath = old, cnew
+			headers = true
+		case strings.HasPrefix(l, "@@ ") && cur != nil:
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing patch of %v at line %v", cur.newPath, i+1)
+			}
+			cur.hunks = append(cur.hunks, h)
+			i += n - 1
+		}
+	}
+	for _, fp := range patches {
+		if fp.oldPath == "" && fp.newPath == "" {
+			return nil, errors.New("error parsing patch: file without path")
+		}
+	}
+	return patches, nil
+}
+
+func isGitHeader(l string) bool {
+	for _, h := range []string{"old mode ", "new mode ", "new file mode ", "deleted file mode ", "rename from ", "rename to ", "copy from ", "copy to ", "similarity index ", "dissimilarity index ", "index ", "Binary files ", "GIT binary patch"} {
+		if strings.HasPrefix(l, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitHeader applies the extended git header line l.
+func (fp *filePatch) gitHeader(l string) error {
+	field := func(prefix string) string {
+		return l[len(prefix):]
+	}
+	var err error
+	switch {
+	case strings.HasPrefix(l, "new file mode "):
+		fp.oldPath = ""
+		fp.mode, err = patchMode(field("new file mode "))
+	case strings.HasPrefix(l, "deleted file mode "):
+		fp.newPath = ""
+	case strings.HasPrefix(l, "new mode "):
+		fp.mode, err = patchMode(field("new mode "))
+	case strings.HasPrefix(l, "rename from "):
+		fp.oldPath, err = unquotePath(field("rename from "))
+	case strings.HasPrefix(l, "rename to "):
+		fp.newPath, err = unquotePath(field("rename to "))
+	case strings.HasPrefix(l, "copy "):
+		err = errors.Errorf("copy of %v is not supported", fp.newPath)
+	case strings.HasPrefix(l, "Binary files "), strings.HasPrefix(l, "GIT binary patch"):
+		err = errors.Errorf("binary patch of %v is not supported", fp.newPath)
+	}
+	return err
+}
+
+// patchMode returns the file mode of the git mode m.
+func patchMode(m string) (os.FileMode, error) {
+	switch m {
+	case "100644":
+		return 0644, nil
+	case "100755":
+		return 0755, nil
+	case "120000":
+		return os.ModeSymlink, nil
+	}
+	return 0, errors.Errorf("unsupported file mode %v in patch", m)
+}
+
+// gitDiffPaths returns the paths of the "a/old b/new" of a diff --git line,
+// which are ambiguous if unquoted and containing spaces: then both are the
+// same path, unless a rename header tells them.
+func gitDiffPaths(s string, strip int) (string, string, error) {
+	var old, cnew string
+	if strings.HasPrefix(s, `"`) {
+		i := 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			return "", "", errors.Errorf("error parsing patch header %v", s)
+		}
+		old, cnew = s[:i+1], strings.TrimPrefix(s[i+1:], " ")
+	} else if n := len(s); n%2 == 1 && s[n/2] == ' ' && stripPath(s[:n/2], strip, "a/") == stripPath(s[n/2+1:], strip, "b/") {
+		old, cnew = s[:n/2], s[n/2+1:]
+	} else if i := strings.Index(s, " b/"); i >= 0 {
+		old, cnew = s[:i], s[i+1:]
+	} else if i := strings.LastIndex(s, " "); i >= 0 {
+		old, cnew = s[:i], s[i+1:]
+	} else {
+		return "", "", errors.Errorf("error parsing patch header %v", s)
+	}
+	old, err := patchPath(old, strip, "a/")
+	if err != nil {
+		return "", "", err
+	}
+	cnew, err = patchPath(cnew, strip, "b/")
+	return old, cnew, err
+}
+
+// patchPath returns the path of a ---, +++ or diff --git line, "" for
+// /dev/null.
+func patchPath(s string, strip int, prefix string) (string, error) {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		// timestamps of diff -u
+		s = s[:i]
+	}
+	s, err := unquotePath(s)
+	if err != nil || s == "/dev/null" {
+		return "", err
+	}
+	if p := stripPath(s, strip, prefix); p != "" {
+		return p, nil
+	}
+	return "", errors.Errorf("path %v of patch has less than %v components", s, strip)
+}
+
+func stripPath(p string, strip int, prefix string) string {
+	if strip == 0 {
+		return strings.TrimPrefix(p, prefix)
+	}
+	parts := strings.SplitN(p, "/", strip+1)
+	if len(parts) <= strip {
+		return ""
+	}
+	return parts[strip]
+}
+
+func unquotePath(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	u, err := strconv.Unquote(s)
+	return u, errors.Wrapf(err, "error parsing path %v of patch", s)
+}
+
+// parseHunk parses the hunk lines start with, returning it and the number
+// of lines it takes.
+func parseHunk(lines []string) (patchHunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[0])
+	if m == nil {
+		return patchHunk{}, 0, errors.Errorf("malformed hunk header %q", lines[0])
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	h := patchHunk{oldStart: count(m[1])}
+	olds, news := count(m[2]), count(m[4])
+
+	n := 1
+	var last byte
+	for ; n < len(lines); n++ {
+		l := lines[n]
+		if olds == 0 && news == 0 && !strings.HasPrefix(l, `\`) {
+			break
+		}
+		if l == "\n" || l == "\r\n" {
+			// context of an empty line, trimmed by some tools
+			l = " " + l
+		}
+		switch l[0] {
+		case ' ':
+			h.oldLines, h.newLines = append(h.oldLines, l[1:]), append(h.newLines, l[1:])
+			olds, news = olds-1, news-1
+		case '-':
+			h.oldLines = append(h.oldLines, l[1:])
+			olds--
+		case '+':
+			h.newLines = append(h.newLines, l[1:])
+			news--
+		case '\\':
+			// no newline at end of file
+			if last == ' ' || last == '-' {
+				noNewline(h.oldLines)
+			}
+			if last == ' ' || last == '+' {
+				noNewline(h.newLines)
+			}
+		default:
+			return patchHunk{}, 0, errors.Errorf("malformed hunk line %q", l)
+		}
+		if olds < 0 || news < 0 {
+			return patchHunk{}, 0, errors.Errorf("hunk longer than its header %q", lines[0])
+		}
+		last = l[0]
+	}
+	if olds > 0 || news > 0 {
+		return patchHunk{}, 0, errors.Errorf("hunk shorter than its header %q", lines[0])
+	}
+	h.text = strings.Join(lines[:n], "")
+	return h, n, nil
+}
+
+func noNewline(lines []string) {
+	if n := len(lines); n > 0 {
+		lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+	}
+}
+
+func uniqueSorted(s []string) []string {
+	sort.Strings(s)
+	var u []string
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			u = append(u, v)
+		}
+	}
+	return u
+}
@@ -0,0 +1,79 @@
+package gitfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const twoFilePatch = `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1 +1 @@
+-a
++A
+diff --git a/b.txt b/b.txt
+--- a/b.txt
++++ b/b.txt
+@@ -1 +1 @@
+-b
++B
+`
+
+func TestApplyPatchChecksAllFilesFirst(t *testing.T) {
+	for name, c := range map[string]*Config{
+		"writable": NewConfig().SetWritablePaths([]string{"a.txt"}),
+		"size":     NewConfig().SetMaxFileSize(2),
+	} {
+		g, err := New(context.Background(), c.NoRemote().UseMemFs())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// b.txt written past the writable paths
+		for _, p := range []string{"a.txt", "b.txt"} {
+			if err := util.WriteFile(g.fs, p, []byte(strings.TrimSuffix(p, ".txt")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		patch := twoFilePatch
+		if name == "size" {
+			patch = strings.Replace(patch, "+B\n", "+BBB\n", 1)
+		}
+		if err := g.ApplyPatch(strings.NewReader(patch)); err == nil {
+			t.Fatalf("%v: patch applied", name)
+		}
+		if data := readTestFile(t, g, "a.txt"); data != "a\n" {
+			t.Fatalf("%v: a.txt patched to %q", name, data)
+		}
+	}
+}
+
+func TestApplyPatchRestoresOnWriteError(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", "a\n")
+	writeTestFile(t, g, "d", "file, not a dir\n")
+
+	patch := strings.SplitAfterN(twoFilePatch, "diff --git a/b.txt", 2)[0]
+	patch = strings.TrimSuffix(patch, "diff --git a/b.txt") + `diff --git a/d/new.txt b/d/new.txt
+new file mode 100644
+--- /dev/null
++++ b/d/new.txt
+@@ -0,0 +1 @@
++new
+`
+	if err := g.ApplyPatch(strings.NewReader(patch)); err == nil {
+		t.Fatal("patch applied")
+	}
+	if data := readTestFile(t, g, "a.txt"); data != "a\n" {
+		t.Fatalf("a.txt left patched as %q", data)
+	}
+	if _, err := g.Stat("d/new.txt"); err == nil {
+		t.Fatal("d/new.txt created")
+	}
+}
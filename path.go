@@ -0,0 +1,92 @@
+package gitfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// InvalidPathError is returned for paths escaping the root, or pointing
+// into .git.
+type InvalidPathError struct {
+	Op     string
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPathError) Error() string {
+	return fmt.Sprintf("%v %q: invalid path: %v", e.Op, e.Path, e.Reason)
+}
+
+// IsInvalidPath reports whether err is, or wraps, an InvalidPathError.
+func IsInvalidPath(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+	for err != nil {
+		if _, ok := err.(*InvalidPathError); ok {
+			return true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// validatePath checks a user supplied path. Paths are taken relative to the
+// root whether they start with a separator or not, but may not leave it
+// through "..", name a volume, or have a .git component unless allowGitDir.
+func validatePath(op, path string, allowGitDir bool) error {
+	invalid := func(reason string) error {
+		return &InvalidPathError{Op: op, Path: path, Reason: reason}
+	}
+
+	if strings.IndexByte(path, 0) >= 0 {
+		return invalid("contains NUL")
+	}
+	if filepath.VolumeName(path) != "" {
+		return invalid("outside of root")
+	}
+
+	rel := strings.TrimLeft(filepath.ToSlash(path), "/")
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(rel)))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return invalid("escapes root")
+	}
+
+	for _, elem := range strings.Split(clean, "/") {
+		if !allowGitDir && strings.EqualFold(elem, git.GitDirName) {
+			return invalid("inside " + git.GitDirName)
+		}
+	}
+	return nil
+}
+
+// isRoot reports whether the user supplied path names the root.
+func isRoot(path string) bool {
+	rel := strings.TrimLeft(filepath.ToSlash(path), "/")
+	return filepath.Clean(filepath.FromSlash(rel)) == "."
+}
+
+// checkPath validates a user supplied path, see validatePath.
+func (g *GitFs) checkPath(op, path string) error {
+	return validatePath(op, path, g.exposeGitDir)
+}
+
+// validateLink checks that the target of a symlink created at link doesn't
+// lead outside the root.
+func validateLink(target, link string) error {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return &InvalidPathError{Op: "symlink", Path: target, Reason: "absolute symlink target"}
+	}
+	resolved := filepath.Join(filepath.Dir(filepath.FromSlash(strings.TrimLeft(link, `/\`))), target)
+	if err := validatePath("symlink", resolved, false); err != nil {
+		return &InvalidPathError{Op: "symlink", Path: target, Reason: err.(*InvalidPathError).Reason}
+	}
+	return nil
+}
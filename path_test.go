@@ -0,0 +1,130 @@
+package gitfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func TestRemoveAllRootKeepsGitDir(t *testing.T) {
+	for _, root := range []string{"/", "", ".", "a/.."} {
+		dir, cleanup := testDir(t)
+		g, err := New(context.Background(), NewConfig().NoRemote().UseOsFs(dir, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, g, "a/b.txt", "b")
+		writeTestFile(t, g, "c.txt", "c")
+		if err := g.Sync(false); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.RemoveAll(root); err != nil {
+			t.Fatalf("RemoveAll(%q): %v", root, err)
+		}
+		if infos, err := g.ReadDir("/"); err != nil || len(infos) != 0 {
+			t.Fatalf("RemoveAll(%q) left %v, %v", root, infos, err)
+		}
+		if err := g.Sync(false); err != nil {
+			t.Fatalf("sync after RemoveAll(%q): %v", root, err)
+		}
+		files, err := g.git.headFiles()
+		if err != nil || len(files) != 0 {
+			t.Fatalf("removal not committed: %v, %v", files, err)
+		}
+		cleanup()
+	}
+}
+
+func TestRemoveRenameRejectRoot(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Remove("/"); !IsInvalidPath(err) {
+		t.Fatalf("Remove(/) got %v", err)
+	}
+	if err := g.Rename("", "x"); !IsInvalidPath(err) {
+		t.Fatalf("Rename(\"\", x) got %v", err)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for path, ok := range map[string]bool{
+		"a/b":        true,
+		"/a/b":       true,
+		"a/../b":     true,
+		"../a":       false,
+		"a/../../b":  false,
+		".git/index": false,
+		"a/.GIT/x":   false,
+		"a\x00b":     false,
+	} {
+		if err := validatePath("test", path, false); (err == nil) != ok {
+			t.Errorf("validatePath(%q) = %v", path, err)
+		}
+	}
+}
+
+func TestSlashPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/a":                              "a",
+		"/a/b.txt":                        "a/b.txt",
+		filepath.Join("/", "a", "b", "c"): "a/b/c",
+		"a":                               "a",
+	} {
+		if got := slashPath(path); got != want {
+			t.Fatalf("slashPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestTraverseDirSlashPaths(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	fs := osfs.New(dir)
+	for _, name := range []string{"a.txt", filepath.Join("b", "c.txt"), filepath.Join("b", "d", "e.txt")} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var paths []string
+	if err := traverseDir(fs, "/", func(path string) error {
+		paths = append(paths, path)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	if want := []string{"a.txt", "b/c.txt", "b/d/e.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got paths %q, want %q", paths, want)
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	for path, want := range map[string]string{
+		"~":             home,
+		"~/.ssh/id_rsa": filepath.Join(home, ".ssh", "id_rsa"),
+		"/etc/id_rsa":   "/etc/id_rsa",
+		"~user/id_rsa":  "~user/id_rsa",
+		"keys/~/id_rsa": "keys/~/id_rsa",
+	} {
+		if got := expandHome(path); got != want {
+			t.Fatalf("expandHome(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
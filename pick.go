@@ -0,0 +1,215 @@
+package gitfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var ErrConflict = errors.New("changes conflict with the current branch")
+
+// Revert commits the inverse of the changes of commit, a revision like a
+// hash or ref, on the current branch, then pushes it like Apply, e.g. to
+// roll back a bad sync. Files are compared whole, if one commit changed
+// since is affected too, it fails with ErrConflict.
+func (g *GitFs) Revert(commit string) error {
+	return g.pick("gitfs.Revert", commit, true)
+}
+
+// CherryPick commits the changes of commit, a revision like a hash or ref,
+// on the current branch, then pushes it like Apply. It fails with
+// ErrConflict if the files changed differ from what commit changed them
+// from.
+func (g *GitFs) CherryPick(commit string) error {
+	return g.pick("gitfs.CherryPick", commit, false)
+}
+
+func (g *GitFs) pick(op, revision string, revert bool) (err error) {
+	defer g.git.trace(op)(&err)
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	c, err := g.git.resolveCommit(revision)
+	if err != nil {
+		return err
+	}
+	if c.NumParents() > 1 {
+		return errors.Errorf("%v is a merge commit, which can't be picked", c.Hash)
+	}
+
+	to, err := commitFiles(c)
+	if err != nil {
+		return err
+	}
+	var from map[string]bareEntry
+	if c.NumParents() == 0 {
+		from = map[string]bareEntry{}
+	} else {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return errors.Wrapf(err, "error reading parent of %v", c.Hash)
+		}
+		if from, err = commitFiles(parent); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("%v\n\n(cherry picked from commit %v)", strings.TrimRight(c.Message, "\n"), c.Hash)
+	if revert {
+		from, to = to, from
+		subject := strings.SplitN(c.Message, "\n", 2)[0]
+		msg = fmt.Sprintf("Revert %q\n\nThis reverts commit %v.", subject, c.Hash)
+	}
+
+	head, err := g.git.headFiles()
+	if err != nil {
+		return err
+	}
+	s, err := g.git.status()
+	if err != nil {
+		return errors.Wrapf(err, "error getting status")
+	}
+
+	var paths, conflicts []string
+	for p := range changedFiles(from, to) {
+		if fstatus, ok := s[p]; ok && (fstatus.Worktree != git.Unmodified || fstatus.Staging != git.Unmodified) {
+			conflicts = append(conflicts, p)
+		} else if head[p] == to[p] {
+			// already applied
+		} else if head[p] != from[p] {
+			conflicts = append(conflicts, p)
+		} else {
+			paths = append(paths, p)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return errors.Wrapf(ErrConflict, "%v of %v at %v", op, c.Hash, strings.Join(conflicts, ", "))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	for _, p := range paths {
+		if err := g.git.checkoutEntry(p, to[p]); err != nil {
+			return err
+		}
+	}
+
+	if err := g.git.Stage(paths); err != nil {
+		return errors.Wrapf(err, "error adding files to git")
+	}
+	if err := g.git.CommitStaged(msg); err != nil {
+		return errors.Wrapf(err, "error committing changes")
+	}
+
+	if !g.pushes() {
+		return nil
+	}
+	if _, err := g.push(g.git.ctx); err != nil {
+		return errors.Wrapf(err, "error pushing change to remote repo")
+	}
+	return nil
+}
+
+// commitFiles returns the files of the tree of c by path.
+func commitFiles(c *object.Commit) (map[string]bareEntry, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading tree of %v", c.Hash)
+	}
+	files := map[string]bareEntry{}
+	if err := tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name] = bareEntry{hash: f.Hash, mode: f.Mode}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error reading tree of %v", c.Hash)
+	}
+	return files, nil
+}
+
+// headFiles returns the files of the HEAD commit, none if unborn.
+func (g *Git) headFiles() (map[string]bareEntry, error) {
+	head, err := g.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return map[string]bareEntry{}, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error reading HEAD")
+	}
+	c, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading HEAD commit")
+	}
+	return commitFiles(c)
+}
+
+// changedFiles returns the paths whose files differ between from and to.
+func changedFiles(from, to map[string]bareEntry) map[string]bool {
+	changed := map[string]bool{}
+	for p, e := range from {
+		if to[p] != e {
+			changed[p] = true
+		}
+	}
+	for p, e := range to {
+		if from[p] != e {
+			changed[p] = true
+		}
+	}
+	return changed
+}
+
+// checkoutEntry writes the blob of e to p in the worktree, or removes p if
+// e is the zero entry.
+func (g *Git) checkoutEntry(p string, e bareEntry) error {
+	if _, err := g.fs.Lstat(p); err == nil {
+		if err := g.fs.Remove(p); err != nil {
+			return errors.Wrapf(err, "error removing %v", p)
+		}
+	}
+	if e.hash.IsZero() {
+		return nil
+	}
+
+	blob, err := g.repo.BlobObject(e.hash)
+	if err != nil {
+		return errors.Wrapf(err, "error reading blob of %v", p)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return errors.Wrapf(err, "error reading blob of %v", p)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrapf(err, "error reading blob of %v", p)
+	}
+	return g.writeEntry(p, data, e.mode)
+}
+
+// writeEntry writes data to p in the worktree as a file of mode, or the
+// target of a symlink.
+func (g *Git) writeEntry(p string, data []byte, mode filemode.FileMode) (err error) {
+	if mode == filemode.Symlink {
+		err = g.fs.Symlink(string(data), p)
+	} else {
+		perm := os.FileMode(0644)
+		if mode == filemode.Executable {
+			perm = 0755
+		}
+		err = util.WriteFile(g.fs, p, data, perm)
+	}
+	return errors.Wrapf(err, "error writing %v", p)
+}
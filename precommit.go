@@ -0,0 +1,92 @@
+package gitfs
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+var ErrSymlinkForbidden = errors.New("symlinks are forbidden")
+
+// Change is a file change about to be committed, or between commits.
+type Change struct {
+	// Slash separated path relative to the repo root
+	Path string
+	// Former path if Renamed, empty otherwise
+	OldPath string
+	Status  StatusCode
+	// New content, nil if the file is deleted or not loaded
+	Data []byte
+}
+
+// PreCommitHook validates the changes about to be committed. Returning an
+// error aborts the commit.
+type PreCommitHook func(changes []Change) error
+
+// stagedChanges returns the changes a commit would contain, sorted by path.
+// With all, changes of tracked files not in the index are included, like
+// commit with All does. With load, Data is read.
+func (g *Git) stagedChanges(all, load bool) ([]Change, error) {
+	s, err := g.status()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting status")
+	}
+
+	var changes []Change
+	for path, fstatus := range s {
+		code := fstatus.Staging
+		if all && (fstatus.Worktree == git.Deleted || fstatus.Worktree == git.Modified) {
+			code = fstatus.Worktree
+		}
+		if code == git.Unmodified || code == git.Untracked {
+			continue
+		}
+
+		c := Change{Path: path, Status: StatusCode(byte(code))}
+		if load && code != git.Deleted {
+			if c.Data, err = readFile(g.fs, path); err != nil {
+				return nil, errors.Wrapf(err, "error reading %v", path)
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// runPreCommit checks the changes a commit would contain, see
+// stagedChanges, against the symlink policy and the pre-commit hook.
+func (g *Git) runPreCommit(all bool) error {
+	if g.preCommit == nil && !g.noSymlinks {
+		return nil
+	}
+
+	changes, err := g.stagedChanges(all, true)
+	if err != nil {
+		return err
+	}
+
+	if g.noSymlinks {
+		for _, c := range changes {
+			if c.Status == Deleted {
+				continue
+			}
+			if fi, err := g.fs.Lstat(c.Path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return errors.Wrapf(ErrSymlinkForbidden, "error committing %v", c.Path)
+			}
+		}
+	}
+
+	if g.preCommit == nil {
+		return nil
+	}
+	if err := g.preCommit(changes); err != nil {
+		return errors.Wrapf(err, "pre-commit hook rejected changes")
+	}
+	return nil
+}
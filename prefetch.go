@@ -0,0 +1,136 @@
+package gitfs
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefetch keeps at most this many bytes of hot files in memory.
+const maxPrefetchSize = 64 << 20
+
+// prefetcher reads the files under hot path globs ahead of their first
+// read, see Config.Prefetch. Files are kept until read once, written, or
+// replaced by the next prefetch, so later reads see the worktree as usual.
+type prefetcher struct {
+	globs []string
+
+	mu    sync.Mutex
+	files map[string]prefetched
+	size  int64
+	// Bumped on every write, so prefetches racing writes drop their reads
+	gen uint64
+}
+
+// prefetched is a prefetched file, valid while its size and mtime are.
+type prefetched struct {
+	data       []byte
+	compressed bool
+	size       int64
+	modTime    time.Time
+}
+
+func newPrefetcher(globs []string) *prefetcher {
+	if len(globs) == 0 {
+		return nil
+	}
+	return &prefetcher{globs: globs, files: map[string]prefetched{}}
+}
+
+// take returns and forgets the prefetched file filename, relative to the
+// repo root.
+func (p *prefetcher) take(filename string) (prefetched, bool) {
+	if p == nil {
+		return prefetched{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.files[filename]
+	if ok {
+		delete(p.files, filename)
+		p.size -= int64(len(f.data))
+	}
+	return f, ok
+}
+
+// invalidate forgets filename, relative to the repo root, once written.
+func (p *prefetcher) invalidate(filename string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gen++
+	if f, ok := p.files[filename]; ok {
+		delete(p.files, filename)
+		p.size -= int64(len(f.data))
+	}
+}
+
+// prefetch replaces the prefetched files by the files of the worktree
+// under the hot paths, decompressing the ones stored compressed. Errors
+// are reported to Hooks.OnError only, reads fall back to the worktree.
+func (g *GitFs) prefetch() {
+	p := g.prefetched
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.files, p.size = map[string]prefetched{}, 0
+	p.mu.Unlock()
+
+	if err := walk(g.fs, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		compressed := strings.HasSuffix(path, compressedExt)
+		name := strings.TrimSuffix(g.repoPath(path), compressedExt)
+		if fi.IsDir() || !fi.Mode().IsRegular() || !writablePath(p.globs, name) {
+			return nil
+		}
+
+		p.mu.Lock()
+		gen, full := p.gen, p.size+fi.Size() > maxPrefetchSize
+		p.mu.Unlock()
+		if full {
+			return nil
+		}
+		data, err := readFile(g.fs, path)
+		if err == nil && compressed {
+			data, err = decompress(data)
+		}
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.gen == gen && p.size+int64(len(data)) <= maxPrefetchSize {
+			p.files[name] = prefetched{data: data, compressed: compressed, size: fi.Size(), modTime: fi.ModTime()}
+			p.size += int64(len(data))
+		}
+		return nil
+	}); err != nil {
+		g.git.reportError("prefetch", err)
+	}
+}
+
+// readPrefetched returns the prefetched content of filename if it is still
+// the content of the worktree.
+func (g *GitFs) readPrefetched(filename string) ([]byte, bool) {
+	f, ok := g.prefetched.take(g.repoPath(filename))
+	if !ok {
+		return nil, false
+	}
+	if f.compressed {
+		filename += compressedExt
+	}
+	fi, err := g.fs.Stat(filename)
+	if err != nil || fi.Size() != f.size || !fi.ModTime().Equal(f.modTime) {
+		return nil, false
+	}
+	return f.data, true
+}
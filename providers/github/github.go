@@ -0,0 +1,132 @@
+// Package github opens GitHub pull requests of the branches gitfs Sync
+// pushes, see gitfs.Config.SetReviewProvider, and authenticates gitfs as a
+// GitHub App, see AppTokenSource.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+)
+
+const defaultBaseURL = "https://api.github.com"
+
+// Provider opens pull requests in a GitHub repo.
+type Provider struct {
+	// API url, for GitHub Enterprise, https://api.github.com if empty
+	BaseURL string
+	Owner   string
+	Repo    string
+	// Token allowed to create pull requests and, for labels, to edit issues
+	Token  string
+	Client *http.Client
+	// If Token is a json web token of an app rather than an access token
+	bearer bool
+}
+
+// New returns a Provider of the repo owner/repo on github.com.
+func New(owner, repo, token string) *Provider {
+	return &Provider{Owner: owner, Repo: repo, Token: token}
+}
+
+// OpenReview opens a pull request of r.Branch into r.Base, labeled with
+// r.Labels, returning its url.
+func (p *Provider) OpenReview(ctx context.Context, r gitfs.ReviewRequest) (string, error) {
+	var pr struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := p.call(ctx, http.MethodPost, fmt.Sprintf("/repos/%v/%v/pulls", p.Owner, p.Repo), map[string]string{
+		"title": r.Title,
+		"head":  r.Branch,
+		"base":  r.Base,
+		"body":  r.Body,
+	}, &pr); err != nil {
+		return "", errors.Wrapf(err, "error creating pull request")
+	}
+
+	if len(r.Labels) > 0 {
+		if err := p.call(ctx, http.MethodPost, fmt.Sprintf("/repos/%v/%v/issues/%v/labels", p.Owner, p.Repo, pr.Number), map[string][]string{
+			"labels": r.Labels,
+		}, nil); err != nil {
+			return pr.HTMLURL, errors.Wrapf(err, "error labeling pull request %v", pr.HTMLURL)
+		}
+	}
+	return pr.HTMLURL, nil
+}
+
+// FindReview returns the url of the open pull request of branch into base,
+// empty if there is none.
+func (p *Provider) FindReview(ctx context.Context, branch, base string) (string, error) {
+	q := url.Values{"state": {"open"}, "head": {p.Owner + ":" + branch}, "base": {base}}
+	var prs []struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := p.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%v/%v/pulls?%v", p.Owner, p.Repo, q.Encode()), nil, &prs); err != nil {
+		return "", errors.Wrapf(err, "error listing pull requests")
+	}
+	if len(prs) == 0 {
+		return "", nil
+	}
+	return prs[0].HTMLURL, nil
+}
+
+// call sends req as json, unless nil, to the api path, decoding the
+// response into resp unless nil.
+func (p *Provider) call(ctx context.Context, method, path string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
+	hreq, err := http.NewRequest(method, strings.TrimRight(base, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	hreq = hreq.WithContext(ctx)
+	hreq.Header.Set("Accept", "application/vnd.github+json")
+	hreq.Header.Set("Content-Type", "application/json")
+	if p.bearer {
+		hreq.Header.Set("Authorization", "Bearer "+p.Token)
+	} else {
+		hreq.Header.Set("Authorization", "token "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	data, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+	if hresp.StatusCode/100 != 2 {
+		return errors.Errorf("github api returned %v: %s", hresp.Status, bytes.TrimSpace(data))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
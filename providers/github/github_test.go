@@ -0,0 +1,70 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+)
+
+func TestFindReview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/repos/org/repo/pulls" {
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+		q := r.URL.Query()
+		if q.Get("head") == "org:gitfs/abc" && q.Get("base") == "main" && q.Get("state") == "open" {
+			json.NewEncoder(w).Encode([]map[string]string{{"html_url": "https://github.com/org/repo/pull/1"}})
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	p := &Provider{BaseURL: srv.URL, Owner: "org", Repo: "repo", Token: "t"}
+	url, err := p.FindReview(context.Background(), "gitfs/abc", "main")
+	if err != nil || url != "https://github.com/org/repo/pull/1" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+	url, err = p.FindReview(context.Background(), "gitfs/def", "main")
+	if err != nil || url != "" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+}
+
+func TestOpenReview(t *testing.T) {
+	var labeled []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token t" {
+			t.Errorf("got auth %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/repos/org/repo/pulls":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["head"] != "gitfs/abc" || req["base"] != "main" {
+				t.Errorf("got request %v", req)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"number": 7, "html_url": "https://github.com/org/repo/pull/7"})
+		case "/repos/org/repo/issues/7/labels":
+			var req map[string][]string
+			json.NewDecoder(r.Body).Decode(&req)
+			labeled = req["labels"]
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	}))
+	defer srv.Close()
+
+	p := &Provider{BaseURL: srv.URL, Owner: "org", Repo: "repo", Token: "t"}
+	url, err := p.OpenReview(context.Background(), gitfs.ReviewRequest{Branch: "gitfs/abc", Base: "main", Labels: []string{"bot"}})
+	if err != nil || url != "https://github.com/org/repo/pull/7" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+	if len(labeled) != 1 || labeled[0] != "bot" {
+		t.Fatalf("labeled %v", labeled)
+	}
+}
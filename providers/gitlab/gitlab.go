@@ -0,0 +1,116 @@
+// Package gitlab opens GitLab merge requests of the branches gitfs Sync
+// pushes, see gitfs.Config.SetReviewProvider.
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/iamjinlei/gitfs"
+	"github.com/pkg/errors"
+)
+
+const defaultBaseURL = "https://gitlab.com"
+
+// Provider opens merge requests in a GitLab project.
+type Provider struct {
+	// Url of the GitLab instance, https://gitlab.com if empty
+	BaseURL string
+	// Numeric id or full path of the project, e.g. "group/project"
+	Project string
+	// Token with api scope
+	Token  string
+	Client *http.Client
+	// If the source branch is removed once merged
+	RemoveSourceBranch bool
+}
+
+// New returns a Provider of project on gitlab.com.
+func New(project, token string) *Provider {
+	return &Provider{Project: project, Token: token}
+}
+
+// OpenReview opens a merge request of r.Branch into r.Base, labeled with
+// r.Labels, returning its url.
+func (p *Provider) OpenReview(ctx context.Context, r gitfs.ReviewRequest) (string, error) {
+	var mr struct {
+		WebURL string `json:"web_url"`
+	}
+	if err := p.call(ctx, http.MethodPost, "/merge_requests", map[string]interface{}{
+		"source_branch":        r.Branch,
+		"target_branch":        r.Base,
+		"title":                r.Title,
+		"description":          r.Body,
+		"labels":               strings.Join(r.Labels, ","),
+		"remove_source_branch": p.RemoveSourceBranch,
+	}, &mr); err != nil {
+		return "", errors.Wrapf(err, "error creating merge request")
+	}
+	return mr.WebURL, nil
+}
+
+// FindReview returns the url of the open merge request of branch into
+// base, empty if there is none.
+func (p *Provider) FindReview(ctx context.Context, branch, base string) (string, error) {
+	q := url.Values{"state": {"opened"}, "source_branch": {branch}, "target_branch": {base}}
+	var mrs []struct {
+		WebURL string `json:"web_url"`
+	}
+	if err := p.call(ctx, http.MethodGet, "/merge_requests?"+q.Encode(), nil, &mrs); err != nil {
+		return "", errors.Wrapf(err, "error listing merge requests")
+	}
+	if len(mrs) == 0 {
+		return "", nil
+	}
+	return mrs[0].WebURL, nil
+}
+
+// call sends req as json, unless nil, to the path of the project api,
+// decoding the response into resp.
+func (p *Provider) call(ctx context.Context, method, path string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
+	u := strings.TrimRight(base, "/") + "/api/v4/projects/" + url.PathEscape(p.Project) + path
+	hreq, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	hreq = hreq.WithContext(ctx)
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("PRIVATE-TOKEN", p.Token)
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	data, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+	if hresp.StatusCode/100 != 2 {
+		return errors.Errorf("gitlab api returned %v: %s", hresp.Status, bytes.TrimSpace(data))
+	}
+	return errors.Wrapf(json.Unmarshal(data, resp), "error decoding response")
+}
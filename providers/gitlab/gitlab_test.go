@@ -0,0 +1,57 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iamjinlei/gitfs"
+)
+
+func TestFindReview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.EscapedPath() != "/api/v4/projects/group%2Fproject/merge_requests" {
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+		q := r.URL.Query()
+		if q.Get("source_branch") == "gitfs/abc" && q.Get("target_branch") == "main" && q.Get("state") == "opened" {
+			json.NewEncoder(w).Encode([]map[string]string{{"web_url": "https://gitlab.com/group/project/-/merge_requests/1"}})
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	p := &Provider{BaseURL: srv.URL, Project: "group/project", Token: "t"}
+	url, err := p.FindReview(context.Background(), "gitfs/abc", "main")
+	if err != nil || url != "https://gitlab.com/group/project/-/merge_requests/1" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+	url, err = p.FindReview(context.Background(), "gitfs/def", "main")
+	if err != nil || url != "" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+}
+
+func TestOpenReview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("PRIVATE-TOKEN") != "t" {
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["source_branch"] != "gitfs/abc" || req["labels"] != "a,b" {
+			t.Errorf("got request %v", req)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"web_url": "https://gitlab.com/group/project/-/merge_requests/2"})
+	}))
+	defer srv.Close()
+
+	p := &Provider{BaseURL: srv.URL, Project: "group/project", Token: "t"}
+	url, err := p.OpenReview(context.Background(), gitfs.ReviewRequest{Branch: "gitfs/abc", Base: "main", Labels: []string{"a", "b"}})
+	if err != nil || url != "https://gitlab.com/group/project/-/merge_requests/2" {
+		t.Fatalf("got %q, %v", url, err)
+	}
+}
@@ -0,0 +1,69 @@
+package gitfs
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func parseProxyUrl(proxyUrl string) (*url.URL, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing proxy url %v", proxyUrl)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return u, nil
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %v", u.Scheme)
+	}
+}
+
+// newHTTPTransport returns the http(s) transport of a remote, routing
+// requests through proxyUrl, or through the proxy from the environment if
+// proxyUrl is empty.
+func newHTTPTransport(proxyUrl string) (transport.Transport, error) {
+	proxy := proxyFromEnvironment
+	if proxyUrl != "" {
+		u, err := parseProxyUrl(proxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	return githttp.NewClient(&http.Client{
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}), nil
+}
+
+// proxyFromEnvironment returns the proxy from HTTPS_PROXY/HTTP_PROXY, else
+// the one from ALL_PROXY.
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if u, err := http.ProxyFromEnvironment(req); u != nil || err != nil {
+		return u, err
+	}
+
+	for _, env := range []string{"ALL_PROXY", "all_proxy"} {
+		if v := os.Getenv(env); v != "" {
+			return parseProxyUrl(v)
+		}
+	}
+	return nil, nil
+}
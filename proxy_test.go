@@ -0,0 +1,54 @@
+package gitfs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// countingServer counts the requests it serves, failing every one.
+type countingServer struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (s *countingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hosts = append(s.hosts, r.Host)
+	s.mu.Unlock()
+	http.NotFound(w, r)
+}
+
+func (s *countingServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.hosts)
+}
+
+func TestProxyPerInstance(t *testing.T) {
+	remote, proxy := &countingServer{}, &countingServer{}
+	remoteSrv, proxySrv := httptest.NewServer(remote), httptest.NewServer(proxy)
+	defer remoteSrv.Close()
+	defer proxySrv.Close()
+	url := remoteSrv.URL + "/repo.git"
+
+	if _, err := New(context.Background(), NewConfig().SetUrl(url).UseMemFs().SetProxy(proxySrv.URL)); err == nil {
+		t.Fatal("cloned from a failing server")
+	}
+	if proxy.count() == 0 || remote.count() != 0 {
+		t.Fatalf("proxied clone got %v requests to the proxy, %v to the remote", proxy.count(), remote.count())
+	}
+	if proxy.hosts[0] != remoteSrv.Listener.Addr().String() {
+		t.Fatalf("proxy got a request for %v", proxy.hosts[0])
+	}
+
+	proxied := proxy.count()
+	if _, err := New(context.Background(), NewConfig().SetUrl(url).UseMemFs()); err == nil {
+		t.Fatal("cloned from a failing server")
+	}
+	if proxy.count() != proxied || remote.count() == 0 {
+		t.Fatalf("clone without proxy got %v requests to the proxy, %v to the remote", proxy.count()-proxied, remote.count())
+	}
+}
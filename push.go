@@ -0,0 +1,383 @@
+package gitfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	// Objects larger than this are sent whole, diffing them is too slow
+	maxDeltaSize = 16 << 20
+	// Servers asking for packs without external delta bases advertise it,
+	// go-git has no constant for it
+	noThinCapability capability.Capability = "no-thin"
+)
+
+// pushStats counts what a push transferred.
+type pushStats struct {
+	objects int
+	deltas  int
+	bytes   int64
+}
+
+// pushBranch force pushes the branch to origin like Push, sending a thin
+// pack: objects changed since the commit origin has are sent as deltas
+// against their version at the same path there, so small changes to large
+// files and trees cost little. Servers advertising no-thin get a self
+// contained pack instead, as do file remotes: go-git serves them in
+// process, and its receive-pack fails to resolve delta bases outside the
+// pack when writing it to disk.
+func (g *Git) pushBranch(ctx context.Context) (stats pushStats, err error) {
+	branch := plumbing.NewBranchReferenceName(g.branchName())
+	specs := []string{"+" + branch.String() + ":" + branch.String()}
+	ctx, end := g.traceContext(ctx, "gitfs.Push")
+	defer end(&err)
+	defer g.pushHook(time.Now(), specs)(&err)
+
+	if g.noRemote {
+		return stats, ErrNoRemote
+	}
+	local, err := g.repo.Reference(branch, true)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error reading branch %v", branch.Short())
+	}
+	remote, err := g.repo.Remote("origin")
+	if err != nil {
+		return stats, errors.Wrapf(err, "error getting remote origin")
+	}
+
+	done, err := g.limiter.begin(ctx)
+	if err != nil {
+		return stats, err
+	}
+	defer done(&err)
+	auth, err := g.authMethod(ctx)
+	if err != nil {
+		return stats, err
+	}
+	ep, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return stats, errors.Wrapf(err, "error parsing remote url")
+	}
+	t, err := client.NewClient(ep)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error creating client")
+	}
+	sess, err := t.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error connecting to remote")
+	}
+	defer ioutil.CheckClose(sess, &err)
+
+	ar, err := sess.AdvertisedReferences()
+	if err != nil {
+		return stats, errors.Wrapf(err, "error listing remote refs")
+	}
+	refs, err := ar.AllReferences()
+	if err != nil {
+		return stats, errors.Wrapf(err, "error listing remote refs")
+	}
+	var old plumbing.Hash
+	if ref, ok := refs[branch]; ok {
+		old = ref.Hash()
+	}
+	if old == local.Hash() {
+		return stats, git.NoErrAlreadyUpToDate
+	}
+
+	req := packp.NewReferenceUpdateRequestFromCapabilities(ar.Capabilities)
+	req.Progress = os.Stdout
+	if ar.Capabilities.Supports(capability.Sideband64k) {
+		req.Capabilities.Set(capability.Sideband64k)
+	} else if ar.Capabilities.Supports(capability.Sideband) {
+		req.Capabilities.Set(capability.Sideband)
+	}
+	req.Commands = []*packp.Command{{Name: branch, Old: old, New: local.Hash()}}
+
+	var haves []plumbing.Hash
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			haves = append(haves, ref.Hash())
+		}
+	}
+	haves = append(haves, ar.Shallows...)
+	hashes, err := revlist.Objects(g.repo.Storer, []plumbing.Hash{local.Hash()}, haves)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error listing objects to push")
+	}
+
+	var bases map[plumbing.Hash]plumbing.Hash
+	if ep.Protocol != "file" && !ar.Capabilities.Supports(noThinCapability) {
+		if bases, err = g.deltaBases(old, local.Hash()); err != nil {
+			return stats, err
+		}
+	}
+
+	rd, wr := io.Pipe()
+	req.Packfile = rd
+	encoded := make(chan error, 1)
+	go func() {
+		var err error
+		if bases != nil {
+			err = writeThinPack(wr, g.repo.Storer, hashes, bases, &stats)
+		} else {
+			err = writePack(wr, g.repo, hashes, &stats)
+		}
+		wr.CloseWithError(err)
+		encoded <- err
+	}()
+
+	rs, err := sess.ReceivePack(ctx, req)
+	if err != nil {
+		rd.Close()
+		<-encoded
+		return stats, errors.Wrapf(err, "error pushing to remote")
+	}
+	if err := <-encoded; err != nil {
+		return stats, errors.Wrapf(err, "error encoding pack")
+	}
+	if rs != nil {
+		if err := rs.Error(); err != nil {
+			return stats, errors.Wrapf(err, "remote rejected push")
+		}
+	}
+
+	tracking := plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", branch.Short()), local.Hash())
+	if err := g.repo.Storer.SetReference(tracking); err != nil {
+		return stats, errors.Wrapf(err, "error updating remote branch")
+	}
+	return stats, nil
+}
+
+// deltaBases maps the blobs and trees of the tree of commit cur to their
+// version at the same path in the tree of commit old, where they differ.
+// It is empty if old is missing locally, e.g. pushed by someone else.
+func (g *Git) deltaBases(old, cur plumbing.Hash) (map[plumbing.Hash]plumbing.Hash, error) {
+	bases := map[plumbing.Hash]plumbing.Hash{}
+	if old.IsZero() {
+		return bases, nil
+	}
+	oldCommit, err := g.repo.CommitObject(old)
+	if err == plumbing.ErrObjectNotFound {
+		return bases, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error reading commit %v", old)
+	}
+	oldTree, err := oldCommit.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading tree of %v", old)
+	}
+	curCommit, err := g.repo.CommitObject(cur)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading commit %v", cur)
+	}
+	curTree, err := curCommit.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading tree of %v", cur)
+	}
+
+	oldEntries, err := treeEntries(oldTree)
+	if err != nil {
+		return nil, err
+	}
+	curEntries, err := treeEntries(curTree)
+	if err != nil {
+		return nil, err
+	}
+	if oldTree.Hash != curTree.Hash {
+		bases[curTree.Hash] = oldTree.Hash
+	}
+	for p, e := range curEntries {
+		o, ok := oldEntries[p]
+		if ok && o.Hash != e.Hash && (o.Mode == filemode.Dir) == (e.Mode == filemode.Dir) && e.Mode != filemode.Submodule {
+			bases[e.Hash] = o.Hash
+		}
+	}
+	return bases, nil
+}
+
+// treeEntries returns the entries under t by path.
+func treeEntries(t *object.Tree) (map[string]object.TreeEntry, error) {
+	entries := map[string]object.TreeEntry{}
+	w := object.NewTreeWalker(t, true, nil)
+	defer w.Close()
+	for {
+		p, e, err := w.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error walking tree %v", t.Hash)
+		}
+		entries[p] = e
+	}
+}
+
+// writePack writes a self contained pack of hashes to w, with deltas
+// between the objects sent only.
+func writePack(w io.Writer, repo *git.Repository, hashes []plumbing.Hash, stats *pushStats) error {
+	cfg, err := repo.Storer.Config()
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: w}
+	if _, err := packfile.NewEncoder(cw, repo.Storer, true).Encode(hashes, cfg.Pack.Window); err != nil {
+		return err
+	}
+	stats.objects, stats.bytes = len(hashes), cw.n
+	return nil
+}
+
+// writeThinPack writes a pack of hashes to w, sending objects with a base
+// in bases as a ref delta against it where that is much smaller. The bases
+// aren't sent, the remote has them.
+func writeThinPack(w io.Writer, s storer.EncodedObjectStorer, hashes []plumbing.Hash, bases map[plumbing.Hash]plumbing.Hash, stats *pushStats) error {
+	p := &packWriter{w: &countingWriter{w: w}, sum: sha1.New()}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(hashes)))
+	if err := p.write(header); err != nil {
+		return err
+	}
+
+	for _, h := range hashes {
+		obj, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return errors.Wrapf(err, "error reading object %v", h)
+		}
+		content, err := objectContent(obj)
+		if err != nil {
+			return err
+		}
+		base := bases[h]
+		delta, err := thinDelta(s, content, base)
+		if err != nil {
+			return err
+		}
+		if delta != nil {
+			stats.deltas++
+			err = p.entry(plumbing.REFDeltaObject, append(base[:], delta...), len(delta))
+		} else {
+			err = p.entry(obj.Type(), content, len(content))
+		}
+		if err != nil {
+			return err
+		}
+		stats.objects++
+	}
+
+	if _, err := p.w.Write(p.sum.Sum(nil)); err != nil {
+		return err
+	}
+	stats.bytes = p.w.n
+	return nil
+}
+
+// thinDelta returns content as a delta against base, nil if there is no
+// base or the delta saves too little to be worth it.
+func thinDelta(s storer.EncodedObjectStorer, content []byte, base plumbing.Hash) ([]byte, error) {
+	if base.IsZero() || len(content) > maxDeltaSize {
+		return nil, nil
+	}
+	obj, err := s.EncodedObject(plumbing.AnyObject, base)
+	if err == plumbing.ErrObjectNotFound || err == nil && obj.Size() > maxDeltaSize {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error reading object %v", base)
+	}
+	src, err := objectContent(obj)
+	if err != nil {
+		return nil, err
+	}
+	// like git, a delta must at least halve the object, counting the base
+	// hash it names
+	delta := packfile.DiffDelta(src, content)
+	if len(delta)+len(base) >= len(content)/2 {
+		return nil, nil
+	}
+	return delta, nil
+}
+
+func objectContent(obj plumbing.EncodedObject) ([]byte, error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading object %v", obj.Hash())
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, errors.Wrapf(err, "error reading object %v", obj.Hash())
+	}
+	return buf.Bytes(), nil
+}
+
+// packWriter writes pack entries, summing them for the pack trailer.
+type packWriter struct {
+	w   *countingWriter
+	sum hash.Hash
+}
+
+func (p *packWriter) write(b []byte) error {
+	p.sum.Write(b)
+	_, err := p.w.Write(b)
+	return err
+}
+
+// entry writes an object of type t and size, data being its content, or
+// for ref deltas the base hash followed by the delta.
+func (p *packWriter) entry(t plumbing.ObjectType, data []byte, size int) error {
+	header := []byte{byte(t)<<4 | byte(size&0x0f)}
+	for size >>= 4; size > 0; size >>= 7 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+	}
+	if t == plumbing.REFDeltaObject {
+		header, data = append(header, data[:len(plumbing.ZeroHash)]...), data[len(plumbing.ZeroHash):]
+	}
+	if err := p.write(header); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return p.write(buf.Bytes())
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
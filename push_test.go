@@ -0,0 +1,104 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// bigText returns a text of many lines, pushed as a delta once edited.
+func bigText() string {
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("line %v", i))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestPushToLocalRemote(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "remote.git")
+	data := bigText()
+
+	s := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+	repo, err := git.Init(s, memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(wt.Filesystem, "big.txt", []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("big.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "remote", Email: "remote@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := New(context.Background(), NewConfig().SetUrl("file://"+path).UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(data, "line 1000\n", "edited\n", 1)
+	writeTestFile(t, g, "big.txt", edited)
+	res, err := g.syncContext(context.Background(), SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a thin pack would fail
+	if res.PushedObjects == 0 {
+		t.Fatal("pushed no objects")
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.File("big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Contents(); err != nil || got != edited {
+		t.Fatalf("remote got big.txt of %v bytes, %v", len(got), err)
+	}
+}
+
+func TestPushSendsThinPack(t *testing.T) {
+	data := bigText()
+	r := newTestRemote(t, map[string]string{"big.txt": data})
+	g := r.clone(nil)
+
+	edited := strings.Replace(data, "line 1000\n", "edited\n", 1)
+	writeTestFile(t, g, "big.txt", edited)
+	res, err := g.syncContext(context.Background(), SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PushedDeltas == 0 {
+		t.Fatalf("pushed %v objects, none as delta", res.PushedObjects)
+	}
+	if got, _ := r.file("big.txt"); got != edited {
+		t.Fatalf("remote got big.txt of %v bytes", len(got))
+	}
+}
@@ -0,0 +1,179 @@
+package gitfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// QuotaError is returned when a write or sync exceeds a configured limit.
+type QuotaError struct {
+	// Path of the file exceeding the max file size, empty if the repo quota
+	// is exceeded.
+	Path  string
+	Size  int64
+	Limit int64
+}
+
+func (e *QuotaError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("repo size %v exceeds quota %v", e.Size, e.Limit)
+	}
+	return fmt.Sprintf("file %v size %v exceeds max file size %v", e.Path, e.Size, e.Limit)
+}
+
+// IsQuotaError reports whether err is, or wraps, a QuotaError.
+func IsQuotaError(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+	for err != nil {
+		if _, ok := err.(*QuotaError); ok {
+			return true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+func (g *GitFs) checkFileSize(filename string, size int64) error {
+	if g.maxFileSize > 0 && size > g.maxFileSize {
+		return &QuotaError{Path: filename, Size: size, Limit: g.maxFileSize}
+	}
+	return nil
+}
+
+// checkQuota returns a QuotaError if the repo exceeds the repo quota.
+func (g *GitFs) checkQuota() error {
+	return g.checkQuotaGrowth(0)
+}
+
+// checkQuotaGrowth returns a QuotaError if the repo would exceed the repo
+// quota once grown by delta bytes.
+func (g *GitFs) checkQuotaGrowth(delta int64) error {
+	if g.repoQuota <= 0 {
+		return nil
+	}
+	size, err := g.repoSize()
+	if err != nil {
+		return err
+	}
+	if size+delta > g.repoQuota {
+		return &QuotaError{Size: size + delta, Limit: g.repoQuota}
+	}
+	return nil
+}
+
+// repoSize returns the bytes the worktree and the objects of the repo take
+// up.
+func (g *GitFs) repoSize() (int64, error) {
+	size, err := filesSize(g.fs, "/")
+	if err != nil {
+		return 0, errors.Wrapf(err, "error measuring the worktree")
+	}
+	objects, err := g.git.objectsSize()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error measuring the objects")
+	}
+	return size + objects, nil
+}
+
+// objectsSize returns the size of the objects dir of repos stored on a
+// filesystem, the total size of the objects otherwise.
+func (g *Git) objectsSize() (int64, error) {
+	s := g.repo.Storer
+	if w, ok := s.(*worktreeStorer); ok {
+		s = w.Storer
+	}
+	if fs, ok := s.(interface{ Filesystem() billy.Filesystem }); ok {
+		return filesSize(fs.Filesystem(), "objects")
+	}
+
+	iter, err := s.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		size += obj.Size()
+		return nil
+	})
+	return size, err
+}
+
+// filesSize returns the total size of the files under root of fs.
+func filesSize(fs billy.Filesystem, root string) (int64, error) {
+	var size int64
+	err := walk(fs, root, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// limitFile wraps f so writes growing it beyond the max file size fail.
+func (g *GitFs) limitFile(f billy.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	if g.maxFileSize <= 0 {
+		return f, nil
+	}
+	return &limitedFile{File: f, g: g}, nil
+}
+
+type limitedFile struct {
+	billy.File
+	g *GitFs
+}
+
+func (f *limitedFile) Write(p []byte) (int, error) {
+	pos, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.g.checkFileSize(f.Name(), pos+int64(len(p))); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *limitedFile) Truncate(size int64) error {
+	if err := f.g.checkFileSize(f.Name(), size); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
+
+// sizeLimiter fails writes to w growing the file name beyond the max file
+// size.
+type sizeLimiter struct {
+	w    io.Writer
+	g    *GitFs
+	name string
+	n    int64
+}
+
+func (l *sizeLimiter) Write(p []byte) (int, error) {
+	if err := l.g.checkFileSize(l.name, l.n+int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
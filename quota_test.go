@@ -0,0 +1,68 @@
+package gitfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestApplyChecksQuotaBeforeWriting(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", strings.Repeat("a", 100))
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	size, err := g.repoSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := g.git.objectsSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects < 100 || size < 200 {
+		t.Fatalf("got repo size %v with objects %v", size, objects)
+	}
+
+	g.repoQuota = size + 100
+	err = g.Apply(func(w Writer) error {
+		return w.WriteFile("b.txt", []byte(strings.Repeat("b", 80)), 0644)
+	}, "add b")
+	if !IsQuotaError(err) {
+		t.Fatalf("apply over quota got %v", err)
+	}
+	if _, err := g.Stat("b.txt"); err == nil {
+		t.Fatal("b.txt written over quota")
+	}
+
+	// replacing a.txt by a smaller file fits
+	if err := g.Apply(func(w Writer) error {
+		return w.WriteFile("a.txt", []byte(strings.Repeat("b", 80)), 0644)
+	}, "replace a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncQuotaCountsObjects(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", strings.Repeat("a", 100))
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blob of a.txt is still in the repo
+	g.repoQuota = 50
+	writeTestFile(t, g, "b.txt", "b")
+	if err := g.Sync(false); !IsQuotaError(err) {
+		t.Fatalf("sync over quota got %v", err)
+	}
+}
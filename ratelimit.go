@@ -0,0 +1,194 @@
+package gitfs
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// ErrRateLimited is returned by remote operations the RateLimiter didn't
+// admit within its max wait, and by those the git host rejected with 429
+// Too Many Requests. They are worth retrying later, see Health.RetryAt.
+var ErrRateLimited = errors.New("remote operations are rate limited")
+
+const (
+	defaultRateLimitWait = time.Minute
+	// Pause after a 429 without Retry-After
+	defaultRetryAfter = time.Minute
+)
+
+// RateLimiter bounds the clones, pulls, fetches, pushes and ref listings
+// of the repos sharing it, so many repos of a Manager, or busy auto-syncs,
+// don't hammer the git host. Operations wait for their turn up to the max
+// wait and fail with ErrRateLimited beyond it. A 429 from the git host
+// pauses all operations for its Retry-After.
+type RateLimiter struct {
+	// Time between operations, 0 for no rate limit
+	interval time.Duration
+	burst    int
+	maxWait  time.Duration
+	// Buffered to the max concurrent operations, nil for no limit
+	slots chan struct{}
+
+	mu sync.Mutex
+	// When the next operation is due if no burst is left
+	next   time.Time
+	paused time.Time
+}
+
+// NewRateLimiter returns a RateLimiter admitting rate operations per
+// second on average, in bursts of up to burst, with at most concurrency of
+// them in flight. rate <= 0 and concurrency <= 0 disable the respective
+// limit. Operations wait at most a minute, see SetMaxWait.
+func NewRateLimiter(rate float64, burst, concurrency int) *RateLimiter {
+	l := &RateLimiter{burst: burst, maxWait: defaultRateLimitWait}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// SetMaxWait sets how long operations wait for their turn before failing
+// with ErrRateLimited, 0 to fail right away.
+func (l *RateLimiter) SetMaxWait(d time.Duration) *RateLimiter {
+	l.maxWait = d
+	return l
+}
+
+// acquire waits until an operation is admitted, returning the func
+// releasing it once done. A nil RateLimiter admits everything.
+func (l *RateLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	deadline := time.Now().Add(l.maxWait)
+
+	start, err := l.reserve(deadline)
+	if err != nil {
+		return nil, err
+	}
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	default:
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-timer.C:
+		return nil, errors.Wrapf(ErrRateLimited, "%v remote operations already in flight", cap(l.slots))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reserve books the earliest start allowed by the rate, failing if it is
+// past deadline.
+func (l *RateLimiter) reserve(deadline time.Time) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	start := now
+	if l.paused.After(start) {
+		start = l.paused
+	}
+	if l.interval > 0 {
+		if allowed := l.next.Add(-time.Duration(l.burst-1) * l.interval); allowed.After(start) {
+			start = allowed
+		}
+	}
+	if start.After(deadline) {
+		return time.Time{}, errors.Wrapf(ErrRateLimited, "next remote operation allowed in %v", start.Sub(now).Round(time.Millisecond))
+	}
+
+	if l.interval > 0 {
+		if l.next.Before(start) {
+			l.next = start
+		}
+		l.next = l.next.Add(l.interval)
+	}
+	return start, nil
+}
+
+// pause delays all operations by d.
+func (l *RateLimiter) pause(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.paused) {
+		l.paused = until
+	}
+}
+
+// hostRateLimit returns the Retry-After of err if it is a 429 response of
+// an http remote.
+func hostRateLimit(err error) (retryAfter time.Duration, ok bool) {
+	uerr, ok := errors.Cause(err).(*plumbing.UnexpectedError)
+	if !ok {
+		return 0, false
+	}
+	herr, ok := uerr.Err.(*githttp.Err)
+	if !ok || herr.StatusCode() != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	h := herr.Response.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t), true
+	}
+	return defaultRetryAfter, true
+}
+
+// begin waits for l to admit a remote operation. The returned func must be
+// called with the error of the operation once done: it releases l and
+// turns host rate limiting into ErrRateLimited. A nil RateLimiter admits
+// everything, but still converts host rate limiting.
+func (l *RateLimiter) begin(ctx context.Context) (done func(*error), err error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(errp *error) {
+		release()
+		if *errp == nil {
+			return
+		}
+		if retryAfter, ok := hostRateLimit(*errp); ok {
+			l.pause(retryAfter)
+			*errp = errors.Wrapf(ErrRateLimited, "%v, retry after %v", *errp, retryAfter.Round(time.Second))
+		}
+	}, nil
+}
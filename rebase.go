@@ -0,0 +1,184 @@
+package gitfs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Fetch downloads the branches of the remote repo into the remote branches,
+// e.g. "origin/master", leaving the current branch and worktree untouched,
+// see Rebase.
+func (g *GitFs) Fetch() error {
+	if g.git.noRemote {
+		return ErrNoRemote
+	}
+	if err := g.git.FetchRefSpecs(nil); err != nil {
+		return errors.Wrapf(err, "error fetching changes from origin")
+	}
+	return nil
+}
+
+// Rebase replays the commits of the current branch missing from onto, a
+// revision like "origin/master" or a hash, on top of it, one commit each,
+// for a linear history: Fetch, Rebase, then Sync pushes the result. An empty
+// onto is the remote branch of the current one. Files are compared whole,
+// if a replayed commit changes a file changed differently by onto, Rebase
+// fails with ErrConflict, changing nothing. Commits already in onto are
+// dropped, merge commits can't be replayed, and replayed commits are signed
+// anew with the configured signing key, if any. The commits of onto are
+// verified against the trust policy like those of Pull. Files with changes
+// not yet synced must not be touched.
+func (g *GitFs) Rebase(onto string) (err error) {
+	defer g.git.trace("gitfs.Rebase")(&err)
+
+	if onto == "" {
+		onto = plumbing.NewRemoteReferenceName("origin", g.git.branchName()).String()
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	base, err := g.git.resolveCommit(onto)
+	if err != nil {
+		return err
+	}
+	if err := g.git.verifyIncoming(base); err != nil {
+		return err
+	}
+	replay, err := g.git.commitsSince(base)
+	if err != nil {
+		return err
+	}
+	if g.git.headHash() == base.Hash || len(replay) > 0 && replay[0].NumParents() > 0 && replay[0].ParentHashes[0] == base.Hash {
+		// already on top of onto
+		return nil
+	}
+
+	files, err := commitFiles(base)
+	if err != nil {
+		return err
+	}
+	hash := base.Hash
+	for _, c := range replay {
+		if hash, err = g.git.replay(c, hash, files); err != nil {
+			return err
+		}
+	}
+
+	ours, err := g.git.headFiles()
+	if err != nil {
+		return err
+	}
+	s, err := g.git.status()
+	if err != nil {
+		return errors.Wrapf(err, "error getting status")
+	}
+	if err := unsynced("rebase", onto, s, changedFiles(ours, files)); err != nil {
+		return err
+	}
+	return g.git.fastForward(plumbing.NewBranchReferenceName(g.git.branchName()), hash)
+}
+
+// commitsSince returns the commits of HEAD missing from the history of
+// base, oldest first, following first parents.
+func (g *Git) commitsSince(base *object.Commit) ([]*object.Commit, error) {
+	head := g.headHash()
+	if head.IsZero() {
+		return nil, nil
+	}
+	known := map[plumbing.Hash]bool{}
+	if err := object.NewCommitPreorderIter(base, nil, nil).ForEach(func(c *object.Commit) error {
+		known[c.Hash] = true
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error walking history of %v", base.Hash)
+	}
+
+	var commits []*object.Commit
+	c, err := g.repo.CommitObject(head)
+	for err == nil && !known[c.Hash] {
+		if c.NumParents() > 1 {
+			return nil, errors.Errorf("%v is a merge commit, which can't be rebased", c.Hash)
+		}
+		commits = append([]*object.Commit{c}, commits...)
+		if c.NumParents() == 0 {
+			break
+		}
+		c, err = c.Parent(0)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error walking history")
+	}
+	return commits, nil
+}
+
+// replay applies the changes of c to files, the files of commit parent,
+// committing them on top of parent. It returns the new commit, parent
+// itself if c changes nothing parent lacks.
+func (g *Git) replay(c *object.Commit, parent plumbing.Hash, files map[string]bareEntry) (plumbing.Hash, error) {
+	to, err := commitFiles(c)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	from := map[string]bareEntry{}
+	if c.NumParents() > 0 {
+		p, err := c.Parent(0)
+		if err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "error reading parent of %v", c.Hash)
+		}
+		if from, err = commitFiles(p); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	var conflicts []string
+	applied := false
+	for p := range changedFiles(from, to) {
+		switch {
+		case files[p] == to[p]:
+			// already applied
+		case files[p] != from[p]:
+			conflicts = append(conflicts, p)
+		case to[p].hash.IsZero():
+			delete(files, p)
+			applied = true
+		default:
+			files[p] = to[p]
+			applied = true
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return plumbing.ZeroHash, errors.Wrapf(ErrConflict, "rebase of %v at %v", c.Hash, strings.Join(conflicts, ", "))
+	}
+	if !applied {
+		return parent, nil
+	}
+
+	tree, err := g.storeTree(files, "")
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error storing tree")
+	}
+	hash, err := g.storeSignedCommit(&object.Commit{
+		Author: c.Author,
+		Committer: object.Signature{
+			Name:  "gitfs",
+			Email: "gitfs@github.com",
+			When:  g.clock.Now(),
+		},
+		Message:      c.Message,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{parent},
+	})
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "error rebasing commit %v", c.Hash)
+	}
+	return hash, nil
+}
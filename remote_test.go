@@ -0,0 +1,174 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// testScheme serves the remotes of tests in process, like the gittest
+// package, which tests of this package can't import.
+const testScheme = "gitfs-test"
+
+var (
+	installTestScheme sync.Once
+	testRemotes       = &testLoader{repos: map[string]storer.Storer{}}
+	lastTestRemote    int64
+)
+
+type testLoader struct {
+	mu    sync.Mutex
+	repos map[string]storer.Storer
+}
+
+func (l *testLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.repos[ep.Host+ep.Path]
+	if !ok {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return s, nil
+}
+
+// testRemote is a repo kept in memory, served at url.
+type testRemote struct {
+	t    *testing.T
+	url  string
+	repo *git.Repository
+	fs   billy.Filesystem
+}
+
+// newTestRemote returns a remote whose master branch holds files in a
+// single commit.
+func newTestRemote(t *testing.T, files map[string]string) *testRemote {
+	t.Helper()
+	installTestScheme.Do(func() {
+		client.InstallProtocol(testScheme, server.NewClient(testRemotes))
+	})
+
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fmt.Sprintf("remote-%d/repo.git", atomic.AddInt64(&lastTestRemote, 1))
+	testRemotes.mu.Lock()
+	testRemotes.repos[key] = repo.Storer
+	testRemotes.mu.Unlock()
+
+	r := &testRemote{t: t, url: testScheme + "://" + key, repo: repo, fs: fs}
+	r.commit(files)
+	return r
+}
+
+// commit commits files on master, on top of what clients pushed.
+func (r *testRemote) commit(files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if _, err := r.repo.Head(); err == nil {
+		if err := wt.Reset(&git.ResetOptions{Mode: git.HardReset}); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	for p, data := range files {
+		if err := util.WriteFile(r.fs, p, []byte(data), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := wt.Add(p); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	h, err := wt.Commit("remote commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "remote", Email: "remote@example.com"},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return h
+}
+
+// file returns the content of path on master, with ok false if missing.
+func (r *testRemote) file(path string) (data string, ok bool) {
+	r.t.Helper()
+	ref, err := r.repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	c, err := r.repo.CommitObject(ref.Hash())
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	f, err := c.File(path)
+	if err == object.ErrFileNotFound {
+		return "", false
+	} else if err != nil {
+		r.t.Fatal(err)
+	}
+	data, err = f.Contents()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return data, true
+}
+
+// clone returns a GitFs of r configured by c, memFs unless set otherwise.
+func (r *testRemote) clone(c *Config) *GitFs {
+	r.t.Helper()
+	if c == nil {
+		c = NewConfig().UseMemFs()
+	}
+	g, err := New(context.Background(), c.SetUrl(r.url))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return g
+}
+
+// testDir returns a new temp dir, removed by the returned func.
+func testDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gitfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeTestFile writes data to name of g, failing t on error.
+func writeTestFile(t *testing.T, g *GitFs, name, data string) {
+	t.Helper()
+	if err := g.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFile returns the content of name of g, failing t on error.
+func readTestFile(t *testing.T, g *GitFs, name string) string {
+	t.Helper()
+	data, err := g.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
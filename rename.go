@@ -0,0 +1,309 @@
+package gitfs
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const (
+	defaultRenameScore = 50
+	// Most added files compared by content to removed ones, beyond which
+	// only files of equal content are paired, like git's renameLimit
+	renameLimit = 1000
+)
+
+// renameDetector pairs removed and added files of similar content.
+type renameDetector struct {
+	// Minimum similarity in percent, 0 for the default, < 0 to disable
+	score  int
+	copies bool
+}
+
+// fileRef is a file compared by the rename detector.
+type fileRef struct {
+	hash plumbing.Hash
+	read func() ([]byte, error)
+	data []byte
+}
+
+func (f *fileRef) content() ([]byte, error) {
+	if f.data == nil {
+		data, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+		f.data = data
+	}
+	return f.data, nil
+}
+
+type renamePair struct {
+	from, to string
+	score    int
+	copy     bool
+}
+
+// apply turns the Added changes of changes which are similar to a Deleted
+// one into Renamed from it, dropping the Deleted change. With copies, Added
+// changes equal to any file of old, or similar to a Modified one, become
+// Copied from it. old holds the files before the changes by path, cur the
+// files of Added changes after them.
+func (d renameDetector) apply(changes []Change, old, cur map[string]*fileRef) ([]Change, error) {
+	if d.score < 0 {
+		return changes, nil
+	}
+	score := d.score
+	if score == 0 {
+		score = defaultRenameScore
+	}
+
+	var deleted, added, modified []string
+	for _, c := range changes {
+		switch c.Status {
+		case Deleted:
+			deleted = append(deleted, c.Path)
+		case Added:
+			added = append(added, c.Path)
+		case Modified:
+			modified = append(modified, c.Path)
+		}
+	}
+	if len(added) == 0 || (len(deleted) == 0 && !d.copies) {
+		return changes, nil
+	}
+
+	pairs := map[string]renamePair{}
+	renamed := map[string]bool{}
+
+	// files of equal content first, as git does
+	byHash := map[plumbing.Hash][]string{}
+	for _, p := range deleted {
+		byHash[old[p].hash] = append(byHash[old[p].hash], p)
+	}
+	for _, p := range added {
+		h := cur[p].hash
+		if froms := byHash[h]; len(froms) > 0 {
+			byHash[h] = froms[1:]
+			pairs[p] = renamePair{from: froms[0], to: p, score: 100}
+			renamed[froms[0]] = true
+		}
+	}
+	if d.copies {
+		sources := map[plumbing.Hash]string{}
+		for p, f := range old {
+			if s, ok := sources[f.hash]; !ok || p < s {
+				sources[f.hash] = p
+			}
+		}
+		for _, p := range added {
+			if _, ok := pairs[p]; ok {
+				continue
+			}
+			if s, ok := sources[cur[p].hash]; ok && !isDeleted(s, deleted) {
+				pairs[p] = renamePair{from: s, to: p, score: 100, copy: true}
+			}
+		}
+	}
+
+	var candidates []renamePair
+	var unpaired []string
+	for _, p := range added {
+		if _, ok := pairs[p]; !ok {
+			unpaired = append(unpaired, p)
+		}
+	}
+	if score < 100 && len(unpaired) <= renameLimit {
+		var froms []renamePair
+		for _, p := range deleted {
+			if !renamed[p] {
+				froms = append(froms, renamePair{from: p})
+			}
+		}
+		if d.copies {
+			for _, p := range modified {
+				froms = append(froms, renamePair{from: p, copy: true})
+			}
+		}
+		for _, to := range unpaired {
+			for _, from := range froms {
+				s, err := similarity(old[from.from], cur[to])
+				if err != nil {
+					return nil, err
+				}
+				if s >= score {
+					candidates = append(candidates, renamePair{from: from.from, to: to, score: s, copy: from.copy})
+				}
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	for _, c := range candidates {
+		if _, ok := pairs[c.to]; ok || (!c.copy && renamed[c.from]) {
+			continue
+		}
+		pairs[c.to] = c
+		if !c.copy {
+			renamed[c.from] = true
+		}
+	}
+
+	var result []Change
+	for _, c := range changes {
+		if c.Status == Deleted && renamed[c.Path] {
+			continue
+		}
+		if pair, ok := pairs[c.Path]; ok && c.Status == Added {
+			c.OldPath = pair.from
+			c.Status = Renamed
+			if pair.copy {
+				c.Status = Copied
+			}
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func isDeleted(p string, deleted []string) bool {
+	for _, d := range deleted {
+		if d == p {
+			return true
+		}
+	}
+	return false
+}
+
+// similarity returns how much of the content of a and b is shared, in
+// percent of the larger one. Like git, content is compared by lines, and
+// empty files are similar to nothing.
+func similarity(a, b *fileRef) (int, error) {
+	da, err := a.content()
+	if err != nil {
+		return 0, err
+	}
+	db, err := b.content()
+	if err != nil {
+		return 0, err
+	}
+	max := len(da)
+	if len(db) > max {
+		max = len(db)
+	}
+	if len(da) == 0 || len(db) == 0 {
+		return 0, nil
+	}
+
+	lines := map[string]int{}
+	for _, l := range bytes.SplitAfter(da, []byte("\n")) {
+		lines[string(l)]++
+	}
+	common := 0
+	for _, l := range bytes.SplitAfter(db, []byte("\n")) {
+		if lines[string(l)] > 0 {
+			lines[string(l)]--
+			common += len(l)
+		}
+	}
+	return common * 100 / max, nil
+}
+
+// blobRefs returns the files of entries, read from the object store.
+func (g *Git) blobRefs(files map[string]bareEntry) map[string]*fileRef {
+	refs := map[string]*fileRef{}
+	for p, e := range files {
+		h := e.hash
+		refs[p] = &fileRef{hash: h, read: func() ([]byte, error) {
+			return g.readBlob(h)
+		}}
+	}
+	return refs
+}
+
+// readBlob returns the content of the blob h.
+func (g *Git) readBlob(h plumbing.Hash) ([]byte, error) {
+	blob, err := g.repo.BlobObject(h)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading blob %v", h)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading blob %v", h)
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, errors.Wrapf(err, "error reading blob %v", h)
+	}
+	return buf.Bytes(), nil
+}
+
+// fileStatusCode returns the single code describing fstatus, Unmodified if
+// neither the index nor the worktree changed.
+func fileStatusCode(fstatus *git.FileStatus) StatusCode {
+	if fstatus.Staging == git.Unmodified {
+		return StatusCode(byte(fstatus.Worktree))
+	} else if fstatus.Worktree == git.Unmodified {
+		return StatusCode(byte(fstatus.Staging))
+	} else if fstatus.Staging != fstatus.Worktree {
+		return Inconsistent
+	}
+	return StatusCode(byte(fstatus.Worktree))
+}
+
+// worktreeChanges returns the changes of the worktree against HEAD sorted
+// by path, with renames and copies detected.
+func (g *Git) worktreeChanges() ([]Change, error) {
+	s, err := g.status()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting status")
+	}
+
+	var changes []Change
+	var added []string
+	for p, fstatus := range s {
+		code := fileStatusCode(fstatus)
+		if code == Unmodified {
+			continue
+		}
+		if code == Untracked {
+			added = append(added, p)
+			code = Added
+		} else if code == Added {
+			added = append(added, p)
+		}
+		changes = append(changes, Change{Path: p, Status: code})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	head, err := g.headFiles()
+	if err != nil {
+		return nil, err
+	}
+	cur := map[string]*fileRef{}
+	for _, p := range added {
+		data, err := readFile(g.fs, p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %v", p)
+		}
+		cur[p] = &fileRef{hash: plumbing.ComputeHash(plumbing.BlobObject, data), data: data}
+	}
+	if changes, err = g.renames.apply(changes, g.blobRefs(head), cur); err != nil {
+		return nil, err
+	}
+
+	// untracked files which are not renames are reported as such
+	for i, c := range changes {
+		if c.Status == Added && s[c.Path].Staging == git.Untracked {
+			changes[i].Status = Untracked
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,96 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+const defaultReviewPrefix = "gitfs/"
+
+// ReviewRequest describes a pull or merge request to open.
+type ReviewRequest struct {
+	// Branch pushed with the changes, and the branch to merge them into
+	Branch string
+	Base   string
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// ReviewProvider opens pull or merge requests on a git host, see the
+// providers/github and providers/gitlab packages.
+type ReviewProvider interface {
+	// OpenReview opens a request to merge r.Branch, returning its url.
+	OpenReview(ctx context.Context, r ReviewRequest) (url string, err error)
+	// FindReview returns the url of the open request to merge branch into
+	// base, empty if there is none.
+	FindReview(ctx context.Context, branch, base string) (url string, err error)
+}
+
+// ReviewOptions configures the requests Sync opens, see
+// Config.SetReviewProvider.
+type ReviewOptions struct {
+	// Prefix of the pushed branches, followed by the commit hash, "gitfs/"
+	// if empty
+	BranchPrefix string
+	// Title of requests, the subject of the commit if empty
+	Title  string
+	Labels []string
+}
+
+// pushReview pushes HEAD to a branch of its own and opens a request to
+// merge it into the synced branch, once per commit: a request still open
+// for the branch, e.g. opened before a restart, is reused.
+func (g *GitFs) pushReview(ctx context.Context, res *SyncResult) error {
+	c, err := g.git.resolveCommit("")
+	if err != nil {
+		return err
+	}
+	if c.Hash == g.reviewed {
+		return nil
+	}
+
+	prefix := g.reviewOpts.BranchPrefix
+	if prefix == "" {
+		prefix = defaultReviewPrefix
+	}
+	branch := prefix + c.Hash.String()[:12]
+	spec := fmt.Sprintf("+refs/heads/%v:refs/heads/%v", g.git.branchName(), branch)
+	if err := g.git.pushRefs(ctx, []string{spec}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "error pushing review branch %v", branch)
+	}
+	res.Pushed = true
+	res.ReviewBranch = branch
+
+	url, err := g.review.FindReview(ctx, branch, g.git.branchName())
+	if err != nil {
+		return errors.Wrapf(err, "error finding review of %v", branch)
+	}
+	if url != "" {
+		res.ReviewURL = url
+		g.reviewed = c.Hash
+		return nil
+	}
+
+	title := g.reviewOpts.Title
+	if title == "" {
+		title = strings.SplitN(c.Message, "\n", 2)[0]
+	}
+	url, err = g.review.OpenReview(ctx, ReviewRequest{
+		Branch: branch,
+		Base:   g.git.branchName(),
+		Title:  title,
+		Body:   c.Message,
+		Labels: g.reviewOpts.Labels,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error opening review of %v", branch)
+	}
+	res.ReviewURL = url
+	g.reviewed = c.Hash
+	return nil
+}
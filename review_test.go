@@ -0,0 +1,53 @@
+package gitfs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// fakeReviews keeps the open reviews of branches like a git host.
+type fakeReviews struct {
+	open   map[string]string
+	opened int
+}
+
+func (f *fakeReviews) OpenReview(ctx context.Context, r ReviewRequest) (string, error) {
+	if _, ok := f.open[r.Branch]; ok {
+		return "", fmt.Errorf("a review of %v is already open", r.Branch)
+	}
+	f.opened++
+	f.open[r.Branch] = fmt.Sprintf("https://example.com/reviews/%v", f.opened)
+	return f.open[r.Branch], nil
+}
+
+func (f *fakeReviews) FindReview(ctx context.Context, branch, base string) (string, error) {
+	return f.open[branch], nil
+}
+
+func TestReviewReusedAfterRestart(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	reviews := &fakeReviews{open: map[string]string{}}
+	g := r.clone(NewConfig().UseMemFs().SetReviewProvider(reviews, ReviewOptions{}))
+
+	writeTestFile(t, g, "a.txt", "a")
+	res, err := g.SyncWithResult(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ReviewURL == "" || reviews.opened != 1 {
+		t.Fatalf("review not opened: %+v", res)
+	}
+
+	// a restart forgets the reviews opened
+	g.reviewed = plumbing.ZeroHash
+	again, err := g.SyncWithResult(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviews.opened != 1 || again.ReviewURL != res.ReviewURL {
+		t.Fatalf("review opened again: %+v", again)
+	}
+}
@@ -0,0 +1,99 @@
+package gitfs
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Scope returns a view of the dir path like Chroot, whose syncs commit only
+// the changes under path, with messages prefixed by path, e.g.
+// "services/billing: gitfs sync - ...". Components sharing a repo each sync
+// their own scope without committing half written files of the others.
+// Syncs of scopes can't purge, and pull and push the whole branch as usual.
+func (g *GitFs) Scope(path string) (*GitFs, error) {
+	s, err := g.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.scope = s.repoPath(""); s.scope == "" {
+		return nil, errors.Errorf("scope %v is the repo root", path)
+	}
+	return s, nil
+}
+
+// top returns the view of the repo root sharing the state and scope of g,
+// which repo wide operations like Sync run on.
+func (g *GitFs) top() *GitFs {
+	if g.root == "" {
+		return g
+	}
+	t := *g
+	t.fs, t.root = g.git.fs, ""
+	return &t
+}
+
+// inScope reports whether path, relative to the repo root, is synced by g.
+func (g *GitFs) inScope(path string) bool {
+	return g.scope == "" || underAny(path, []string{g.scope})
+}
+
+// scopeStatus returns the status of the changed files synced by g.
+func (g *GitFs) scopeStatus() (map[string]StatusCode, error) {
+	files, err := g.git.GetStatus()
+	if err != nil || g.scope == "" {
+		return files, err
+	}
+	for p := range files {
+		if !g.inScope(p) {
+			delete(files, p)
+		}
+	}
+	return files, nil
+}
+
+// stageSync stages the changes synced by g.
+func (g *GitFs) stageSync() error {
+	if g.scope == "" {
+		return g.git.AddAll()
+	}
+	return g.git.Stage([]string{g.scope})
+}
+
+// syncChanges returns the changes a sync by g commits, once staged.
+func (g *GitFs) syncChanges() ([]Change, error) {
+	changes, err := g.git.stagedChanges(g.scope == "", false)
+	if err != nil || g.scope == "" {
+		return changes, err
+	}
+	return scopeChanges(changes, g.inScope), nil
+}
+
+// hasSyncChanges reports whether a sync by g, once staged, would commit
+// anything.
+func (g *GitFs) hasSyncChanges() (bool, error) {
+	if g.scope == "" {
+		return g.git.hasChanges()
+	}
+	changes, err := g.syncChanges()
+	return len(changes) > 0, err
+}
+
+// commitSync commits the changes synced by g with msg, traced in ctx,
+// those of other scopes staged meanwhile stay staged.
+func (g *GitFs) commitSync(ctx context.Context, msg string) error {
+	if g.scope == "" {
+		return g.git.commitPaths(ctx, msg, true, nil, nil)
+	}
+	return g.git.commitPaths(ctx, g.scope+": "+msg, false, []string{g.scope}, nil)
+}
+
+func scopeChanges(changes []Change, in func(string) bool) []Change {
+	var scoped []Change
+	for _, c := range changes {
+		if in(c.Path) || c.OldPath != "" && in(c.OldPath) {
+			scoped = append(scoped, c)
+		}
+	}
+	return scoped
+}
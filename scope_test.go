@@ -0,0 +1,45 @@
+package gitfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestScopeSyncCommitsOnlyItsScope(t *testing.T) {
+	for name, c := range map[string]*Config{
+		"worktree": NewConfig().UseMemFs(),
+		"bare":     NewConfig().UseMemFs().Bare("master"),
+	} {
+		r := newTestRemote(t, map[string]string{"README": "readme"})
+		g := r.clone(c.RefuseLargeBinaries(8))
+		a, err := g.Scope("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := g.Scope("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFile(t, b, "blob.bin", "\x00"+strings.Repeat("x", 64))
+		if err := b.Sync(false); errors.Cause(err) != ErrLargeBinary {
+			t.Fatalf("%v: sync of b got %v", name, err)
+		}
+		writeTestFile(t, a, "a.txt", "a")
+		if err := a.Sync(false); err != nil {
+			t.Fatalf("%v: sync of a: %v", name, err)
+		}
+
+		if _, ok := r.file("a/a.txt"); !ok {
+			t.Fatalf("%v: a/a.txt not pushed", name)
+		}
+		if _, ok := r.file("b/blob.bin"); ok {
+			t.Fatalf("%v: refused b/blob.bin pushed by sync of a", name)
+		}
+		if data := readTestFile(t, b, "blob.bin"); data[0] != 0 {
+			t.Fatalf("%v: b/blob.bin changed to %q", name, data)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package gitfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+	sshSigHashAlg   = "sha512"
+	sshSigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd       = "-----END SSH SIGNATURE-----"
+)
+
+var (
+	ErrUnsignedCommit  = errors.New("commit is not signed")
+	ErrUntrustedCommit = errors.New("commit is not signed by a trusted key")
+)
+
+// PublicKey verifies commit signatures.
+type PublicKey interface {
+	// Verify returns nil if signature is a valid signature of payload made
+	// by this key.
+	Verify(payload []byte, signature string) error
+}
+
+type pgpPublicKey struct {
+	keyring openpgp.EntityList
+}
+
+// PGPPublicKey returns a PublicKey verifying gpg signatures made by e.
+func PGPPublicKey(e *openpgp.Entity) PublicKey {
+	return &pgpPublicKey{keyring: openpgp.EntityList{e}}
+}
+
+func (k *pgpPublicKey) Verify(payload []byte, signature string) error {
+	_, err := openpgp.CheckArmoredDetachedSignature(k.keyring, bytes.NewReader(payload), strings.NewReader(signature))
+	return err
+}
+
+type sshPublicKey struct {
+	key ssh.PublicKey
+}
+
+// SSHPublicKey returns a PublicKey verifying ssh signatures made by key.
+func SSHPublicKey(key ssh.PublicKey) PublicKey {
+	return &sshPublicKey{key: key}
+}
+
+// sshSigSigned is the blob an ssh signature is computed over.
+type sshSigSigned struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+// sshSigEnvelope is the armored content of an ssh signature.
+type sshSigEnvelope struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+func sshSignedBlob(payload []byte) []byte {
+	h := sha512.Sum512(payload)
+	return append([]byte(sshSigMagic), ssh.Marshal(sshSigSigned{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlg,
+		Hash:          string(h[:]),
+	})...)
+}
+
+func (k *sshPublicKey) Verify(payload []byte, signature string) error {
+	if !strings.HasPrefix(signature, sshSigBegin) {
+		return errors.New("not an ssh signature")
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(signature, sshSigBegin)), sshSigEnd))
+	raw, err := base64.StdEncoding.DecodeString(strings.Replace(body, "\n", "", -1))
+	if err != nil {
+		return errors.Wrapf(err, "error decoding ssh signature")
+	}
+	if !bytes.HasPrefix(raw, []byte(sshSigMagic)) {
+		return errors.New("invalid ssh signature")
+	}
+
+	var env sshSigEnvelope
+	if err := ssh.Unmarshal(raw[len(sshSigMagic):], &env); err != nil {
+		return errors.Wrapf(err, "error parsing ssh signature")
+	}
+	if env.Namespace != sshSigNamespace || env.HashAlgorithm != sshSigHashAlg {
+		return errors.New("unsupported ssh signature")
+	}
+	if !bytes.Equal([]byte(env.PublicKey), k.key.Marshal()) {
+		return errors.New("ssh signature made by another key")
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal([]byte(env.Signature), &sig); err != nil {
+		return errors.Wrapf(err, "error parsing ssh signature")
+	}
+	return k.key.Verify(sshSignedBlob(payload), &sig)
+}
+
+func sshSign(signer ssh.Signer, payload []byte) (string, error) {
+	blob := sshSignedBlob(payload)
+
+	var sig *ssh.Signature
+	var err error
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, blob, ssh.SigAlgoRSASHA2512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, blob)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	raw := append([]byte(sshSigMagic), ssh.Marshal(sshSigEnvelope{
+		Version:       1,
+		PublicKey:     string(signer.PublicKey().Marshal()),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlg,
+		Signature:     string(ssh.Marshal(sig)),
+	})...)
+
+	enc := base64.StdEncoding.EncodeToString(raw)
+	lines := []string{sshSigBegin}
+	for len(enc) > 70 {
+		lines = append(lines, enc[:70])
+		enc = enc[70:]
+	}
+	lines = append(lines, enc, sshSigEnd)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func commitPayload(c *object.Commit) ([]byte, error) {
+	obj := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(obj); err != nil {
+		return nil, err
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// storeSignedCommit stores c, signed with the configured pgp or ssh key
+// like the commits of Sync, e.g. those rewritten by Rebase.
+func (g *Git) storeSignedCommit(c *object.Commit) (plumbing.Hash, error) {
+	if g.pgpKey != nil || g.sshSigner != nil {
+		payload, err := commitPayload(c)
+		if err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "error encoding commit")
+		}
+		if g.pgpKey != nil {
+			var sig bytes.Buffer
+			if err := openpgp.ArmoredDetachSign(&sig, g.pgpKey, bytes.NewReader(payload), nil); err != nil {
+				return plumbing.ZeroHash, errors.Wrapf(err, "error signing commit")
+			}
+			c.PGPSignature = sig.String()
+		} else if c.PGPSignature, err = sshSign(g.sshSigner, payload); err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "error signing commit")
+		}
+	}
+	return g.storeCommit(c)
+}
+
+// sshSignCommit replaces commit hash, which must be HEAD, by a copy signed
+// with the configured ssh key.
+func (g *Git) sshSignCommit(hash plumbing.Hash) error {
+	c, err := g.repo.CommitObject(hash)
+	if err != nil {
+		return errors.Wrapf(err, "error reading commit %v", hash)
+	}
+
+	payload, err := commitPayload(c)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding commit %v", hash)
+	}
+
+	if c.PGPSignature, err = sshSign(g.sshSigner, payload); err != nil {
+		return errors.Wrapf(err, "error signing commit %v", hash)
+	}
+
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return errors.Wrapf(err, "error encoding signed commit")
+	}
+	signed, err := g.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return errors.Wrapf(err, "error storing signed commit")
+	}
+
+	head, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+	name := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		name = head.Target()
+	}
+	return g.repo.Storer.SetReference(plumbing.NewHashReference(name, signed))
+}
+
+type trustPolicy struct {
+	keys          []PublicKey
+	requireSigned bool
+}
+
+func (p trustPolicy) verify(c *object.Commit) error {
+	if c.PGPSignature == "" {
+		if p.requireSigned {
+			return errors.Wrapf(ErrUnsignedCommit, "commit %v", c.Hash)
+		}
+		return nil
+	}
+
+	payload, err := commitPayload(c)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding commit %v", c.Hash)
+	}
+
+	for _, k := range p.keys {
+		if k.Verify(payload, c.PGPSignature) == nil {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrUntrustedCommit, "commit %v", c.Hash)
+}
+
+// pullVerified fetches origin, verifies all commits of the remote branch
+// which are not yet part of HEAD against the trust policy, and only then
+// fast-forwards HEAD onto the verified remote commit. Bare repos, which
+// go-git can't pull, are pulled this way too, verifying only if trusted
+// keys are set.
+func (g *Git) pullVerified(ctx context.Context) error {
+	auth, err := g.authMethod(ctx)
+	if err != nil {
+		return err
+	}
+	if err := g.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
+		Progress:   os.Stdout,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "error fetching changes from origin")
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+
+	remote, err := g.repo.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if err != nil {
+		return errors.Wrapf(err, "error reading remote branch")
+	}
+	if remote.Hash() == head.Hash() {
+		return nil
+	}
+
+	headCommit, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return errors.Wrapf(err, "error reading HEAD commit")
+	}
+	known := map[plumbing.Hash]bool{}
+	if err := object.NewCommitPreorderIter(headCommit, nil, nil).ForEach(func(c *object.Commit) error {
+		known[c.Hash] = true
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "error walking local history")
+	}
+	if known[remote.Hash()] {
+		// remote is behind
+		return nil
+	}
+
+	remoteCommit, err := g.repo.CommitObject(remote.Hash())
+	if err != nil {
+		return errors.Wrapf(err, "error reading remote commit")
+	}
+	ff := false
+	if err := object.NewCommitPreorderIter(remoteCommit, known, nil).ForEach(func(c *object.Commit) error {
+		for _, p := range c.ParentHashes {
+			ff = ff || p == head.Hash()
+		}
+		if len(g.trust.keys) == 0 {
+			return nil
+		}
+		return g.trust.verify(c)
+	}); err != nil {
+		return err
+	}
+	if !ff {
+		return errors.Wrapf(git.ErrNonFastForwardUpdate, "error pulling changes from origin")
+	}
+
+	return g.fastForward(head.Name(), remote.Hash())
+}
+
+// verifyIncoming verifies the commits of the history of c which are not
+// yet part of HEAD against the trust policy, like pullVerified, before
+// they are brought into the current branch, if trusted keys are set.
+func (g *Git) verifyIncoming(c *object.Commit) error {
+	if len(g.trust.keys) == 0 {
+		return nil
+	}
+	known := map[plumbing.Hash]bool{}
+	if head := g.headHash(); !head.IsZero() {
+		headCommit, err := g.repo.CommitObject(head)
+		if err != nil {
+			return errors.Wrapf(err, "error reading HEAD commit")
+		}
+		if err := object.NewCommitPreorderIter(headCommit, nil, nil).ForEach(func(c *object.Commit) error {
+			known[c.Hash] = true
+			return nil
+		}); err != nil {
+			return errors.Wrapf(err, "error walking local history")
+		}
+	}
+	return object.NewCommitPreorderIter(c, known, nil).ForEach(g.trust.verify)
+}
+
+// fastForward moves branch name, checked out, to hash, updating the
+// worktree.
+func (g *Git) fastForward(name plumbing.ReferenceName, hash plumbing.Hash) error {
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+		return errors.Wrapf(err, "error updating %v", name)
+	}
+	if g.bare != nil {
+		return g.bare.reload()
+	}
+	if err := g.wt.Reset(&git.ResetOptions{
+		Mode:   git.MergeReset,
+		Commit: hash,
+	}); err != nil {
+		return errors.Wrapf(err, "error updating worktree")
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package gitfs
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// testSSHKey returns an ssh signer and the PublicKey verifying it.
+func testSSHKey(t *testing.T) (ssh.Signer, PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, SSHPublicKey(signer.PublicKey())
+}
+
+func TestTrustPolicyRequiresKeys(t *testing.T) {
+	if err := NewConfig().NoRemote().UseMemFs().SetTrustPolicy(nil, true).Valid(); err == nil {
+		t.Fatal("signed commits required with no trusted key")
+	}
+	if err := NewConfig().NoRemote().UseMemFs().VerifySignatures().Valid(); err == nil {
+		t.Fatal("signatures verified with no trusted key")
+	}
+	if err := NewConfig().NoRemote().UseMemFs().SetTrustPolicy(nil, false).Valid(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRebaseSignsReplayedCommits(t *testing.T) {
+	signer, key := testSSHKey(t)
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetSSHSigningKey(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "base.txt", "base")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	base := g.git.headHash()
+	writeTestFile(t, g, "onto.txt", "onto")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	onto := g.git.headHash()
+	if err := g.git.wt.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "ours.txt", "ours")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Rebase(onto.String()); err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.git.repo.CommitObject(g.git.headHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.NumParents() != 1 || c.ParentHashes[0] != onto {
+		t.Fatalf("commit %v not replayed onto %v", c.Hash, onto)
+	}
+	trust := trustPolicy{keys: []PublicKey{key}, requireSigned: true}
+	if err := trust.verify(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSquashSignsRewrittenCommits(t *testing.T) {
+	signer, key := testSSHKey(t)
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetSSHSigningKey(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeTestFile(t, g, name, name)
+		if err := g.Sync(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, err := g.git.squash(1); err != nil || !ok {
+		t.Fatalf("history not squashed: %v", err)
+	}
+	trust := trustPolicy{keys: []PublicKey{key}, requireSigned: true}
+	c, err := g.git.repo.CommitObject(g.git.headHash())
+	for i := 0; i < 2; i++ {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := trust.verify(c); err != nil {
+			t.Fatalf("commit %v: %v", i, err)
+		}
+		if c.NumParents() > 0 {
+			c, err = c.Parent(0)
+		}
+	}
+}
+
+// unsignedBranch commits name on a new branch of the same name, off HEAD,
+// without signing it, then resets the current branch back.
+func unsignedBranch(t *testing.T, g *GitFs, name string) plumbing.Hash {
+	t.Helper()
+	base := g.git.headHash()
+	signer := g.git.sshSigner
+	g.git.sshSigner = nil
+	writeTestFile(t, g, name+".txt", name)
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	g.git.sshSigner = signer
+	hash := g.git.headHash()
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash)
+	if err := g.git.repo.Storer.SetReference(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.git.wt.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestMergeVerifiesTrustPolicy(t *testing.T) {
+	signer, key := testSSHKey(t)
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().
+		SetSSHSigningKey(signer).SetTrustPolicy([]PublicKey{key}, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "base.txt", "base")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	base := g.git.headHash()
+	unsignedBranch(t, g, "dev")
+
+	if err := g.Merge("dev", MergeFail); errors.Cause(err) != ErrUnsignedCommit {
+		t.Fatalf("got %v merging unsigned commits", err)
+	}
+	if g.git.headHash() != base {
+		t.Fatal("unsigned commits merged")
+	}
+}
+
+func TestRebaseVerifiesTrustPolicy(t *testing.T) {
+	signer, key := testSSHKey(t)
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().
+		SetSSHSigningKey(signer).SetTrustPolicy([]PublicKey{key}, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "base.txt", "base")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	onto := unsignedBranch(t, g, "onto")
+	writeTestFile(t, g, "ours.txt", "ours")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	head := g.git.headHash()
+
+	if err := g.Rebase(onto.String()); errors.Cause(err) != ErrUnsignedCommit {
+		t.Fatalf("got %v rebasing onto unsigned commits", err)
+	}
+	if g.git.headHash() != head {
+		t.Fatal("rebased onto unsigned commits")
+	}
+}
@@ -0,0 +1,161 @@
+package gitfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+const bundleSignature = "# v2 git bundle"
+
+// Snapshot writes all refs and objects of the repo to w as a git bundle,
+// which git clone and Restore accept. Changes not yet synced are not part
+// of it. Shallow clones can't be snapshotted.
+func (g *GitFs) Snapshot(w io.Writer) (err error) {
+	defer g.git.trace("gitfs.Snapshot")(&err)
+
+	s := g.git.repo.Storer
+	if shallow, err := s.Shallow(); err != nil {
+		return errors.Wrapf(err, "error reading shallow commits")
+	} else if len(shallow) > 0 {
+		return errors.New("snapshot of a shallow clone is not supported")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, bundleSignature)
+	if head, err := g.git.repo.Head(); err == nil {
+		fmt.Fprintf(bw, "%v %v\n", head.Hash(), plumbing.HEAD)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return errors.Wrapf(err, "error reading HEAD")
+	}
+
+	refs, err := s.IterReferences()
+	if err != nil {
+		return errors.Wrapf(err, "error reading refs")
+	}
+	if err := refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && ref.Name() != plumbing.HEAD {
+			fmt.Fprintf(bw, "%v %v\n", ref.Hash(), ref.Name())
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "error reading refs")
+	}
+	fmt.Fprintln(bw)
+
+	var hashes []plumbing.Hash
+	objs, err := s.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return errors.Wrapf(err, "error reading objects")
+	}
+	if err := objs.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "error reading objects")
+	}
+
+	if _, err := packfile.NewEncoder(bw, s, false).Encode(hashes, 10); err != nil {
+		return errors.Wrapf(err, "error encoding objects")
+	}
+	return errors.Wrapf(bw.Flush(), "error writing snapshot")
+}
+
+// Restore recreates a repo from a git bundle read from r, e.g. one written
+// by Snapshot, where c places it. The repo must not exist yet. With a repo
+// url, it becomes the origin of the restored repo, nothing is fetched.
+func Restore(ctx context.Context, r io.Reader, c *Config) (*GitFs, error) {
+	c.bundle = r
+	return New(ctx, c)
+}
+
+// initFromBundle inits a repo in s and fs holding the refs and objects of
+// the bundle read from r. HEAD points to the branch the bundle HEAD is
+// at, preferring master, and is checked out.
+func initFromBundle(s storage.Storer, fs billy.Filesystem, r io.Reader, repoUrl string) (*git.Repository, error) {
+	repo, err := git.Init(s, fs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initing repo")
+	}
+	if repoUrl != "" {
+		if _, err := repo.CreateRemote(&config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{repoUrl},
+		}); err != nil {
+			return nil, errors.Wrapf(err, "error adding remote origin")
+		}
+	}
+
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != bundleSignature {
+		return nil, errors.New("error reading bundle: not a v2 git bundle")
+	}
+
+	var head plumbing.Hash
+	var refs []*plumbing.Reference
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading bundle refs")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "-") {
+			return nil, errors.New("bundles with prerequisites are not supported")
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("error reading bundle ref %q", line)
+		}
+		h := plumbing.NewHash(fields[0])
+		if fields[1] == string(plumbing.HEAD) {
+			head = h
+		} else {
+			refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(fields[1]), h))
+		}
+	}
+
+	if err := packfile.UpdateObjectStorage(s, br); err != nil {
+		return nil, errors.Wrapf(err, "error reading bundle objects")
+	}
+
+	headRef := plumbing.Master
+	found := false
+	for _, ref := range refs {
+		if err := s.SetReference(ref); err != nil {
+			return nil, errors.Wrapf(err, "error restoring %v", ref.Name())
+		}
+		if ref.Name().IsBranch() && ref.Hash() == head && (!found || ref.Name() == plumbing.Master) {
+			headRef, found = ref.Name(), true
+		}
+	}
+
+	if err := s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, headRef)); err != nil {
+		return nil, errors.Wrapf(err, "error restoring HEAD")
+	}
+
+	if fs != nil && !head.IsZero() {
+		wt, err := repo.Worktree()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading worktree")
+		}
+		if err := wt.Reset(&git.ResetOptions{Mode: git.HardReset, Commit: head}); err != nil {
+			return nil, errors.Wrapf(err, "error checking out %v", head)
+		}
+	}
+	return repo, nil
+}
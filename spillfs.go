@@ -0,0 +1,334 @@
+package gitfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// spillFs keeps files in memory as long as their total size stays within
+// budget. Files closed after the budget is exceeded are moved to disk. The
+// directory tree itself always lives in memory.
+type spillFs struct {
+	mem    billy.Filesystem
+	disk   billy.Filesystem
+	budget int64
+
+	mu     sync.Mutex
+	used   int64
+	sizes  map[string]int64
+	onDisk map[string]bool
+}
+
+func newSpillFs(disk billy.Filesystem, budget int64) *spillFs {
+	return &spillFs{
+		mem:    memfs.New(),
+		disk:   disk,
+		budget: budget,
+		sizes:  map[string]int64{},
+		onDisk: map[string]bool{},
+	}
+}
+
+// removeDisk removes the temp dir files are spilled to, with all of them.
+func (fs *spillFs) removeDisk() error {
+	return os.RemoveAll(fs.disk.Root())
+}
+
+func spillPath(filename string) string {
+	return filepath.Join(string(filepath.Separator), filename)
+}
+
+func (fs *spillFs) isOnDisk(filename string) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.onDisk[spillPath(filename)]
+}
+
+// maxSymlinks bounds the symlinks followed by resolve, like ELOOP.
+const maxSymlinks = 40
+
+// resolve returns the file filename refers to once its symlinks, kept in
+// memory, are followed to a spilled file, filename itself otherwise.
+func (fs *spillFs) resolve(filename string) string {
+	p := spillPath(filename)
+	for i := 0; i < maxSymlinks; i++ {
+		if fs.isOnDisk(p) {
+			return p
+		}
+		fi, err := fs.mem.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+		target, err := fs.mem.Readlink(p)
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		p = spillPath(target)
+	}
+	return filename
+}
+
+func (fs *spillFs) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *spillFs) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *spillFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if p := fs.resolve(filename); fs.isOnDisk(p) {
+		return fs.disk.OpenFile(p, flag, perm)
+	}
+
+	f, err := fs.mem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f, nil
+	}
+	return &spillFile{File: f, fs: fs, path: spillPath(filename)}, nil
+}
+
+// closed updates the memory accounting of a written file, spilling it to
+// disk if the budget is exceeded.
+func (fs *spillFs) closed(path string) error {
+	fi, err := fs.mem.Stat(path)
+	if err != nil {
+		// removed or renamed meanwhile
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.used += fi.Size() - fs.sizes[path]
+	fs.sizes[path] = fi.Size()
+	if fs.used <= fs.budget || fs.onDisk[path] {
+		return nil
+	}
+
+	src, err := fs.mem.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := fs.disk.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "error spilling %v", path)
+	}
+	dst, err := fs.disk.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return errors.Wrapf(err, "error spilling %v", path)
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error spilling %v", path)
+	}
+
+	if err := fs.mem.Remove(path); err != nil {
+		return err
+	}
+	fs.used -= fi.Size()
+	delete(fs.sizes, path)
+	fs.onDisk[path] = true
+	return nil
+}
+
+func (fs *spillFs) Stat(filename string) (os.FileInfo, error) {
+	if p := fs.resolve(filename); fs.isOnDisk(p) {
+		return fs.disk.Stat(p)
+	}
+	return fs.mem.Stat(filename)
+}
+
+func (fs *spillFs) Lstat(filename string) (os.FileInfo, error) {
+	if fs.isOnDisk(filename) {
+		return fs.disk.Lstat(filename)
+	}
+	return fs.mem.Lstat(filename)
+}
+
+func (fs *spillFs) ReadDir(path string) ([]os.FileInfo, error) {
+	files, err := fs.mem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	var spilled []string
+	dir := spillPath(path)
+	for p := range fs.onDisk {
+		if filepath.Dir(p) == dir {
+			spilled = append(spilled, p)
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, p := range spilled {
+		fi, err := fs.disk.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fi)
+	}
+	return files, nil
+}
+
+func (fs *spillFs) MkdirAll(filename string, perm os.FileMode) error {
+	// spilled files are missing from the tree in memory
+	fs.mu.Lock()
+	for p := spillPath(filename); p != filepath.Dir(p); p = filepath.Dir(p) {
+		if fs.onDisk[p] {
+			fs.mu.Unlock()
+			return &os.PathError{Op: "mkdir", Path: filename, Err: syscall.ENOTDIR}
+		}
+	}
+	fs.mu.Unlock()
+	return fs.mem.MkdirAll(filename, perm)
+}
+
+func (fs *spillFs) Rename(from, to string) error {
+	from, to = spillPath(from), spillPath(to)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.onDisk[from] {
+		if err := fs.disk.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := fs.disk.Rename(from, to); err != nil {
+			return err
+		}
+		if _, err := fs.mem.Stat(to); err == nil {
+			// spilled file replaces an in-memory one
+			fs.mem.Remove(to)
+			fs.used -= fs.sizes[to]
+			delete(fs.sizes, to)
+		}
+		delete(fs.onDisk, from)
+		fs.onDisk[to] = true
+		return nil
+	}
+
+	if err := fs.mem.Rename(from, to); err != nil {
+		return err
+	}
+	if fs.onDisk[to] {
+		// in-memory file replaces a spilled one
+		fs.disk.Remove(to)
+		delete(fs.onDisk, to)
+	}
+
+	moved := map[string]string{}
+	for p := range fs.sizes {
+		if p == from || strings.HasPrefix(p, from+string(filepath.Separator)) {
+			moved[p] = to + strings.TrimPrefix(p, from)
+		}
+	}
+	for p, q := range moved {
+		fs.sizes[q] = fs.sizes[p]
+		delete(fs.sizes, p)
+	}
+
+	moved = map[string]string{}
+	for p := range fs.onDisk {
+		if strings.HasPrefix(p, from+string(filepath.Separator)) {
+			moved[p] = to + strings.TrimPrefix(p, from)
+		}
+	}
+	for p, q := range moved {
+		if err := fs.disk.MkdirAll(filepath.Dir(q), 0755); err != nil {
+			return err
+		}
+		if err := fs.disk.Rename(p, q); err != nil {
+			return err
+		}
+		delete(fs.onDisk, p)
+		fs.onDisk[q] = true
+	}
+	return nil
+}
+
+func (fs *spillFs) Remove(filename string) error {
+	path := spillPath(filename)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.onDisk[path] {
+		if err := fs.disk.Remove(path); err != nil {
+			return err
+		}
+		delete(fs.onDisk, path)
+		return nil
+	}
+
+	for p := range fs.onDisk {
+		if filepath.Dir(p) == path {
+			return errors.Errorf("dir: %s contains files", path)
+		}
+	}
+	if err := fs.mem.Remove(path); err != nil {
+		return err
+	}
+	fs.used -= fs.sizes[path]
+	delete(fs.sizes, path)
+	return nil
+}
+
+func (fs *spillFs) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+func (fs *spillFs) Join(elem ...string) string {
+	return fs.mem.Join(elem...)
+}
+
+func (fs *spillFs) Symlink(target, link string) error {
+	return fs.mem.Symlink(target, link)
+}
+
+func (fs *spillFs) Readlink(link string) (string, error) {
+	return fs.mem.Readlink(link)
+}
+
+func (fs *spillFs) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, path), nil
+}
+
+func (fs *spillFs) Root() string {
+	return string(filepath.Separator)
+}
+
+type spillFile struct {
+	billy.File
+	fs   *spillFs
+	path string
+}
+
+func (f *spillFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return f.fs.closed(f.path)
+}
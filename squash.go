@@ -0,0 +1,108 @@
+package gitfs
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var ErrSquashNotConfirmed = errors.New("squashing history force pushes the remote branch and must be confirmed")
+
+// SquashHistory collapses all but the last keepLast commits of the current
+// branch into a single baseline commit, then force pushes the rewritten
+// branch. As this discards history on the remote repo for every client, it
+// fails with ErrSquashNotConfirmed unless confirm is set. Only first parents
+// are followed. The baseline and kept commits are rewritten, signed anew
+// with the configured signing key, if any.
+func (g *GitFs) SquashHistory(keepLast int, confirm bool) error {
+	if !confirm {
+		return ErrSquashNotConfirmed
+	}
+	if keepLast < 0 {
+		return errors.New("negative number of commits to keep")
+	}
+
+	rewritten, err := g.git.squash(keepLast)
+	if err != nil {
+		return err
+	}
+	if !rewritten {
+		return nil
+	}
+
+	if _, err := g.push(g.git.ctx); err != nil {
+		return errors.Wrapf(err, "error pushing squashed history to remote repo")
+	}
+	return nil
+}
+
+// squash rewrites the current branch as described by SquashHistory. It
+// reports false if the history is already short enough.
+func (g *Git) squash(keepLast int) (bool, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		return false, errors.Wrapf(err, "error reading HEAD")
+	}
+
+	// history[0] is HEAD, history[len-1] the root commit
+	var history []*object.Commit
+	c, err := g.repo.CommitObject(head.Hash())
+	for err == nil {
+		history = append(history, c)
+		if c.NumParents() == 0 {
+			break
+		}
+		c, err = c.Parent(0)
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error walking history")
+	}
+	if len(history) <= keepLast+1 {
+		return false, nil
+	}
+
+	base := history[keepLast]
+	sig := object.Signature{
+		Name:  "gitfs",
+		Email: "gitfs@github.com",
+		When:  base.Committer.When,
+	}
+	hash, err := g.storeSignedCommit(&object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   fmt.Sprintf("gitfs squash - history up to %v", base.Hash),
+		TreeHash:  base.TreeHash,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "error creating baseline commit")
+	}
+
+	for i := keepLast - 1; i >= 0; i-- {
+		c := history[i]
+		hash, err = g.storeSignedCommit(&object.Commit{
+			Author:       c.Author,
+			Committer:    c.Committer,
+			Message:      c.Message,
+			TreeHash:     c.TreeHash,
+			ParentHashes: []plumbing.Hash{hash},
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, "error rewriting commit %v", c.Hash)
+		}
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), hash)); err != nil {
+		return false, errors.Wrapf(err, "error updating %v", head.Name())
+	}
+	return true, nil
+}
+
+func (g *Git) storeCommit(c *object.Commit) (plumbing.Hash, error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
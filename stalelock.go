@@ -0,0 +1,165 @@
+package gitfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+var ErrLocked = errors.New("repo is locked by git")
+
+// gitLocks are the lock files git takes in the git dir while changing the
+// index and refs.
+var gitLocks = []string{"index.lock", "HEAD.lock", "config.lock", "packed-refs.lock", "shallow.lock"}
+
+// checkGitLocks fails with ErrLocked if a git process sharing the git dir
+// holds a lock, or a crashed one left it.
+func (l *lockFiles) checkGitLocks() error {
+	if l.dir == nil {
+		return nil
+	}
+	for _, name := range gitLocks {
+		if _, err := l.dir.Stat(name); err == nil {
+			return errors.Wrapf(ErrLocked, "%v exists, if no git process is running it is stale, see BreakStaleLocks", name)
+		} else if !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error checking %v", name)
+		}
+	}
+	return nil
+}
+
+// BreakStaleLocks removes the lock files older than olderThan, those of
+// LockRepo and LockFile as well as those git leaves in the git dir when
+// it crashes, so Sync no longer fails with ErrLocked or ErrLockTimeout.
+// Locks of processes no longer running on this host are removed whatever
+// their age. It returns the lock files removed.
+func (g *GitFs) BreakStaleLocks(olderThan time.Duration) ([]string, error) {
+	l := g.git.locks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var removed []string
+	breakStale := func(fs billy.Filesystem, prefix string, p string, stale bool) error {
+		if !stale {
+			return nil
+		}
+		if err := fs.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error removing lock file %v", p)
+		}
+		removed = append(removed, path.Join(prefix, p))
+		return nil
+	}
+
+	if err := walkLocks(l.fs, "", func(p string, fi os.FileInfo) error {
+		owner, ok := readLockOwner(l.fs, p)
+		if !ok {
+			return breakStale(l.fs, lockDir, p, now.Sub(fi.ModTime()) >= olderThan)
+		}
+		return breakStale(l.fs, lockDir, p, owner.gone() || now.Sub(owner.at) >= olderThan)
+	}); err != nil {
+		return removed, err
+	}
+
+	if l.dir == nil {
+		return removed, nil
+	}
+	for _, name := range gitLocks {
+		fi, err := l.dir.Stat(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return removed, errors.Wrapf(err, "error checking %v", name)
+		}
+		if err := breakStale(l.dir, "", name, now.Sub(fi.ModTime()) >= olderThan); err != nil {
+			return removed, err
+		}
+	}
+	err := walkLocks(l.dir, "refs", func(p string, fi os.FileInfo) error {
+		return breakStale(l.dir, "", p, now.Sub(fi.ModTime()) >= olderThan)
+	})
+	return removed, err
+}
+
+// walkLocks calls fn for every lock file under dir of fs.
+func walkLocks(fs billy.Filesystem, dir string, fn func(p string, fi os.FileInfo) error) error {
+	infos, err := fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error reading dir %v", dir)
+	}
+	for _, fi := range infos {
+		p := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = walkLocks(fs, p, fn)
+		} else if strings.HasSuffix(fi.Name(), ".lock") {
+			err = fn(p, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockOwner is the process holding a lock file, as recorded by tryLock.
+type lockOwner struct {
+	pid  int
+	host string
+	at   time.Time
+}
+
+// same reports whether o and other are the same owner.
+func (o lockOwner) same(other lockOwner) bool {
+	return o.pid == other.pid && o.host == other.host && o.at.Equal(other.at)
+}
+
+func readLockOwner(fs billy.Filesystem, p string) (lockOwner, bool) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return lockOwner{}, false
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return lockOwner{}, false
+	}
+
+	var o lockOwner
+	var nanos int64
+	if _, err := fmt.Sscanf(string(b), "%d %s %d", &o.pid, &o.host, &nanos); err != nil {
+		return lockOwner{}, false
+	}
+	o.at = time.Unix(0, nanos)
+	return o, true
+}
+
+// gone reports whether the owner is known to no longer run, which can only
+// be told for processes of this host.
+func (o lockOwner) gone() bool {
+	if host, _ := os.Hostname(); host != o.host || runtime.GOOS == "windows" {
+		return false
+	}
+	p, err := os.FindProcess(o.pid)
+	if err != nil {
+		return true
+	}
+	// signal 0 only checks the process exists. Signal turns ESRCH into an
+	// error of its own, only exported as os.ErrProcessDone since Go 1.16,
+	// so it is told by its message.
+	err = p.Signal(syscall.Signal(0))
+	return err == syscall.ESRCH || err != nil && err.Error() == errProcessFinished
+}
+
+// errProcessFinished is the message of the error os.Process.Signal
+// returns for processes no longer running.
+const errProcessFinished = "os: process already finished"
@@ -0,0 +1,142 @@
+package gitfs
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Stats lists at most this many largest blobs and duplicate groups.
+const statsTop = 10
+
+// RepoStats describes the size of a repo and where it comes from, see
+// GitFs.Stats.
+type RepoStats struct {
+	// Bytes the git dir takes, 0 for storers not backed by a filesystem
+	GitDirSize int64
+	// Objects of all history by type, and their total size uncompressed
+	Commits    int
+	Trees      int
+	Blobs      int
+	Tags       int
+	ObjectSize int64
+	// Files at HEAD and their total size
+	Files     int
+	FilesSize int64
+	// Largest blobs of all history, largest first
+	LargestBlobs []BlobStat
+	// Files at HEAD sharing the same content, most bytes wasted first
+	Duplicates []DuplicateGroup
+}
+
+// BlobStat describes a blob.
+type BlobStat struct {
+	Hash string
+	Size int64
+	// A path of the blob at HEAD, empty if only in history
+	Path string
+}
+
+// DuplicateGroup is a set of files with the same content.
+type DuplicateGroup struct {
+	Hash  string
+	Size  int64
+	Paths []string
+}
+
+// Wasted returns the bytes the worktree would save keeping one copy.
+func (d DuplicateGroup) Wasted() int64 {
+	return d.Size * int64(len(d.Paths)-1)
+}
+
+// Stats reports the size of the repo, its object counts, its largest blobs
+// and the files at HEAD with duplicate content, e.g. to decide when to gc
+// or restructure a fast growing repo. It reads every object, so it takes a
+// while on large repos. Changes not yet synced are not part of it.
+func (g *GitFs) Stats() (stats RepoStats, err error) {
+	defer g.git.trace("gitfs.Stats")(&err)
+
+	s := g.git.repo.Storer
+	if fs, ok := s.(interface{ Filesystem() billy.Filesystem }); ok {
+		if err := walk(fs.Filesystem(), "/", func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				stats.GitDirSize += fi.Size()
+			}
+			return nil
+		}); err != nil {
+			return stats, errors.Wrapf(err, "error measuring git dir")
+		}
+	}
+
+	files, err := g.git.headFiles()
+	if err != nil {
+		return stats, err
+	}
+	paths := map[plumbing.Hash][]string{}
+	for p, e := range files {
+		paths[e.hash] = append(paths[e.hash], p)
+	}
+	for _, ps := range paths {
+		sort.Strings(ps)
+	}
+
+	sizes := map[plumbing.Hash]int64{}
+	objs, err := s.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error reading objects")
+	}
+	if err := objs.ForEach(func(obj plumbing.EncodedObject) error {
+		stats.ObjectSize += obj.Size()
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			stats.Commits++
+		case plumbing.TreeObject:
+			stats.Trees++
+		case plumbing.TagObject:
+			stats.Tags++
+		case plumbing.BlobObject:
+			stats.Blobs++
+			sizes[obj.Hash()] = obj.Size()
+		}
+		return nil
+	}); err != nil {
+		return stats, errors.Wrapf(err, "error reading objects")
+	}
+
+	for h, size := range sizes {
+		b := BlobStat{Hash: h.String(), Size: size}
+		if ps := paths[h]; len(ps) > 0 {
+			b.Path = ps[0]
+		}
+		stats.LargestBlobs = append(stats.LargestBlobs, b)
+	}
+	sort.Slice(stats.LargestBlobs, func(i, j int) bool {
+		a, b := stats.LargestBlobs[i], stats.LargestBlobs[j]
+		return a.Size > b.Size || a.Size == b.Size && a.Hash < b.Hash
+	})
+	if len(stats.LargestBlobs) > statsTop {
+		stats.LargestBlobs = stats.LargestBlobs[:statsTop]
+	}
+
+	for h, ps := range paths {
+		stats.Files += len(ps)
+		stats.FilesSize += sizes[h] * int64(len(ps))
+		if len(ps) > 1 {
+			stats.Duplicates = append(stats.Duplicates, DuplicateGroup{Hash: h.String(), Size: sizes[h], Paths: ps})
+		}
+	}
+	sort.Slice(stats.Duplicates, func(i, j int) bool {
+		a, b := stats.Duplicates[i], stats.Duplicates[j]
+		return a.Wasted() > b.Wasted() || a.Wasted() == b.Wasted() && a.Hash < b.Hash
+	})
+	if len(stats.Duplicates) > statsTop {
+		stats.Duplicates = stats.Duplicates[:statsTop]
+	}
+	return stats, nil
+}
@@ -0,0 +1,140 @@
+package gitfs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+)
+
+const (
+	// Files larger than this get no line stats, diffing them is too slow
+	maxStatSize = 1 << 20
+	// At most this many files are listed in commit bodies
+	maxSummaryFiles = 100
+	// Like git, files with a NUL byte in their first 8000 are binary
+	binarySniffLen = 8000
+)
+
+// fileStat is the line stat of a changed file.
+type fileStat struct {
+	Change
+	added, deleted int
+	// If no line stats were computed, for binary or large files
+	binary bool
+}
+
+// looksBinary reports whether data is binary rather than text.
+func looksBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// lineStats returns the lines added and deleted between old and cur.
+func lineStats(old, cur []byte) (added, deleted int) {
+	for _, d := range diff.Do(string(old), string(cur)) {
+		n := strings.Count(d.Text, "\n")
+		if d.Text != "" && !strings.HasSuffix(d.Text, "\n") {
+			n++
+		}
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			added += n
+		case diffmatchpatch.DiffDelete:
+			deleted += n
+		}
+	}
+	return added, deleted
+}
+
+// changeStats returns the line stats of changes of the worktree against
+// HEAD.
+func (g *Git) changeStats(changes []Change) ([]fileStat, error) {
+	head, err := g.headFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]fileStat, len(changes))
+	for i, c := range changes {
+		s := fileStat{Change: c}
+		var old, cur []byte
+		if e, ok := head[c.Path]; ok {
+			if old, err = g.readBlob(e.hash); err != nil {
+				return nil, err
+			}
+		}
+		if c.Status != Deleted {
+			if cur, err = readFile(g.fs, c.Path); err != nil {
+				return nil, err
+			}
+		}
+		if len(old) > maxStatSize || len(cur) > maxStatSize || looksBinary(old) || looksBinary(cur) {
+			s.binary = true
+		} else {
+			s.added, s.deleted = lineStats(old, cur)
+		}
+		stats[i] = s
+	}
+	return stats, nil
+}
+
+// summaryBody returns the commit body listing stats like git diff --stat,
+// with the status of each file, e.g.
+//
+//	A docs/intro.md | +12
+//	M main.go       | +3 -1
+//	M logo.png      | binary
+//
+//	2 files changed, 15 insertions(+), 1 deletion(-)
+func summaryBody(stats []fileStat) string {
+	width := 0
+	for i, s := range stats {
+		if i < maxSummaryFiles && len(s.Path) > width {
+			width = len(s.Path)
+		}
+	}
+
+	var b strings.Builder
+	added, deleted := 0, 0
+	for i, s := range stats {
+		added += s.added
+		deleted += s.deleted
+		if i >= maxSummaryFiles {
+			continue
+		}
+		fmt.Fprintf(&b, "%c %-*v |", byte(s.Status), width, s.Path)
+		switch {
+		case s.binary:
+			b.WriteString(" binary")
+		case s.added == 0 && s.deleted == 0:
+			b.WriteString(" 0")
+		default:
+			if s.added > 0 {
+				fmt.Fprintf(&b, " +%v", s.added)
+			}
+			if s.deleted > 0 {
+				fmt.Fprintf(&b, " -%v", s.deleted)
+			}
+		}
+		b.WriteString("\n")
+	}
+	if n := len(stats) - maxSummaryFiles; n > 0 {
+		fmt.Fprintf(&b, "... and %v more\n", n)
+	}
+
+	fmt.Fprintf(&b, "\n%v changed, %v(+), %v(-)",
+		plural(len(stats), "file"), plural(added, "insertion"), plural(deleted, "deletion"))
+	return b.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%v %v", n, noun)
+	}
+	return fmt.Sprintf("%v %vs", n, noun)
+}
@@ -0,0 +1,86 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+)
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	for name, config := range map[string]func(dir string) *Config{
+		"memfs": func(string) *Config { return NewConfig().UseMemFs() },
+		"osfs":  func(dir string) *Config { return NewConfig().UseOsFs(dir, false) },
+	} {
+		r := newTestRemote(t, map[string]string{"a.txt": "a"})
+		dir, cleanup := testDir(t)
+		g := r.clone(config(filepath.Join(dir, "1")))
+		if err := g.Symlink("a.txt", "link"); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if err := g.Sync(false); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+
+		ref, err := r.repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := r.repo.CommitObject(ref.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := tree.FindEntry("link")
+		if err != nil {
+			t.Fatalf("%v: link not pushed: %v", name, err)
+		}
+		if entry.Mode != filemode.Symlink {
+			t.Fatalf("%v: link pushed with mode %v", name, entry.Mode)
+		}
+		if data, _ := r.file("link"); data != "a.txt" {
+			t.Fatalf("%v: link pushed pointing to %q", name, data)
+		}
+
+		clone := r.clone(config(filepath.Join(dir, "2")))
+		fi, err := clone.Lstat("link")
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("%v: link cloned with mode %v", name, fi.Mode())
+		}
+		if target, err := clone.Readlink("link"); err != nil || target != "a.txt" {
+			t.Fatalf("%v: link cloned pointing to %q, %v", name, target, err)
+		}
+		cleanup()
+	}
+}
+
+func TestForbidSymlinks(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	r := newTestRemote(t, map[string]string{"a.txt": "a"})
+	g := r.clone(NewConfig().UseOsFs(dir, false).ForbidSymlinks())
+
+	err := g.Symlink("a.txt", "link")
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != ErrSymlinkForbidden {
+		t.Fatalf("Symlink got %v", err)
+	}
+
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	if err := g.Sync(false); errors.Cause(err) != ErrSymlinkForbidden {
+		t.Fatalf("sync of a symlink got %v", err)
+	}
+	if _, ok := r.file("link"); ok {
+		t.Fatal("forbidden symlink pushed")
+	}
+}
@@ -0,0 +1,128 @@
+package gitfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Sync commands may run this long unless they set a timeout.
+const defaultSyncCommandTimeout = time.Minute
+
+// SyncCommand is an external command run around syncs, like the git hooks
+// go-git doesn't run, e.g. a formatter or linter. It runs without a shell,
+// in the worktree on osFs and in the current dir otherwise, with these
+// variables added to the environment:
+//
+//	GITFS_BRANCH    branch synced
+//	GITFS_WORKTREE  worktree dir, empty unless on osFs
+//	GITFS_CHANGES   changes, one per line like git diff --name-status,
+//	                e.g. "M\tmain.go" or "R\told.go\tnew.go"
+//
+// and after syncs, which run only if a commit was created:
+//
+//	GITFS_COMMIT    commit created
+//	GITFS_PUSHED    "true" if the branch was pushed
+type SyncCommand struct {
+	// Program and its arguments
+	Args []string
+	// Extra environment variables, "KEY=value"
+	Env []string
+	// How long it may run before being killed, 0 for a minute
+	Timeout time.Duration
+}
+
+// changesEnv formats changes for GITFS_CHANGES.
+func changesEnv(changes []Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		if c.OldPath != "" {
+			fmt.Fprintf(&b, "%c\t%v\t%v\n", byte(c.Status), c.OldPath, c.Path)
+		} else {
+			fmt.Fprintf(&b, "%c\t%v\n", byte(c.Status), c.Path)
+		}
+	}
+	return b.String()
+}
+
+// run runs c with env added to the environment, failing with its output if
+// it exits non zero.
+func (c *SyncCommand) run(ctx context.Context, dir string, env []string) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultSyncCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), env...), c.Env...)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("%v timed out after %v", c.Args[0], timeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%v failed: %s", c.Args[0], bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// runSyncCommand runs cmd in the worktree if on osFs, describing a sync of
+// changes by env plus the common variables.
+func (g *GitFs) runSyncCommand(ctx context.Context, cmd *SyncCommand, changes []Change, env ...string) error {
+	dir, _ := osPath(g.git.fs, "")
+	return cmd.run(ctx, dir, append([]string{
+		"GITFS_BRANCH=" + g.git.branchName(),
+		"GITFS_WORKTREE=" + dir,
+		"GITFS_CHANGES=" + changesEnv(changes),
+	}, env...))
+}
+
+// runPreSync runs the pre-sync command, if any, on the changes of the
+// worktree, failing the sync if it fails. Syncs without changes skip it,
+// unless empty commits are allowed.
+func (g *GitFs) runPreSync(ctx context.Context) error {
+	if g.preSync == nil {
+		return nil
+	}
+	changes, err := g.git.worktreeChanges()
+	if err != nil {
+		return err
+	}
+	if g.scope != "" {
+		changes = scopeChanges(changes, g.inScope)
+	}
+	if len(changes) == 0 && !g.allowEmpty {
+		return nil
+	}
+	if err := g.runSyncCommand(ctx, g.preSync, changes); err != nil {
+		return errors.Wrapf(err, "pre-sync command rejected changes")
+	}
+	return nil
+}
+
+// runPostSync runs the post-sync command, if any, once a sync succeeded
+// committing the changes since before. It can't fail the sync anymore, so
+// its failure is reported to Hooks.OnError only.
+func (g *GitFs) runPostSync(ctx context.Context, before plumbing.Hash, res SyncResult) {
+	if g.postSync == nil || res.Commit.IsZero() {
+		return
+	}
+	changes, err := g.git.hashChanges(before, res.Commit)
+	if err == nil {
+		err = g.runSyncCommand(ctx, g.postSync, changes,
+			"GITFS_COMMIT="+res.Commit.String(),
+			fmt.Sprintf("GITFS_PUSHED=%v", res.Pushed))
+	}
+	if err != nil {
+		g.git.reportError("post-sync", errors.Wrapf(err, "post-sync command failed"))
+	}
+}
@@ -0,0 +1,56 @@
+package gitfs
+
+import (
+	"context"
+)
+
+// Puller pulls the changes of the remote branch into the repo, like Git.
+// Config.SetPuller replaces the Puller of a GitFs, e.g. by a fake in unit
+// tests.
+type Puller interface {
+	Pull() error
+}
+
+// Pusher pushes the commits of the branch to the remote repo, like Git.
+// Config.SetPusher replaces the Pusher of a GitFs, e.g. by a fake in unit
+// tests.
+type Pusher interface {
+	Push() error
+}
+
+// Syncer syncs a worktree with its remote repo, like GitFs. Applications
+// depending on a Syncer rather than a GitFs can unit test their sync logic
+// with a fake.
+type Syncer interface {
+	Puller
+	Sync(purge bool) error
+}
+
+var (
+	_ Puller = (*Git)(nil)
+	_ Pusher = (*Git)(nil)
+	_ Syncer = (*GitFs)(nil)
+)
+
+// pull pulls by the Puller of g, with ctx for pulls of its Git only.
+func (g *GitFs) pull(ctx context.Context) error {
+	if g.puller != nil {
+		return g.puller.Pull()
+	}
+	return g.git.pull(ctx)
+}
+
+// push pushes by the Pusher of g, with ctx and stats for pushes of its Git
+// only.
+func (g *GitFs) push(ctx context.Context) (pushStats, error) {
+	if g.pusher != nil {
+		return pushStats{}, g.pusher.Push()
+	}
+	return g.git.pushBranch(ctx)
+}
+
+// pushes reports whether commits are pushed once made, unless offline or
+// without a remote repo nor Pusher to push them to.
+func (g *GitFs) pushes() bool {
+	return !g.offline && (!g.git.noRemote || g.pusher != nil)
+}
@@ -0,0 +1,123 @@
+package gitfs
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+)
+
+const defaultTempDir = ".gitfs-tmp"
+
+// tempAreas tracks the temp dirs TempFile created files in, and the files
+// it created elsewhere, so they can be cleaned on Sync.
+type tempAreas struct {
+	// Name of the temp dirs, never staged wherever they are
+	name  string
+	clean bool
+	mu    sync.Mutex
+	dirs  map[string]bool
+	// Files created in other dirs, by slash separated path
+	files map[string]bool
+}
+
+func newTempAreas(name string, clean bool) *tempAreas {
+	if name == "" {
+		name = defaultTempDir
+	}
+	return &tempAreas{name: name, clean: clean, dirs: map[string]bool{name: true}, files: map[string]bool{}}
+}
+
+// pattern excludes the temp dirs from status and staging.
+func (t *tempAreas) pattern() gitignore.Pattern {
+	return gitignore.ParsePattern(t.name+"/", nil)
+}
+
+// contains reports whether the slash separated path p lies in a temp dir
+// or is a file TempFile created elsewhere.
+func (t *tempAreas) contains(p string) bool {
+	parts := strings.Split(p, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if part == t.name {
+			return true
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.files[p]
+}
+
+func (t *tempAreas) addDir(dir string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirs[dir] = true
+}
+
+func (t *tempAreas) addFile(p string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[p] = true
+}
+
+// unstageTemp removes the files of temp dirs from the index, as
+// Worktree.Add ignores Worktree.Excludes for dirs.
+func (g *Git) unstageTemp() error {
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return errors.Wrapf(err, "error reading index")
+	}
+
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if !g.temp.contains(e.Name) {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == len(idx.Entries) {
+		return nil
+	}
+	idx.Entries = entries
+	return g.repo.Storer.SetIndex(idx)
+}
+
+// cleanTemp removes the temp dirs files were created in, and the files
+// created elsewhere, if cleaning on Sync is enabled.
+func (g *Git) cleanTemp() error {
+	t := g.temp
+	if !t.clean {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for dir := range t.dirs {
+		if err := util.RemoveAll(g.fs, dir); err != nil {
+			return errors.Wrapf(err, "error removing temp dir %v", dir)
+		}
+	}
+	for p := range t.files {
+		if err := g.fs.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error removing temp file %v", p)
+		}
+	}
+	t.dirs = map[string]bool{t.name: true}
+	t.files = map[string]bool{}
+	return nil
+}
+
+func validTempDir(name string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." || strings.EqualFold(name, ".git") {
+		return errors.Errorf("invalid temp dir %v, must be a plain dir name", name)
+	}
+	if strings.ContainsAny(name, "*?[!") {
+		return errors.Errorf("invalid temp dir %v, must not be a pattern", name)
+	}
+	return nil
+}
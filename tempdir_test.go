@@ -0,0 +1,51 @@
+package gitfs
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestCleanTempKeepsOtherFilesOfDir(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().CleanTempOnSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "configs/app.yaml", "a: 1\n")
+	f, err := g.TempFile("configs", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("temp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := g.TempFile("", "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, g, "configs/app.yaml"); got != "a: 1\n" {
+		t.Fatalf("configs/app.yaml is %q", got)
+	}
+	if _, err := g.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Fatalf("temp file %v kept: %v", f.Name(), err)
+	}
+	if _, err := g.Stat(d.Name()); !os.IsNotExist(err) {
+		t.Fatalf("temp file %v kept: %v", d.Name(), err)
+	}
+
+	files, err := g.git.headFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["configs/app.yaml"]; !ok || len(files) != 1 {
+		t.Fatalf("committed %v", files)
+	}
+}
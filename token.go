@@ -0,0 +1,72 @@
+package gitfs
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// tokenUser is the basic auth user of access tokens, ignored by most hosts
+// but required by GitHub for installation tokens.
+const tokenUser = "x-access-token"
+
+// AuthProvider returns the auth method of the remote repo. It is consulted
+// before every clone, pull, fetch and push, so credentials can rotate, e.g.
+// ssh certificates issued by Vault or tokens exchanged for OIDC ones,
+// without recreating the GitFs. It should cache credentials while they are
+// valid.
+type AuthProvider interface {
+	Method(ctx context.Context) (transport.AuthMethod, error)
+}
+
+// AuthProviderFunc adapts a func to an AuthProvider.
+type AuthProviderFunc func(ctx context.Context) (transport.AuthMethod, error)
+
+// Method calls f.
+func (f AuthProviderFunc) Method(ctx context.Context) (transport.AuthMethod, error) {
+	return f(ctx)
+}
+
+// TokenSource returns access tokens of https remotes, e.g. short-lived
+// ones minted on demand like those of providers/github.AppTokenSource.
+// Token is called before every clone, pull, fetch and push, so it should
+// cache tokens while they are valid.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenAuth returns the AuthProvider authenticating to https remotes with
+// tokens of ts as basic auth passwords.
+func TokenAuth(ts TokenSource) AuthProvider {
+	return AuthProviderFunc(func(ctx context.Context) (transport.AuthMethod, error) {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting access token")
+		}
+		return &githttp.BasicAuth{Username: tokenUser, Password: token}, nil
+	})
+}
+
+// providerAuth returns the auth method p provides.
+func providerAuth(ctx context.Context, p AuthProvider) (transport.AuthMethod, error) {
+	auth, err := p.Method(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting auth method")
+	}
+	return auth, nil
+}
+
+// authMethod returns the auth of the next transport operation, run with
+// ctx, from the auth provider if set, carrying the transport of the remote.
+func (g *Git) authMethod(ctx context.Context) (transport.AuthMethod, error) {
+	if g.authSource == nil {
+		return g.transport.with(g.auth), nil
+	}
+	auth, err := providerAuth(ctx, g.authSource)
+	if err != nil {
+		return nil, err
+	}
+	return g.transport.with(auth), nil
+}
@@ -0,0 +1,55 @@
+package gitfs
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+type opKey struct{}
+
+func TestAuthProviderGetsOperationContext(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	var ops []interface{}
+	g := r.clone(NewConfig().UseMemFs().SetAuthProvider(AuthProviderFunc(func(ctx context.Context) (transport.AuthMethod, error) {
+		ops = append(ops, ctx.Value(opKey{}))
+		return nil, nil
+	})))
+	ops = nil
+
+	if err := g.Ping(context.WithValue(context.Background(), opKey{}, "ping")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", "a")
+	if _, err := g.syncContext(context.WithValue(context.Background(), opKey{}, "sync"), SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0] != "ping" || ops[1] != "sync" {
+		t.Fatalf("auth provider got contexts of %v", ops)
+	}
+	if _, ok := r.file("a.txt"); !ok {
+		t.Fatal("a.txt not pushed")
+	}
+}
+
+func TestSyncCanceledByContext(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	g := r.clone(nil)
+	writeTestFile(t, g, "a.txt", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.syncContext(ctx, SyncOptions{}); err == nil {
+		t.Fatal("canceled sync pushed")
+	}
+	if _, ok := r.file("a.txt"); ok {
+		t.Fatal("a.txt pushed")
+	}
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.file("a.txt"); !ok {
+		t.Fatal("a.txt not pushed by Flush")
+	}
+}
@@ -0,0 +1,45 @@
+package gitfs
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/iamjinlei/gitfs"
+
+// trace starts a span named name, returning the func ending it with the
+// error err points to. It is a no-op on a nil Git.
+func (g *Git) trace(name string) func(err *error) {
+	if g == nil {
+		return func(*error) {}
+	}
+	_, end := startSpan(g.ctx, g.tracer, name)
+	return end
+}
+
+// traceContext is like trace, with the span a child of the span of ctx,
+// returning ctx with the span for the operations nested in it.
+func (g *Git) traceContext(ctx context.Context, name string) (context.Context, func(err *error)) {
+	if g == nil {
+		return ctx, func(*error) {}
+	}
+	return startSpan(ctx, g.tracer, name)
+}
+
+// startSpan is like traceContext, a no-op without a tracer. Spans of
+// failed operations record the error and the Error status.
+func startSpan(ctx context.Context, t trace.Tracer, name string) (context.Context, func(err *error)) {
+	if t == nil {
+		return ctx, func(*error) {}
+	}
+	ctx, span := t.Start(ctx, name)
+	return ctx, func(err *error) {
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
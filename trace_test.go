@@ -0,0 +1,85 @@
+package gitfs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeSpan records its name, parent and status.
+type fakeSpan struct {
+	trace.Span
+	name   string
+	parent *fakeSpan
+	failed bool
+	ended  bool
+}
+
+func (s *fakeSpan) SetStatus(code codes.Code, _ string) { s.failed = code == codes.Error }
+
+func (s *fakeSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+// fakeTracer records the spans it starts.
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Tracer(string, ...trace.TracerOption) trace.Tracer { return t }
+
+func (t *fakeTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &fakeSpan{Span: trace.SpanFromContext(context.Background()), name: name}
+	s.parent, _ = trace.SpanFromContext(ctx).(*fakeSpan)
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (t *fakeTracer) span(name string) *fakeSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTraceNestsSyncSpans(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	tracer := &fakeTracer{}
+	g := r.clone(NewConfig().UseMemFs().SetTracerProvider(tracer))
+	if s := tracer.span("gitfs.Clone"); s == nil || !s.ended {
+		t.Fatal("clone not traced")
+	}
+	tracer.spans = nil
+
+	writeTestFile(t, g, "a.txt", "a")
+	if err := g.Sync(false); err != nil {
+		t.Fatal(err)
+	}
+	syncSpan := tracer.span("gitfs.Sync")
+	if syncSpan == nil || syncSpan.parent != nil || !syncSpan.ended {
+		t.Fatalf("got sync span %+v", syncSpan)
+	}
+	for _, name := range []string{"gitfs.Commit", "gitfs.Push"} {
+		if s := tracer.span(name); s == nil || s.parent != syncSpan || !s.ended {
+			t.Fatalf("got %v span %+v, want a child of the sync span", name, s)
+		}
+	}
+}
+
+func TestTraceRecordsErrors(t *testing.T) {
+	tracer := &fakeTracer{}
+	_, end := startSpan(context.Background(), tracer, "op")
+	err := ErrClosed
+	end(&err)
+	if s := tracer.span("op"); !s.failed || !s.ended {
+		t.Fatalf("got span %+v of a failed operation", s)
+	}
+}
@@ -0,0 +1,43 @@
+package gitfs
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Trailer is a git trailer, a "Key: Value" line at the end of a commit
+// message, e.g. {"Request-Id", "42"}. git interpret-trailers and most repo
+// browsers parse them.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// validTrailers checks keys are single tokens and values single lines, so
+// trailers can't break the message apart.
+func validTrailers(trailers []Trailer) error {
+	for _, t := range trailers {
+		if t.Key == "" || strings.ContainsAny(t.Key, ": \t\r\n") {
+			return errors.Errorf("invalid trailer key %q", t.Key)
+		}
+		if strings.ContainsAny(t.Value, "\r\n") {
+			return errors.Errorf("trailer %v has a multi-line value", t.Key)
+		}
+	}
+	return nil
+}
+
+// withTrailers appends trailers to msg, separated by a blank line.
+func withTrailers(msg string, trailers []Trailer) string {
+	if len(trailers) == 0 {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(msg, "\n"))
+	b.WriteString("\n\n")
+	for _, t := range trailers {
+		b.WriteString(t.Key + ": " + strings.TrimSpace(t.Value) + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,321 @@
+package gitfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	gogitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// go-git picks the transport of a url from a process wide registry by its
+// scheme, which it reads unguarded. The transport of the remote of a Git
+// is carried along with the auth of every operation instead, see
+// instanceAuth, and picked by the dispatchTransport registered in place of
+// the one of the scheme, which is left to serve any other operation. The
+// schemes of go-git are registered once at init, before any go-git use,
+// those installed by the application once on first use, see dispatched.
+func init() {
+	for _, scheme := range []string{"file", "git", "http", "https", "ssh"} {
+		dispatched.schemes[scheme] = true
+		client.InstallProtocol(scheme, &dispatchTransport{registered: client.Protocols[scheme]})
+	}
+}
+
+// dispatched records the schemes a dispatchTransport is registered for.
+var dispatched = struct {
+	sync.Mutex
+	schemes map[string]bool
+}{schemes: map[string]bool{}}
+
+// remoteTransport is the transport of the remote of a Git.
+type remoteTransport struct {
+	scheme string
+	// Transport of the scheme, nil for the one registered in go-git
+	base transport.Transport
+	// Packfiles are throttled to, nil for no limit
+	bandwidth *bandwidth
+}
+
+// newRemoteTransport returns the transport of the repo url of c. Local
+// paths and file:// urls are served in process, so no git binary is
+// required, https remotes get an http client of their own going through
+// the proxy of c.
+func newRemoteTransport(c *Config) (*remoteTransport, error) {
+	ep, err := transport.NewEndpoint(c.repoUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing repo url %v", c.repoUrl)
+	}
+	t := &remoteTransport{scheme: ep.Protocol}
+	switch ep.Protocol {
+	case "file":
+		t.base = server.DefaultServer
+	case "http", "https":
+		if t.base, err = newHTTPTransport(c.proxyUrl); err != nil {
+			return nil, err
+		}
+	}
+	if c.bandwidthLimit > 0 {
+		t.bandwidth = &bandwidth{rate: c.bandwidthLimit}
+	}
+	return t, nil
+}
+
+// with returns auth carrying t to the dispatchTransport of its scheme,
+// registering it unless done yet. It returns auth itself if t is nil.
+func (t *remoteTransport) with(auth transport.AuthMethod) transport.AuthMethod {
+	if t == nil {
+		return auth
+	}
+	dispatched.Lock()
+	if !dispatched.schemes[t.scheme] {
+		dispatched.schemes[t.scheme] = true
+		if d := client.Protocols[t.scheme]; !isDispatch(d) {
+			client.InstallProtocol(t.scheme, &dispatchTransport{registered: d})
+		}
+	}
+	dispatched.Unlock()
+	return &instanceAuth{AuthMethod: auth, t: t}
+}
+
+// instanceAuth is the auth of an operation of a Git, nil if none, along
+// with the transport of its remote.
+type instanceAuth struct {
+	transport.AuthMethod
+	t *remoteTransport
+}
+
+func (a *instanceAuth) Name() string {
+	if a.AuthMethod == nil {
+		return "none"
+	}
+	return a.AuthMethod.Name()
+}
+
+func (a *instanceAuth) String() string {
+	if a.AuthMethod == nil {
+		return "none"
+	}
+	return a.AuthMethod.String()
+}
+
+// dispatchTransport opens sessions with the transport carried by the auth
+// of the operation, or with the transport registered before for others.
+type dispatchTransport struct {
+	registered transport.Transport
+}
+
+func isDispatch(t transport.Transport) bool {
+	_, ok := t.(*dispatchTransport)
+	return ok
+}
+
+// pick returns the transport to open a session with and its auth.
+func (d *dispatchTransport) pick(ep *transport.Endpoint, auth transport.AuthMethod) (transport.Transport, transport.AuthMethod, error) {
+	t := d.registered
+	a, ok := auth.(*instanceAuth)
+	if ok {
+		auth = a.AuthMethod
+		if a.t.base != nil {
+			t = a.t.base
+		}
+	}
+	if t == nil {
+		return nil, nil, errors.Errorf("unsupported scheme %q", ep.Protocol)
+	}
+	if ok && a.t.bandwidth != nil {
+		t = &throttledTransport{Transport: t, b: a.t.bandwidth}
+	}
+	return t, auth, nil
+}
+
+func (d *dispatchTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	t, auth, err := d.pick(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return t.NewUploadPackSession(ep, auth)
+}
+
+func (d *dispatchTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	t, auth, err := d.pick(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return t.NewReceivePackSession(ep, auth)
+}
+
+// remoteAuth returns the auth method for the repo url of c, chosen by its
+// scheme, nil if taken from the auth provider. Local paths and file:// urls
+// need no auth. git:// has no auth at all, nor have protocols installed in
+// go-git by the application.
+func remoteAuth(c *Config) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(c.repoUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing repo url %v", c.repoUrl)
+	}
+
+	switch ep.Protocol {
+	case "file", "git":
+		return nil, nil
+	case "http", "https":
+		if c.authProvider != nil {
+			return nil, nil
+		}
+		if c.httpUser == "" && c.httpPassword == "" {
+			if !c.credentialHelper || ep.Password != "" {
+				// credentials, if any, are taken from the url
+				return nil, nil
+			}
+			user, password, err := credentialFill(ep)
+			if err != nil {
+				return nil, err
+			}
+			return &githttp.BasicAuth{Username: user, Password: password}, nil
+		}
+		return &githttp.BasicAuth{Username: c.httpUser, Password: c.httpPassword}, nil
+	case "ssh":
+		if c.proxyUrl != "" {
+			// go-git dials ssh through ALL_PROXY only, with no dialer hook
+			return nil, errors.New("proxy of ssh remotes can only be set by ALL_PROXY")
+		}
+		if c.authProvider != nil {
+			return nil, nil
+		}
+		if c.sshAuths != nil {
+			return c.sshAuths.get(ep, c.sshUser, c.sshKeyFile, c.sshCertFile)
+		}
+		return sshAuth(ep, c.sshUser, c.sshKeyFile, c.sshCertFile)
+	default:
+		if _, ok := client.Protocols[ep.Protocol]; ok {
+			// installed by the application, which handles its auth
+			return nil, nil
+		}
+		return nil, errors.Errorf("unsupported protocol %v of repo url %v", ep.Protocol, c.repoUrl)
+	}
+}
+
+// sshAuth returns public key auth for ep, honoring the User,
+// IdentityFile and CertificateFile entries of ~/.ssh/config like plain git
+// does. go-git itself resolves Hostname and Port entries when dialing. A
+// non empty user, keyFile or certFile overrides the url and ssh config.
+// Like OpenSSH, a certificate next to the key named after it plus
+// "-cert.pub" is presented along with the key.
+func sshAuth(ep *transport.Endpoint, user, keyFile, certFile string) (transport.AuthMethod, error) {
+	if user == "" {
+		user = ep.User
+	}
+	home, _ := os.UserHomeDir()
+	if cfg := gogitssh.DefaultSSHConfig; cfg != nil {
+		if u := cfg.Get(ep.Host, "User"); user == "" && u != "" {
+			user = u
+		}
+		if f := cfg.Get(ep.Host, "IdentityFile"); keyFile == "" && f != "" && f != ssh_config.Default("IdentityFile") {
+			keyFile = expandHome(f)
+		}
+		if f := cfg.Get(ep.Host, "CertificateFile"); certFile == "" && f != "" {
+			certFile = expandHome(f)
+		}
+	}
+	if user == "" {
+		user = "git"
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(home, ".ssh", "id_rsa")
+	}
+
+	sshKey, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading private key")
+	}
+
+	var cert []byte
+	if certFile != "" {
+		if cert, err = ioutil.ReadFile(certFile); err != nil {
+			return nil, errors.Wrapf(err, "error reading ssh certificate")
+		}
+	} else if cert, err = ioutil.ReadFile(keyFile + "-cert.pub"); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "error reading ssh certificate")
+	}
+	if cert != nil {
+		return SSHCertificateAuth(user, sshKey, cert)
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(sshKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing private key %v", keyFile)
+	}
+	return &gogitssh.PublicKeys{User: user, Signer: signer}, nil
+}
+
+// SSHCertificateAuth returns the ssh auth presenting the OpenSSH
+// certificate cert, in authorized_keys format, signed by an ssh CA, along
+// with privateKey, e.g. for an AuthProvider of short-lived certificates.
+// It fails if cert has expired or doesn't certify the key.
+func SSHCertificateAuth(user string, privateKey, cert []byte) (transport.AuthMethod, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing private key")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing ssh certificate")
+	}
+	c, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("ssh certificate is a plain public key")
+	}
+	if c.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(c.ValidBefore) {
+		return nil, errors.Errorf("ssh certificate expired at %v", time.Unix(int64(c.ValidBefore), 0).Format(time.RFC3339))
+	}
+	certSigner, err := ssh.NewCertSigner(c, signer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error using ssh certificate")
+	}
+	return &gogitssh.PublicKeys{User: user, Signer: certSigner}, nil
+}
+
+// sshAuthCache shares ssh auth between repos, so keys are read and parsed
+// once per host and user.
+type sshAuthCache struct {
+	mu    sync.Mutex
+	auths map[string]transport.AuthMethod
+}
+
+func newSSHAuthCache() *sshAuthCache {
+	return &sshAuthCache{auths: map[string]transport.AuthMethod{}}
+}
+
+func (c *sshAuthCache) get(ep *transport.Endpoint, user, keyFile, certFile string) (transport.AuthMethod, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.Join([]string{ep.Host, ep.User, user, keyFile, certFile}, "\x00")
+	if auth, ok := c.auths[key]; ok {
+		return auth, nil
+	}
+	auth, err := sshAuth(ep, user, keyFile, certFile)
+	if err != nil {
+		return nil, err
+	}
+	c.auths[key] = auth
+	return auth, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[1:])
+	}
+	return path
+}
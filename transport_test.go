@@ -0,0 +1,109 @@
+package gitfs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/file"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestLocalPathRemote(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "remote.git")
+	// a bare repo on disk, committed to through a worktree in memory
+	s := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+	repo, err := git.Init(s, memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(wt.Filesystem, "README", []byte("readme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("README"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "remote", Email: "remote@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	registered := client.Protocols["file"]
+
+	for name, url := range map[string]string{"path.txt": path, "url.txt": "file://" + path} {
+		g, err := New(context.Background(), NewConfig().SetUrl(url).UseMemFs())
+		if err != nil {
+			t.Fatalf("%v: %v", url, err)
+		}
+		if data := readTestFile(t, g, "README"); data != "readme" {
+			t.Fatalf("%v: got README %q", url, data)
+		}
+		if err := g.Ping(context.Background()); err != nil {
+			t.Fatalf("%v: %v", url, err)
+		}
+		writeTestFile(t, g, name, url)
+		if err := g.Sync(false); err != nil {
+			t.Fatalf("%v: %v", url, err)
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"path.txt", "url.txt"} {
+		if _, err := c.File(name); err != nil {
+			t.Fatalf("%v not pushed: %v", name, err)
+		}
+	}
+
+	// other operations still get the transport registered before
+	d, ok := client.Protocols["file"].(*dispatchTransport)
+	if !ok {
+		t.Fatalf("got file transport %T", client.Protocols["file"])
+	}
+	if isDispatch(registered) {
+		registered = d.registered
+	}
+	if d.registered != registered || d.registered != file.DefaultClient {
+		t.Fatalf("file transport %v replaced by %v", registered, d.registered)
+	}
+}
+
+func TestDispatchRegisteredOnce(t *testing.T) {
+	for _, scheme := range []string{"file", "http", "https", "ssh"} {
+		if !isDispatch(client.Protocols[scheme]) {
+			t.Fatalf("got %v transport %T", scheme, client.Protocols[scheme])
+		}
+	}
+
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	r.clone(NewConfig().UseMemFs())
+	d := client.Protocols[testScheme]
+	if !isDispatch(d) {
+		t.Fatalf("got %v transport %T", testScheme, d)
+	}
+	g := r.clone(NewConfig().UseMemFs())
+	if err := g.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.Protocols[testScheme] != d {
+		t.Fatalf("%v transport registered again", testScheme)
+	}
+}
@@ -0,0 +1,442 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Writer is the set of file operations available to transactional
+// mutations. It is implemented by Tx.
+type Writer interface {
+	Create(filename string) (File, error)
+	Open(filename string) (File, error)
+	ReadFile(filename string) ([]byte, error)
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	Remove(filename string) error
+}
+
+// Tx stages writes in an in-memory overlay on top of a GitFs. Staged
+// changes are visible through the Tx only, and are applied to the GitFs
+// (and thus picked up by the next Sync) on Commit.
+type Tx struct {
+	g       *GitFs
+	overlay billy.Filesystem
+	removed map[string]bool
+	done    bool
+}
+
+// Begin starts a new transaction.
+func (g *GitFs) Begin() (*Tx, error) {
+	return &Tx{
+		g:       g,
+		overlay: memfs.New(),
+		removed: map[string]bool{},
+	}, nil
+}
+
+func txPath(path string) string {
+	return filepath.Join(string(filepath.Separator), path)
+}
+
+func (tx *Tx) isRemoved(filename string) bool {
+	for p := txPath(filename); ; p = filepath.Dir(p) {
+		if tx.removed[p] {
+			return true
+		}
+		if p == filepath.Dir(p) {
+			return false
+		}
+	}
+}
+
+// Create creates or truncates the named file in the overlay.
+func (tx *Tx) Create(filename string) (File, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if err := tx.g.checkWritable("create", filename); err != nil {
+		return nil, err
+	}
+	delete(tx.removed, txPath(filename))
+	return tx.overlay.Create(filename)
+}
+
+// Open opens the named file for reading, preferring the staged version.
+func (tx *Tx) Open(filename string) (File, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if _, err := tx.overlay.Stat(filename); err == nil {
+		return tx.overlay.Open(filename)
+	}
+	if tx.isRemoved(filename) {
+		return nil, os.ErrNotExist
+	}
+	return tx.g.Open(filename)
+}
+
+// ReadFile reads the named file, preferring the staged version.
+func (tx *Tx) ReadFile(filename string) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if _, err := tx.overlay.Stat(filename); err == nil {
+		return readFile(tx.overlay, filename)
+	}
+	if tx.isRemoved(filename) {
+		return nil, os.ErrNotExist
+	}
+	return tx.g.ReadFile(filename)
+}
+
+// WriteFile stages data to be written to the named file.
+func (tx *Tx) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := tx.g.checkWritable("write", filename); err != nil {
+		return err
+	}
+	delete(tx.removed, txPath(filename))
+	return util.WriteFile(tx.overlay, filename, data, perm)
+}
+
+// Remove stages the removal of the named file or directory including
+// sub-directories.
+func (tx *Tx) Remove(filename string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := tx.g.checkWritable("remove", filename); err != nil {
+		return err
+	}
+	if err := util.RemoveAll(tx.overlay, filename); err != nil {
+		return errors.Wrapf(err, "error removing staged %v", filename)
+	}
+	tx.removed[txPath(filename)] = true
+	return nil
+}
+
+// paths returns the slash separated, root relative paths touched by the
+// transaction.
+func (tx *Tx) paths() ([]string, error) {
+	var paths []string
+	for path := range tx.removed {
+		paths = append(paths, slashPath(path))
+	}
+
+	if err := walk(tx.overlay, "/", func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, slashPath(path))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// Commit applies all staged changes to the GitFs and ends the transaction.
+// If applying fails, the files changed so far are restored and the
+// transaction stays open, to be rolled back or committed again.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	saved, err := tx.save()
+	if err != nil {
+		return err
+	}
+	if err := tx.apply(); err != nil {
+		for i := len(saved) - 1; i >= 0; i-- {
+			if rerr := tx.g.restoreSaved(saved[i]); rerr != nil {
+				tx.g.git.reportError("gitfs.Commit", errors.Wrapf(rerr, "error restoring %v", saved[i].path))
+			}
+		}
+		return err
+	}
+	tx.done = true
+	return nil
+}
+
+// apply applies the staged changes to the GitFs.
+func (tx *Tx) apply() error {
+	for path := range tx.removed {
+		if err := tx.g.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "error removing %v", path)
+		}
+	}
+
+	return walk(tx.overlay, "/", func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// nothing staged
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		data, err := readFile(tx.overlay, path)
+		if err != nil {
+			return errors.Wrapf(err, "error reading staged %v", path)
+		}
+		return tx.g.WriteFile(path, data, fi.Mode().Perm())
+	})
+}
+
+// growth returns by how many bytes applying the staged changes grows the
+// repo: the staged content counts twice, in the worktree and as new blobs,
+// less the files it replaces or removes.
+func (tx *Tx) growth() (int64, error) {
+	var delta int64
+	seen := map[string]bool{}
+	replace := func(path string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		fi, err := tx.g.fs.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			delta -= fi.Size()
+		}
+		return nil
+	}
+
+	for path := range tx.removed {
+		if err := walk(tx.g.fs, path, func(p string, fi os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			return replace(p)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	err := walk(tx.overlay, "/", func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		delta += 2 * fi.Size()
+		if err := replace(path); err != nil {
+			return err
+		}
+		return replace(path + compressedExt)
+	})
+	return delta, err
+}
+
+// savedFile is a file of the GitFs as stored before a Commit, to restore it
+// if the Commit fails.
+type savedFile struct {
+	path    string
+	existed bool
+	data    []byte
+	mode    os.FileMode
+}
+
+// save saves the files of the GitFs the staged changes replace or remove,
+// under their compressed names too.
+func (tx *Tx) save() ([]savedFile, error) {
+	var saved []savedFile
+	seen := map[string]bool{}
+	add := func(path string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		f, err := tx.g.saveFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "error saving %v", path)
+		}
+		saved = append(saved, f)
+		return nil
+	}
+
+	for path := range tx.removed {
+		if err := walk(tx.g.fs, path, func(p string, fi os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			return add(p)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := walk(tx.overlay, "/", func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if err := add(path); err != nil {
+			return err
+		}
+		return add(path + compressedExt)
+	}); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// saveFile returns path of g as stored, existed false if missing.
+func (g *GitFs) saveFile(path string) (savedFile, error) {
+	f := savedFile{path: path}
+	fi, err := g.fs.Lstat(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return f, err
+	}
+	f.existed, f.mode = true, fi.Mode()
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := g.fs.Readlink(path)
+		f.data = []byte(target)
+		return f, err
+	}
+	f.data, err = readFile(g.fs, path)
+	return f, err
+}
+
+// restoreSaved stores f as saved by saveFile.
+func (g *GitFs) restoreSaved(f savedFile) error {
+	if err := g.fs.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !f.existed {
+		return nil
+	}
+	if err := g.fs.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	if f.mode&os.ModeSymlink != 0 {
+		return g.fs.Symlink(string(f.data), f.path)
+	}
+	return util.WriteFile(g.fs, f.path, f.data, f.mode.Perm())
+}
+
+// Rollback discards all staged changes and ends the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.overlay = nil
+	tx.removed = nil
+	return nil
+}
+
+// Apply runs fn against a transaction, then stages exactly the files fn
+// touched, commits them with msg and pushes to the remote repo. Nothing is
+// applied if fn returns an error.
+func (g *GitFs) Apply(fn func(w Writer) error, msg string) (err error) {
+	defer g.git.trace("gitfs.Apply")(&err)
+
+	tx, err := g.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	paths, err := tx.paths()
+	if err != nil {
+		return errors.Wrapf(err, "error listing staged files")
+	}
+
+	unlock, err := g.git.lockRepo()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer unlock(&err)
+
+	if g.repoQuota > 0 {
+		delta, err := tx.growth()
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error measuring staged files")
+		}
+		if err := g.top().checkQuotaGrowth(delta); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "error applying staged files")
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// Paths are relative to the view, the commit is of the whole repo
+	t := g.top()
+	repoPaths := make([]string, 0, 2*len(paths))
+	for _, p := range paths {
+		// WriteFile may have stored files under their compressed name
+		repoPaths = append(repoPaths, g.repoPath(p), g.repoPath(p+compressedExt))
+	}
+
+	if err := t.git.Stage(repoPaths); err != nil {
+		return errors.Wrapf(err, "error adding files to git")
+	}
+
+	if err := t.git.runPreCommit(false); err != nil {
+		return err
+	}
+
+	if err := t.git.commitPaths(t.git.ctx, msg, false, repoPaths, nil); err != nil {
+		return errors.Wrapf(err, "error committing changes")
+	}
+
+	if !t.pushes() {
+		return nil
+	}
+
+	if _, err := t.push(t.git.ctx); err != nil {
+		return errors.Wrapf(err, "error pushing change to remote repo")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package gitfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTxCommitRestoresOnError(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs().SetMaxFileSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, g, "a.txt", "a")
+	writeTestFile(t, g, "dir/old.txt", "old")
+
+	tx, err := g.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Remove("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile("a.txt", []byte("a2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile("z.txt", []byte(strings.Repeat("z", 32)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("committed a file over the size limit")
+	}
+
+	if data := readTestFile(t, g, "a.txt"); data != "a" {
+		t.Fatalf("a.txt left as %q", data)
+	}
+	if data := readTestFile(t, g, "dir/old.txt"); data != "old" {
+		t.Fatalf("dir/old.txt left as %q", data)
+	}
+	if _, err := g.Stat("z.txt"); err == nil {
+		t.Fatal("z.txt left behind")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback of the failed transaction: %v", err)
+	}
+}
+
+func TestTxCommitDone(t *testing.T) {
+	g, err := New(context.Background(), NewConfig().NoRemote().UseMemFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := g.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile("a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatalf("second commit got %v", err)
+	}
+	if data := readTestFile(t, g, "a.txt"); data != "a" {
+		t.Fatalf("got a.txt %q", data)
+	}
+}
+
+func TestApplyCommitsOnlyTouchedFiles(t *testing.T) {
+	for name, c := range map[string]*Config{
+		"worktree": NewConfig().NoRemote().UseMemFs(),
+		"bare":     NewConfig().NoRemote().UseMemFs().Bare("master"),
+	} {
+		g, err := New(context.Background(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, g, "other.txt", "other")
+		if err := g.git.Stage([]string{"other.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Apply(func(w Writer) error {
+			return w.WriteFile("a.txt", []byte("a"), 0644)
+		}, "add a"); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+
+		files, err := g.git.headFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := files["a.txt"]; !ok {
+			t.Fatalf("%v: a.txt not committed", name)
+		}
+		if _, ok := files["other.txt"]; ok {
+			t.Fatalf("%v: other.txt committed by Apply", name)
+		}
+		if err := g.Sync(false); err != nil {
+			t.Fatal(err)
+		}
+		if files, err = g.git.headFiles(); err != nil {
+			t.Fatal(err)
+		} else if _, ok := files["other.txt"]; !ok {
+			t.Fatalf("%v: other.txt not committed by Sync", name)
+		}
+	}
+}
+
+func TestApplyOnViews(t *testing.T) {
+	r := newTestRemote(t, map[string]string{"README": "readme"})
+	g := r.clone(nil)
+	scope, err := g.Scope("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chroot, err := g.Chroot("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, view := range map[string]*GitFs{"svc/x.txt": scope, "dir/y.txt": chroot} {
+		if err := view.Apply(func(w Writer) error {
+			return w.WriteFile(path[strings.Index(path, "/")+1:], []byte(path), 0644)
+		}, "add "+path); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if data, ok := r.file(path); !ok || data != path {
+			t.Fatalf("%v not pushed: %q", path, data)
+		}
+	}
+	status, err := g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 0 {
+		t.Fatalf("status after Apply is %v", status)
+	}
+}
@@ -0,0 +1,185 @@
+package gitfs
+
+import (
+	"os"
+	"sort"
+)
+
+// UnionFs is a merged read view of several GitFs. Reads look up the layers in
+// order and the first layer holding a path wins. Writes always go to the
+// primary layer; there are no whiteouts, so a path only found in an
+// overlay can't be removed through the UnionFs.
+type UnionFs struct {
+	layers []*GitFs
+}
+
+// Union layers overlays under primary, e.g. a defaults repo under a repo
+// of tenant specific overrides.
+func Union(primary *GitFs, overlays ...*GitFs) *UnionFs {
+	return &UnionFs{layers: append([]*GitFs{primary}, overlays...)}
+}
+
+// Primary returns the layer written to.
+func (u *UnionFs) Primary() *GitFs {
+	return u.layers[0]
+}
+
+// lookup calls fn with each layer until it succeeds or fails with another
+// error than not exist.
+func (u *UnionFs) lookup(fn func(g *GitFs) error) error {
+	var err error
+	for _, g := range u.layers {
+		if err = fn(g); !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Open opens the named file for reading from the first layer holding it.
+func (u *UnionFs) Open(filename string) (File, error) {
+	var f File
+	err := u.lookup(func(g *GitFs) (err error) {
+		f, err = g.Open(filename)
+		return err
+	})
+	return f, err
+}
+
+// OpenFile opens the named file like GitFs.OpenFile. Files opened for
+// writing are opened in the primary layer, others in the first layer
+// holding them.
+func (u *UnionFs) OpenFile(filename string, flag int, perm os.FileMode) (File, error) {
+	if isWriteFlag(flag) {
+		return u.Primary().OpenFile(filename, flag, perm)
+	}
+
+	var f File
+	err := u.lookup(func(g *GitFs) (err error) {
+		f, err = g.OpenFile(filename, flag, perm)
+		return err
+	})
+	return f, err
+}
+
+// ReadFile reads the named file from the first layer holding it.
+func (u *UnionFs) ReadFile(filename string) ([]byte, error) {
+	var data []byte
+	err := u.lookup(func(g *GitFs) (err error) {
+		data, err = g.ReadFile(filename)
+		return err
+	})
+	return data, err
+}
+
+// Stat returns a FileInfo describing the named file of the first layer
+// holding it.
+func (u *UnionFs) Stat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := u.lookup(func(g *GitFs) (err error) {
+		fi, err = g.Stat(filename)
+		return err
+	})
+	return fi, err
+}
+
+// Lstat is like Stat, but doesn't follow symlinks.
+func (u *UnionFs) Lstat(filename string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := u.lookup(func(g *GitFs) (err error) {
+		fi, err = g.Lstat(filename)
+		return err
+	})
+	return fi, err
+}
+
+// Readlink returns the target path of link from the first layer holding it.
+func (u *UnionFs) Readlink(link string) (string, error) {
+	var target string
+	err := u.lookup(func(g *GitFs) (err error) {
+		target, err = g.Readlink(link)
+		return err
+	})
+	return target, err
+}
+
+// Exist reports whether path exists in any layer.
+func (u *UnionFs) Exist(path string) (bool, error) {
+	_, err := u.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReadDir returns the merged entries of the directory in all layers,
+// sorted by filename. An entry of a layer hides same named ones of later
+// layers.
+func (u *UnionFs) ReadDir(path string) ([]os.FileInfo, error) {
+	seen := map[string]bool{}
+	var files []os.FileInfo
+	found := false
+	for _, g := range u.layers {
+		fis, err := g.ReadDir(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, fi := range fis {
+			if !seen[fi.Name()] {
+				seen[fi.Name()] = true
+				files = append(files, fi)
+			}
+		}
+	}
+	if !found {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// Create creates or truncates the named file in the primary layer.
+func (u *UnionFs) Create(filename string) (File, error) {
+	return u.Primary().Create(filename)
+}
+
+// WriteFile writes data to the named file in the primary layer.
+func (u *UnionFs) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return u.Primary().WriteFile(filename, data, perm)
+}
+
+// MkdirAll creates a directory in the primary layer.
+func (u *UnionFs) MkdirAll(filename string, perm os.FileMode) error {
+	return u.Primary().MkdirAll(filename, perm)
+}
+
+// Rename renames a file of the primary layer.
+func (u *UnionFs) Rename(oldpath, newpath string) error {
+	return u.Primary().Rename(oldpath, newpath)
+}
+
+// Remove removes a file of the primary layer.
+func (u *UnionFs) Remove(filename string) error {
+	return u.Primary().Remove(filename)
+}
+
+// RemoveAll removes a file or directory of the primary layer including
+// sub-directories.
+func (u *UnionFs) RemoveAll(path string) error {
+	return u.Primary().RemoveAll(path)
+}
+
+// Symlink creates a symlink in the primary layer.
+func (u *UnionFs) Symlink(target, link string) error {
+	return u.Primary().Symlink(target, link)
+}
+
+// Sync syncs the primary layer, see GitFs.Sync. Overlays are read only
+// through the UnionFs and are synced by their owners.
+func (u *UnionFs) Sync(purge bool) error {
+	return u.Primary().Sync(purge)
+}
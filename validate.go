@@ -0,0 +1,118 @@
+package gitfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+)
+
+// ConfigError lists every problem Valid found with a Config.
+type ConfigError struct {
+	Problems []error
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, err := range e.Problems {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid config: %v", strings.Join(msgs, "; "))
+}
+
+// scpLikeUrl matches "[user@]host:path" repo urls.
+var scpLikeUrl = regexp.MustCompile(`^(?:([^@/]+)@)?([^@/:]+):(.*)$`)
+
+// normalizeRepoUrl checks repo url u is one go-git can clone, returning it
+// with the scheme and host lower cased and trailing slashes removed. ssh
+// urls are either "ssh://[user@]host[:port]/path" or scp-like
+// "[user@]host:dir/repo", git+ssh:// becomes ssh://. Local paths become
+// absolute.
+func normalizeRepoUrl(u string) (string, error) {
+	if strings.Contains(u, "://") {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid repo url %v", u)
+		}
+		parsed.Scheme = strings.ToLower(parsed.Scheme)
+		switch parsed.Scheme {
+		case "git+ssh", "ssh+git":
+			parsed.Scheme = "ssh"
+		}
+
+		switch parsed.Scheme {
+		case "ssh", "git", "http", "https":
+			if parsed.Hostname() == "" {
+				return "", errors.Errorf("repo url %v has no host", u)
+			}
+			parsed.Host = strings.ToLower(parsed.Host)
+			parsed.Path = strings.TrimRight(parsed.Path, "/")
+			if parsed.Path == "" {
+				return "", errors.Errorf("repo url %v has no repo path", u)
+			}
+		case "file":
+			if parsed.Path == "" {
+				return "", errors.Errorf("repo url %v has no repo path", u)
+			}
+		default:
+			if _, ok := client.Protocols[parsed.Scheme]; ok {
+				// installed by the application, e.g. by gittest
+				break
+			}
+			return "", errors.Errorf("unsupported protocol %v of repo url %v, use ssh, https, git or file", parsed.Scheme, u)
+		}
+		return parsed.String(), nil
+	}
+
+	// a single letter host is a windows drive
+	if m := scpLikeUrl.FindStringSubmatch(u); m != nil && len(m[2]) > 1 {
+		p := strings.TrimRight(m[3], "/")
+		if p == "" {
+			return "", errors.Errorf("repo url %v has no repo path", u)
+		}
+		// go-git takes scp-like urls with no dir for local paths
+		if !strings.Contains(strings.TrimLeft(p, "/"), "/") {
+			return "", errors.Errorf("repo url %v has no dir in its path, use ssh://%v/%v instead", u, strings.TrimSuffix(u[:len(u)-len(m[3])], ":"), strings.TrimLeft(p, "/"))
+		}
+		return u[:len(u)-len(m[3])] + p, nil
+	}
+
+	abs, err := filepath.Abs(u)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid repo path %v", u)
+	}
+	return abs, nil
+}
+
+// checkWritableDir checks files can be created in dir, or in its closest
+// existing parent dir if it does not exist yet.
+func checkWritableDir(dir string) error {
+	d := dir
+	for {
+		fi, err := os.Stat(d)
+		if os.IsNotExist(err) && filepath.Dir(d) != d {
+			d = filepath.Dir(d)
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "error checking base dir %v", dir)
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("base dir %v is not a directory, %v is a file", dir, d)
+		}
+		break
+	}
+
+	f, err := ioutil.TempFile(d, ".gitfs-check")
+	if err != nil {
+		return errors.Wrapf(err, "base dir %v is not writable", dir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
@@ -0,0 +1,128 @@
+package gitfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// SkipDir can be returned by a walk callback to skip the directory being
+// visited. It is the same value as filepath.SkipDir.
+var SkipDir = filepath.SkipDir
+
+// DirEntry is an entry read from a directory, mirroring io/fs.DirEntry.
+type DirEntry interface {
+	// Name returns the name of the file (or subdirectory) described by the
+	// entry.
+	Name() string
+	// IsDir reports whether the entry describes a directory.
+	IsDir() bool
+	// Type returns the type bits for the entry.
+	Type() os.FileMode
+	// Info returns the FileInfo for the file or subdirectory described by
+	// the entry.
+	Info() (os.FileInfo, error)
+}
+
+// WalkDirFunc is the type of the function called by WalkDir to visit each
+// file or directory, mirroring io/fs.WalkDirFunc.
+type WalkDirFunc func(path string, d DirEntry, err error) error
+
+type dirEntry struct {
+	fi os.FileInfo
+}
+
+func (d dirEntry) Name() string               { return d.fi.Name() }
+func (d dirEntry) IsDir() bool                { return d.fi.IsDir() }
+func (d dirEntry) Type() os.FileMode          { return d.fi.Mode() & os.ModeType }
+func (d dirEntry) Info() (os.FileInfo, error) { return d.fi, nil }
+
+// Walk walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root. Files are walked in lexical order
+// and the .git directory is skipped. Files stored compressed are visited
+// decompressed under their name, like ReadDir lists them.
+func (g *GitFs) Walk(root string, fn filepath.WalkFunc) (err error) {
+	defer g.git.trace("gitfs.Walk")(&err)
+
+	if err := g.checkPath("walk", root); err != nil {
+		return err
+	}
+	return g.walk(root, fn)
+}
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root, like Walk.
+func (g *GitFs) WalkDir(root string, fn WalkDirFunc) (err error) {
+	defer g.git.trace("gitfs.WalkDir")(&err)
+
+	if err := g.checkPath("walk", root); err != nil {
+		return err
+	}
+	return g.walk(root, func(path string, fi os.FileInfo, err error) error {
+		if fi == nil {
+			return fn(path, nil, err)
+		}
+		return fn(path, dirEntry{fi}, err)
+	})
+}
+
+// walk walks g.fs like Walk.
+func (g *GitFs) walk(root string, fn filepath.WalkFunc) error {
+	return walk(g.fs, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(path, compressedExt) {
+			return fn(path, fi, err)
+		}
+		zfi, err := g.decompressedInfo(path, fi)
+		return fn(strings.TrimSuffix(path, compressedExt), zfi, err)
+	})
+}
+
+// slashPath returns the slash separated form of path, walked from the root,
+// relative to the root, as used by git.
+func slashPath(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+func walk(fs billy.Filesystem, root string, fn filepath.WalkFunc) error {
+	fi, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkPath(fs, root, fi, fn)
+	}
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkPath(fs billy.Filesystem, path string, fi os.FileInfo, fn filepath.WalkFunc) error {
+	if !fi.IsDir() {
+		return fn(path, fi, nil)
+	}
+
+	files, err := fs.ReadDir(path)
+	err1 := fn(path, fi, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	for _, f := range files {
+		if f.Name() == git.GitDirName {
+			continue
+		}
+
+		if err := walkPath(fs, fs.Join(path, f.Name()), f, fn); err != nil {
+			if !f.IsDir() || err != SkipDir {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
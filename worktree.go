@@ -0,0 +1,146 @@
+package gitfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// worktreeStorer shares the objects and refs of a repo, but keeps a HEAD
+// and index of its own, so another branch can be checked out of it.
+type worktreeStorer struct {
+	storage.Storer
+	head *plumbing.Reference
+	idx  *index.Index
+}
+
+func (s *worktreeStorer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if name == plumbing.HEAD {
+		return s.head, nil
+	}
+	return s.Storer.Reference(name)
+}
+
+func (s *worktreeStorer) SetReference(ref *plumbing.Reference) error {
+	if ref.Name() == plumbing.HEAD {
+		s.head = ref
+		return nil
+	}
+	return s.Storer.SetReference(ref)
+}
+
+func (s *worktreeStorer) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref.Name() == plumbing.HEAD {
+		if old != nil && old.Hash() != s.head.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+		s.head = ref
+		return nil
+	}
+	return s.Storer.CheckAndSetReference(ref, old)
+}
+
+func (s *worktreeStorer) Index() (*index.Index, error) {
+	if s.idx == nil {
+		s.idx = &index.Index{Version: 2}
+	}
+	return s.idx, nil
+}
+
+func (s *worktreeStorer) SetIndex(idx *index.Index) error {
+	s.idx = idx
+	return nil
+}
+
+// WorktreeFor checks branch out into a worktree of its own, sharing the
+// object store of g, so several branches can be used at once without
+// cloning again. A branch only known to origin is created locally first.
+// With osFs, the worktree is the directory next to the repo one, named
+// after the repo with "@branch" appended, and is checked out afresh on
+// every call. Otherwise it is kept in memory. The index of the worktree is
+// kept in memory too. Like Git, the worktrees of a repo must not be used
+// concurrently.
+func (g *GitFs) WorktreeFor(branch string) (*GitFs, error) {
+	parent := g.git
+	name := plumbing.NewBranchReferenceName(branch)
+	create := false
+	ref, err := parent.repo.Storer.Reference(name)
+	if err == plumbing.ErrReferenceNotFound {
+		ref, err = parent.repo.Storer.Reference(plumbing.NewRemoteReferenceName("origin", branch))
+		create = true
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving branch %v", branch)
+	}
+
+	var fs billy.Filesystem
+	if dir, ok := osPath(parent.fs, ""); ok {
+		dir = filepath.Clean(dir) + "@" + strings.Replace(branch, "/", "-", -1)
+		fs = osfs.New(dir)
+	} else {
+		fs = memfs.New()
+	}
+
+	s := &worktreeStorer{
+		Storer: parent.repo.Storer,
+		head:   plumbing.NewSymbolicReference(plumbing.HEAD, name),
+	}
+	repo, err := git.Open(s, fs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening worktree of %v", branch)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading worktree")
+	}
+	opts := &git.CheckoutOptions{Branch: name, Create: create, Force: true}
+	if create {
+		opts.Hash = ref.Hash()
+	}
+	if err := wt.Checkout(opts); err != nil {
+		return nil, errors.Wrapf(err, "error checking out %v", branch)
+	}
+
+	child := *parent
+	child.fs = fs
+	child.repo = repo
+	child.wt = wt
+	child.branch = branch
+	child.temp = newTempAreas(parent.temp.name, parent.temp.clean)
+	wt.Excludes = append(wt.Excludes, child.temp.pattern())
+	if parent.statusCache != nil {
+		child.statusCache = newStatusCache()
+	}
+	if parent.modTimes != nil {
+		child.modTimes = newModTimeCache()
+	}
+	if parent.eol != nil {
+		child.eol = newEOLPolicy(parent.eol.autocrlf, parent.eol.attributes)
+	}
+
+	return &GitFs{
+		git:           &child,
+		fs:            fs,
+		compressAbove: g.compressAbove,
+		maxFileSize:   g.maxFileSize,
+		repoQuota:     g.repoQuota,
+		writable:      g.writable,
+		offline:       g.offline,
+		events:        newEventBus(),
+		exposeGitDir:  g.exposeGitDir,
+		message:       g.message,
+		preSync:       g.preSync,
+		postSync:      g.postSync,
+		binaryLimit:   g.binaryLimit,
+		refuseBinary:  g.refuseBinary,
+		mergeDrivers:  g.mergeDrivers,
+	}, nil
+}